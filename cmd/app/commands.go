@@ -0,0 +1,44 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/storage/postgres"
+	"github.com/spf13/pflag"
+)
+
+// Commands are the subcommands of the binary, the server runs without one
+var Commands = map[string]func(args []string) error{
+	"export":   RunExport,
+	"migrate":  RunMigrate,
+	"replay":   RunReplay,
+	"simulate": RunSimulate,
+}
+
+// RunMigrate applies the database migrations not applied yet
+func RunMigrate(args []string) error {
+	fs := pflag.NewFlagSet("migrate", pflag.ContinueOnError)
+	url := fs.String("database-url", os.Getenv("DATABASE_URL"), "PostgreSQL url to migrate, defaults to $DATABASE_URL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *url == "" {
+		return fmt.Errorf("migrate needs --database-url")
+	}
+	db, err := postgres.Open(*url)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	applied, err := db.Migrate()
+	if err != nil {
+		return err
+	}
+	version, err := db.Version()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("applied %d migrations, the schema is at version %d\n", applied, version)
+	return nil
+}
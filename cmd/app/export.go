@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// RunExport streams the usage export of a running server to a file or stdout
+func RunExport(args []string) error {
+	fs := pflag.NewFlagSet("export", pflag.ContinueOnError)
+	server := fs.String("server", "http://localhost:8000", "Address of the billing server")
+	from := fs.String("from", "", "Export usage that ended at or after this time, RFC3339 or 2006-01-02")
+	to := fs.String("to", "", "Export usage that ended before this time, RFC3339 or 2006-01-02")
+	format := fs.String("format", "csv", "Output format, csv, jsonl or parquet with --schema focus")
+	columns := fs.String("columns", "", "Comma separated columns in output order, the default columns if empty")
+	schema := fs.String("schema", "", "Export the columns of a schema instead, focus for the FinOps Open Cost and Usage Specification")
+	output := fs.String("output", "", "File to write to, stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("export needs --from and --to")
+	}
+
+	query := url.Values{}
+	query.Set("from", *from)
+	query.Set("to", *to)
+	query.Set("format", *format)
+	if *columns != "" {
+		query.Set("columns", *columns)
+	}
+	if *schema != "" {
+		query.Set("schema", *schema)
+	}
+	resp, err := http.Get(strings.TrimSuffix(*server, "/") + "/usage/export?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("failed to request export: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("export failed with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return fmt.Errorf("failed to write export: %v", err)
+	}
+	return nil
+}
@@ -18,6 +18,7 @@ package options
 
 import (
 	"github.com/spf13/pflag"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -35,7 +36,27 @@ func TestAddFlags(t *testing.T) {
 
 	// This is a snapshot of expected options parsed by args.
 	expected := &ServerOption{
-		ListenAddress:      defaultListenAddress,
+		ListenAddress:          defaultListenAddress,
+		CleanPeriod:            defaultCleanPeriod,
+		BillingSyncPeriod:      defaultSyncPeriod,
+		BudgetEvalPeriod:       defaultBudgetPeriod,
+		BudgetWebhookTimeout:   defaultAlertTimeout,
+		EnforcementGracePeriod: defaultGracePeriod,
+		CostAnnotationPeriod:   defaultAnnotatePeriod,
+		CostAnnotationQPS:      defaultAnnotateQPS,
+		ParquetInterval:        defaultLakeInterval,
+		ParquetDelay:           defaultLakeDelay,
+		ParquetExportPeriod:    defaultLakePeriod,
+		DatabaseURL:            os.Getenv("DATABASE_URL"),
+		DatabaseMigrate:        true,
+		DatabaseFlushPeriod:    defaultDatabasePeriod,
+		EventLogSegmentMB:      defaultSegmentMB,
+		EventLogMaxSegments:    defaultMaxSegments,
+		SnapshotPeriod:         defaultSnapshotPeriod,
+		RollupPeriod:           defaultRollupPeriod,
+		RollupHourlyRetention:  defaultHourlyRollups,
+		StreamHistory:          defaultStreamHistory,
+		StreamCostPeriod:       defaultStreamPeriod,
 	}
 
 	if !reflect.DeepEqual(expected, s) {
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/eventlog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/cache"
+	"github.com/spf13/pflag"
+)
+
+// RunReplay rebuilds the cache and the usage records from an event log
+// offline and prints them as json
+func RunReplay(args []string) error {
+	fs := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	dir := fs.String("event-log-dir", "", "Directory of the event log")
+	config := fs.String("billing-config", "", "Path to the billing config the usage is priced with")
+	until := fs.String("until", "", "Only replay the events received before this RFC3339 time")
+	output := fs.String("output", "usage", "What to print: usage for the usage records, jobs for the jobs of the cache")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("replay needs --event-log-dir")
+	}
+	var end time.Time
+	if *until != "" {
+		t, err := time.Parse(time.RFC3339, *until)
+		if err != nil {
+			return fmt.Errorf("invalid until: %v", err)
+		}
+		end = t
+	}
+	billingConfig, err := billing.LoadConfig(*config)
+	if err != nil {
+		return err
+	}
+
+	l := ledger.New(billingConfig)
+	bc := cache.NewReplayCache(l)
+	replayed, err := eventlog.Replay(*dir, end, bc.PodHandler(), bc.FrameworkHandler())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "replayed %d events\n", replayed)
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	switch *output {
+	case "usage":
+		return encoder.Encode(l.Records())
+	case "jobs":
+		return encoder.Encode(bc.Snapshot().Jobs)
+	}
+	return fmt.Errorf("unknown output %q, expected usage or jobs", *output)
+}
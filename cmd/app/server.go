@@ -18,11 +18,27 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
+	"os"
+	"time"
 	"github.com/ruanxingbaozi/k8s-billing/cmd/app/options"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/admission"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/budget"
 	"github.com/ruanxingbaozi/k8s-billing/pkg/controller"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/eventlog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/events"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/grafana"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/invoice"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/lake"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/rollup"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/snapshot"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/stream"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/storage/postgres"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
 	"github.com/ruanxingbaozi/k8s-billing/pkg/version"
 
 	// Register gcp auth
@@ -53,7 +69,93 @@ func Run(opt *options.ServerOption) error {
 		return err
 	}
 
-	jc := controller.New(config)
+	billingConfig, err := billing.LoadConfig(opt.BillingConfig)
+	if err != nil {
+		return err
+	}
+
+	l := ledger.New(billingConfig)
+	accounts := billing.NewAccountDirectory()
+	jc := controller.New(config, l, accounts)
+	recorder := events.NewRecorder(jc.Cache().KubeClient(), accounts)
+
+	var store invoice.Store = invoice.NewMemoryStore()
+	var rollupStore rollup.Store
+	if opt.DatabaseURL != "" {
+		db, err := postgres.Open(opt.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		if opt.DatabaseMigrate {
+			_, err = db.Migrate()
+		} else {
+			err = db.CheckVersion()
+		}
+		if err != nil {
+			return err
+		}
+		if err := l.SetStore(db); err != nil {
+			return fmt.Errorf("failed to load usage records: %v", err)
+		}
+		store = db
+		rollupStore = db
+	} else if opt.InvoiceDir != "" {
+		if store, err = invoice.NewFileStore(opt.InvoiceDir); err != nil {
+			return err
+		}
+	} else {
+		glog.Warningf("No invoice directory configured, invoices are lost on restart")
+	}
+	jc.SetInvoices(invoice.NewInvoicer(l, accounts, store, time.Local))
+	rollups := rollup.NewAggregator(l, jc.Cache(), rollupStore, time.Local)
+	rollups.SetHourlyRetention(opt.RollupHourlyRetention)
+	jc.SetRollups(rollups)
+	broker := stream.NewBroker(opt.StreamHistory, l, jc.Cache())
+	jc.Cache().SetWatcher(broker)
+
+	if opt.EventLogDir != "" {
+		// replayed before events are recorded, so nothing is logged or emitted twice
+		if opt.EventLogReplay {
+			replayed, err := eventlog.Replay(opt.EventLogDir, time.Time{}, jc.Cache().PodHandler(), jc.Cache().FrameworkHandler())
+			if err != nil {
+				return fmt.Errorf("failed to replay the event log: %v", err)
+			}
+			glog.Infof("Replayed %d events of %s", replayed, opt.EventLogDir)
+		}
+		eventLog, err := eventlog.Open(opt.EventLogDir, int64(opt.EventLogSegmentMB)<<20)
+		if err != nil {
+			return err
+		}
+		defer eventLog.Close()
+		if err := eventLog.SetRetention(opt.EventLogMaxSegments, opt.EventLogMaxAge); err != nil {
+			return fmt.Errorf("failed to remove old segments of the event log: %v", err)
+		}
+		jc.Cache().SetEventLog(eventLog)
+	}
+	jc.Cache().SetRecorder(recorder)
+
+	notifiers := []budget.Notifier{budget.LogNotifier{}, recorder}
+	if opt.BudgetWebhookURL != "" {
+		notifiers = append(notifiers, budget.NewWebhookNotifier(opt.BudgetWebhookURL, opt.BudgetWebhookTimeout))
+	}
+	evaluator := budget.NewEvaluator(l, jc.Cache(), accounts, notifiers...)
+	if opt.BudgetAlertStateFile != "" {
+		if err := evaluator.SetStateFile(opt.BudgetAlertStateFile); err != nil {
+			return fmt.Errorf("failed to load the budget alert state: %v", err)
+		}
+	}
+	jc.SetBudgets(evaluator)
+
+	var enforcer *budget.Enforcer
+	if opt.EnableBudgetEnforcement {
+		enforcer = budget.NewEnforcer(evaluator, jc.Cache(), recorder.EventRecorder(), jc.Cache().FrameworkClient(),
+			budget.NewAuditTrail(opt.EnforcementAuditLog), budget.EnforcerOptions{
+				DryRun:      opt.EnforcementDryRun,
+				GracePeriod: opt.EnforcementGracePeriod,
+				Allowlist:   opt.EnforcementAllowlist,
+			})
+		jc.SetEnforcer(enforcer)
+	}
 
 	go func() {
 		http.Handle("/metrics", promhttp.Handler())
@@ -61,11 +163,99 @@ func Run(opt *options.ServerOption) error {
 		http.HandleFunc("/jobs", jc.GetAllJobs)
 		http.HandleFunc("/pods", jc.GetAllPods)
 		http.HandleFunc("/job/:name", jc.GetJobByName)
+		http.HandleFunc("/job/cost", jc.GetJobCost)
+		http.HandleFunc("/job/estimate", jc.EstimateCost)
+		http.HandleFunc("/usage", jc.GetUsage)
+		http.HandleFunc("/usage/recompute", jc.RecomputeUsage)
+		http.HandleFunc("/usage/export", jc.ExportUsage)
+		http.HandleFunc("/usage/series", jc.GetUsageSeries)
+		http.HandleFunc("/leaderboard", jc.GetLeaderboard)
+		http.HandleFunc("/prices", jc.GetPrices)
+		http.HandleFunc("/accounts", jc.GetAccounts)
+		http.HandleFunc("/invoices", jc.GetInvoices)
+		http.HandleFunc("/invoice", jc.GetInvoice)
+		http.HandleFunc("/invoices/close", jc.CloseInvoicePeriod)
+		http.HandleFunc("/invoices/adjust", jc.AdjustInvoice)
+		http.HandleFunc("/anomalies", jc.GetAnomalies)
+		http.HandleFunc("/snapshots", jc.GetSnapshots)
+		http.HandleFunc("/snapshot", jc.GetSnapshot)
+		http.HandleFunc("/snapshots/diff", jc.DiffSnapshots)
+		http.HandleFunc("/budgets", jc.GetBudgets)
+		http.HandleFunc("/budgets/audit", jc.GetEnforcementAudit)
+		http.HandleFunc("/stream", broker.Serve)
+		// grafana json datasource
+		http.Handle("/grafana/", http.StripPrefix("/grafana", grafana.New(rollups, jc.Cache()).Handler()))
 		glog.Fatalf("Prometheus Http Server failed %s", http.ListenAndServe(opt.ListenAddress, nil))
 	}()
 
+	if opt.WebhookListenAddress != "" {
+		wh := admission.New(l, evaluator, jc.Cache())
+		mux := http.NewServeMux()
+		mux.HandleFunc("/validate", wh.Validate)
+		mux.HandleFunc("/mutate", wh.Mutate)
+		go func() {
+			glog.Fatalf("Admission webhook server failed %s",
+				http.ListenAndServeTLS(opt.WebhookListenAddress, opt.WebhookTLSCertFile, opt.WebhookTLSKeyFile, mux))
+		}()
+	}
+
+	var aw *controller.AnnotationWriter
+	if opt.EnableCostAnnotations {
+		aw = controller.NewAnnotationWriter(jc.Cache(), l, opt.CostAnnotationQPS)
+	}
+
+	var exporter *lake.Exporter
+	if opt.ParquetDir != "" || opt.ParquetS3Endpoint != "" {
+		var sink lake.Sink = &lake.DirSink{Dir: opt.ParquetDir}
+		if opt.ParquetS3Endpoint != "" {
+			if sink, err = lake.NewS3Sink(opt.ParquetS3Endpoint, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"),
+				opt.ParquetS3Bucket, opt.ParquetS3Prefix, !opt.ParquetS3Insecure); err != nil {
+				return err
+			}
+		}
+		if exporter, err = lake.NewExporter(l, sink, opt.ParquetInterval, opt.ParquetDelay); err != nil {
+			return err
+		}
+	}
+
+	var snapshotter *snapshot.Snapshotter
+	if opt.SnapshotDir != "" {
+		store, err := snapshot.NewStore(opt.SnapshotDir)
+		if err != nil {
+			return err
+		}
+		snapshotter = snapshot.NewSnapshotter(jc.Cache(), l, store, opt.SnapshotRetention)
+		jc.SetSnapshots(store)
+	}
+
+	var rc *controller.ResourceController
+	if opt.EnableBillingCRDs {
+		if rc, err = controller.NewResourceController(config, l, accounts, opt.BillingSyncPeriod); err != nil {
+			return err
+		}
+	}
+
 	run := func(ctx context.Context) {
 		jc.Run(ctx.Done())
+		go l.Run(opt.DatabaseFlushPeriod, ctx.Done())
+		go evaluator.Run(opt.BudgetEvalPeriod, ctx.Done())
+		go rollups.Run(opt.RollupPeriod, ctx.Done())
+		go broker.Run(opt.StreamCostPeriod, ctx.Done())
+		if enforcer != nil {
+			go enforcer.Run(opt.BudgetEvalPeriod, ctx.Done())
+		}
+		if rc != nil {
+			go rc.Run(ctx.Done())
+		}
+		if aw != nil {
+			go aw.Run(opt.CostAnnotationPeriod, ctx.Done())
+		}
+		if snapshotter != nil {
+			go snapshotter.Run(opt.SnapshotPeriod, ctx.Done())
+		}
+		if exporter != nil {
+			go exporter.Run(opt.ParquetExportPeriod, ctx.Done())
+		}
 		<-ctx.Done()
 	}
 	run(context.TODO())
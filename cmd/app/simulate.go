@@ -0,0 +1,126 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/export"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/simulate"
+	"github.com/spf13/pflag"
+)
+
+// RunSimulate bills recorded manifests or an event log offline under a
+// billing config and prints the cost by job and user, compared with a
+// baseline config if given
+func RunSimulate(args []string) error {
+	fs := pflag.NewFlagSet("simulate", pflag.ContinueOnError)
+	manifests := fs.String("manifests", "", "Directory of Pod and Framework manifests to bill")
+	eventLogDir := fs.String("event-log-dir", "", "Directory of an event log to bill instead of manifests")
+	config := fs.String("billing-config", "", "Path to the billing config with the rates to simulate")
+	baseline := fs.String("baseline-config", "", "Path to the billing config the simulated cost is compared with, e.g. the current rates")
+	from := fs.String("from", "", "Only bill attempts that ended at or after this time, RFC3339 or 2006-01-02")
+	to := fs.String("to", "", "Only bill attempts that ended before this time, RFC3339 or 2006-01-02. Running attempts are billed until then, or until now if empty.")
+	output := fs.String("output", "table", "Output format, table or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*manifests == "") == (*eventLogDir == "") {
+		return fmt.Errorf("simulate needs either --manifests or --event-log-dir")
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("unknown output %q, expected table or json", *output)
+	}
+	var opts simulate.Options
+	var err error
+	if *from != "" {
+		if opts.From, err = export.ParseTime(*from, time.Local); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if opts.To, err = export.ParseTime(*to, time.Local); err != nil {
+			return err
+		}
+	}
+	opts.Now = time.Now()
+
+	var source simulate.Source
+	if *manifests != "" {
+		m, err := simulate.LoadManifests(*manifests)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "loaded %d frameworks and %d pods\n", len(m.Frameworks), len(m.Pods))
+		source = simulate.ManifestSource(m)
+	} else {
+		source = simulate.EventLogSource(*eventLogDir, opts.To)
+	}
+
+	result, err := simulateConfig(*config, source, opts)
+	if err != nil {
+		return err
+	}
+	if *baseline != "" {
+		base, err := simulateConfig(*baseline, source, opts)
+		if err != nil {
+			return err
+		}
+		result.Compare(base)
+	}
+
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	return printSimulation(os.Stdout, result)
+}
+
+func simulateConfig(path string, source simulate.Source, opts simulate.Options) (*simulate.Result, error) {
+	config, err := billing.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return simulate.Run(config, source, opts)
+}
+
+func printSimulation(out io.Writer, result *simulate.Result) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	compared := result.Baseline != nil
+	row := func(c *simulate.Cost, name string) {
+		fmt.Fprintf(w, "%s\t%d\t%.2f\t%s", name, c.Attempts, c.GPUHours, c.Cost.RoundCurrency())
+		if compared {
+			fmt.Fprintf(w, "\t%s\t%s", c.Baseline.RoundCurrency(), c.Delta.RoundCurrency())
+		}
+		fmt.Fprintln(w)
+	}
+	header := func(title string) {
+		fmt.Fprintf(w, "%s\tATTEMPTS\tGPU HOURS\tCOST", title)
+		if compared {
+			fmt.Fprint(w, "\tBASELINE\tDELTA")
+		}
+		fmt.Fprintln(w)
+	}
+
+	header("JOB")
+	for _, c := range result.Jobs {
+		row(c, c.Namespace+"/"+c.Name)
+	}
+	fmt.Fprintln(w)
+	header("USER")
+	for _, c := range result.Users {
+		row(c, c.Name)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "TOTAL\t\t\t%s", result.Total.RoundCurrency())
+	if compared {
+		fmt.Fprintf(w, "\t%s\t%s", result.Baseline.RoundCurrency(), result.Delta.RoundCurrency())
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// globalOptions locate the billing service and format the output
+type globalOptions struct {
+	kubeconfig       string
+	context          string
+	serviceNamespace string
+	service          string
+	servicePort      string
+	server           string
+	output           string
+}
+
+func addGlobalFlags(fs *pflag.FlagSet) *globalOptions {
+	o := &globalOptions{}
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, the default loading rules apply if empty")
+	fs.StringVar(&o.context, "context", "", "The kubeconfig context to use, the current context if empty")
+	fs.StringVar(&o.serviceNamespace, "service-namespace", "kube-system", "Namespace of the billing service")
+	fs.StringVar(&o.service, "service", "k8s-billing", "Name of the billing service")
+	fs.StringVar(&o.servicePort, "service-port", "8000", "Port of the billing service, by number or name")
+	fs.StringVar(&o.server, "server", "", "Address of the billing server, e.g. http://localhost:8000. The service is reached through the API server proxy if empty.")
+	fs.StringVarP(&o.output, "output", "o", "table", "Output format, table, json or yaml")
+	return o
+}
+
+func (o *globalOptions) validate() error {
+	switch o.output {
+	case "table", "json", "yaml":
+		return nil
+	}
+	return fmt.Errorf("unknown output %q, expected table, json or yaml", o.output)
+}
+
+// client gets the json of the billing api
+type client interface {
+	get(path string, query url.Values, out interface{}) error
+}
+
+// notFoundError is returned for a path the billing server does not serve,
+// e.g. an endpoint of a newer version
+type notFoundError struct {
+	err error
+}
+
+func (e *notFoundError) Error() string {
+	return e.err.Error()
+}
+
+func isNotFound(err error) bool {
+	_, ok := err.(*notFoundError)
+	return ok
+}
+
+func (o *globalOptions) client() (client, error) {
+	if o.server != "" {
+		return &directClient{server: strings.TrimSuffix(o.server, "/")}, nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules,
+		&clientcmd.ConfigOverrides{CurrentContext: o.context}).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyClient{kubeClient: kubeClient, namespace: o.serviceNamespace, name: o.service, port: o.servicePort}, nil
+}
+
+// proxyClient reaches the billing service through the API server proxy with
+// the credentials of the kubeconfig
+type proxyClient struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	name       string
+	port       string
+}
+
+func (c *proxyClient) get(path string, query url.Values, out interface{}) error {
+	params := make(map[string]string)
+	for key := range query {
+		params[key] = query.Get(key)
+	}
+	data, err := c.kubeClient.CoreV1().Services(c.namespace).ProxyGet("http", c.name, c.port, path, params).DoRaw()
+	if err != nil {
+		wrapped := fmt.Errorf("failed to get %s from service %s/%s: %v", path, c.namespace, c.name, err)
+		if apierrors.IsNotFound(err) {
+			return &notFoundError{err: wrapped}
+		}
+		return wrapped
+	}
+	return json.Unmarshal(data, out)
+}
+
+// directClient calls a billing server reachable from here, e.g. through a
+// port forward
+type directClient struct {
+	server string
+}
+
+func (c *directClient) get(path string, query url.Values, out interface{}) error {
+	resp, err := http.Get(c.server + path + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		err := fmt.Errorf("failed to get %s with %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+		if resp.StatusCode == http.StatusNotFound {
+			return &notFoundError{err: err}
+		}
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/invoice"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/leaderboard"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	"github.com/spf13/pflag"
+)
+
+// commands of the plugin by name
+var commands = map[string]func(args []string) error{
+	"jobs":    runJobs,
+	"cost":    runCost,
+	"top":     runTop,
+	"invoice": runInvoice,
+}
+
+// stdout is where the commands print, replaced in tests
+var stdout io.Writer = os.Stdout
+
+// jobRow is a job of the cache with its finalized usage
+type jobRow struct {
+	Name      string        `json:"name"`
+	Namespace string        `json:"namespace"`
+	UserId    string        `json:"userId"`
+	GroupId   string        `json:"groupId"`
+	State     string        `json:"state"`
+	Attempts  int           `json:"attempts"`
+	GPUHours  float64       `json:"gpuHours"`
+	Cost      billing.Money `json:"cost"`
+}
+
+// list the jobs of the cache, optionally of a user, group or namespace
+func runJobs(args []string) error {
+	fs := pflag.NewFlagSet("jobs", pflag.ContinueOnError)
+	o := addGlobalFlags(fs)
+	user := fs.String("user", "", "Only list the jobs of this user")
+	group := fs.String("group", "", "Only list the jobs of this group")
+	namespace := fs.StringP("namespace", "n", "", "Only list the jobs of this namespace")
+	c, err := parse(fs, o, args, 0)
+	if err != nil {
+		return err
+	}
+	var jobs map[string]*api.JobInfo
+	if err := c.get("/jobs", nil, &jobs); err != nil {
+		return err
+	}
+	var records []*billing.UsageRecord
+	if err := c.get("/usage", nil, &records); err != nil {
+		return err
+	}
+	usage := make(map[string]*jobRow)
+	for _, record := range records {
+		row, found := usage[record.JobName]
+		if !found {
+			row = &jobRow{}
+			usage[record.JobName] = row
+		}
+		row.Attempts++
+		row.GPUHours += record.Usage.GPUHours()
+		row.Cost += record.Cost
+	}
+
+	rows := []*jobRow{}
+	for _, fi := range jobs {
+		if (*user != "" && fi.UserId != *user) || (*group != "" && fi.GroupId != *group) ||
+			(*namespace != "" && fi.Namespace != *namespace) {
+			continue
+		}
+		row := &jobRow{Name: fi.JobName, Namespace: fi.Namespace, UserId: fi.UserId, GroupId: fi.GroupId}
+		if fi.Status != nil {
+			row.State = string(fi.Status.State)
+		}
+		if u, found := usage[fi.JobName]; found {
+			row.Attempts, row.GPUHours, row.Cost = u.Attempts, u.GPUHours, u.Cost
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Namespace != rows[j].Namespace {
+			return rows[i].Namespace < rows[j].Namespace
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	return printOutput(stdout, o.output, rows, func(w io.Writer) {
+		printRow(w, "NAMESPACE", "NAME", "USER", "GROUP", "STATE", "ATTEMPTS", "GPU HOURS", "COST")
+		for _, row := range rows {
+			printRow(w, row.Namespace, row.Name, row.UserId, row.GroupId, row.State, row.Attempts,
+				fmt.Sprintf("%.2f", row.GPUHours), row.Cost.RoundCurrency())
+		}
+	})
+}
+
+// show the cost of a framework by price window
+func runCost(args []string) error {
+	fs := pflag.NewFlagSet("cost", pflag.ContinueOnError)
+	o := addGlobalFlags(fs)
+	c, err := parse(fs, o, args, 1)
+	if err != nil {
+		return err
+	}
+	var cost ledger.JobCost
+	if err := c.get("/job/cost", url.Values{"name": {fs.Arg(0)}}, &cost); err != nil {
+		return err
+	}
+	return printOutput(stdout, o.output, &cost, func(w io.Writer) {
+		printRow(w, "PRICE VERSION", "WINDOW", "MULTIPLIER", "DURATION", "GPU HOURS", "COST")
+		for _, wc := range cost.Breakdown {
+			printRow(w, wc.PriceVersion, wc.Window, wc.Multiplier, wc.Duration.Round(time.Second),
+				fmt.Sprintf("%.2f", wc.Usage.GPUHours()), wc.Cost.RoundCurrency())
+		}
+		fmt.Fprintf(w, "\n%s: %d attempts, cost %s\n", cost.JobName, cost.Attempts, cost.Cost.RoundCurrency())
+	})
+}
+
+// show who used the most gpu hours, cpu hours or cost, or failed most often
+func runTop(args []string) error {
+	fs := pflag.NewFlagSet("top", pflag.ContinueOnError)
+	o := addGlobalFlags(fs)
+	by := fs.String("by", "cost", "What to rank by, gpu, cpu, cost or failures")
+	groupBy := fs.String("group-by", "user", "What to sum the usage by, user, group, namespace or job")
+	from := fs.String("from", "", "Only count usage that ended at or after this RFC3339 time, all usage if empty")
+	to := fs.String("to", "", "Only count usage that ended before this RFC3339 time")
+	gpuType := fs.String("gpu-type", "", "Only count usage of this gpu type")
+	limit := fs.Int("limit", 10, "Number of rows to show, all if zero")
+	c, err := parse(fs, o, args, 0)
+	if err != nil {
+		return err
+	}
+	q := leaderboard.Query{By: *groupBy, Sort: *by, GpuType: *gpuType, Limit: *limit}
+	if *from != "" {
+		if q.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if *to != "" {
+		if q.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("invalid to: %v", err)
+		}
+	}
+	entries, err := top(c, q)
+	if err != nil {
+		return err
+	}
+	return printOutput(stdout, o.output, entries, func(w io.Writer) {
+		printRow(w, "RANK", strings.ToUpper(*groupBy), "ATTEMPTS", "FAILURES", "CPU HOURS", "GPU HOURS", "COST")
+		for _, entry := range entries {
+			printRow(w, entry.Rank, entry.Name, entry.Attempts, entry.Failures, fmt.Sprintf("%.2f", entry.CPUHours),
+				fmt.Sprintf("%.2f", entry.GPUHours), entry.Cost.RoundCurrency())
+		}
+	})
+}
+
+// top gets the leaderboard of the query from the server. The server ranks
+// the last week unless given a start, top asks for all usage then. Servers
+// without the leaderboard are ranked here from their usage.
+func top(c client, q leaderboard.Query) ([]*leaderboard.Entry, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	from := q.From
+	if from.IsZero() {
+		from = time.Unix(0, 0)
+	}
+	query := url.Values{
+		"by":    {q.By},
+		"sort":  {q.Sort},
+		"from":  {from.UTC().Format(time.RFC3339Nano)},
+		"limit": {strconv.Itoa(q.Limit)},
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.UTC().Format(time.RFC3339Nano))
+	}
+	if q.GpuType != "" {
+		query.Set("gpuType", q.GpuType)
+	}
+	var entries []*leaderboard.Entry
+	if err := c.get("/leaderboard", query, &entries); !isNotFound(err) {
+		return entries, err
+	}
+	var records []*billing.UsageRecord
+	if err := c.get("/usage", nil, &records); err != nil {
+		return nil, err
+	}
+	return leaderboard.Rank(records, q)
+}
+
+// list the invoices of a period, optionally of an account
+func runInvoice(args []string) error {
+	fs := pflag.NewFlagSet("invoice", pflag.ContinueOnError)
+	o := addGlobalFlags(fs)
+	account := fs.String("account", "", "Only list the invoices of this billing account")
+	c, err := parse(fs, o, args, 1)
+	if err != nil {
+		return err
+	}
+	var invoices []*invoice.Invoice
+	if err := c.get("/invoices", url.Values{"period": {fs.Arg(0)}, "account": {*account}}, &invoices); err != nil {
+		return err
+	}
+	return printOutput(stdout, o.output, invoices, func(w io.Writer) {
+		printRow(w, "NUMBER", "KIND", "ACCOUNT", "PERIOD", "ISSUED", "JOBS", "SUBTOTAL", "TOTAL")
+		for _, inv := range invoices {
+			printRow(w, inv.Number, inv.Kind, inv.Account, inv.Period, inv.IssuedAt.Format("2006-01-02"),
+				len(inv.Jobs), inv.Subtotal.RoundCurrency(), inv.Total.RoundCurrency())
+		}
+	})
+}
+
+// parse parses the flags and the positional arguments of a command
+func parse(fs *pflag.FlagSet, o *globalOptions, args []string, positional int) (client, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != positional {
+		return nil, fmt.Errorf("expected %d arguments, got %d", positional, fs.NArg())
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o.client()
+}
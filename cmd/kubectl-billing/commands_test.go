@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/invoice"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/leaderboard"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	"sigs.k8s.io/yaml"
+)
+
+func testRecord(user, job string, gpuHours int64, cost string, end time.Time) *billing.UsageRecord {
+	usage := billing.NewResourceTime()
+	usage.MilliGPUSeconds = big.NewInt(gpuHours * 3600 * 1000)
+	return &billing.UsageRecord{
+		UserId:    user,
+		Namespace: "default",
+		JobName:   job,
+		Usage:     usage,
+		Cost:      billing.MustParseMoney(cost),
+		End:       end,
+	}
+}
+
+func TestTop(t *testing.T) {
+	now := time.Date(2019, 11, 5, 0, 0, 0, 0, time.UTC)
+	records := []*billing.UsageRecord{
+		testRecord("alice", "train", 1, "10", now),
+		testRecord("alice", "train", 1, "10", now),
+		testRecord("bob", "eval", 4, "8", now),
+		testRecord("carol", "old", 10, "50", now.AddDate(0, -1, 0)),
+	}
+	// a server before the leaderboard only serves the usage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/usage" {
+			http.NotFound(w, r)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(records); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+	c := &directClient{server: server.URL}
+
+	rows, err := top(c, leaderboard.Query{By: "user", Sort: "gpu", From: now.AddDate(0, 0, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Name != "bob" || rows[0].GPUHours != 4 || rows[1].Name != "alice" || rows[1].Attempts != 2 {
+		t.Errorf("unexpected gpu ranking %+v %+v", rows[0], rows[1])
+	}
+
+	rows, err = top(c, leaderboard.Query{By: "job", Sort: "cost", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Name != "default/old" || rows[1].Name != "default/train" || rows[1].Cost != billing.MustParseMoney("20") {
+		t.Errorf("unexpected cost ranking %+v %+v", rows[0], rows[1])
+	}
+
+	if _, err := top(c, leaderboard.Query{By: "user", Sort: "memory"}); err == nil {
+		t.Error("expected an error ranking by an unknown resource")
+	}
+	if _, err := top(c, leaderboard.Query{By: "pod", Sort: "gpu"}); err == nil {
+		t.Error("expected an error grouping by an unknown key")
+	}
+}
+
+// testServer serves the billing api the commands get and keeps the queries
+// by path
+func testServer(t *testing.T) (*httptest.Server, map[string]url.Values) {
+	gpuHours := billing.NewResourceTime()
+	gpuHours.MilliGPUSeconds = big.NewInt(2 * 3600 * 1000)
+	multiplier, _ := billing.ParseFactor("1.5")
+	responses := map[string]interface{}{
+		"/jobs": map[string]*api.JobInfo{
+			"train": {JobName: "train", Namespace: "default", UserId: "alice", GroupId: "vision",
+				Status: &fcapi.FrameworkStatus{State: fcapi.FrameworkAttemptRunning}},
+			"eval": {JobName: "eval", Namespace: "default", UserId: "bob"},
+		},
+		"/usage": []*billing.UsageRecord{
+			{JobName: "train", Namespace: "default", UserId: "alice", Usage: gpuHours, Cost: billing.MustParseMoney("1.5"),
+				End: time.Date(2019, 11, 5, 0, 0, 0, 0, time.UTC)},
+			{JobName: "train", Namespace: "default", UserId: "alice", Usage: billing.NewResourceTime(),
+				Cost: billing.MustParseMoney("2.5"), End: time.Date(2019, 11, 6, 0, 0, 0, 0, time.UTC)},
+		},
+		"/job/cost": &ledger.JobCost{JobName: "train", Attempts: 2, Cost: billing.MustParseMoney("4"),
+			Breakdown: []billing.WindowCost{{PriceVersion: "2019-11", Window: "peak", Multiplier: multiplier,
+				Duration: time.Hour, Usage: gpuHours, Cost: billing.MustParseMoney("4")}}},
+		"/leaderboard": []*leaderboard.Entry{{Rank: 1, Name: "default/train", Attempts: 2, Failures: 1, GPUHours: 2,
+			Cost: billing.MustParseMoney("4")}},
+		"/invoices": []*invoice.Invoice{{Number: "INV-000001", Kind: invoice.KindInvoice, Account: "lab", Period: "2019-11",
+			IssuedAt: time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC), Subtotal: billing.MustParseMoney("10"),
+			Total: billing.MustParseMoney("9")}},
+	}
+	queries := make(map[string]url.Values)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, found := responses[r.URL.Path]
+		if !found {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		queries[r.URL.Path] = r.URL.Query()
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Error(err)
+		}
+	}))
+	return server, queries
+}
+
+// run runs a command against the server and returns what it printed
+func run(t *testing.T, command func(args []string) error, server string, args ...string) string {
+	var buf bytes.Buffer
+	previous := stdout
+	stdout = &buf
+	defer func() { stdout = previous }()
+	if err := command(append([]string{"--server", server + "/"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// row returns the cells of a table line
+func row(t *testing.T, output string, line int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if line >= len(lines) {
+		t.Fatalf("expected %d lines, got %q", line+1, output)
+	}
+	return strings.Join(strings.Fields(lines[line]), " ")
+}
+
+func TestCommands(t *testing.T) {
+	server, queries := testServer(t)
+	defer server.Close()
+
+	output := run(t, runJobs, server.URL, "--user", "alice")
+	if header := row(t, output, 0); header != "NAMESPACE NAME USER GROUP STATE ATTEMPTS GPU HOURS COST" {
+		t.Errorf("unexpected header %q", header)
+	}
+	if train := row(t, output, 1); train != "default train alice vision AttemptRunning 2 2.00 4.00" {
+		t.Errorf("unexpected row %q", train)
+	}
+	if lines := strings.Count(output, "\n"); lines != 2 {
+		t.Errorf("expected only the jobs of alice, got %q", output)
+	}
+
+	var cost ledger.JobCost
+	output = run(t, runCost, server.URL, "-o", "json", "train")
+	if err := json.Unmarshal([]byte(output), &cost); err != nil || cost.Cost != billing.MustParseMoney("4") || len(cost.Breakdown) != 1 {
+		t.Errorf("expected the cost as json, got %q, %v", output, err)
+	}
+	if name := queries["/job/cost"].Get("name"); name != "train" {
+		t.Errorf("expected the cost of train, got %q", name)
+	}
+	output = run(t, runCost, server.URL, "train")
+	if window := row(t, output, 1); window != "2019-11 peak 1.5 1h0m0s 2.00 4.00" {
+		t.Errorf("unexpected window %q", window)
+	}
+	if !strings.HasSuffix(output, "train: 2 attempts, cost 4.00\n") {
+		t.Errorf("expected the total, got %q", output)
+	}
+
+	output = run(t, runTop, server.URL, "--by", "gpu", "--group-by", "job", "--from", "2019-11-01T00:00:00Z",
+		"--to", "2019-12-01T00:00:00Z", "--gpu-type", "v100", "--limit", "5")
+	if header := row(t, output, 0); header != "RANK JOB ATTEMPTS FAILURES CPU HOURS GPU HOURS COST" {
+		t.Errorf("unexpected header %q", header)
+	}
+	if entry := row(t, output, 1); entry != "1 default/train 2 1 0.00 2.00 4.00" {
+		t.Errorf("unexpected entry %q", entry)
+	}
+	if query := queries["/leaderboard"].Encode(); query != "by=job&from=2019-11-01T00%3A00%3A00Z&gpuType=v100&limit=5&sort=gpu&to=2019-12-01T00%3A00%3A00Z" {
+		t.Errorf("unexpected query %s", query)
+	}
+	// all usage is ranked unless a start is given
+	run(t, runTop, server.URL)
+	if query := queries["/leaderboard"].Encode(); query != "by=user&from=1970-01-01T00%3A00%3A00Z&limit=10&sort=cost" {
+		t.Errorf("unexpected query %s", query)
+	}
+
+	var invoices []*invoice.Invoice
+	output = run(t, runInvoice, server.URL, "-o", "yaml", "--account", "lab", "2019-11")
+	if err := yaml.Unmarshal([]byte(output), &invoices); err != nil || len(invoices) != 1 || invoices[0].Total != billing.MustParseMoney("9") {
+		t.Errorf("expected the invoices as yaml, got %q, %v", output, err)
+	}
+	if query := queries["/invoices"].Encode(); query != "account=lab&period=2019-11" {
+		t.Errorf("unexpected query %s", query)
+	}
+	output = run(t, runInvoice, server.URL, "2019-11")
+	if inv := row(t, output, 1); inv != "INV-000001 invoice lab 2019-11 2019-12-01 0 10.00 9.00" {
+		t.Errorf("unexpected invoice %q", inv)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	server, _ := testServer(t)
+	defer server.Close()
+
+	for name, args := range map[string][]string{
+		"missing argument": {"--server", server.URL},
+		"unknown output":   {"--server", server.URL, "-o", "xml", "train"},
+		"failed request":   {"--server", server.URL + "/missing", "train"},
+	} {
+		if err := runCost(args); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// kubectl-billing is a kubectl plugin showing jobs, costs, top consumers and
+// invoices of the billing service. Install it on the PATH and run e.g.
+//
+//	kubectl billing jobs --user alice
+//	kubectl billing cost <framework>
+//	kubectl billing top --by gpu
+//	kubectl billing invoice 2019-11 -o yaml
+//
+// The service is reached through the API server proxy of the current
+// kubeconfig context.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/spf13/pflag"
+)
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "Usage: kubectl billing <command> [flags]\n\nCommands: %v\n", names)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+	command, found := commands[os.Args[1]]
+	if !found {
+		usage()
+		os.Exit(1)
+	}
+	if err := command(os.Args[2:]); err != nil && err != pflag.ErrHelp {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+)
+
+// printOutput writes value as json or yaml, or as the table printed by table
+func printOutput(out io.Writer, format string, value interface{}, table func(w io.Writer)) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case "yaml":
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// printRow writes the cells of a table row separated by tabs
+func printRow(w io.Writer, cells ...interface{}) {
+	values := make([]string, len(cells))
+	for i, cell := range cells {
+		values[i] = fmt.Sprint(cell)
+		if values[i] == "" {
+			values[i] = "<none>"
+		}
+	}
+	fmt.Fprintln(w, strings.Join(values, "\t"))
+}
@@ -2,6 +2,7 @@
 
 cd ${GOPATH}/src/pcl/k8s-billing
 go build -o deploy/build/billing cmd/main.go || exit 0
+go build -o deploy/build/kubectl-billing ./cmd/kubectl-billing || exit 0
 
 cd ${GOPATH}/src/pcl/k8s-billing/deploy/build
 sudo docker build -t k8s-billing:v1.0.1 .
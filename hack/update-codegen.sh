@@ -0,0 +1,16 @@
+#!/usr/bin/env bash
+
+# Generates deepcopy, clientset, listers and informers of the billing CRDs.
+# Needs k8s.io/code-generator checked out next to the repo in GOPATH.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
+CODEGEN_PKG=${CODEGEN_PKG:-$(cd "${SCRIPT_ROOT}"; ls -d -1 ./vendor/k8s.io/code-generator 2>/dev/null || echo ../../../k8s.io/code-generator)}
+
+bash "${CODEGEN_PKG}"/generate-groups.sh "deepcopy,client,informer,lister" \
+  github.com/ruanxingbaozi/k8s-billing/pkg/client github.com/ruanxingbaozi/k8s-billing/pkg/apis \
+  billing:v1 \
+  --go-header-file "${SCRIPT_ROOT}"/hack/boilerplate.go.txt
@@ -0,0 +1,266 @@
+package admission
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/budget"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/estimate"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// AnnotationEstimatedHourlyCost is the estimate written on admitted jobs
+	AnnotationEstimatedHourlyCost = "billing.pcl.ac.cn/estimated-hourly-cost"
+	// AnnotationPriceVersion is the price list version of the estimate
+	AnnotationPriceVersion = "billing.pcl.ac.cn/price-version"
+	// AnnotationBudgetWarning explains why an admitted job may exceed a budget
+	AnnotationBudgetWarning = "billing.pcl.ac.cn/budget-warning"
+)
+
+// Frameworks provides the frameworks of the cluster
+type Frameworks interface {
+	Framework(namespace, name string) (*fcapi.Framework, bool)
+}
+
+// Webhook admits Frameworks and Pods against the budgets of their user,
+// group, namespace and account.
+type Webhook struct {
+	ledger     *ledger.Ledger
+	budgets    *budget.Evaluator
+	frameworks Frameworks
+}
+
+// New creates the admission webhook
+func New(l *ledger.Ledger, budgets *budget.Evaluator, frameworks Frameworks) *Webhook {
+	return &Webhook{ledger: l, budgets: budgets, frameworks: frameworks}
+}
+
+// Decision of admitting a job
+type Decision struct {
+	Allowed  bool
+	Message  string
+	Warnings []string
+	Estimate *estimate.Estimate
+}
+
+// job is what the webhook needs to know about a submitted object
+type job struct {
+	name      string
+	namespace string
+	labels    map[string]string
+	hasAnnots bool
+	estimate  *estimate.Estimate
+	// framework owning a pod and its uid
+	framework    string
+	frameworkUID types.UID
+}
+
+// Validate handles the validating admission review
+func (wh *Webhook) Validate(w http.ResponseWriter, r *http.Request) {
+	wh.serve(w, r, func(req *admissionv1beta1.AdmissionRequest, j *job, d *Decision) *admissionv1beta1.AdmissionResponse {
+		resp := &admissionv1beta1.AdmissionResponse{UID: req.UID, Allowed: d.Allowed}
+		if !d.Allowed {
+			resp.Result = &metav1.Status{
+				Status:  metav1.StatusFailure,
+				Code:    http.StatusForbidden,
+				Reason:  metav1.StatusReasonForbidden,
+				Message: d.Message,
+			}
+		}
+		if len(d.Warnings) > 0 {
+			resp.AuditAnnotations = map[string]string{"budget-warning": strings.Join(d.Warnings, "; ")}
+		}
+		return resp
+	})
+}
+
+// Mutate handles the mutating admission review, it annotates the job with its estimate
+func (wh *Webhook) Mutate(w http.ResponseWriter, r *http.Request) {
+	wh.serve(w, r, func(req *admissionv1beta1.AdmissionRequest, j *job, d *Decision) *admissionv1beta1.AdmissionResponse {
+		// rejecting is left to the validating path
+		resp := &admissionv1beta1.AdmissionResponse{UID: req.UID, Allowed: true}
+		if j == nil || d.Estimate == nil {
+			return resp
+		}
+		annotations := map[string]string{
+			AnnotationEstimatedHourlyCost: d.Estimate.HourlyCost.RoundCurrency().String(),
+			AnnotationPriceVersion:        d.Estimate.PriceVersion,
+		}
+		if len(d.Warnings) > 0 {
+			annotations[AnnotationBudgetWarning] = strings.Join(d.Warnings, "; ")
+		}
+		patch, err := annotationPatch(j.hasAnnots, annotations)
+		if err != nil {
+			glog.Errorf("Failed to patch annotations of %s/%s: %v", j.namespace, j.name, err)
+			return resp
+		}
+		patchType := admissionv1beta1.PatchTypeJSONPatch
+		resp.Patch = patch
+		resp.PatchType = &patchType
+		return resp
+	})
+}
+
+type reviewFunc func(req *admissionv1beta1.AdmissionRequest, j *job, d *Decision) *admissionv1beta1.AdmissionResponse
+
+func (wh *Webhook) serve(w http.ResponseWriter, r *http.Request, review reviewFunc) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ar := admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, &ar); err != nil || ar.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	j, err := wh.decode(ar.Request)
+	d := &Decision{Allowed: true}
+	if err != nil {
+		// never block the cluster on objects the webhook does not understand
+		glog.Errorf("Admitting %s %s/%s without budget check: %v", ar.Request.Kind.Kind, ar.Request.Namespace, ar.Request.Name, err)
+	} else if j != nil {
+		d = wh.decide(j, time.Now())
+	}
+	ar.Response = review(ar.Request, j, d)
+	ar.Request = nil
+
+	resp, err := json.Marshal(ar)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// decode reads the submitted framework or pod, it returns nil for other objects
+func (wh *Webhook) decode(req *admissionv1beta1.AdmissionRequest) (*job, error) {
+	prices := wh.ledger.Prices()
+	now := time.Now()
+	switch req.Kind.Kind {
+	case "Framework":
+		fm := &fcapi.Framework{}
+		if err := json.Unmarshal(req.Object.Raw, fm); err != nil {
+			return nil, err
+		}
+		return &job{
+			name:      fm.Name,
+			namespace: namespaceOf(fm.Namespace, req.Namespace),
+			labels:    fm.Labels,
+			hasAnnots: fm.Annotations != nil,
+			estimate:  estimate.Framework(fm, prices, now),
+		}, nil
+	case "Pod":
+		pod := &v1.Pod{}
+		if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+			return nil, err
+		}
+		j := &job{
+			name:      pod.Name,
+			namespace: namespaceOf(pod.Namespace, req.Namespace),
+			labels:    pod.Labels,
+			hasAnnots: pod.Annotations != nil,
+			estimate:  estimate.Pod(pod, prices, now),
+		}
+		if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "Framework" {
+			j.framework, j.frameworkUID = owner.Name, owner.UID
+		}
+		return j, nil
+	}
+	return nil, nil
+}
+
+// decide checks the budgets covering the job. Exhausted hard budgets reject
+// the job, exhausted soft budgets and budgets with less than an hour of the
+// estimate remaining only warn. Pods owned by an existing framework were
+// admitted with their framework and are not checked again.
+func (wh *Webhook) decide(j *job, now time.Time) *Decision {
+	d := &Decision{Allowed: true, Estimate: j.estimate}
+	if wh.budgets == nil || wh.ownedByFramework(j) {
+		return d
+	}
+	record := &billing.UsageRecord{
+		JobName:   j.name,
+		Namespace: j.namespace,
+		UserId:    j.labels[api.LabelPlatformUserKey],
+		Group:     j.labels[api.LabelPlatformGroupKey],
+	}
+	for _, status := range wh.budgets.Status(now) {
+		if !status.Covers(record, wh.budgets.Accounts()) {
+			continue
+		}
+		if status.Exhausted(status.Spent) {
+			d.Allowed = false
+			d.Message = fmt.Sprintf("budget %s of %s %s is exhausted: spent %v of %v",
+				status.Name, status.Scope, status.Subject, status.Spent, status.Amount)
+			return d
+		}
+		if status.Remaining <= 0 {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("budget %s is exceeded: spent %v of %v", status.Name, status.Spent, status.Amount))
+		} else if j.estimate != nil && status.Remaining < j.estimate.HourlyCost {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("budget %s has %v left, less than an hour of the estimated %v/h",
+				status.Name, status.Remaining, j.estimate.HourlyCost.RoundCurrency()))
+		}
+	}
+	return d
+}
+
+// ownedByFramework returns whether the framework the job claims to be owned
+// by exists, a pod may name any owner
+func (wh *Webhook) ownedByFramework(j *job) bool {
+	if j.framework == "" || wh.frameworks == nil {
+		return false
+	}
+	fm, found := wh.frameworks.Framework(j.namespace, j.framework)
+	return found && fm.UID == j.frameworkUID
+}
+
+func namespaceOf(namespace, requestNamespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+	return requestNamespace
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// annotationPatch returns a json patch adding the annotations
+func annotationPatch(hasAnnotations bool, annotations map[string]string) ([]byte, error) {
+	if !hasAnnotations {
+		return json.Marshal([]patchOperation{{Op: "add", Path: "/metadata/annotations", Value: annotations}})
+	}
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var ops []patchOperation
+	for _, key := range keys {
+		ops = append(ops, patchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + strings.Replace(key, "/", "~1", -1),
+			Value: annotations[key],
+		})
+	}
+	return json.Marshal(ops)
+}
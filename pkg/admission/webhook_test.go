@@ -0,0 +1,120 @@
+package admission
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/budget"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/estimate"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fakeFrameworks are the frameworks of the cluster by namespace/name
+type fakeFrameworks map[string]*fcapi.Framework
+
+func (f fakeFrameworks) Framework(namespace, name string) (*fcapi.Framework, bool) {
+	fm, found := f[namespace+"/"+name]
+	return fm, found
+}
+
+func newTestWebhook(hard bool, now time.Time) *Webhook {
+	config := billing.DefaultConfig()
+	config.Rates.CPUCoreHour = billing.MustParseMoney("1")
+	config.Budgets = []billing.Budget{
+		{Name: "ns01", Scope: billing.ScopeNamespace, Subject: "ns01", Amount: billing.MustParseMoney("10"), Hard: hard},
+		{Name: "u1", Scope: billing.ScopeUser, Subject: "u1", Amount: billing.MustParseMoney("100")},
+	}
+	l := ledger.New(config)
+	// 12 core hours spent in ns01 this month
+	l.Finalize(nil, &api.PodInfo{
+		UID:         "pod-1",
+		Namespace:   "ns01",
+		Status:      api.PodStatus{Phase: v1.PodSucceeded},
+		RunningTime: metav1.NewTime(now.Add(-2 * time.Minute)),
+		CompateTime: metav1.NewTime(now.Add(-time.Minute)),
+		Requests:    billing.Requests{MilliCPU: 720000},
+	})
+	frameworks := fakeFrameworks{"ns01/fm": {ObjectMeta: metav1.ObjectMeta{Name: "fm", Namespace: "ns01", UID: "fm-1"}}}
+	return New(l, budget.NewEvaluator(l, nil, nil), frameworks)
+}
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+	hourly := &estimate.Estimate{HourlyCost: billing.MustParseMoney("200")}
+	tests := []struct {
+		name     string
+		hard     bool
+		job      *job
+		allowed  bool
+		warnings int
+	}{
+		{"hard budget exhausted", true, &job{namespace: "ns01", estimate: hourly}, false, 0},
+		{"soft budget exceeded", false, &job{namespace: "ns01", estimate: hourly}, true, 1},
+		{"not enough left for an hour", false, &job{namespace: "ns02", labels: map[string]string{api.LabelPlatformUserKey: "u1"}, estimate: hourly}, true, 1},
+		{"pods of frameworks are not checked", true, &job{namespace: "ns01", framework: "fm", frameworkUID: "fm-1", estimate: hourly}, true, 0},
+		{"pods of unknown frameworks are checked", true, &job{namespace: "ns01", framework: "other", frameworkUID: "fm-1", estimate: hourly}, false, 0},
+		{"pods of replaced frameworks are checked", true, &job{namespace: "ns01", framework: "fm", frameworkUID: "fm-0", estimate: hourly}, false, 0},
+		{"no budget", true, &job{namespace: "ns02", estimate: hourly}, true, 0},
+	}
+	for _, test := range tests {
+		d := newTestWebhook(test.hard, now).decide(test.job, now)
+		if d.Allowed != test.allowed || len(d.Warnings) != test.warnings {
+			t.Errorf("%s: expected allowed %v with %d warnings, got %v with %v", test.name, test.allowed, test.warnings, d.Allowed, d.Warnings)
+		}
+	}
+}
+
+func TestDecodePodOwner(t *testing.T) {
+	wh := newTestWebhook(true, time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC))
+	isController := true
+	for name, test := range map[string]struct {
+		meta      metav1.ObjectMeta
+		framework string
+	}{
+		"annotated": {meta: metav1.ObjectMeta{Name: "fm-worker-0", Namespace: "ns01",
+			Annotations: map[string]string{api.AnnotationFrameworkNameKey: "fm"}}},
+		"owned": {meta: metav1.ObjectMeta{Name: "fm-worker-0", Namespace: "ns01",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Framework", Name: "fm", UID: "fm-1", Controller: &isController}}}, framework: "fm"},
+	} {
+		raw, _ := json.Marshal(&v1.Pod{ObjectMeta: test.meta})
+		j, err := wh.decode(&admissionv1beta1.AdmissionRequest{Kind: metav1.GroupVersionKind{Kind: "Pod"},
+			Object: runtime.RawExtension{Raw: raw}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.framework != test.framework {
+			t.Errorf("%s: expected framework %q, got %q", name, test.framework, j.framework)
+		}
+	}
+}
+
+func TestAnnotationPatch(t *testing.T) {
+	annotations := map[string]string{AnnotationEstimatedHourlyCost: "1.50"}
+
+	patch, err := annotationPatch(false, annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []patchOperation
+	json.Unmarshal(patch, &ops)
+	if len(ops) != 1 || ops[0].Path != "/metadata/annotations" {
+		t.Errorf("expected the annotations to be added at once, got %s", patch)
+	}
+
+	patch, err = annotationPatch(true, annotations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"op":"add","path":"/metadata/annotations/billing.pcl.ac.cn~1estimated-hourly-cost","value":"1.50"}]`
+	if string(patch) != expected {
+		t.Errorf("expected %s, got %s", expected, patch)
+	}
+}
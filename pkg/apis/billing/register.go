@@ -0,0 +1,4 @@
+package billing
+
+// GroupName is the api group of the billing custom resources
+const GroupName = "billing.pcl.ac.cn"
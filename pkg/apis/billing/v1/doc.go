@@ -0,0 +1,5 @@
+// +k8s:deepcopy-gen=package
+// +groupName=billing.pcl.ac.cn
+
+// Package v1 is the v1 version of the billing custom resources.
+package v1
@@ -0,0 +1,135 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PriceList is one version of the rate card. A price list without
+// effectiveFrom holds the default rates used outside of all versions.
+type PriceList struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PriceListSpec   `json:"spec"`
+	Status PriceListStatus `json:"status,omitempty"`
+}
+
+// PriceListSpec holds the hourly prices as decimal strings.
+type PriceListSpec struct {
+	// replaces the rates of the config if unset, only one price list may
+	// omit it, further ones are invalid
+	EffectiveFrom *metav1.Time `json:"effectiveFrom,omitempty"`
+	// exclusive, open if unset or ended by the next price list
+	EffectiveTo *metav1.Time `json:"effectiveTo,omitempty"`
+
+	CPUCoreHour    string            `json:"cpuCoreHour,omitempty"`
+	MemoryGiBHour  string            `json:"memoryGiBHour,omitempty"`
+	DefaultGPUHour string            `json:"defaultGpuHour,omitempty"`
+	GPUHour        map[string]string `json:"gpuHour,omitempty"`
+
+	TimeOfUse *TimeOfUse `json:"timeOfUse,omitempty"`
+}
+
+// TimeOfUse multiplies prices in time windows
+type TimeOfUse struct {
+	TimeZone string `json:"timeZone,omitempty"`
+	// holidays as YYYY-MM-DD
+	Holidays []string      `json:"holidays,omitempty"`
+	Windows  []PriceWindow `json:"windows,omitempty"`
+}
+
+// PriceWindow is a range of hours on some days with a price multiplier
+type PriceWindow struct {
+	Name       string   `json:"name"`
+	Days       []string `json:"days,omitempty"`
+	StartHour  int32    `json:"startHour"`
+	EndHour    int32    `json:"endHour"`
+	Multiplier string   `json:"multiplier"`
+}
+
+type PriceListPhase string
+
+const (
+	PriceListPending PriceListPhase = "Pending"
+	PriceListActive  PriceListPhase = "Active"
+	PriceListExpired PriceListPhase = "Expired"
+	PriceListInvalid PriceListPhase = "Invalid"
+)
+
+// PriceListStatus is reconciled by the billing service
+type PriceListStatus struct {
+	Phase   PriceListPhase `json:"phase,omitempty"`
+	Message string         `json:"message,omitempty"`
+	// cost billed with this price list
+	CurrentSpend     string       `json:"currentSpend,omitempty"`
+	LastComputedTime *metav1.Time `json:"lastComputedTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// PriceListList is a list of PriceList
+type PriceListList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []PriceList `json:"items"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BillingAccount is charged for the usage of its users, groups and namespaces.
+type BillingAccount struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BillingAccountSpec   `json:"spec"`
+	Status BillingAccountStatus `json:"status,omitempty"`
+}
+
+// BillingAccountSpec maps usage to the account. A platform-user mapping wins
+// over a platform-group mapping which wins over a namespace mapping.
+type BillingAccountSpec struct {
+	DisplayName string   `json:"displayName,omitempty"`
+	Users       []string `json:"users,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Namespaces  []string `json:"namespaces,omitempty"`
+	// alerts when the spending of the account crosses thresholds
+	Budget *BudgetSpec `json:"budget,omitempty"`
+}
+
+// BudgetSpec limits the cost of an account per period
+type BudgetSpec struct {
+	// decimal string, e.g. "1000"
+	Amount string `json:"amount"`
+	// monthly or quarterly, monthly by default
+	Period string `json:"period,omitempty"`
+	// percentages of the amount, 50/80/100 by default
+	Thresholds []int32 `json:"thresholds,omitempty"`
+	// stop the running frameworks of the account when spent, e.g. a prepaid balance
+	Hard bool `json:"hard,omitempty"`
+}
+
+// BillingAccountStatus is reconciled by the billing service
+type BillingAccountStatus struct {
+	// cost of the current calendar month
+	CurrentSpend     string       `json:"currentSpend,omitempty"`
+	PeriodStart      *metav1.Time `json:"periodStart,omitempty"`
+	UsageRecords     int64        `json:"usageRecords,omitempty"`
+	LastComputedTime *metav1.Time `json:"lastComputedTime,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BillingAccountList is a list of BillingAccount
+type BillingAccountList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []BillingAccount `json:"items"`
+}
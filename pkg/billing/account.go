@@ -0,0 +1,124 @@
+package billing
+
+import (
+	"sort"
+	"sync"
+)
+
+// Account is charged for the usage of its users, groups and namespaces.
+type Account struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Users       []string `json:"users,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Namespaces  []string `json:"namespaces,omitempty"`
+	// budget of the whole account
+	Budget *Budget `json:"budget,omitempty"`
+}
+
+// AccountDirectory maps usage to billing accounts. A user mapping wins over a
+// group mapping which wins over a namespace mapping, if several accounts claim
+// the same user, group or namespace the account with the lowest name wins.
+type AccountDirectory struct {
+	sync.RWMutex
+
+	accounts    map[string]*Account
+	byUser      map[string]*Account
+	byGroup     map[string]*Account
+	byNamespace map[string]*Account
+}
+
+// NewAccountDirectory returns an empty directory
+func NewAccountDirectory() *AccountDirectory {
+	d := &AccountDirectory{}
+	d.Set(nil)
+	return d
+}
+
+// Set replaces all accounts of the directory
+func (d *AccountDirectory) Set(accounts []*Account) {
+	sorted := make([]*Account, len(accounts))
+	copy(sorted, accounts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	byName := make(map[string]*Account)
+	byUser := make(map[string]*Account)
+	byGroup := make(map[string]*Account)
+	byNamespace := make(map[string]*Account)
+	index := func(m map[string]*Account, keys []string, account *Account) {
+		for _, key := range keys {
+			if _, found := m[key]; !found {
+				m[key] = account
+			}
+		}
+	}
+	for _, account := range sorted {
+		byName[account.Name] = account
+		index(byUser, account.Users, account)
+		index(byGroup, account.Groups, account)
+		index(byNamespace, account.Namespaces, account)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.accounts, d.byUser, d.byGroup, d.byNamespace = byName, byUser, byGroup, byNamespace
+}
+
+// Get returns the account with the given name, nil if there is none
+func (d *AccountDirectory) Get(name string) *Account {
+	d.RLock()
+	defer d.RUnlock()
+	return d.accounts[name]
+}
+
+// List returns all accounts ordered by name
+func (d *AccountDirectory) List() []*Account {
+	d.RLock()
+	defer d.RUnlock()
+
+	accounts := make([]*Account, 0, len(d.accounts))
+	for _, account := range d.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts
+}
+
+// Resolve returns the account charged for usage of the user, group and namespace, nil if there is none
+func (d *AccountDirectory) Resolve(user, group, namespace string) *Account {
+	d.RLock()
+	defer d.RUnlock()
+
+	if account, found := d.byUser[user]; found && user != "" {
+		return account
+	}
+	if account, found := d.byGroup[group]; found && group != "" {
+		return account
+	}
+	if account, found := d.byNamespace[namespace]; found && namespace != "" {
+		return account
+	}
+	return nil
+}
+
+// AccountOf returns the account charged for the record, nil if there is none
+func (d *AccountDirectory) AccountOf(record *UsageRecord) *Account {
+	return d.Resolve(record.UserId, record.Group, record.Namespace)
+}
+
+// BilledTo returns the name of the account charged for the record. Records of
+// no account are billed to their user, group or namespace.
+func (d *AccountDirectory) BilledTo(record *UsageRecord) string {
+	if d != nil {
+		if account := d.AccountOf(record); account != nil {
+			return account.Name
+		}
+	}
+	switch {
+	case record.UserId != "":
+		return "user:" + record.UserId
+	case record.Group != "":
+		return "group:" + record.Group
+	}
+	return "namespace:" + record.Namespace
+}
@@ -0,0 +1,112 @@
+package billing
+
+import (
+	"fmt"
+	"time"
+)
+
+// BudgetScope is what a budget is attached to
+type BudgetScope string
+
+const (
+	ScopeUser      BudgetScope = "user"
+	ScopeGroup     BudgetScope = "group"
+	ScopeNamespace BudgetScope = "namespace"
+	ScopeAccount   BudgetScope = "account"
+)
+
+// BudgetPeriod is how often a budget starts over
+type BudgetPeriod string
+
+const (
+	Monthly   BudgetPeriod = "monthly"
+	Quarterly BudgetPeriod = "quarterly"
+)
+
+// DefaultThresholds are the percentages of a budget alerts are sent at
+var DefaultThresholds = []int{50, 80, 100}
+
+// Budget limits the cost of a user (platform-user), group (platform-group),
+// namespace or billing account per period.
+type Budget struct {
+	Name    string      `json:"name"`
+	Scope   BudgetScope `json:"scope"`
+	Subject string      `json:"subject"`
+	// monthly by default
+	Period BudgetPeriod `json:"period,omitempty"`
+	Amount Money        `json:"amount"`
+	// percentages of the amount alerts are sent at, 50/80/100 by default
+	Thresholds []int `json:"thresholds,omitempty"`
+	// stop running jobs when the amount is spent, e.g. a prepaid balance
+	Hard bool `json:"hard,omitempty"`
+}
+
+// Validate checks the budget is usable
+func (b *Budget) Validate() error {
+	if b.Name == "" || b.Subject == "" {
+		return fmt.Errorf("budget needs a name and a subject")
+	}
+	switch b.Scope {
+	case ScopeUser, ScopeGroup, ScopeNamespace, ScopeAccount:
+	default:
+		return fmt.Errorf("budget %s has unknown scope %q", b.Name, b.Scope)
+	}
+	switch b.Period {
+	case Monthly, Quarterly, "":
+	default:
+		return fmt.Errorf("budget %s has unknown period %q", b.Name, b.Period)
+	}
+	if b.Amount <= 0 {
+		return fmt.Errorf("budget %s needs a positive amount", b.Name)
+	}
+	for _, threshold := range b.Thresholds {
+		if threshold <= 0 {
+			return fmt.Errorf("budget %s has invalid threshold %d", b.Name, threshold)
+		}
+	}
+	return nil
+}
+
+// GetThresholds returns the alert thresholds in percent
+func (b *Budget) GetThresholds() []int {
+	if len(b.Thresholds) == 0 {
+		return DefaultThresholds
+	}
+	return b.Thresholds
+}
+
+// Exhausted checks whether a hard budget is spent
+func (b *Budget) Exhausted(spent Money) bool {
+	return b.Hard && spent >= b.Amount
+}
+
+// Bounds returns the period [start, end) containing t
+func (p BudgetPeriod) Bounds(t time.Time) (time.Time, time.Time) {
+	month := t.Month()
+	months := 1
+	if p == Quarterly {
+		month = (month-1)/3*3 + 1
+		months = 3
+	}
+	start := time.Date(t.Year(), month, 1, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, months, 0)
+}
+
+// Covers checks whether the record is charged to the budget
+func (b *Budget) Covers(record *UsageRecord, accounts *AccountDirectory) bool {
+	switch b.Scope {
+	case ScopeUser:
+		return record.UserId == b.Subject
+	case ScopeGroup:
+		return record.Group == b.Subject
+	case ScopeNamespace:
+		return record.Namespace == b.Subject
+	case ScopeAccount:
+		if accounts == nil {
+			return false
+		}
+		account := accounts.AccountOf(record)
+		return account != nil && account.Name == b.Subject
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetPeriodBounds(t *testing.T) {
+	at := time.Date(2019, 8, 17, 13, 0, 0, 0, time.UTC)
+	tests := []struct {
+		period     BudgetPeriod
+		start, end time.Time
+	}{
+		{Monthly, time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{"", time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{Quarterly, time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		start, end := test.period.Bounds(at)
+		if !start.Equal(test.start) || !end.Equal(test.end) {
+			t.Errorf("%q: expected [%v, %v), got [%v, %v)", test.period, test.start, test.end, start, end)
+		}
+	}
+}
+
+func TestBudgetCovers(t *testing.T) {
+	accounts := NewAccountDirectory()
+	accounts.Set([]*Account{{Name: "lab", Namespaces: []string{"ns01"}}})
+	record := &UsageRecord{UserId: "u1", Group: "g1", Namespace: "ns01"}
+
+	tests := []struct {
+		budget Budget
+		covers bool
+	}{
+		{Budget{Scope: ScopeUser, Subject: "u1"}, true},
+		{Budget{Scope: ScopeUser, Subject: "u2"}, false},
+		{Budget{Scope: ScopeGroup, Subject: "g1"}, true},
+		{Budget{Scope: ScopeNamespace, Subject: "ns02"}, false},
+		{Budget{Scope: ScopeAccount, Subject: "lab"}, true},
+		{Budget{Scope: ScopeAccount, Subject: "other"}, false},
+	}
+	for _, test := range tests {
+		if covers := test.budget.Covers(record, accounts); covers != test.covers {
+			t.Errorf("%s %s: expected %v, got %v", test.budget.Scope, test.budget.Subject, test.covers, covers)
+		}
+	}
+}
+
+func TestBudgetExhausted(t *testing.T) {
+	soft := Budget{Amount: MustParseMoney("100")}
+	hard := Budget{Amount: MustParseMoney("100"), Hard: true}
+	if soft.Exhausted(MustParseMoney("150")) {
+		t.Errorf("a soft budget is never exhausted")
+	}
+	if hard.Exhausted(MustParseMoney("99.99")) {
+		t.Errorf("expected hard budget not exhausted below its amount")
+	}
+	if !hard.Exhausted(MustParseMoney("100")) {
+		t.Errorf("expected hard budget exhausted at its amount")
+	}
+}
@@ -0,0 +1,90 @@
+package billing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the billing configuration, read from a yaml or json file.
+type Config struct {
+	// rates used outside of all price versions
+	Rates RateCard `json:"rates"`
+	// versioned rates with effective dates
+	PriceHistory []RateCardVersion `json:"priceHistory,omitempty"`
+	Policies     PolicySet         `json:"policies"`
+	Budgets      []Budget          `json:"budgets,omitempty"`
+	// ISO 4217 code of the currency of all amounts, USD if empty
+	Currency string `json:"currency,omitempty"`
+	// invoicing by billing account
+	Discounts []Discount `json:"discounts,omitempty"`
+	Credits   []Credit   `json:"credits,omitempty"`
+
+	prices *PriceList
+}
+
+// DefaultConfig bills per second without any minimum charge and free resources.
+func DefaultConfig() *Config {
+	config := &Config{}
+	config.prices = SinglePriceList(&config.Rates)
+	return config
+}
+
+// Prices returns the versioned price list of the config
+func (c *Config) Prices() *PriceList {
+	if c.prices == nil {
+		c.prices = SinglePriceList(&c.Rates)
+	}
+	return c.prices
+}
+
+// LoadConfig reads the billing configuration from path, an empty path gives the default config.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return DefaultConfig(), nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read billing config %s: %v", path, err)
+	}
+	config := DefaultConfig()
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse billing config %s: %v", path, err)
+	}
+	if err := config.Policies.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid billing config %s: %v", path, err)
+	}
+	for i := range config.Budgets {
+		if err := config.Budgets[i].Validate(); err != nil {
+			return nil, fmt.Errorf("invalid billing config %s: %v", path, err)
+		}
+	}
+	for i := range config.Discounts {
+		if err := config.Discounts[i].Validate(); err != nil {
+			return nil, fmt.Errorf("invalid billing config %s: %v", path, err)
+		}
+	}
+	for i := range config.Credits {
+		if err := config.Credits[i].Validate(); err != nil {
+			return nil, fmt.Errorf("invalid billing config %s: %v", path, err)
+		}
+	}
+	if tou := config.Rates.TimeOfUse; tou != nil {
+		if err := tou.Load(filepath.Dir(path)); err != nil {
+			return nil, fmt.Errorf("invalid time of use pricing in %s: %v", path, err)
+		}
+	}
+	for _, version := range config.PriceHistory {
+		if tou := version.TimeOfUse; tou != nil {
+			if err := tou.Load(filepath.Dir(path)); err != nil {
+				return nil, fmt.Errorf("invalid time of use pricing of version %s in %s: %v", version.Version, path, err)
+			}
+		}
+	}
+	if config.prices, err = NewPriceList(&config.Rates, config.PriceHistory); err != nil {
+		return nil, fmt.Errorf("invalid price history in %s: %v", path, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,62 @@
+package billing
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Discount reduces every invoice of an account by a percentage
+type Discount struct {
+	Name    string `json:"name"`
+	Account string `json:"account"`
+	// e.g. "10" for 10%
+	Percent     Factor `json:"percent"`
+	Description string `json:"description,omitempty"`
+}
+
+// Validate checks the discount is usable
+func (d *Discount) Validate() error {
+	if d.Name == "" || d.Account == "" {
+		return fmt.Errorf("discount needs a name and an account")
+	}
+	percent := d.Percent.Rat()
+	if d.Percent.rat == nil || percent.Sign() <= 0 || percent.Cmp(big.NewRat(100, 1)) > 0 {
+		return fmt.Errorf("discount %s needs a percentage in (0, 100]", d.Name)
+	}
+	return nil
+}
+
+// Of returns the discount of amount, rounded once to the currency precision
+func (d *Discount) Of(amount Money) Money {
+	// amount * percent / 100 in cents
+	unit := int64(MoneyScale / 100)
+	cents := new(big.Rat).Mul(big.NewRat(int64(amount), unit*100), d.Percent.Rat())
+	return Money(roundHalfEven(cents) * unit)
+}
+
+// Credit is an amount granted to an account once, it is used up by the
+// invoices of the account until nothing is left.
+type Credit struct {
+	Name    string `json:"name"`
+	Account string `json:"account"`
+	Amount  Money  `json:"amount"`
+	// first billing period the credit applies to, e.g. 2019-09, all if empty
+	From        string `json:"from,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Validate checks the credit is usable
+func (c *Credit) Validate() error {
+	if c.Name == "" || c.Account == "" {
+		return fmt.Errorf("credit needs a name and an account")
+	}
+	if c.Amount <= 0 {
+		return fmt.Errorf("credit %s needs a positive amount", c.Name)
+	}
+	return nil
+}
+
+// AppliesTo checks whether the credit can be used in the period
+func (c *Credit) AppliesTo(period string) bool {
+	return c.From == "" || c.From <= period
+}
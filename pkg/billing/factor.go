@@ -0,0 +1,62 @@
+package billing
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Factor is an exact multiplier written as a decimal like "1.5" or "0.75".
+type Factor struct {
+	rat *big.Rat
+}
+
+// ParseFactor parses a decimal or fraction multiplier
+func ParseFactor(s string) (Factor, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Factor{}, fmt.Errorf("invalid multiplier %q", s)
+	}
+	if rat.Sign() < 0 {
+		return Factor{}, fmt.Errorf("multiplier must not be negative: %q", s)
+	}
+	return Factor{rat: rat}, nil
+}
+
+// Rat returns the multiplier, an unset factor is one
+func (f Factor) Rat() *big.Rat {
+	if f.rat == nil {
+		return big.NewRat(1, 1)
+	}
+	return new(big.Rat).Set(f.rat)
+}
+
+// String formats the factor as decimal
+func (f Factor) String() string {
+	str := f.Rat().FloatString(MoneyDecimals)
+	str = strings.TrimRight(str, "0")
+	return strings.TrimSuffix(str, ".")
+}
+
+// MarshalJSON writes the factor as a decimal string
+func (f Factor) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(f.String())), nil
+}
+
+// UnmarshalJSON reads a decimal string or a json number without converting it to float
+func (f *Factor) UnmarshalJSON(data []byte) error {
+	str := string(data)
+	if str == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(str); err == nil {
+		str = unquoted
+	}
+	v, err := ParseFactor(str)
+	if err != nil {
+		return err
+	}
+	*f = v
+	return nil
+}
@@ -0,0 +1,159 @@
+package billing
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MoneyDecimals is the number of decimals a Money keeps
+	MoneyDecimals = 6
+	// MoneyScale is the number of micro units of one currency unit
+	MoneyScale = 1000000
+	// CurrencyDecimals is the precision of charged amounts
+	CurrencyDecimals = 2
+)
+
+// Money is an exact amount of currency in micro units (1e-6). Amounts are
+// written as decimal strings in json so they never pass through a float.
+type Money int64
+
+// ParseMoney parses a decimal amount like "12", "-0.5" or "0.000125".
+func ParseMoney(s string) (Money, error) {
+	str := strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		negative = str[0] == '-'
+		str = str[1:]
+	}
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(fracPart) > MoneyDecimals {
+		return 0, fmt.Errorf("amount %q has more than %d decimals", s, MoneyDecimals)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	digits := intPart + fracPart + strings.Repeat("0", MoneyDecimals-len(fracPart))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	v, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %v", s, err)
+	}
+	if negative {
+		v = -v
+	}
+	return Money(v), nil
+}
+
+// MustParseMoney is like ParseMoney but panics on an invalid amount, for constants and tests.
+func MustParseMoney(s string) Money {
+	m, err := ParseMoney(s)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// String formats the amount with at least the currency decimals, e.g. "1.50" or "0.000125".
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+	}
+	abs := uint64(v)
+	if v < 0 {
+		abs = uint64(-v)
+	}
+	frac := fmt.Sprintf("%06d", abs%MoneyScale)
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) < CurrencyDecimals {
+		frac += strings.Repeat("0", CurrencyDecimals-len(frac))
+	}
+	return fmt.Sprintf("%s%d.%s", sign, abs/MoneyScale, frac)
+}
+
+// MarshalJSON writes the amount as a decimal string
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(m.String())), nil
+}
+
+// UnmarshalJSON reads a decimal string or a json number without converting it to float
+func (m *Money) UnmarshalJSON(data []byte) error {
+	str := string(data)
+	if str == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(str); err == nil {
+		str = unquoted
+	}
+	v, err := ParseMoney(str)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Round rounds the amount to the given number of decimals, ties go to the
+// even neighbour (banker's rounding).
+func (m Money) Round(decimals int) Money {
+	if decimals >= MoneyDecimals {
+		return m
+	}
+	unit := int64(1)
+	for i := decimals; i < MoneyDecimals; i++ {
+		unit *= 10
+	}
+	return Money(roundHalfEven(big.NewRat(int64(m), unit)) * unit)
+}
+
+// RoundCurrency rounds the amount to the currency precision
+func (m Money) RoundCurrency() Money {
+	return m.Round(CurrencyDecimals)
+}
+
+// MulRat multiplies the amount by an exact factor, rounding half to even to a micro unit.
+func (m Money) MulRat(factor *big.Rat) Money {
+	product := new(big.Rat).Mul(big.NewRat(int64(m), 1), factor)
+	return Money(roundHalfEven(product))
+}
+
+// Float returns the amount as float, for metrics only
+func (m Money) Float() float64 {
+	return float64(m) / MoneyScale
+}
+
+// roundHalfEven rounds r to the nearest integer, ties go to the even integer.
+func roundHalfEven(r *big.Rat) int64 {
+	num := new(big.Int).Set(r.Num())
+	den := r.Denom()
+	negative := num.Sign() < 0
+	num.Abs(num)
+
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	switch new(big.Int).Lsh(rem, 1).Cmp(den) {
+	case 1:
+		quo.Add(quo, big.NewInt(1))
+	case 0:
+		if quo.Bit(0) == 1 {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	if negative {
+		quo.Neg(quo)
+	}
+	return quo.Int64()
+}
@@ -0,0 +1,129 @@
+package billing
+
+import (
+	"encoding/json"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Money
+		str      string
+	}{
+		{"0", 0, "0.00"},
+		{"12", 12000000, "12.00"},
+		{"1.5", 1500000, "1.50"},
+		{"-0.5", -500000, "-0.50"},
+		{".25", 250000, "0.25"},
+		{"0.000125", 125, "0.000125"},
+		{"+3.10", 3100000, "3.10"},
+	}
+	for _, test := range tests {
+		got, err := ParseMoney(test.in)
+		if err != nil {
+			t.Errorf("ParseMoney(%q): unexpected error %v", test.in, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("ParseMoney(%q): expected %d, got %d", test.in, test.expected, got)
+		}
+		if got.String() != test.str {
+			t.Errorf("String(%q): expected %s, got %s", test.in, test.str, got.String())
+		}
+	}
+	for _, in := range []string{"", ".", "1.0000001", "1e3", "abc", "1.2.3"} {
+		if _, err := ParseMoney(in); err == nil {
+			t.Errorf("ParseMoney(%q): expected an error", in)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	rates := &RateCard{}
+	if err := json.Unmarshal([]byte(`{"cpuCoreHour": 0.1, "memoryGiBHour": "0.005", "gpuHour": {"v100": 4}}`), rates); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if rates.CPUCoreHour != 100000 || rates.MemoryGiBHour != 5000 || rates.GPUHour["v100"] != 4000000 {
+		t.Errorf("unexpected rates %+v", rates)
+	}
+	data, err := json.Marshal(rates.CPUCoreHour)
+	if err != nil || string(data) != `"0.10"` {
+		t.Errorf("expected \"0.10\", got %s (%v)", data, err)
+	}
+}
+
+func TestMoneyRound(t *testing.T) {
+	tests := []struct {
+		in       string
+		decimals int
+		expected string
+	}{
+		{"0.125", 2, "0.12"},
+		{"0.135", 2, "0.14"},
+		{"-0.125", 2, "-0.12"},
+		{"-0.135", 2, "-0.14"},
+		{"2.5", 0, "2.00"},
+		{"3.5", 0, "4.00"},
+		{"0.000125", 5, "0.00012"},
+		{"1.999999", 6, "1.999999"},
+	}
+	for _, test := range tests {
+		if got := MustParseMoney(test.in).Round(test.decimals).String(); got != test.expected {
+			t.Errorf("Round(%s, %d): expected %s, got %s", test.in, test.decimals, test.expected, got)
+		}
+	}
+}
+
+func TestCostIsExact(t *testing.T) {
+	rates := &RateCard{
+		CPUCoreHour:    MustParseMoney("0.033333"),
+		MemoryGiBHour:  MustParseMoney("0.000777"),
+		DefaultGPUHour: MustParseMoney("1.234567"),
+	}
+	// one milli cpu for one second
+	rt := Requests{MilliCPU: 1}.Over(time.Second)
+	if got := rates.Cost(rt, ""); got != 0 {
+		t.Errorf("expected 0.033333/3600000 to round to 0, got %v", got)
+	}
+	rt = Requests{MilliCPU: 1000}.Over(3600 * time.Second)
+	if got := rates.Cost(rt, ""); got != MustParseMoney("0.033333") {
+		t.Errorf("expected 0.033333, got %v", got)
+	}
+
+	// totals do not depend on the order records are summed in
+	r := rand.New(rand.NewSource(1))
+	records := make([]*UsageRecord, 1000)
+	for i := range records {
+		records[i] = &UsageRecord{
+			Requests: Requests{
+				MilliCPU: r.Int63n(64000),
+				Memory:   r.Int63n(512 * bytesPerGiB),
+				MilliGPU: r.Int63n(8) * 1000,
+			},
+			Start: time.Unix(0, 0),
+			End:   time.Unix(r.Int63n(30*24*3600), r.Int63n(1e9)),
+		}
+		records[i].Finalize(BillingPolicy{}, SinglePriceList(rates))
+	}
+	total, usage := TotalCost(records), TotalUsage(records)
+	r.Shuffle(len(records), func(i, j int) { records[i], records[j] = records[j], records[i] })
+	if got := TotalCost(records); got != total {
+		t.Errorf("expected total %v, got %v after reordering", total, got)
+	}
+	if got := TotalUsage(records); got.MemoryByteSeconds.Cmp(usage.MemoryByteSeconds) != 0 {
+		t.Errorf("expected memory usage %v, got %v after reordering", usage.MemoryByteSeconds, got.MemoryByteSeconds)
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	if got := MustParseMoney("1.000001").MulRat(big.NewRat(1, 2)); got != MustParseMoney("0.5") {
+		t.Errorf("expected 0.50, got %v", got)
+	}
+	if got := MustParseMoney("1.000003").MulRat(big.NewRat(1, 2)); got != MustParseMoney("0.500002") {
+		t.Errorf("expected 0.500002, got %v", got)
+	}
+}
@@ -0,0 +1,133 @@
+package billing
+
+import (
+	"fmt"
+	"time"
+)
+
+// Granularity is the unit a pod attempt's runtime is rounded up to before it is charged.
+type Granularity string
+
+const (
+	PerSecond Granularity = "second"
+	PerMinute Granularity = "minute"
+	PerHour   Granularity = "hour"
+)
+
+// Duration returns the length of one billing unit.
+func (g Granularity) Duration() (time.Duration, error) {
+	switch g {
+	case PerSecond, "":
+		return time.Second, nil
+	case PerMinute:
+		return time.Minute, nil
+	case PerHour:
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown billing granularity %q", g)
+}
+
+// BillingPolicy describes how the runtime of a pod attempt is turned into a charge.
+type BillingPolicy struct {
+	// Granularity the runtime is rounded up to, default per second
+	Granularity Granularity `json:"granularity,omitempty"`
+	// MinimumCharge is the lowest amount charged for a single pod attempt
+	MinimumCharge Money `json:"minimumCharge,omitempty"`
+	// WaivePlatformFailures does not charge attempts which failed because of the platform
+	WaivePlatformFailures bool `json:"waivePlatformFailures,omitempty"`
+}
+
+// PlatformFailureReasons are the pod status reasons of attempts which failed
+// because of the platform rather than the job.
+var PlatformFailureReasons = map[string]bool{
+	"Evicted":                  true,
+	"NodeLost":                 true,
+	"Shutdown":                 true,
+	"UnexpectedAdmissionError": true,
+	"OutOfcpu":                 true,
+	"OutOfmemory":              true,
+	"OutOfnvidia.com/gpu":      true,
+}
+
+// Waives checks whether an attempt which ended with the pod status reason is not charged
+func (p BillingPolicy) Waives(reason string) bool {
+	return p.WaivePlatformFailures && PlatformFailureReasons[reason]
+}
+
+// Validate checks the policy is usable
+func (p BillingPolicy) Validate() error {
+	if _, err := p.Granularity.Duration(); err != nil {
+		return err
+	}
+	if p.MinimumCharge < 0 {
+		return fmt.Errorf("minimum charge must not be negative: %v", p.MinimumCharge)
+	}
+	return nil
+}
+
+// BilledDuration rounds d up to the policy granularity. Any started unit is
+// billed in full, a non-positive duration is never billed.
+func (p BillingPolicy) BilledDuration(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	unit, err := p.Granularity.Duration()
+	if err != nil {
+		unit = time.Second
+	}
+	units := d / unit
+	if d%unit != 0 {
+		units++
+	}
+	return units * unit
+}
+
+// Charge applies the minimum charge and rounds cost to the currency precision
+// with banker's rounding.
+func (p BillingPolicy) Charge(cost Money) Money {
+	if cost < p.MinimumCharge {
+		cost = p.MinimumCharge
+	}
+	return cost.RoundCurrency()
+}
+
+// PolicySet holds the default policy and the policies agreed with single
+// namespaces or groups (platform-group label).
+type PolicySet struct {
+	Default    BillingPolicy            `json:"default"`
+	Namespaces map[string]BillingPolicy `json:"namespaces,omitempty"`
+	Groups     map[string]BillingPolicy `json:"groups,omitempty"`
+}
+
+// Select returns the policy for a pod attempt, a group policy wins over a
+// namespace policy which wins over the default.
+func (ps *PolicySet) Select(namespace, group string) BillingPolicy {
+	if ps == nil {
+		return BillingPolicy{}
+	}
+	if p, found := ps.Groups[group]; found && group != "" {
+		return p
+	}
+	if p, found := ps.Namespaces[namespace]; found && namespace != "" {
+		return p
+	}
+	return ps.Default
+}
+
+// Validate checks all policies of the set
+func (ps *PolicySet) Validate() error {
+	if err := ps.Default.Validate(); err != nil {
+		return fmt.Errorf("default policy: %v", err)
+	}
+	for ns, p := range ps.Namespaces {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("policy of namespace %s: %v", ns, err)
+		}
+	}
+	for group, p := range ps.Groups {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("policy of group %s: %v", group, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBilledDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   BillingPolicy
+		duration time.Duration
+		expected time.Duration
+	}{
+		{"zero", BillingPolicy{Granularity: PerMinute}, 0, 0},
+		{"negative", BillingPolicy{Granularity: PerSecond}, -time.Second, 0},
+		{"default is per second", BillingPolicy{}, 1500 * time.Millisecond, 2 * time.Second},
+		{"exact second", BillingPolicy{Granularity: PerSecond}, 3 * time.Second, 3 * time.Second},
+		{"one nanosecond", BillingPolicy{Granularity: PerSecond}, time.Nanosecond, time.Second},
+		{"exact minute", BillingPolicy{Granularity: PerMinute}, time.Minute, time.Minute},
+		{"minute and a nanosecond", BillingPolicy{Granularity: PerMinute}, time.Minute + time.Nanosecond, 2 * time.Minute},
+		{"exact hour", BillingPolicy{Granularity: PerHour}, time.Hour, time.Hour},
+		{"just below an hour", BillingPolicy{Granularity: PerHour}, time.Hour - time.Second, time.Hour},
+		{"hour and a second", BillingPolicy{Granularity: PerHour}, time.Hour + time.Second, 2 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := test.policy.BilledDuration(test.duration); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestCharge(t *testing.T) {
+	policy := BillingPolicy{Granularity: PerMinute, MinimumCharge: MustParseMoney("0.5")}
+	tests := []struct {
+		cost     string
+		expected string
+	}{
+		{"0.1", "0.50"},
+		{"0.5", "0.50"},
+		{"0.625", "0.62"},
+		{"0.635", "0.64"},
+		{"0.625001", "0.63"},
+		{"1.005", "1.00"},
+		{"10.004999", "10.00"},
+	}
+	for _, test := range tests {
+		if got := policy.Charge(MustParseMoney(test.cost)).String(); got != test.expected {
+			t.Errorf("Charge(%s): expected %s, got %s", test.cost, test.expected, got)
+		}
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	start := time.Date(2019, 9, 20, 2, 24, 19, 0, time.UTC)
+	rates := &RateCard{
+		CPUCoreHour:    MustParseMoney("0.1"),
+		MemoryGiBHour:  MustParseMoney("0.01"),
+		GPUHour:        map[string]Money{"2080ti": MustParseMoney("2")},
+		DefaultGPUHour: MustParseMoney("1"),
+	}
+	record := &UsageRecord{
+		Requests: Requests{MilliCPU: 16000, Memory: 128 * bytesPerGiB, MilliGPU: 8000},
+		GpuType:  "2080ti",
+		Start:    start,
+		End:      start.Add(90*time.Minute + time.Second),
+	}
+	record.Finalize(BillingPolicy{Granularity: PerHour}, SinglePriceList(rates))
+	if record.BilledDuration != 2*time.Hour {
+		t.Errorf("expected billed duration 2h, got %v", record.BilledDuration)
+	}
+	// (16*0.1 + 128*0.01 + 8*2) * 2h
+	if record.Cost != MustParseMoney("37.76") {
+		t.Errorf("expected cost 37.76, got %v", record.Cost)
+	}
+	if record.Usage.GPUHours() != 16 {
+		t.Errorf("expected 16 gpu hours, got %v", record.Usage.GPUHours())
+	}
+
+	notRun := &UsageRecord{Requests: Requests{MilliCPU: 1000}, Start: start, End: start}
+	notRun.Finalize(BillingPolicy{MinimumCharge: MustParseMoney("1")}, SinglePriceList(rates))
+	if notRun.Cost != 0 {
+		t.Errorf("expected an attempt that never ran to be free, got %v", notRun.Cost)
+	}
+
+	evicted := &UsageRecord{Requests: Requests{MilliCPU: 1000}, Start: start, End: start.Add(time.Hour), Reason: "Evicted"}
+	evicted.Finalize(BillingPolicy{}, SinglePriceList(rates))
+	if evicted.Waived || evicted.Cost != MustParseMoney("0.1") {
+		t.Errorf("expected evicted attempts to be charged by default, got %v", evicted.Cost)
+	}
+	evicted.Finalize(BillingPolicy{WaivePlatformFailures: true, MinimumCharge: MustParseMoney("1")}, SinglePriceList(rates))
+	if !evicted.Waived || evicted.Cost != 0 {
+		t.Errorf("expected evicted attempt to be waived, got %v", evicted.Cost)
+	}
+}
+
+func TestPolicySetSelect(t *testing.T) {
+	ps := &PolicySet{
+		Default:    BillingPolicy{Granularity: PerSecond},
+		Namespaces: map[string]BillingPolicy{"ns01": {Granularity: PerMinute}},
+		Groups:     map[string]BillingPolicy{"1wlsc2w5iew": {Granularity: PerHour}},
+	}
+	tests := []struct {
+		namespace string
+		group     string
+		expected  Granularity
+	}{
+		{"default", "", PerSecond},
+		{"ns01", "", PerMinute},
+		{"ns01", "other", PerMinute},
+		{"ns01", "1wlsc2w5iew", PerHour},
+		{"default", "1wlsc2w5iew", PerHour},
+	}
+	for _, test := range tests {
+		if got := ps.Select(test.namespace, test.group).Granularity; got != test.expected {
+			t.Errorf("Select(%q, %q): expected %v, got %v", test.namespace, test.group, test.expected, got)
+		}
+	}
+	if err := (&PolicySet{Default: BillingPolicy{Granularity: "day"}}).Validate(); err == nil {
+		t.Errorf("expected unknown granularity to be rejected")
+	}
+}
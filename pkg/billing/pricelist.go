@@ -0,0 +1,155 @@
+package billing
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultPriceVersion names the rate card used outside of all versions
+const DefaultPriceVersion = "default"
+
+// RateCardVersion is a rate card effective in [effectiveFrom, effectiveTo).
+type RateCardVersion struct {
+	Version       string    `json:"version"`
+	EffectiveFrom time.Time `json:"effectiveFrom"`
+	// exclusive end, open if unset or ended by the next version
+	EffectiveTo *time.Time `json:"effectiveTo,omitempty"`
+	RateCard
+}
+
+// PriceList resolves the rate cards that applied during an interval.
+type PriceList struct {
+	versions []RateCardVersion
+	// rates used where no version is effective
+	fallback *RateCard
+}
+
+// PriceInterval is a part of an interval priced by a single rate card version.
+type PriceInterval struct {
+	Version  string
+	Rates    *RateCard
+	Start    time.Time
+	Duration time.Duration
+}
+
+// NewPriceList checks the versions do not overlap and orders them by effective date.
+func NewPriceList(fallback *RateCard, versions []RateCardVersion) (*PriceList, error) {
+	sorted := make([]RateCardVersion, len(versions))
+	copy(sorted, versions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].EffectiveFrom.Before(sorted[j].EffectiveFrom)
+	})
+	seen := make(map[string]bool)
+	for i := range sorted {
+		v := &sorted[i]
+		if v.Version == "" || v.Version == DefaultPriceVersion || seen[v.Version] {
+			return nil, fmt.Errorf("price version needs a unique name other than %q, got %q", DefaultPriceVersion, v.Version)
+		}
+		seen[v.Version] = true
+		if v.EffectiveFrom.IsZero() {
+			return nil, fmt.Errorf("price version %s has no effectiveFrom", v.Version)
+		}
+		if v.EffectiveTo != nil && !v.EffectiveTo.After(v.EffectiveFrom) {
+			return nil, fmt.Errorf("price version %s ends before it starts", v.Version)
+		}
+		if i+1 < len(sorted) {
+			next := sorted[i+1]
+			if !next.EffectiveFrom.After(v.EffectiveFrom) || (v.EffectiveTo != nil && v.EffectiveTo.After(next.EffectiveFrom)) {
+				return nil, fmt.Errorf("price versions %s and %s overlap", v.Version, next.Version)
+			}
+			// an open version ends where the next one starts
+			if v.EffectiveTo == nil {
+				end := next.EffectiveFrom
+				v.EffectiveTo = &end
+			}
+		}
+	}
+	if fallback == nil {
+		fallback = &RateCard{}
+	}
+	return &PriceList{versions: sorted, fallback: fallback}, nil
+}
+
+// SinglePriceList returns a price list with one rate card effective all the time
+func SinglePriceList(rates *RateCard) *PriceList {
+	return &PriceList{fallback: rates}
+}
+
+// Versions returns the rate card versions ordered by effective date
+func (pl *PriceList) Versions() []RateCardVersion {
+	versions := make([]RateCardVersion, len(pl.versions))
+	copy(versions, pl.versions)
+	return versions
+}
+
+// Default returns the rates used outside of all versions
+func (pl *PriceList) Default() *RateCard {
+	return pl.fallback
+}
+
+// At returns the version name and rate card effective at t
+func (pl *PriceList) At(t time.Time) (string, *RateCard) {
+	for i := range pl.versions {
+		if pl.versions[i].covers(t) {
+			return pl.versions[i].Version, &pl.versions[i].RateCard
+		}
+	}
+	return DefaultPriceVersion, pl.fallback
+}
+
+// Resolve cuts [start, start+d) at every version boundary.
+func (pl *PriceList) Resolve(start time.Time, d time.Duration) []PriceInterval {
+	var intervals []PriceInterval
+	end := start.Add(d)
+	for t := start; t.Before(end); {
+		version, rates := pl.At(t)
+		next := pl.nextBoundary(t)
+		if next.IsZero() || next.After(end) {
+			next = end
+		}
+		if n := len(intervals); n > 0 && intervals[n-1].Version == version {
+			intervals[n-1].Duration += next.Sub(t)
+		} else {
+			intervals = append(intervals, PriceInterval{Version: version, Rates: rates, Start: t, Duration: next.Sub(t)})
+		}
+		t = next
+	}
+	return intervals
+}
+
+// nextBoundary returns the first version start or end after t, zero if there is none.
+func (pl *PriceList) nextBoundary(t time.Time) time.Time {
+	var next time.Time
+	consider := func(b time.Time) {
+		if b.After(t) && (next.IsZero() || b.Before(next)) {
+			next = b
+		}
+	}
+	for _, v := range pl.versions {
+		consider(v.EffectiveFrom)
+		if v.EffectiveTo != nil {
+			consider(*v.EffectiveTo)
+		}
+	}
+	return next
+}
+
+// Breakdown integrates the cost over the versions and their price windows.
+func (pl *PriceList) Breakdown(r Requests, gpuType string, start time.Time, d time.Duration) []WindowCost {
+	var breakdown []WindowCost
+	for _, interval := range pl.Resolve(start, d) {
+		for _, wc := range interval.Rates.Breakdown(r, gpuType, interval.Start, interval.Duration) {
+			wc.PriceVersion = interval.Version
+			breakdown = append(breakdown, wc)
+		}
+	}
+	return breakdown
+}
+
+func (v *RateCardVersion) covers(t time.Time) bool {
+	if t.Before(v.EffectiveFrom) {
+		return false
+	}
+	return v.EffectiveTo == nil || t.Before(*v.EffectiveTo)
+}
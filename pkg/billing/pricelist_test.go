@@ -0,0 +1,66 @@
+package billing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceListResolve(t *testing.T) {
+	sep := time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)
+	oct := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	nov := time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+	prices, err := NewPriceList(&RateCard{DefaultGPUHour: MustParseMoney("1")}, []RateCardVersion{
+		{Version: "2019-10", EffectiveFrom: oct, RateCard: RateCard{DefaultGPUHour: MustParseMoney("3")}},
+		{Version: "2019-09", EffectiveFrom: sep, RateCard: RateCard{DefaultGPUHour: MustParseMoney("2")}},
+		{Version: "2019-11", EffectiveFrom: nov, EffectiveTo: timePtr(nov.Add(24 * time.Hour)), RateCard: RateCard{DefaultGPUHour: MustParseMoney("4")}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if version, _ := prices.At(sep.Add(-time.Second)); version != DefaultPriceVersion {
+		t.Errorf("expected default prices before the first version, got %s", version)
+	}
+	if version, _ := prices.At(oct.Add(-time.Second)); version != "2019-09" {
+		t.Errorf("expected 2019-09 to end where 2019-10 starts, got %s", version)
+	}
+	if version, _ := prices.At(nov.Add(48 * time.Hour)); version != DefaultPriceVersion {
+		t.Errorf("expected default prices after the last version ended, got %s", version)
+	}
+
+	// a job running over the price change is billed with both versions
+	breakdown := prices.Breakdown(Requests{MilliGPU: 1000}, "", oct.Add(-time.Hour), 3*time.Hour)
+	if len(breakdown) != 2 || breakdown[0].PriceVersion != "2019-09" || breakdown[1].PriceVersion != "2019-10" {
+		t.Fatalf("unexpected breakdown %+v", breakdown)
+	}
+	if breakdown[0].Cost != MustParseMoney("2") || breakdown[1].Cost != MustParseMoney("6") {
+		t.Errorf("expected costs 2 and 6, got %v and %v", breakdown[0].Cost, breakdown[1].Cost)
+	}
+
+	intervals := prices.Resolve(nov.Add(23*time.Hour), 2*time.Hour)
+	if len(intervals) != 2 || intervals[0].Version != "2019-11" || intervals[1].Version != DefaultPriceVersion {
+		t.Errorf("unexpected intervals %+v", intervals)
+	}
+}
+
+func TestPriceListValidation(t *testing.T) {
+	oct := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	invalid := [][]RateCardVersion{
+		{{Version: "", EffectiveFrom: oct}},
+		{{Version: DefaultPriceVersion, EffectiveFrom: oct}},
+		{{Version: "a", EffectiveFrom: oct}, {Version: "a", EffectiveFrom: oct.Add(time.Hour)}},
+		{{Version: "a"}},
+		{{Version: "a", EffectiveFrom: oct, EffectiveTo: timePtr(oct)}},
+		{{Version: "a", EffectiveFrom: oct}, {Version: "b", EffectiveFrom: oct}},
+		{{Version: "a", EffectiveFrom: oct, EffectiveTo: timePtr(oct.Add(2 * time.Hour))}, {Version: "b", EffectiveFrom: oct.Add(time.Hour)}},
+	}
+	for i, versions := range invalid {
+		if _, err := NewPriceList(nil, versions); err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
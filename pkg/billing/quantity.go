@@ -0,0 +1,98 @@
+package billing
+
+import (
+	"math/big"
+	"time"
+)
+
+// Requests are the billed resource requests of a pod in exact integer units.
+type Requests struct {
+	MilliCPU int64 `json:"milliCPU"`
+	// memory in bytes
+	Memory   int64 `json:"memory"`
+	MilliGPU int64 `json:"milliGPU"`
+}
+
+// Add adds rr to the requests
+func (r *Requests) Add(rr Requests) {
+	r.MilliCPU += rr.MilliCPU
+	r.Memory += rr.Memory
+	r.MilliGPU += rr.MilliGPU
+}
+
+// Multi multiplies the requests by n
+func (r Requests) Multi(n int64) Requests {
+	return Requests{
+		MilliCPU: r.MilliCPU * n,
+		Memory:   r.Memory * n,
+		MilliGPU: r.MilliGPU * n,
+	}
+}
+
+// Over returns the resource time of holding the requests for d, truncated to whole seconds.
+func (r Requests) Over(d time.Duration) ResourceTime {
+	seconds := big.NewInt(int64(d / time.Second))
+	return ResourceTime{
+		MilliCPUSeconds:   new(big.Int).Mul(big.NewInt(r.MilliCPU), seconds),
+		MemoryByteSeconds: new(big.Int).Mul(big.NewInt(r.Memory), seconds),
+		MilliGPUSeconds:   new(big.Int).Mul(big.NewInt(r.MilliGPU), seconds),
+	}
+}
+
+// ResourceTime is the exact integral of resource requests over time. Byte
+// seconds of a few large pods already overflow int64, so big integers are used.
+type ResourceTime struct {
+	MilliCPUSeconds   *big.Int `json:"milliCPUSeconds"`
+	MemoryByteSeconds *big.Int `json:"memoryByteSeconds"`
+	MilliGPUSeconds   *big.Int `json:"milliGPUSeconds"`
+}
+
+// NewResourceTime returns a zero resource time
+func NewResourceTime() ResourceTime {
+	return ResourceTime{
+		MilliCPUSeconds:   new(big.Int),
+		MemoryByteSeconds: new(big.Int),
+		MilliGPUSeconds:   new(big.Int),
+	}
+}
+
+// Add adds rt2 to the resource time
+func (rt *ResourceTime) Add(rt2 ResourceTime) {
+	rt.MilliCPUSeconds = addInt(rt.MilliCPUSeconds, rt2.MilliCPUSeconds)
+	rt.MemoryByteSeconds = addInt(rt.MemoryByteSeconds, rt2.MemoryByteSeconds)
+	rt.MilliGPUSeconds = addInt(rt.MilliGPUSeconds, rt2.MilliGPUSeconds)
+}
+
+// CPUHours returns the cpu core hours, for display only
+func (rt ResourceTime) CPUHours() float64 {
+	return ratFloat(rt.MilliCPUSeconds, milliPerUnit*secondsPerHour)
+}
+
+// MemoryGiBHours returns the memory GiB hours, for display only
+func (rt ResourceTime) MemoryGiBHours() float64 {
+	return ratFloat(rt.MemoryByteSeconds, bytesPerGiB*secondsPerHour)
+}
+
+// GPUHours returns the gpu hours, for display only
+func (rt ResourceTime) GPUHours() float64 {
+	return ratFloat(rt.MilliGPUSeconds, milliPerUnit*secondsPerHour)
+}
+
+func addInt(a, b *big.Int) *big.Int {
+	sum := new(big.Int)
+	if a != nil {
+		sum.Add(sum, a)
+	}
+	if b != nil {
+		sum.Add(sum, b)
+	}
+	return sum
+}
+
+func ratFloat(v *big.Int, den int64) float64 {
+	if v == nil {
+		return 0
+	}
+	f, _ := new(big.Rat).SetFrac(v, big.NewInt(den)).Float64()
+	return f
+}
@@ -0,0 +1,91 @@
+package billing
+
+import (
+	"math/big"
+	"time"
+)
+
+const (
+	milliPerUnit   = 1000
+	bytesPerGiB    = 1024 * 1024 * 1024
+	secondsPerHour = 3600
+)
+
+// RateCard holds the hourly prices of the billed resources.
+type RateCard struct {
+	// price of one cpu core per hour
+	CPUCoreHour Money `json:"cpuCoreHour"`
+	// price of one GiB memory per hour
+	MemoryGiBHour Money `json:"memoryGiBHour"`
+	// price of one gpu per hour, keyed by gpu type (pod nodeSelector resourceType)
+	GPUHour map[string]Money `json:"gpuHour,omitempty"`
+	// price of one gpu per hour if the gpu type has no own price
+	DefaultGPUHour Money `json:"defaultGpuHour"`
+	// optional price multipliers by time of use
+	TimeOfUse *TimeOfUse `json:"timeOfUse,omitempty"`
+}
+
+// GPUPrice returns the hourly price of one gpu of the given type
+func (rc *RateCard) GPUPrice(gpuType string) Money {
+	if price, found := rc.GPUHour[gpuType]; found {
+		return price
+	}
+	return rc.DefaultGPUHour
+}
+
+// Cost returns the exact cost of the resource time, rounded half to even to a micro unit.
+func (rc *RateCard) Cost(rt ResourceTime, gpuType string) Money {
+	return Money(roundHalfEven(rc.cost(rt, gpuType)))
+}
+
+// HourlyCost returns the cost of holding the requests for one hour
+func (rc *RateCard) HourlyCost(r Requests, gpuType string) Money {
+	return rc.Cost(r.Over(time.Hour), gpuType)
+}
+
+func (rc *RateCard) cost(rt ResourceTime, gpuType string) *big.Rat {
+	cost := new(big.Rat)
+	cost.Add(cost, priceOf(rt.MilliCPUSeconds, rc.CPUCoreHour, milliPerUnit*secondsPerHour))
+	cost.Add(cost, priceOf(rt.MemoryByteSeconds, rc.MemoryGiBHour, bytesPerGiB*secondsPerHour))
+	cost.Add(cost, priceOf(rt.MilliGPUSeconds, rc.GPUPrice(gpuType), milliPerUnit*secondsPerHour))
+	return cost
+}
+
+// ResourceCost is a cost split by resource type
+type ResourceCost struct {
+	CPU    Money `json:"cpu"`
+	Memory Money `json:"memory"`
+	GPU    Money `json:"gpu"`
+}
+
+// Add adds rc2 to the cost
+func (rc *ResourceCost) Add(rc2 ResourceCost) {
+	rc.CPU += rc2.CPU
+	rc.Memory += rc2.Memory
+	rc.GPU += rc2.GPU
+}
+
+// Total sums the resource types
+func (rc ResourceCost) Total() Money {
+	return rc.CPU + rc.Memory + rc.GPU
+}
+
+func (rc *RateCard) resourceCost(rt ResourceTime, gpuType string, multiplier Factor) ResourceCost {
+	of := func(cost *big.Rat) Money {
+		return Money(roundHalfEven(cost.Mul(cost, multiplier.Rat())))
+	}
+	return ResourceCost{
+		CPU:    of(priceOf(rt.MilliCPUSeconds, rc.CPUCoreHour, milliPerUnit*secondsPerHour)),
+		Memory: of(priceOf(rt.MemoryByteSeconds, rc.MemoryGiBHour, bytesPerGiB*secondsPerHour)),
+		GPU:    of(priceOf(rt.MilliGPUSeconds, rc.GPUPrice(gpuType), milliPerUnit*secondsPerHour)),
+	}
+}
+
+// priceOf returns amount * price / per in micro units
+func priceOf(amount *big.Int, price Money, per int64) *big.Rat {
+	if amount == nil {
+		return new(big.Rat)
+	}
+	num := new(big.Int).Mul(amount, big.NewInt(int64(price)))
+	return new(big.Rat).SetFrac(num, big.NewInt(per))
+}
@@ -0,0 +1,24 @@
+package billing
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// GPUResourceName is the extended resource name of nvidia gpus
+const GPUResourceName v1.ResourceName = "nvidia.com/gpu"
+
+// NewRequests converts a resource list to exact requests. Quantities keep
+// their precision, cpu and gpu are counted in milli units and memory in bytes.
+func NewRequests(rl v1.ResourceList) Requests {
+	r := Requests{}
+	if q, found := rl[v1.ResourceCPU]; found {
+		r.MilliCPU = q.MilliValue()
+	}
+	if q, found := rl[v1.ResourceMemory]; found {
+		r.Memory = q.Value()
+	}
+	if q, found := rl[GPUResourceName]; found {
+		r.MilliGPU = q.MilliValue()
+	}
+	return r
+}
@@ -0,0 +1,282 @@
+package billing
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// StandardWindow is the name of the time not covered by any price window
+	StandardWindow = "standard"
+
+	dateLayout = "2006-01-02"
+)
+
+// day selectors of a price window
+const (
+	DayWeekday = "weekday"
+	DayWeekend = "weekend"
+	DayHoliday = "holiday"
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// TimeOfUse multiplies prices in time windows, e.g. peak hours on weekdays.
+type TimeOfUse struct {
+	// IANA time zone the windows are defined in, default UTC
+	TimeZone string `json:"timeZone,omitempty"`
+	// file with one holiday per line as YYYY-MM-DD, '#' starts a comment
+	HolidayCalendar string `json:"holidayCalendar,omitempty"`
+	// holidays as YYYY-MM-DD in addition to the calendar
+	Holidays []string `json:"holidays,omitempty"`
+	// windows are matched in order, the first match wins
+	Windows []PriceWindow `json:"windows,omitempty"`
+
+	location *time.Location
+	holidays map[string]bool
+}
+
+// PriceWindow is a range of hours on some days with its own price multiplier.
+type PriceWindow struct {
+	Name string `json:"name"`
+	// weekday, weekend, holiday or mon..sun, empty matches every day. A holiday
+	// matches holiday and weekend but neither weekday nor a day name.
+	Days []string `json:"days,omitempty"`
+	// hours [startHour, endHour) in the time zone, startHour > endHour spans midnight
+	StartHour int `json:"startHour"`
+	EndHour   int `json:"endHour"`
+	// price multiplier inside the window
+	Multiplier Factor `json:"multiplier"`
+}
+
+// WindowCost is the part of a cost that falls into one price window.
+type WindowCost struct {
+	PriceVersion string        `json:"priceVersion,omitempty"`
+	Window       string        `json:"window"`
+	Multiplier   Factor        `json:"multiplier"`
+	Duration     time.Duration `json:"duration"`
+	Usage        ResourceTime  `json:"usage"`
+	Cost         Money         `json:"cost"`
+	// cost by resource type, each rounded on its own
+	Resources ResourceCost `json:"resources"`
+}
+
+// Load validates the windows and resolves time zone and holiday calendar,
+// relative calendar paths are resolved against baseDir.
+func (tou *TimeOfUse) Load(baseDir string) error {
+	location, err := time.LoadLocation(tou.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid time zone %q: %v", tou.TimeZone, err)
+	}
+	tou.location = location
+
+	for _, w := range tou.Windows {
+		if w.Name == "" || w.Name == StandardWindow {
+			return fmt.Errorf("price window needs a name other than %q", StandardWindow)
+		}
+		if w.StartHour < 0 || w.StartHour > 23 || w.EndHour < 0 || w.EndHour > 24 || w.StartHour == w.EndHour {
+			return fmt.Errorf("price window %s has invalid hours [%d, %d)", w.Name, w.StartHour, w.EndHour)
+		}
+		for _, day := range w.Days {
+			if _, found := weekdayNames[day]; !found && day != DayWeekday && day != DayWeekend && day != DayHoliday {
+				return fmt.Errorf("price window %s has unknown day %q", w.Name, day)
+			}
+		}
+	}
+
+	tou.holidays = make(map[string]bool)
+	for _, holiday := range tou.Holidays {
+		if _, err := time.Parse(dateLayout, holiday); err != nil {
+			return fmt.Errorf("invalid holiday %q: %v", holiday, err)
+		}
+		tou.holidays[holiday] = true
+	}
+	if tou.HolidayCalendar == "" {
+		return nil
+	}
+	path := tou.HolidayCalendar
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	return tou.loadHolidays(path)
+}
+
+func (tou *TimeOfUse) loadHolidays(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open holiday calendar: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if _, err := time.Parse(dateLayout, fields[0]); err != nil {
+			return fmt.Errorf("invalid holiday at %s:%d: %v", path, line, err)
+		}
+		tou.holidays[fields[0]] = true
+	}
+	return scanner.Err()
+}
+
+// window returns the index of the first window covering t, -1 for standard time.
+func (tou *TimeOfUse) window(t time.Time) int {
+	holiday := tou.holidays[t.Format(dateLayout)]
+	for i, w := range tou.Windows {
+		if w.matchesHour(t.Hour()) && w.matchesDay(t.Weekday(), holiday) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (w *PriceWindow) matchesHour(hour int) bool {
+	if w.StartHour < w.EndHour {
+		return hour >= w.StartHour && hour < w.EndHour
+	}
+	return hour >= w.StartHour || hour < w.EndHour
+}
+
+func (w *PriceWindow) matchesDay(day time.Weekday, holiday bool) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	weekend := day == time.Saturday || day == time.Sunday
+	for _, d := range w.Days {
+		switch d {
+		case DayHoliday:
+			if holiday {
+				return true
+			}
+		case DayWeekend:
+			if weekend || holiday {
+				return true
+			}
+		case DayWeekday:
+			if !weekend && !holiday {
+				return true
+			}
+		default:
+			if weekdayNames[d] == day && !holiday {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// split returns how long [start, start+d) lies in every window, the last
+// entry is standard time. Windows start on full hours, so the range is cut at
+// every hour of the time zone. Boundaries are found in absolute time, local
+// hours repeated or skipped by daylight saving time are cut like any other.
+func (tou *TimeOfUse) split(start time.Time, d time.Duration) []time.Duration {
+	durations := make([]time.Duration, len(tou.Windows)+1)
+	location := tou.location
+	if location == nil {
+		// not loaded
+		location = time.UTC
+	}
+	end := start.Add(d)
+	for t := start.In(location); t.Before(end); {
+		// the start of the local hour may be offset from the utc hour, e.g. in Asia/Kolkata
+		hourStart := t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second -
+			time.Duration(t.Nanosecond()))
+		next := hourStart.Add(time.Hour)
+		if !next.After(t) {
+			next = t.Add(time.Hour)
+		}
+		if next.After(end) {
+			next = end
+		}
+		i := tou.window(t)
+		if i < 0 {
+			i = len(tou.Windows)
+		}
+		durations[i] += next.Sub(t)
+		t = next.In(location)
+	}
+	return durations
+}
+
+// Breakdown integrates the cost of holding the requests from start for d over
+// the price windows. Durations are truncated to whole seconds.
+func (rc *RateCard) Breakdown(r Requests, gpuType string, start time.Time, d time.Duration) []WindowCost {
+	if rc.TimeOfUse == nil || len(rc.TimeOfUse.Windows) == 0 {
+		return []WindowCost{rc.windowCost(StandardWindow, Factor{}, r, gpuType, d)}
+	}
+	var breakdown []WindowCost
+	durations := rc.TimeOfUse.split(start, d)
+	for i, wd := range durations {
+		if wd == 0 {
+			continue
+		}
+		if i == len(rc.TimeOfUse.Windows) {
+			breakdown = append(breakdown, rc.windowCost(StandardWindow, Factor{}, r, gpuType, wd))
+			continue
+		}
+		w := rc.TimeOfUse.Windows[i]
+		breakdown = append(breakdown, rc.windowCost(w.Name, w.Multiplier, r, gpuType, wd))
+	}
+	return breakdown
+}
+
+func (rc *RateCard) windowCost(name string, multiplier Factor, r Requests, gpuType string, d time.Duration) WindowCost {
+	usage := r.Over(d)
+	cost := new(big.Rat).Mul(rc.cost(usage, gpuType), multiplier.Rat())
+	return WindowCost{
+		Window:     name,
+		Multiplier: multiplier,
+		Duration:   d,
+		Usage:      usage,
+		Cost:       Money(roundHalfEven(cost)),
+		Resources:  rc.resourceCost(usage, gpuType, multiplier),
+	}
+}
+
+// SumBreakdown adds up window costs by price version and window name, keeping
+// the order they first appear in.
+func SumBreakdown(breakdowns ...[]WindowCost) []WindowCost {
+	var sum []WindowCost
+	index := make(map[string]int)
+	for _, breakdown := range breakdowns {
+		for _, wc := range breakdown {
+			key := wc.PriceVersion + "/" + wc.Window
+			i, found := index[key]
+			if !found {
+				index[key] = len(sum)
+				sum = append(sum, WindowCost{
+					PriceVersion: wc.PriceVersion,
+					Window:       wc.Window,
+					Multiplier:   wc.Multiplier,
+					Usage:        NewResourceTime(),
+				})
+				i = len(sum) - 1
+			}
+			sum[i].Duration += wc.Duration
+			sum[i].Usage.Add(wc.Usage)
+			sum[i].Cost += wc.Cost
+			sum[i].Resources.Add(wc.Resources)
+		}
+	}
+	return sum
+}
@@ -0,0 +1,146 @@
+package billing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestTimeOfUse(t *testing.T) *TimeOfUse {
+	dir, err := ioutil.TempDir("", "tou")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	calendar := "# national holidays\n2019-10-01 national day\n\n2019-10-02\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "holidays.txt"), []byte(calendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tou := &TimeOfUse{
+		TimeZone:        "Asia/Shanghai",
+		HolidayCalendar: "holidays.txt",
+		Windows: []PriceWindow{
+			{Name: "peak", Days: []string{DayWeekday}, StartHour: 8, EndHour: 20, Multiplier: mustParseFactor("1.5")},
+			{Name: "night", StartHour: 22, EndHour: 6, Multiplier: mustParseFactor("0.5")},
+			{Name: "weekend", Days: []string{DayWeekend}, StartHour: 0, EndHour: 24, Multiplier: mustParseFactor("0.8")},
+		},
+	}
+	if err := tou.Load(dir); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return tou
+}
+
+func mustParseFactor(s string) Factor {
+	f, err := ParseFactor(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func TestBreakdown(t *testing.T) {
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	rates := &RateCard{DefaultGPUHour: MustParseMoney("2"), TimeOfUse: newTestTimeOfUse(t)}
+	gpu := Requests{MilliGPU: 1000}
+
+	tests := []struct {
+		name     string
+		start    time.Time
+		duration time.Duration
+		expected map[string]time.Duration
+		cost     string
+	}{
+		{
+			name:     "friday evening into saturday",
+			start:    time.Date(2019, 9, 27, 19, 30, 0, 0, shanghai),
+			duration: 5 * time.Hour,
+			// 19:30-20:00 peak, 20:00-22:00 standard, 22:00-00:30 night
+			expected: map[string]time.Duration{"peak": 30 * time.Minute, StandardWindow: 2 * time.Hour, "night": 150 * time.Minute},
+			cost:     "8.00",
+		},
+		{
+			name:     "holiday is not a weekday",
+			start:    time.Date(2019, 10, 1, 9, 0, 0, 0, shanghai),
+			duration: time.Hour,
+			expected: map[string]time.Duration{"weekend": time.Hour},
+			cost:     "1.60",
+		},
+		{
+			name:     "utc start",
+			start:    time.Date(2019, 9, 30, 1, 0, 0, 0, time.UTC),
+			duration: 90 * time.Minute,
+			expected: map[string]time.Duration{"peak": 90 * time.Minute},
+			cost:     "4.50",
+		},
+	}
+	for _, test := range tests {
+		breakdown := rates.Breakdown(gpu, "", test.start, test.duration)
+		var cost Money
+		for _, wc := range breakdown {
+			if test.expected[wc.Window] != wc.Duration {
+				t.Errorf("%s: expected %v in %s, got %v", test.name, test.expected[wc.Window], wc.Window, wc.Duration)
+			}
+			cost += wc.Cost
+		}
+		if len(breakdown) != len(test.expected) {
+			t.Errorf("%s: expected %d windows, got %+v", test.name, len(test.expected), breakdown)
+		}
+		if cost.String() != test.cost {
+			t.Errorf("%s: expected cost %s, got %v", test.name, test.cost, cost)
+		}
+	}
+}
+
+func TestTimeOfUseValidation(t *testing.T) {
+	invalid := []*TimeOfUse{
+		{TimeZone: "Mars/Olympus"},
+		{Windows: []PriceWindow{{Name: "peak", StartHour: 8, EndHour: 8}}},
+		{Windows: []PriceWindow{{Name: "peak", StartHour: 8, EndHour: 25}}},
+		{Windows: []PriceWindow{{Name: StandardWindow, StartHour: 8, EndHour: 20}}},
+		{Windows: []PriceWindow{{Name: "peak", Days: []string{"someday"}, StartHour: 8, EndHour: 20}}},
+		{HolidayCalendar: "/does/not/exist"},
+	}
+	for i, tou := range invalid {
+		if err := tou.Load(""); err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+}
+
+func TestSplitDaylightSaving(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeZone string
+		start    string
+		duration time.Duration
+		// in the window and in standard time
+		window   time.Duration
+		standard time.Duration
+	}{
+		// 01:00-02:00 happens twice
+		{name: "fall back", timeZone: "America/New_York", start: "2019-11-03T00:00:00-04:00", duration: 4 * time.Hour,
+			window: 3 * time.Hour, standard: time.Hour},
+		// 02:00-03:00 is skipped
+		{name: "spring forward", timeZone: "America/New_York", start: "2019-03-10T00:00:00-05:00", duration: 3 * time.Hour,
+			window: 2 * time.Hour, standard: time.Hour},
+		{name: "half hour offset", timeZone: "Asia/Kolkata", start: "2019-11-04T23:30:00+05:30", duration: 3 * time.Hour,
+			window: 2 * time.Hour, standard: time.Hour},
+	}
+	for _, test := range tests {
+		tou := &TimeOfUse{TimeZone: test.timeZone, Windows: []PriceWindow{{Name: "night", StartHour: 0, EndHour: 2}}}
+		if err := tou.Load(""); err != nil {
+			t.Fatal(err)
+		}
+		start, err := time.Parse(time.RFC3339, test.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		durations := tou.split(start, test.duration)
+		if durations[0] != test.window || durations[1] != test.standard {
+			t.Errorf("%s: expected %v in the window and %v standard, got %v", test.name, test.window, test.standard, durations)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package billing
+
+import (
+	"time"
+)
+
+// UsageRecord is the finalized usage of one pod attempt.
+type UsageRecord struct {
+	// pod uid, unique per attempt
+	ID      string `json:"id"`
+	JobName string `json:"jobName"`
+	// framework uid, tells re-created jobs of the same name apart
+	JobUID     string `json:"jobUid,omitempty"`
+	TaskName   string `json:"taskName"`
+	PodName    string `json:"podName"`
+	RetryCount int    `json:"retryCount"`
+	UserId     string `json:"userId"`
+	Group      string `json:"group"`
+	Namespace  string `json:"namespace"`
+	GpuType    string `json:"gpuType"`
+	// labels of the framework
+	Labels map[string]string `json:"labels,omitempty"`
+	// pod status reason of the attempt, e.g. Evicted
+	Reason string `json:"reason,omitempty"`
+	// the attempt ended in the failed phase
+	Failed bool `json:"failed,omitempty"`
+
+	// requested resources
+	Requests Requests `json:"requests"`
+
+	Start          time.Time     `json:"start"`
+	End            time.Time     `json:"end"`
+	Duration       time.Duration `json:"duration"`
+	BilledDuration time.Duration `json:"billedDuration"`
+	Granularity    Granularity   `json:"granularity"`
+	// requests integrated over the billed duration
+	Usage ResourceTime `json:"usage"`
+	// cost by price version and window before minimum charge and rounding
+	Breakdown []WindowCost `json:"breakdown"`
+	Cost      Money        `json:"cost"`
+	// the attempt failed because of the platform and is not charged
+	Waived bool `json:"waived,omitempty"`
+	// when the ledger finalized the record, zero for records of old versions
+	FinalizedAt time.Time `json:"finalizedAt"`
+}
+
+// Finalize computes the billed duration, usage and cost of the record under
+// the given policy and the prices effective while the attempt ran.
+func (ur *UsageRecord) Finalize(policy BillingPolicy, prices *PriceList) {
+	ur.Duration = ur.End.Sub(ur.Start)
+	if ur.Duration < 0 {
+		ur.Duration = 0
+	}
+	ur.Granularity = policy.Granularity
+	ur.BilledDuration = policy.BilledDuration(ur.Duration)
+	ur.Usage = ur.Requests.Over(ur.BilledDuration)
+	// an attempt that never ran or failed because of the platform is not
+	// charged, not even the minimum
+	ur.Waived = ur.BilledDuration > 0 && policy.Waives(ur.Reason)
+	if ur.BilledDuration == 0 || ur.Waived {
+		ur.Breakdown = nil
+		ur.Cost = 0
+		return
+	}
+	// the rounded up part of the billed duration is charged after the end
+	ur.Breakdown = prices.Breakdown(ur.Requests, ur.GpuType, ur.Start, ur.BilledDuration)
+	var cost Money
+	for _, wc := range ur.Breakdown {
+		cost += wc.Cost
+	}
+	ur.Cost = policy.Charge(cost)
+}
+
+// ResourceCost sums the breakdown by resource type, before minimum charges and rounding
+func (ur *UsageRecord) ResourceCost() ResourceCost {
+	var cost ResourceCost
+	for _, wc := range ur.Breakdown {
+		cost.Add(wc.Resources)
+	}
+	return cost
+}
+
+// TotalCost sums the cost of the records
+func TotalCost(records []*UsageRecord) Money {
+	var total Money
+	for _, record := range records {
+		total += record.Cost
+	}
+	return total
+}
+
+// TotalUsage sums the usage of the records
+func TotalUsage(records []*UsageRecord) ResourceTime {
+	total := NewResourceTime()
+	for _, record := range records {
+		total.Add(record.Usage)
+	}
+	return total
+}
@@ -0,0 +1,97 @@
+package budget
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// maxAuditEntries is the number of recent audit entries kept in memory
+const maxAuditEntries = 1000
+
+// enforcement actions
+const (
+	ActionPending = "pending"
+	ActionDryRun  = "dry-run"
+	ActionStop    = "stop"
+	ActionSkipped = "skipped"
+	ActionFailed  = "failed"
+)
+
+// AuditEntry is an action the enforcer took or would have taken
+type AuditEntry struct {
+	Time      time.Time           `json:"time"`
+	Action    string              `json:"action"`
+	Budget    string              `json:"budget"`
+	Scope     billing.BudgetScope `json:"scope"`
+	Subject   string              `json:"subject"`
+	Namespace string              `json:"namespace,omitempty"`
+	Framework string              `json:"framework,omitempty"`
+	Spent     billing.Money       `json:"spent"`
+	Amount    billing.Money       `json:"amount"`
+	Message   string              `json:"message,omitempty"`
+}
+
+// AuditTrail keeps the recent enforcement actions and appends all of them
+// as json lines to a file if a path is given.
+type AuditTrail struct {
+	sync.Mutex
+
+	path    string
+	entries []AuditEntry
+}
+
+// NewAuditTrail creates an audit trail, path may be empty
+func NewAuditTrail(path string) *AuditTrail {
+	return &AuditTrail{path: path}
+}
+
+// Record logs and keeps the entry
+func (at *AuditTrail) Record(entry AuditEntry) {
+	at.Lock()
+	defer at.Unlock()
+
+	glog.Infof("Budget enforcement %s: budget %s of %s %s, framework %s/%s, spent %v of %v: %s",
+		entry.Action, entry.Budget, entry.Scope, entry.Subject, entry.Namespace, entry.Framework,
+		entry.Spent, entry.Amount, entry.Message)
+
+	at.entries = append(at.entries, entry)
+	if len(at.entries) > maxAuditEntries {
+		at.entries = at.entries[len(at.entries)-maxAuditEntries:]
+	}
+	if at.path != "" {
+		if err := at.append(entry); err != nil {
+			glog.Errorf("Failed to write audit trail %s: %v", at.path, err)
+		}
+	}
+}
+
+func (at *AuditTrail) append(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(at.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Entries returns the recent entries, oldest first
+func (at *AuditTrail) Entries() []AuditEntry {
+	at.Lock()
+	defer at.Unlock()
+
+	entries := make([]AuditEntry, len(at.entries))
+	copy(entries, at.entries)
+	return entries
+}
@@ -0,0 +1,203 @@
+package budget
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	frameworkClient "github.com/microsoft/frameworkcontroller/pkg/client/clientset/versioned"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// ReasonBudgetExhausted is the reason of the events recorded on stopped frameworks
+const ReasonBudgetExhausted = "BudgetExhausted"
+
+var stopPatch = []byte(fmt.Sprintf(`{"spec":{"executionType":%q}}`, fcapi.ExecutionStop))
+
+// EnforcerOptions configures the enforcer
+type EnforcerOptions struct {
+	// only audit what would be stopped
+	DryRun bool
+	// how long a hard budget must stay exhausted before frameworks are stopped
+	GracePeriod time.Duration
+	// frameworks never stopped, by namespace/name, namespace, user or group
+	Allowlist []string
+}
+
+// Enforcer stops the running frameworks charged to exhausted hard budgets.
+type Enforcer struct {
+	sync.Mutex
+
+	evaluator *Evaluator
+	running   RunningUsage
+	fmClient  frameworkClient.Interface
+	recorder  record.EventRecorder
+	audit     *AuditTrail
+	options   EnforcerOptions
+	allowed   map[string]bool
+
+	// first time a budget was seen exhausted in its period
+	exhausted map[string]time.Time
+	// frameworks already stopped or audited per budget period
+	handled map[string]bool
+}
+
+// NewEnforcer creates an enforcer which stops frameworks with fmClient and records events on them
+func NewEnforcer(e *Evaluator, running RunningUsage, recorder record.EventRecorder, fmClient frameworkClient.Interface, audit *AuditTrail, options EnforcerOptions) *Enforcer {
+	allowed := make(map[string]bool)
+	for _, item := range options.Allowlist {
+		allowed[item] = true
+	}
+	return &Enforcer{
+		evaluator: e,
+		running:   running,
+		fmClient:  fmClient,
+		recorder:  recorder,
+		audit:     audit,
+		options:   options,
+		allowed:   allowed,
+		exhausted: make(map[string]time.Time),
+		handled:   make(map[string]bool),
+	}
+}
+
+// Run enforces the hard budgets every period until stopCh is closed
+func (en *Enforcer) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() { en.Enforce(time.Now()) }, period, stopCh)
+}
+
+// Audit returns the audit trail
+func (en *Enforcer) Audit() *AuditTrail {
+	return en.audit
+}
+
+// Enforce stops the frameworks of hard budgets exhausted for longer than the grace period
+func (en *Enforcer) Enforce(now time.Time) {
+	en.Lock()
+	defer en.Unlock()
+
+	var running []*billing.UsageRecord
+	exhausted := make(map[string]bool)
+	for _, status := range en.evaluator.Status(now) {
+		if !status.Exhausted(status.Spent) {
+			continue
+		}
+		key := fmt.Sprintf("%s/%s/%s", status.Scope, status.Name, status.PeriodStart.Format(time.RFC3339))
+		exhausted[key] = true
+		since, found := en.exhausted[key]
+		if !found {
+			since = now
+			en.exhausted[key] = now
+			en.record(status, ActionPending, "", "", fmt.Sprintf("frameworks are stopped after %v", en.options.GracePeriod))
+		}
+		if now.Sub(since) < en.options.GracePeriod {
+			continue
+		}
+		if running == nil && en.running != nil {
+			running = en.running.RunningUsage(now)
+		}
+		for _, fm := range en.frameworksOf(status, running) {
+			en.stop(key, status, fm)
+		}
+	}
+	// forget budgets which are no longer exhausted, e.g. raised or in a new period
+	for key := range en.exhausted {
+		if !exhausted[key] {
+			delete(en.exhausted, key)
+		}
+	}
+	for key := range en.handled {
+		if !exhausted[budgetKeyOf(key)] {
+			delete(en.handled, key)
+		}
+	}
+}
+
+// frameworksOf returns the running frameworks charged to the budget
+func (en *Enforcer) frameworksOf(status *Status, running []*billing.UsageRecord) []*billing.UsageRecord {
+	seen := make(map[string]bool)
+	var frameworks []*billing.UsageRecord
+	for _, record := range running {
+		if record.JobName == "" || !status.Covers(record, en.evaluator.accounts) {
+			continue
+		}
+		name := record.Namespace + "/" + record.JobName
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		frameworks = append(frameworks, record)
+	}
+	return frameworks
+}
+
+// allowedFramework checks the allowlist for the framework, its namespace, user and group
+func (en *Enforcer) allowedFramework(fm *billing.UsageRecord) bool {
+	return en.allowed[fm.Namespace+"/"+fm.JobName] || en.allowed[fm.Namespace] ||
+		(fm.UserId != "" && en.allowed[fm.UserId]) || (fm.Group != "" && en.allowed[fm.Group])
+}
+
+func (en *Enforcer) stop(budgetKey string, status *Status, fm *billing.UsageRecord) {
+	key := budgetKey + "|" + fm.Namespace + "/" + fm.JobName
+	if en.handled[key] {
+		return
+	}
+	en.handled[key] = true
+
+	if en.allowedFramework(fm) {
+		en.record(status, ActionSkipped, fm.Namespace, fm.JobName, "framework is allowlisted")
+		return
+	}
+	message := fmt.Sprintf("budget %s of %s %s is exhausted: spent %v of %v", status.Name, status.Scope, status.Subject, status.Spent, status.Amount)
+	if en.options.DryRun {
+		en.record(status, ActionDryRun, fm.Namespace, fm.JobName, message)
+		return
+	}
+	_, err := en.fmClient.FrameworkcontrollerV1().Frameworks(fm.Namespace).Patch(fm.JobName, types.MergePatchType, stopPatch)
+	if err != nil {
+		// retry in the next round
+		delete(en.handled, key)
+		en.record(status, ActionFailed, fm.Namespace, fm.JobName, err.Error())
+		return
+	}
+	en.recorder.Event(frameworkReference(fm), v1.EventTypeWarning, ReasonBudgetExhausted, "Stopped, "+message)
+	en.record(status, ActionStop, fm.Namespace, fm.JobName, message)
+}
+
+func (en *Enforcer) record(status *Status, action, namespace, framework, message string) {
+	en.audit.Record(AuditEntry{
+		Time:      time.Now(),
+		Action:    action,
+		Budget:    status.Name,
+		Scope:     status.Scope,
+		Subject:   status.Subject,
+		Namespace: namespace,
+		Framework: framework,
+		Spent:     status.Spent,
+		Amount:    status.Amount,
+		Message:   message,
+	})
+}
+
+// budgetKeyOf returns the budget part of a handled framework key
+func budgetKeyOf(key string) string {
+	if i := strings.LastIndex(key, "|"); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func frameworkReference(fm *billing.UsageRecord) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: fcapi.SchemeGroupVersion.String(),
+		Kind:       "Framework",
+		Namespace:  fm.Namespace,
+		Name:       fm.JobName,
+	}
+}
@@ -0,0 +1,151 @@
+package budget
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/microsoft/frameworkcontroller/pkg/client/clientset/versioned/fake"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func testEnforcer(t *testing.T, options EnforcerOptions, auditPath string) (*Enforcer, *fake.Clientset, *record.FakeRecorder) {
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+	budgets := []billing.Budget{{Name: "alice", Scope: billing.ScopeUser, Subject: "alice", Period: billing.Monthly,
+		Amount: billing.MustParseMoney("100"), Hard: true}}
+	l := testLedger(t, budgets, testRecord("1", "alice", "default", "train", "100", now.Add(-time.Hour)))
+	running := &fakeRunning{
+		testRecord("2", "alice", "default", "train", "1", now),
+		testRecord("3", "alice", "default", "train", "1", now),
+		testRecord("4", "alice", "prod", "serve", "1", now),
+		testRecord("5", "bob", "default", "eval", "1", now),
+	}
+	fmClient := fake.NewSimpleClientset(
+		&fcapi.Framework{ObjectMeta: metav1.ObjectMeta{Name: "train", Namespace: "default"}},
+		&fcapi.Framework{ObjectMeta: metav1.ObjectMeta{Name: "serve", Namespace: "prod"}},
+		&fcapi.Framework{ObjectMeta: metav1.ObjectMeta{Name: "eval", Namespace: "default"}},
+	)
+	recorder := record.NewFakeRecorder(10)
+	e := NewEvaluator(l, running, nil)
+	return NewEnforcer(e, running, recorder, fmClient, NewAuditTrail(auditPath), options), fmClient, recorder
+}
+
+func actions(entries []AuditEntry) string {
+	var s []string
+	for _, entry := range entries {
+		s = append(s, entry.Action+" "+entry.Namespace+"/"+entry.Framework)
+	}
+	return strings.Join(s, ", ")
+}
+
+func patches(fmClient *fake.Clientset) []string {
+	var names []string
+	for _, action := range fmClient.Actions() {
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			names = append(names, patch.GetNamespace()+"/"+patch.GetName()+" "+string(patch.GetPatch()))
+		}
+	}
+	return names
+}
+
+func TestEnforce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enforcer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditPath := filepath.Join(dir, "audit.log")
+
+	en, fmClient, recorder := testEnforcer(t, EnforcerOptions{GracePeriod: 10 * time.Minute, Allowlist: []string{"prod"}}, auditPath)
+	failures := 1
+	fmClient.PrependReactor("patch", "frameworks", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, fmt.Errorf("conflict")
+		}
+		return false, nil, nil
+	})
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	en.Enforce(now)
+	en.Enforce(now.Add(5 * time.Minute))
+	if got := actions(en.Audit().Entries()); got != "pending /" {
+		t.Fatalf("expected only the pending budget within the grace period, got %s", got)
+	}
+	if len(fmClient.Actions()) != 0 {
+		t.Fatalf("expected no framework to be stopped within the grace period, got %v", fmClient.Actions())
+	}
+
+	// the patch fails and is retried in the next round, the allowlisted framework is skipped once
+	en.Enforce(now.Add(10 * time.Minute))
+	en.Enforce(now.Add(11 * time.Minute))
+	en.Enforce(now.Add(12 * time.Minute))
+	expected := "pending /, failed default/train, skipped prod/serve, stop default/train"
+	if got := actions(en.Audit().Entries()); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	stop := `default/train {"spec":{"executionType":"Stop"}}`
+	if got := patches(fmClient); len(got) != 2 || got[0] != stop || got[1] != stop {
+		t.Errorf("expected the stop to be patched twice, got %v", got)
+	}
+	fm, err := fmClient.FrameworkcontrollerV1().Frameworks("default").Get("train", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fm.Spec.ExecutionType != fcapi.ExecutionStop {
+		t.Errorf("expected the framework to be stopped, got %q", fm.Spec.ExecutionType)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected one event, got %d", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+ReasonBudgetExhausted+" Stopped, budget alice of user alice is exhausted") {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, entry)
+	}
+	if got := actions(lines); got != expected {
+		t.Errorf("expected the audit log %s, got %s", expected, got)
+	}
+	if last := lines[len(lines)-1]; last.Budget != "alice" || last.Spent != billing.MustParseMoney("103") ||
+		last.Amount != billing.MustParseMoney("100") {
+		t.Errorf("unexpected audit line %+v", last)
+	}
+}
+
+func TestEnforceDryRun(t *testing.T) {
+	en, fmClient, recorder := testEnforcer(t, EnforcerOptions{DryRun: true}, "")
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	en.Enforce(now)
+	en.Enforce(now.Add(time.Minute))
+	if got := actions(en.Audit().Entries()); got != "pending /, dry-run default/train, dry-run prod/serve" {
+		t.Errorf("expected every framework to be audited once, got %s", got)
+	}
+	if len(fmClient.Actions()) != 0 || len(recorder.Events) != 0 {
+		t.Errorf("expected no framework to be stopped in a dry run, got %v", fmClient.Actions())
+	}
+}
@@ -0,0 +1,285 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/metrics"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Alert is delivered once per budget, period and threshold to every notifier
+type Alert struct {
+	Budget      string              `json:"budget"`
+	Scope       billing.BudgetScope `json:"scope"`
+	Subject     string              `json:"subject"`
+	PeriodStart time.Time           `json:"periodStart"`
+	PeriodEnd   time.Time           `json:"periodEnd"`
+	Threshold   int                 `json:"threshold"`
+	Amount      billing.Money       `json:"amount"`
+	Spent       billing.Money       `json:"spent"`
+	Time        time.Time           `json:"time"`
+}
+
+// Status is the spending of a budget in its current period
+type Status struct {
+	billing.Budget
+	PeriodStart time.Time     `json:"periodStart"`
+	PeriodEnd   time.Time     `json:"periodEnd"`
+	Spent       billing.Money `json:"spent"`
+	// cost of pods still running, included in spent
+	Running   billing.Money `json:"running"`
+	Remaining billing.Money `json:"remaining"`
+	// highest threshold crossed, 0 if none
+	Crossed int `json:"crossed"`
+}
+
+// RunningUsage provides provisional usage of running pods, e.g. the BillingCache
+type RunningUsage interface {
+	RunningUsage(now time.Time) []*billing.UsageRecord
+}
+
+// Evaluator compares the accumulated cost with the budgets and sends alerts
+// when thresholds are crossed.
+type Evaluator struct {
+	sync.Mutex
+
+	ledger    *ledger.Ledger
+	running   RunningUsage
+	accounts  *billing.AccountDirectory
+	notifiers []Notifier
+
+	// deliveries of the alerts by budget, period start and threshold, kept
+	// until the period ended
+	sent map[string]*delivery
+	// keeps the alerts delivered to all notifiers across restarts, optional
+	stateFile string
+}
+
+// delivery records the notifiers an alert was delivered to
+type delivery struct {
+	periodEnd time.Time
+	// by index of the notifier
+	notified []bool
+}
+
+// done returns whether all notifiers got the alert
+func (d *delivery) done() bool {
+	for _, notified := range d.notified {
+		if !notified {
+			return false
+		}
+	}
+	return true
+}
+
+// NewEvaluator creates an evaluator of the budgets of the ledger config and the billing accounts
+func NewEvaluator(l *ledger.Ledger, running RunningUsage, accounts *billing.AccountDirectory, notifiers ...Notifier) *Evaluator {
+	return &Evaluator{
+		ledger:    l,
+		running:   running,
+		accounts:  accounts,
+		notifiers: notifiers,
+		sent:      make(map[string]*delivery),
+	}
+}
+
+// SetStateFile loads the alerts delivered before from the file and keeps
+// the alerts delivered from then on in it, so they are not sent again after
+// a restart
+func (e *Evaluator) SetStateFile(path string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		// period end of the delivered alerts by key
+		var delivered map[string]time.Time
+		if err := json.Unmarshal(data, &delivered); err != nil {
+			return fmt.Errorf("invalid budget alert state %s: %v", path, err)
+		}
+		for key, periodEnd := range delivered {
+			d := &delivery{periodEnd: periodEnd, notified: make([]bool, len(e.notifiers))}
+			for i := range d.notified {
+				d.notified[i] = true
+			}
+			e.sent[key] = d
+		}
+	}
+	e.stateFile = path
+	return nil
+}
+
+// Run evaluates the budgets every period until stopCh is closed
+func (e *Evaluator) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() { e.Evaluate(time.Now()) }, period, stopCh)
+}
+
+// Accounts returns the billing accounts budgets may be scoped to
+func (e *Evaluator) Accounts() *billing.AccountDirectory {
+	return e.accounts
+}
+
+// Budgets returns the budgets of the config and of the billing accounts
+func (e *Evaluator) Budgets() []billing.Budget {
+	budgets := append([]billing.Budget{}, e.ledger.Config().Budgets...)
+	if e.accounts != nil {
+		for _, account := range e.accounts.List() {
+			if account.Budget != nil {
+				budgets = append(budgets, *account.Budget)
+			}
+		}
+	}
+	return budgets
+}
+
+// Status returns the spending of all budgets at now
+func (e *Evaluator) Status(now time.Time) []*Status {
+	var running []*billing.UsageRecord
+	if e.running != nil {
+		running = e.running.RunningUsage(now)
+	}
+	var statuses []*Status
+	for _, b := range e.Budgets() {
+		start, end := b.Period.Bounds(now)
+		status := &Status{Budget: b, PeriodStart: start, PeriodEnd: end}
+		for _, record := range e.ledger.RecordsBetween(start, end) {
+			if b.Covers(record, e.accounts) {
+				status.Spent += record.Cost
+			}
+		}
+		for _, record := range running {
+			if b.Covers(record, e.accounts) {
+				status.Running += record.Cost
+			}
+		}
+		status.Spent += status.Running
+		status.Remaining = b.Amount - status.Spent
+		for _, threshold := range b.GetThresholds() {
+			if crossed(status.Spent, b.Amount, threshold) && threshold > status.Crossed {
+				status.Crossed = threshold
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Evaluate sends an alert for every threshold crossed in the current period
+// to the notifiers it was not delivered to yet. It returns the alerts
+// delivered to all notifiers by this evaluation.
+func (e *Evaluator) Evaluate(now time.Time) []*Alert {
+	e.Lock()
+	defer e.Unlock()
+
+	changed := e.prune(now)
+	var alerts []*Alert
+	for _, status := range e.Status(now) {
+		for _, threshold := range status.GetThresholds() {
+			if !crossed(status.Spent, status.Amount, threshold) {
+				continue
+			}
+			key := fmt.Sprintf("%s/%s/%s/%d", status.Scope, status.Name, status.PeriodStart.Format(time.RFC3339), threshold)
+			d, found := e.sent[key]
+			if found && d.done() {
+				continue
+			}
+			if !found {
+				d = &delivery{periodEnd: status.PeriodEnd, notified: make([]bool, len(e.notifiers))}
+				e.sent[key] = d
+			}
+			alert := &Alert{
+				Budget:      status.Name,
+				Scope:       status.Scope,
+				Subject:     status.Subject,
+				PeriodStart: status.PeriodStart,
+				PeriodEnd:   status.PeriodEnd,
+				Threshold:   threshold,
+				Amount:      status.Amount,
+				Spent:       status.Spent,
+				Time:        now,
+			}
+			if e.notify(alert, d) {
+				alerts = append(alerts, alert)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		e.save()
+	}
+	return alerts
+}
+
+// notify sends the alert to the notifiers it was not delivered to, failed
+// notifiers are retried by the next evaluation. It returns whether the alert
+// is delivered to all notifiers now.
+func (e *Evaluator) notify(alert *Alert, d *delivery) bool {
+	for i, notifier := range e.notifiers {
+		if d.notified[i] {
+			continue
+		}
+		if err := notifier.Notify(alert); err != nil {
+			glog.Errorf("Failed to deliver alert of budget %s, retrying: %v", alert.Budget, err)
+			continue
+		}
+		d.notified[i] = true
+	}
+	if !d.done() {
+		return false
+	}
+	metrics.RegisterBudgetAlert(string(alert.Scope), alert.Threshold)
+	return true
+}
+
+// prune forgets the alerts of the periods ended before now
+func (e *Evaluator) prune(now time.Time) bool {
+	pruned := false
+	for key, d := range e.sent {
+		if !now.Before(d.periodEnd) {
+			delete(e.sent, key)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+// save writes the alerts delivered to all notifiers to the state file
+func (e *Evaluator) save() {
+	if e.stateFile == "" {
+		return
+	}
+	delivered := make(map[string]time.Time)
+	for key, d := range e.sent {
+		if d.done() {
+			delivered[key] = d.periodEnd
+		}
+	}
+	data, err := json.Marshal(delivered)
+	if err == nil {
+		tmp := e.stateFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0640); err == nil {
+			err = os.Rename(tmp, e.stateFile)
+		}
+	}
+	if err != nil {
+		glog.Errorf("Failed to save budget alert state, alerts may be sent again after a restart: %v", err)
+	}
+}
+
+// crossed checks spent >= threshold% of amount without rounding
+func crossed(spent, amount billing.Money, threshold int) bool {
+	return int64(spent)*100 >= int64(amount)*int64(threshold)
+}
@@ -0,0 +1,163 @@
+package budget
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+)
+
+// fakeStore hands its records to the ledger
+type fakeStore struct {
+	records []*billing.UsageRecord
+}
+
+func (s *fakeStore) Finalize(record *billing.UsageRecord) (bool, error) { return true, nil }
+func (s *fakeStore) Update(records []*billing.UsageRecord) error        { return nil }
+func (s *fakeStore) Records() ([]*billing.UsageRecord, error)           { return s.records, nil }
+func (s *fakeStore) SavePrices(versions []billing.RateCardVersion) error {
+	return nil
+}
+
+type fakeRunning []*billing.UsageRecord
+
+func (r *fakeRunning) RunningUsage(now time.Time) []*billing.UsageRecord { return *r }
+
+type fakeNotifier struct {
+	alerts []*Alert
+	err    error
+}
+
+func (n *fakeNotifier) Notify(alert *Alert) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func testRecord(id, user, namespace, job, cost string, end time.Time) *billing.UsageRecord {
+	return &billing.UsageRecord{ID: id, UserId: user, Namespace: namespace, JobName: job,
+		Start: end.Add(-time.Hour), End: end, Cost: billing.MustParseMoney(cost)}
+}
+
+func testLedger(t *testing.T, budgets []billing.Budget, records ...*billing.UsageRecord) *ledger.Ledger {
+	config := billing.DefaultConfig()
+	config.Budgets = budgets
+	l := ledger.New(config)
+	if err := l.SetStore(&fakeStore{records: records}); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func thresholds(alerts []*Alert) string {
+	s := ""
+	for _, alert := range alerts {
+		s += fmt.Sprintf("%s@%d ", alert.PeriodStart.Format("2006-01"), alert.Threshold)
+	}
+	return s
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+	budgets := []billing.Budget{{Name: "alice", Scope: billing.ScopeUser, Subject: "alice", Period: billing.Monthly,
+		Amount: billing.MustParseMoney("100"), Thresholds: []int{50, 100}}}
+	l := testLedger(t, budgets,
+		testRecord("1", "alice", "default", "train", "60", now.AddDate(0, 0, -2)),
+		// last month
+		testRecord("2", "alice", "default", "train", "500", now.AddDate(0, -1, 0)),
+		testRecord("3", "bob", "default", "eval", "500", now),
+	)
+	running := &fakeRunning{}
+	notifier := &fakeNotifier{}
+	e := NewEvaluator(l, running, nil, notifier)
+
+	if alerts := e.Evaluate(now); thresholds(alerts) != "2019-11@50 " {
+		t.Fatalf("expected the 50%% alert, got %s", thresholds(alerts))
+	}
+	if alerts := e.Evaluate(now.Add(time.Hour)); len(alerts) != 0 {
+		t.Errorf("expected every threshold to be sent once per period, got %s", thresholds(alerts))
+	}
+
+	// a failed delivery is sent again
+	*running = fakeRunning{testRecord("4", "alice", "default", "train", "40", now)}
+	notifier.err = fmt.Errorf("unreachable")
+	if alerts := e.Evaluate(now); len(alerts) != 0 {
+		t.Errorf("expected no alert to be delivered, got %s", thresholds(alerts))
+	}
+	notifier.err = nil
+	if alerts := e.Evaluate(now); thresholds(alerts) != "2019-11@100 " || alerts[0].Spent != billing.MustParseMoney("100") {
+		t.Errorf("expected the 100%% alert with the running cost, got %s", thresholds(alerts))
+	}
+	if len(notifier.alerts) != 2 {
+		t.Errorf("expected 2 delivered alerts, got %d", len(notifier.alerts))
+	}
+
+	// the next period starts over, the running pod is charged to it
+	next := time.Date(2019, 12, 1, 0, 30, 0, 0, time.UTC)
+	*running = fakeRunning{testRecord("5", "alice", "default", "train", "55", next)}
+	if alerts := e.Evaluate(next); thresholds(alerts) != "2019-12@50 " {
+		t.Errorf("expected the thresholds of the new period, got %s", thresholds(alerts))
+	}
+
+	statuses := e.Status(next)
+	if len(statuses) != 1 || statuses[0].Spent != billing.MustParseMoney("55") || statuses[0].Running != billing.MustParseMoney("55") ||
+		statuses[0].Remaining != billing.MustParseMoney("45") || statuses[0].Crossed != 50 {
+		t.Errorf("unexpected status %+v", statuses[0])
+	}
+}
+
+func TestEvaluateRetries(t *testing.T) {
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+	budgets := []billing.Budget{{Name: "alice", Scope: billing.ScopeUser, Subject: "alice", Period: billing.Monthly,
+		Amount: billing.MustParseMoney("100"), Thresholds: []int{50}}}
+	l := testLedger(t, budgets, testRecord("1", "alice", "default", "train", "60", now.AddDate(0, 0, -2)))
+	dir, err := ioutil.TempDir("", "budget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "alerts.json")
+	logged, webhook := &fakeNotifier{}, &fakeNotifier{err: fmt.Errorf("unreachable")}
+	e := NewEvaluator(l, &fakeRunning{}, nil, logged, webhook)
+	if err := e.SetStateFile(state); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the failed notifier is retried
+	if alerts := e.Evaluate(now); len(alerts) != 0 {
+		t.Errorf("expected no alert to be delivered to all notifiers, got %s", thresholds(alerts))
+	}
+	webhook.err = nil
+	if alerts := e.Evaluate(now); thresholds(alerts) != "2019-11@50 " {
+		t.Errorf("expected the alert once the webhook got it, got %s", thresholds(alerts))
+	}
+	if len(logged.alerts) != 1 || len(webhook.alerts) != 1 {
+		t.Errorf("expected every notifier to get the alert once, got %d and %d", len(logged.alerts), len(webhook.alerts))
+	}
+
+	// a restart does not send the alert again
+	restarted := NewEvaluator(l, &fakeRunning{}, nil, logged, webhook)
+	if err := restarted.SetStateFile(state); err != nil {
+		t.Fatal(err)
+	}
+	if alerts := restarted.Evaluate(now.Add(time.Hour)); len(alerts) != 0 || len(logged.alerts) != 1 {
+		t.Errorf("expected the delivered alert not to be sent after a restart, got %s", thresholds(alerts))
+	}
+
+	// the alerts of ended periods are forgotten
+	restarted.Evaluate(time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC))
+	if len(restarted.sent) != 0 {
+		t.Errorf("expected the alerts of november to be pruned, got %d", len(restarted.sent))
+	}
+	restarted = NewEvaluator(l, &fakeRunning{}, nil, logged)
+	if err := restarted.SetStateFile(state); err != nil || len(restarted.sent) != 0 {
+		t.Errorf("expected the pruned alerts to be removed from the state, got %d, %v", len(restarted.sent), err)
+	}
+}
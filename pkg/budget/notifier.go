@@ -0,0 +1,58 @@
+package budget
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Notifier delivers budget alerts
+type Notifier interface {
+	Notify(alert *Alert) error
+}
+
+// LogNotifier writes alerts to the log
+type LogNotifier struct{}
+
+// Notify logs the alert
+func (LogNotifier) Notify(alert *Alert) error {
+	glog.Warningf("Budget %s of %s %s crossed %d%%: spent %v of %v in period starting %v",
+		alert.Budget, alert.Scope, alert.Subject, alert.Threshold, alert.Spent, alert.Amount,
+		alert.PeriodStart.Format("2006-01-02"))
+	return nil
+}
+
+// WebhookNotifier posts alerts as json to an url
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookNotifier creates a webhook notifier with a request timeout
+func NewWebhookNotifier(url string, timeout time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts the alert, any non 2xx response is an error
+func (wn *WebhookNotifier) Notify(alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := wn.Client.Post(wn.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post alert to %s: %v", wn.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", wn.URL, resp.Status)
+	}
+	return nil
+}
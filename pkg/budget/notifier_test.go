@@ -0,0 +1,63 @@
+package budget
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	alert := &Alert{Budget: "alice", Scope: billing.ScopeUser, Subject: "alice",
+		PeriodStart: time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC), PeriodEnd: time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC),
+		Threshold: 80, Amount: billing.MustParseMoney("100"), Spent: billing.MustParseMoney("85.5")}
+
+	var received map[string]interface{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s request with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		received = nil
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(server.URL, time.Second)
+	if err := notifier.Notify(alert); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"budget": "alice", "scope": "user", "subject": "alice",
+		"periodStart": "2019-11-01T00:00:00Z", "threshold": float64(80)}
+	for key, value := range expected {
+		if received[key] != value {
+			t.Errorf("expected %s %v, got %v", key, value, received[key])
+		}
+	}
+	if _, found := received["spent"]; !found {
+		t.Errorf("expected the spent amount in %v", received)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := notifier.Notify(alert); err == nil {
+		t.Error("expected an error for a non 2xx response")
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+	start := time.Now()
+	if err := NewWebhookNotifier(slow.URL, 50*time.Millisecond).Notify(alert); err == nil {
+		t.Error("expected an error for a webhook answering after the timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("expected the request to be cancelled after the timeout, took %v", elapsed)
+	}
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	frameworkClient "github.com/microsoft/frameworkcontroller/pkg/client/clientset/versioned"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/cache"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	// AnnotationAccumulatedCost is the cost of all attempts of a framework so far
+	AnnotationAccumulatedCost = "billing.pcl.ac.cn/accumulated-cost"
+	// AnnotationGPUHours is the gpu hours of all attempts of a framework so far
+	AnnotationGPUHours = "billing.pcl.ac.cn/gpu-hours"
+	// AnnotationLastUpdated is when the cost annotations were written
+	AnnotationLastUpdated = "billing.pcl.ac.cn/last-updated"
+)
+
+// runningCostChangePercent is how much the cost of a framework with running
+// pods has to grow before it is annotated again. Completed frameworks are
+// annotated whenever their cost changed.
+const runningCostChangePercent = 5
+
+// Jobs provides the frameworks and the usage of their running pods, e.g. the BillingCache
+type Jobs interface {
+	Snapshot() *api.ClusterInfo
+	RunningUsage(now time.Time) []*billing.UsageRecord
+}
+
+// AnnotationWriter writes the accumulated cost of frameworks onto their
+// annotations. Only metadata is merge patched, without a resource version,
+// so the patch itself only conflicts with a framework created again under
+// the same name. It still bumps the resource version,
+// so a whole object update of the frameworkcontroller racing with it gets a
+// conflict and is retried. Running frameworks are patched again only when
+// their cost grew noticeably to keep these conflicts rare.
+type AnnotationWriter struct {
+	jobs     Jobs
+	ledger   *ledger.Ledger
+	fmClient frameworkClient.Interface
+	limiter  flowcontrol.RateLimiter
+
+	// last annotations written by framework uid, unchanged costs are not patched again
+	written map[string]*writtenCost
+}
+
+// frameworkCost is the usage of a framework, finalized and running
+type frameworkCost struct {
+	cost  billing.Money
+	usage billing.ResourceTime
+	// pods of the framework are still running
+	running bool
+}
+
+// writtenCost is the annotated cost of a framework
+type writtenCost struct {
+	value string
+	cost  billing.Money
+}
+
+// NewAnnotationWriter creates a writer patching at most qps frameworks per second
+func NewAnnotationWriter(bc *cache.BillingCache, l *ledger.Ledger, qps float32) *AnnotationWriter {
+	burst := int(qps)
+	if burst < 1 {
+		burst = 1
+	}
+	return newAnnotationWriter(bc, l, bc.FrameworkClient(), flowcontrol.NewTokenBucketRateLimiter(qps, burst))
+}
+
+func newAnnotationWriter(jobs Jobs, l *ledger.Ledger, fmClient frameworkClient.Interface, limiter flowcontrol.RateLimiter) *AnnotationWriter {
+	return &AnnotationWriter{
+		jobs:     jobs,
+		ledger:   l,
+		fmClient: fmClient,
+		limiter:  limiter,
+		written:  make(map[string]*writtenCost),
+	}
+}
+
+// Run writes the annotations every period until stopCh is closed
+func (aw *AnnotationWriter) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() { aw.write(time.Now()) }, period, stopCh)
+}
+
+func (aw *AnnotationWriter) write(now time.Time) {
+	costs := aw.costs(now)
+	seen := make(map[string]bool)
+	for _, fi := range aw.jobs.Snapshot().Jobs {
+		if fi.Namespace == "" || fi.JobName == "" || fi.UID == "" {
+			continue
+		}
+		uid := string(fi.UID)
+		seen[uid] = true
+		fc, found := costs[uid]
+		if !found {
+			continue
+		}
+		value := fmt.Sprintf("%s|%.2f", fc.cost.RoundCurrency(), fc.usage.GPUHours())
+		if !aw.changed(aw.written[uid], value, fc) {
+			continue
+		}
+		aw.limiter.Accept()
+		if err := aw.patch(fi.Namespace, fi.JobName, fi.UID, fc, now); err != nil {
+			// retried in the next round
+			glog.Errorf("Failed to annotate cost of framework %s/%s: %v", fi.Namespace, fi.JobName, err)
+			continue
+		}
+		aw.written[uid] = &writtenCost{value: value, cost: fc.cost}
+	}
+	// forget frameworks removed from the cache
+	for uid := range aw.written {
+		if !seen[uid] {
+			delete(aw.written, uid)
+		}
+	}
+}
+
+// costs sums the finalized and running attempts by framework uid, so a
+// framework created again under the same name starts over
+func (aw *AnnotationWriter) costs(now time.Time) map[string]*frameworkCost {
+	costs := make(map[string]*frameworkCost)
+	add := func(record *billing.UsageRecord) *frameworkCost {
+		fc, found := costs[record.JobUID]
+		if !found {
+			fc = &frameworkCost{usage: billing.NewResourceTime()}
+			costs[record.JobUID] = fc
+		}
+		fc.cost += record.Cost
+		fc.usage.Add(record.Usage)
+		return fc
+	}
+	for _, record := range aw.ledger.Records() {
+		add(record)
+	}
+	for _, record := range aw.jobs.RunningUsage(now) {
+		add(record).running = true
+	}
+	return costs
+}
+
+// changed checks whether the annotations of a framework have to be written again
+func (aw *AnnotationWriter) changed(written *writtenCost, value string, fc *frameworkCost) bool {
+	if written == nil {
+		return true
+	}
+	if written.value == value {
+		return false
+	}
+	if !fc.running {
+		return true
+	}
+	change := fc.cost - written.cost
+	if change < 0 {
+		change = -change
+	}
+	return change*100 >= written.cost*runningCostChangePercent
+}
+
+// patch writes the annotations of the framework with the uid, the uid is a
+// precondition so a framework created again under the same name is left alone
+func (aw *AnnotationWriter) patch(namespace, name string, uid types.UID, fc *frameworkCost, now time.Time) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"uid": uid,
+			"annotations": map[string]string{
+				AnnotationAccumulatedCost: fc.cost.RoundCurrency().String(),
+				AnnotationGPUHours:        fmt.Sprintf("%.2f", fc.usage.GPUHours()),
+				AnnotationLastUpdated:     now.UTC().Format(time.RFC3339),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = aw.fmClient.FrameworkcontrollerV1().Frameworks(namespace).Patch(name, types.MergePatchType, patch)
+	if errors.IsNotFound(err) || errors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
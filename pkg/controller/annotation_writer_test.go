@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/microsoft/frameworkcontroller/pkg/client/clientset/versioned/fake"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+type fakeStore []*billing.UsageRecord
+
+func (s fakeStore) Finalize(record *billing.UsageRecord) (bool, error)  { return true, nil }
+func (s fakeStore) Update(records []*billing.UsageRecord) error         { return nil }
+func (s fakeStore) Records() ([]*billing.UsageRecord, error)            { return s, nil }
+func (s fakeStore) SavePrices(versions []billing.RateCardVersion) error { return nil }
+
+type fakeJobs struct {
+	cluster *api.ClusterInfo
+	running []*billing.UsageRecord
+}
+
+func (j *fakeJobs) Snapshot() *api.ClusterInfo                        { return j.cluster }
+func (j *fakeJobs) RunningUsage(now time.Time) []*billing.UsageRecord { return j.running }
+
+// fakeLimiter counts the accepted requests
+type fakeLimiter struct {
+	accepted int
+}
+
+func (fl *fakeLimiter) TryAccept() bool { fl.accepted++; return true }
+func (fl *fakeLimiter) Accept()         { fl.accepted++ }
+func (fl *fakeLimiter) Stop()           {}
+func (fl *fakeLimiter) QPS() float32    { return 1 }
+
+func costRecord(id, name, cost string, gpuHours int64) *billing.UsageRecord {
+	usage := billing.NewResourceTime()
+	usage.MilliGPUSeconds = big.NewInt(gpuHours * 1000 * 3600)
+	return &billing.UsageRecord{ID: id, Namespace: "default", JobName: name, JobUID: "uid-" + name,
+		Cost: billing.MustParseMoney(cost), Usage: usage}
+}
+
+func testLedger(t *testing.T, records ...*billing.UsageRecord) *ledger.Ledger {
+	l := ledger.New(nil)
+	if err := l.SetStore(fakeStore(records)); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestAnnotationWriter(t *testing.T) {
+	jobs := &fakeJobs{
+		cluster: &api.ClusterInfo{Jobs: map[string]*api.JobInfo{
+			"train": {Namespace: "default", JobName: "train", UID: "uid-train"},
+			// deleted from the apiserver, still in the cache
+			"gone": {Namespace: "default", JobName: "gone", UID: "uid-gone"},
+			"idle": {Namespace: "default", JobName: "idle", UID: "uid-idle"},
+		}},
+		running: []*billing.UsageRecord{costRecord("", "train", "1", 0)},
+	}
+	fmClient := fake.NewSimpleClientset(&fcapi.Framework{ObjectMeta: metav1.ObjectMeta{Name: "train", Namespace: "default", UID: "uid-train"}})
+	limiter := &fakeLimiter{}
+	aw := newAnnotationWriter(jobs, testLedger(t, costRecord("1", "train", "10", 2), costRecord("2", "gone", "5", 0)),
+		fmClient, limiter)
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	patched := func() []clienttesting.PatchAction {
+		var patches []clienttesting.PatchAction
+		for _, action := range fmClient.Actions() {
+			if patch, ok := action.(clienttesting.PatchAction); ok {
+				patches = append(patches, patch)
+			}
+		}
+		fmClient.ClearActions()
+		return patches
+	}
+
+	aw.write(now)
+	patches := patched()
+	if len(patches) != 2 || limiter.accepted != 2 {
+		t.Fatalf("expected the frameworks with a cost to be patched within the rate limit, got %d patches and %d accepted",
+			len(patches), limiter.accepted)
+	}
+	for _, patch := range patches {
+		if patch.GetName() != "train" {
+			continue
+		}
+		var body map[string]struct {
+			UID         string            `json:"uid"`
+			Annotations map[string]string `json:"annotations"`
+		}
+		if err := json.Unmarshal(patch.GetPatch(), &body); err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{
+			AnnotationAccumulatedCost: "11.00",
+			AnnotationGPUHours:        "2.00",
+			AnnotationLastUpdated:     "2019-11-05T12:00:00Z",
+		}
+		annotations := body["metadata"].Annotations
+		if len(body) != 1 || len(annotations) != len(expected) {
+			t.Errorf("expected only the annotations to be patched, got %s", patch.GetPatch())
+		}
+		if uid := body["metadata"].UID; uid != "uid-train" {
+			t.Errorf("expected the uid of train as precondition, got %q", uid)
+		}
+		for key, value := range expected {
+			if annotations[key] != value {
+				t.Errorf("expected %s %q, got %q", key, value, annotations[key])
+			}
+		}
+	}
+	fm, err := fmClient.FrameworkcontrollerV1().Frameworks("default").Get("train", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fm.Annotations[AnnotationAccumulatedCost] != "11.00" {
+		t.Errorf("expected the framework to be annotated, got %v", fm.Annotations)
+	}
+	fmClient.ClearActions()
+
+	// unchanged, and the deleted framework is not patched again
+	aw.write(now.Add(time.Minute))
+	if patches := patched(); len(patches) != 0 {
+		t.Errorf("expected unchanged costs not to be patched, got %d patches", len(patches))
+	}
+
+	// a running framework is patched again once its cost grew by 5%
+	jobs.running[0].Cost = billing.MustParseMoney("1.5")
+	aw.write(now.Add(2 * time.Minute))
+	if patches := patched(); len(patches) != 0 {
+		t.Errorf("expected a small change of a running framework not to be patched, got %d patches", len(patches))
+	}
+	jobs.running[0].Cost = billing.MustParseMoney("1.6")
+	aw.write(now.Add(3 * time.Minute))
+	if patches := patched(); len(patches) != 1 {
+		t.Errorf("expected the grown cost to be patched, got %d patches", len(patches))
+	}
+
+	// the final cost of a completed framework is always written
+	jobs.running = nil
+	delete(jobs.cluster.Jobs, "gone")
+	aw.ledger = testLedger(t, costRecord("1", "train", "10", 2), costRecord("3", "train", "1.65", 0))
+	aw.write(now.Add(4 * time.Minute))
+	if patches := patched(); len(patches) != 1 || limiter.accepted != 4 {
+		t.Errorf("expected the final cost to be patched, got %d patches and %d accepted", len(patches), limiter.accepted)
+	}
+	if _, found := aw.written["uid-gone"]; found {
+		t.Error("expected frameworks removed from the cache to be forgotten")
+	}
+
+	// a framework created again under the same name has no cost yet
+	jobs.cluster.Jobs["train"] = &api.JobInfo{Namespace: "default", JobName: "train", UID: "uid-train-2"}
+	aw.write(now.Add(5 * time.Minute))
+	if patches := patched(); len(patches) != 0 {
+		t.Errorf("expected the cost of the deleted framework not to be written onto the new one, got %d patches", len(patches))
+	}
+}
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// write v as json response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	resultBody, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("warn: Failed due to %v", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resultBody)
+}
+
+// write an error response
+func writeError(w http.ResponseWriter, code int, msg string) {
+	resultBody, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(resultBody)
+}
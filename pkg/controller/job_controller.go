@@ -3,23 +3,54 @@ package controller
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"k8s.io/client-go/rest"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/budget"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/estimate"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/export"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/invoice"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/leaderboard"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
 	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/cache"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/rollup"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/snapshot"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// rows written before an export is flushed to the client
+	exportFlushRows = 1000
+	// entries of a leaderboard unless a limit is given
+	defaultLeaderboardSize = 10
 )
 
 type JobController struct {
-	cache *cache.BillingCache
+	cache     *cache.BillingCache
+	ledger    *ledger.Ledger
+	accounts  *billing.AccountDirectory
+	budgets   *budget.Evaluator
+	enforcer  *budget.Enforcer
+	invoices  *invoice.Invoicer
+	snapshots *snapshot.Store
+	rollups   *rollup.Aggregator
 }
 
 // new
-func New(config *rest.Config) *JobController {
-	return NewJobController(config)
+func New(config *rest.Config, l *ledger.Ledger, accounts *billing.AccountDirectory) *JobController {
+	return NewJobController(config, l, accounts)
 }
-func NewJobController(config *rest.Config) *JobController {
+func NewJobController(config *rest.Config, l *ledger.Ledger, accounts *billing.AccountDirectory) *JobController {
 	return &JobController{
-		cache: cache.New(config),
+		cache:    cache.New(config, l),
+		ledger:   l,
+		accounts: accounts,
 	}
 }
 
@@ -93,6 +124,416 @@ func (jc *JobController) GetAllPods(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// get finalized usage records
+func (jc *JobController) GetUsage(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, jc.ledger.Records())
+}
+
+// export usage records that ended in [from, to) as csv or json lines, one
+// row per pod attempt, in the columns given as a comma separated list or
+// in the FOCUS columns with schema=focus, which are split by month and can
+// also be written as parquet
+func (jc *JobController) ExportUsage(w http.ResponseWriter, r *http.Request) {
+	from, err := export.ParseTime(r.FormValue("from"), time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid from: %v", err))
+		return
+	}
+	to, err := export.ParseTime(r.FormValue("to"), time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid to: %v", err))
+		return
+	}
+	records := jc.ledger.RecordsBetween(from, to)
+	var columns []export.Column
+	switch schema := r.FormValue("schema"); schema {
+	case export.SchemaFocus:
+		config := jc.ledger.Config()
+		focus := &export.Focus{
+			Accounts:  jc.accounts,
+			Discounts: config.Discounts,
+			Currency:  config.Currency,
+			Location:  time.Local,
+		}
+		columns = focus.Columns()
+		records = focus.Charges(records)
+	case "":
+		if columns, err = export.ParseColumns(r.FormValue("columns")); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown schema %q", schema))
+		return
+	}
+	format := r.FormValue("format")
+	w.Header().Set("Content-Type", export.ContentType(format))
+	writer, err := export.NewWriter(w, format, columns)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var flushed func()
+	if flusher, ok := w.(http.Flusher); ok {
+		flushed = flusher.Flush
+	}
+	if err := export.WriteAll(writer, records, exportFlushRows, flushed); err != nil {
+		log.Printf("warn: Failed to export usage: %v", err)
+	}
+}
+
+// get the cost of a job by price window
+func (jc *JobController) GetJobCost(w http.ResponseWriter, r *http.Request) {
+	jobName := r.FormValue("name")
+	if jobName == "" {
+		writeError(w, http.StatusBadRequest, "job name is required")
+		return
+	}
+	writeJSON(w, jc.ledger.JobCost(jobName))
+}
+
+// get the price history, finance audits which prices applied when
+func (jc *JobController) GetPrices(w http.ResponseWriter, r *http.Request) {
+	prices := jc.ledger.Prices()
+	writeJSON(w, map[string]interface{}{
+		"default":  prices.Default(),
+		"versions": prices.Versions(),
+	})
+}
+
+// estimate the cost of a framework yaml or json posted before it is submitted,
+// runtime is the expected runtime of every task, one hour by default
+func (jc *JobController) EstimateCost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "the framework must be posted")
+		return
+	}
+	runtime := time.Hour
+	if value := r.FormValue("runtime"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid runtime %q", value))
+			return
+		}
+		runtime = d
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fm := &fcapi.Framework{}
+	if err := yaml.Unmarshal(body, fm); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid framework: %v", err))
+		return
+	}
+	if len(fm.Spec.TaskRoles) == 0 {
+		writeError(w, http.StatusBadRequest, "framework has no task roles")
+		return
+	}
+	policy := jc.ledger.Config().Policies.Select(fm.Namespace, fm.Labels[api.LabelPlatformGroupKey])
+	writeJSON(w, estimate.FrameworkRuntime(fm, jc.ledger.Prices(), policy, time.Now(), runtime))
+}
+
+// recompute the cost of usage that ended in [from, to) with the current prices
+func (jc *JobController) RecomputeUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "recompute must be requested with POST")
+		return
+	}
+	from, err := time.Parse(time.RFC3339, r.FormValue("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid from: %v", err))
+		return
+	}
+	to, err := time.Parse(time.RFC3339, r.FormValue("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid to: %v", err))
+		return
+	}
+	if jc.invoices != nil {
+		if period, closed := jc.invoices.Closed(from, to); closed {
+			writeError(w, http.StatusConflict, fmt.Sprintf("period %s is closed, issue an adjustment instead", period))
+			return
+		}
+	}
+	changed := jc.ledger.Recompute(from, to)
+	log.Printf("info: Recomputed usage from %v to %v, %d records changed", from, to, changed)
+	writeJSON(w, map[string]int{"changed": changed})
+}
+
+// get the hourly or daily usage of users, groups, namespaces or gpu types as time
+// series, the last day of hours or the last 30 days by default
+func (jc *JobController) GetUsageSeries(w http.ResponseWriter, r *http.Request) {
+	if jc.rollups == nil {
+		writeError(w, http.StatusNotFound, "usage rollups are not enabled")
+		return
+	}
+	now := time.Now()
+	q := rollup.Query{
+		Resolution: r.FormValue("resolution"),
+		Dimension:  r.FormValue("by"),
+		Name:       r.FormValue("name"),
+		To:         now,
+	}
+	if q.Resolution == "" {
+		q.Resolution = rollup.ResolutionHour
+	}
+	if q.Dimension == "" {
+		q.Dimension = "user"
+	}
+	var err error
+	if value := r.FormValue("to"); value != "" {
+		if q.To, err = export.ParseTime(value, time.Local); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if value := r.FormValue("from"); value != "" {
+		if q.From, err = export.ParseTime(value, time.Local); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else if q.Resolution == rollup.ResolutionDay {
+		q.From = q.To.AddDate(0, 0, -30)
+	} else {
+		q.From = q.To.Add(-24 * time.Hour)
+	}
+	series, err := jc.rollups.Series(q, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, series)
+}
+
+// rank users, groups, namespaces or jobs by their finalized and running usage,
+// the usage of the last seven days by default
+func (jc *JobController) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	q := leaderboard.Query{
+		By:      r.FormValue("by"),
+		Sort:    r.FormValue("sort"),
+		From:    now.AddDate(0, 0, -7),
+		GpuType: r.FormValue("gpuType"),
+		Limit:   defaultLeaderboardSize,
+	}
+	if q.By == "" {
+		q.By = "user"
+	}
+	if q.Sort == "" {
+		q.Sort = leaderboard.MetricCost
+	}
+	var err error
+	if value := r.FormValue("from"); value != "" {
+		if q.From, err = export.ParseTime(value, time.Local); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if value := r.FormValue("to"); value != "" {
+		if q.To, err = export.ParseTime(value, time.Local); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if value := r.FormValue("limit"); value != "" {
+		if q.Limit, err = strconv.Atoi(value); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q", value))
+			return
+		}
+	}
+	records := append(jc.ledger.Records(), jc.cache.RunningUsage(now)...)
+	entries, err := leaderboard.Rank(records, q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, entries)
+}
+
+// list invoices, optionally of a period and account
+func (jc *JobController) GetInvoices(w http.ResponseWriter, r *http.Request) {
+	invoices, err := jc.invoices.Invoices(r.FormValue("period"), r.FormValue("account"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, invoices)
+}
+
+// get an invoice by number as json, or as printable html with format=html
+func (jc *JobController) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	inv, err := jc.invoices.Invoice(r.FormValue("number"))
+	if err == invoice.ErrNotFound {
+		writeError(w, http.StatusNotFound, "invoice not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if r.FormValue("format") != "html" {
+		writeJSON(w, inv)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := invoice.WriteHTML(w, inv); err != nil {
+		log.Printf("warn: Failed to render invoice %s: %v", inv.Number, err)
+	}
+}
+
+// close a billing period and issue its invoices
+func (jc *JobController) CloseInvoicePeriod(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "periods must be closed with POST")
+		return
+	}
+	invoices, err := jc.invoices.Close(r.FormValue("period"), time.Now())
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("info: Closed period %s, issued %d invoices", r.FormValue("period"), len(invoices))
+	writeJSON(w, invoices)
+}
+
+// issue an adjustment of a closed period, by amount or by pricing the usage again
+func (jc *JobController) AdjustInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "adjustments must be requested with POST")
+		return
+	}
+	var amount *billing.Money
+	if value := r.FormValue("amount"); value != "" {
+		m, err := billing.ParseMoney(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid amount: %v", err))
+			return
+		}
+		amount = &m
+	}
+	inv, err := jc.invoices.Adjust(r.FormValue("period"), r.FormValue("account"), r.FormValue("reason"), amount, time.Now())
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("info: Issued adjustment %s of %s for period %s: %s", inv.Number, inv.Account, inv.Period, inv.Reason)
+	writeJSON(w, inv)
+}
+
+// get billing accounts
+func (jc *JobController) GetAccounts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, jc.accounts.List())
+}
+
+// get the spending of budgets in their current period
+func (jc *JobController) GetBudgets(w http.ResponseWriter, r *http.Request) {
+	if jc.budgets == nil {
+		writeJSON(w, []*budget.Status{})
+		return
+	}
+	writeJSON(w, jc.budgets.Status(time.Now()))
+}
+
+// get the recent actions of budget enforcement
+func (jc *JobController) GetEnforcementAudit(w http.ResponseWriter, r *http.Request) {
+	if jc.enforcer == nil {
+		writeJSON(w, []budget.AuditEntry{})
+		return
+	}
+	writeJSON(w, jc.enforcer.Audit().Entries())
+}
+
+// list the times of the saved snapshots
+func (jc *JobController) GetSnapshots(w http.ResponseWriter, r *http.Request) {
+	if jc.snapshots == nil {
+		writeJSON(w, []time.Time{})
+		return
+	}
+	writeJSON(w, jc.snapshots.Times())
+}
+
+// get the snapshot closest to time, only with the pods of gpuType if given
+func (jc *JobController) GetSnapshot(w http.ResponseWriter, r *http.Request) {
+	s, ok := jc.closestSnapshot(w, r.FormValue("time"))
+	if !ok {
+		return
+	}
+	if gpuType := r.FormValue("gpuType"); gpuType != "" {
+		s = s.Filter(gpuType)
+	}
+	writeJSON(w, s)
+}
+
+// diff the snapshots closest to from and to
+func (jc *JobController) DiffSnapshots(w http.ResponseWriter, r *http.Request) {
+	from, ok := jc.closestSnapshot(w, r.FormValue("from"))
+	if !ok {
+		return
+	}
+	to, ok := jc.closestSnapshot(w, r.FormValue("to"))
+	if !ok {
+		return
+	}
+	writeJSON(w, snapshot.Compare(from, to))
+}
+
+func (jc *JobController) closestSnapshot(w http.ResponseWriter, value string) (*snapshot.Snapshot, bool) {
+	if jc.snapshots == nil {
+		writeError(w, http.StatusNotFound, "snapshots are not enabled")
+		return nil, false
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid time %q: %v", value, err))
+		return nil, false
+	}
+	s, err := jc.snapshots.Closest(t)
+	if err == snapshot.ErrNotFound {
+		writeError(w, http.StatusNotFound, err.Error())
+		return nil, false
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return s, true
+}
+
+// get recent anomalies of the cache
+func (jc *JobController) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, jc.cache.Anomalies())
+}
+
+// cache of jobs and pods
+func (jc *JobController) Cache() *cache.BillingCache {
+	return jc.cache
+}
+
+// set the evaluator of the budgets
+func (jc *JobController) SetBudgets(e *budget.Evaluator) {
+	jc.budgets = e
+}
+
+// set the invoicer of closed periods
+func (jc *JobController) SetInvoices(iv *invoice.Invoicer) {
+	jc.invoices = iv
+}
+
+// set the store of the snapshots
+func (jc *JobController) SetSnapshots(s *snapshot.Store) {
+	jc.snapshots = s
+}
+
+// set the aggregator of the usage time series
+func (jc *JobController) SetRollups(a *rollup.Aggregator) {
+	jc.rollups = a
+}
+
+// set the enforcer of hard budgets
+func (jc *JobController) SetEnforcer(e *budget.Enforcer) {
+	jc.enforcer = e
+}
+
 // run
 func (jc *JobController) Run(stopCh <-chan struct{}) {
 	go jc.cache.Run(stopCh)
@@ -0,0 +1,360 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	billingv1 "github.com/ruanxingbaozi/k8s-billing/pkg/apis/billing/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	versioned "github.com/ruanxingbaozi/k8s-billing/pkg/client/clientset/versioned"
+	informers "github.com/ruanxingbaozi/k8s-billing/pkg/client/informers/externalversions"
+	listers "github.com/ruanxingbaozi/k8s-billing/pkg/client/listers/billing/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// unchanged status is written every this many periods to refresh its last computed time
+const statusRefreshPeriods = 10
+
+// ResourceController keeps prices and accounts in sync with the PriceList and
+// BillingAccount resources and reconciles their status.
+type ResourceController struct {
+	client versioned.Interface
+
+	priceInformer   cache.SharedIndexInformer
+	accountInformer cache.SharedIndexInformer
+	priceLister     listers.PriceListLister
+	accountLister   listers.BillingAccountLister
+
+	ledger   *ledger.Ledger
+	accounts *billing.AccountDirectory
+	period   time.Duration
+}
+
+// NewResourceController watches the billing resources, status is reconciled every period
+func NewResourceController(config *rest.Config, l *ledger.Ledger, accounts *billing.AccountDirectory, period time.Duration) (*ResourceController, error) {
+	client, err := versioned.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create billing client: %v", err)
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	rc := &ResourceController{
+		client:          client,
+		priceInformer:   factory.Billing().V1().PriceLists().Informer(),
+		accountInformer: factory.Billing().V1().BillingAccounts().Informer(),
+		priceLister:     factory.Billing().V1().PriceLists().Lister(),
+		accountLister:   factory.Billing().V1().BillingAccounts().Lister(),
+		ledger:          l,
+		accounts:        accounts,
+		period:          period,
+	}
+	rc.priceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { rc.syncPrices() },
+		UpdateFunc: func(oldObj, newObj interface{}) { rc.syncPrices() },
+		DeleteFunc: func(obj interface{}) { rc.syncPrices() },
+	})
+	rc.accountInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { rc.syncAccounts() },
+		UpdateFunc: func(oldObj, newObj interface{}) { rc.syncAccounts() },
+		DeleteFunc: func(obj interface{}) { rc.syncAccounts() },
+	})
+	return rc, nil
+}
+
+// Run starts the informers and reconciles status until stopCh is closed
+func (rc *ResourceController) Run(stopCh <-chan struct{}) {
+	go rc.priceInformer.Run(stopCh)
+	go rc.accountInformer.Run(stopCh)
+	if !cache.WaitForCacheSync(stopCh, rc.priceInformer.HasSynced, rc.accountInformer.HasSynced) {
+		glog.Errorf("Failed to sync billing resources")
+		return
+	}
+	go wait.Until(rc.reconcile, rc.period, stopCh)
+}
+
+// syncPrices merges the PriceList resources with the price history of the config file
+func (rc *ResourceController) syncPrices() {
+	priceLists, err := rc.priceLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Failed to list price lists: %v", err)
+		return
+	}
+	config := rc.ledger.Config()
+	fallback := &config.Rates
+	versions := append([]billing.RateCardVersion{}, config.PriceHistory...)
+	defaultName := defaultPriceList(priceLists)
+	for _, pl := range priceLists {
+		version, err := toRateCardVersion(pl)
+		if err != nil {
+			glog.Errorf("Ignoring invalid price list %s: %v", pl.Name, err)
+			continue
+		}
+		if pl.Spec.EffectiveFrom == nil {
+			if pl.Name != defaultName {
+				glog.Errorf("Ignoring price list %s without effectiveFrom, %s is the default price list", pl.Name, defaultName)
+				continue
+			}
+			fallback = &version.RateCard
+			continue
+		}
+		versions = append(versions, version)
+	}
+	prices, err := billing.NewPriceList(fallback, versions)
+	if err != nil {
+		glog.Errorf("Keeping current prices, price lists are inconsistent: %v", err)
+		return
+	}
+	rc.ledger.SetPrices(prices)
+	glog.V(3).Infof("Synced %d price lists", len(priceLists))
+}
+
+// syncAccounts replaces the accounts of the directory with the BillingAccount resources
+func (rc *ResourceController) syncAccounts() {
+	billingAccounts, err := rc.accountLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Failed to list billing accounts: %v", err)
+		return
+	}
+	accounts := make([]*billing.Account, 0, len(billingAccounts))
+	for _, ba := range billingAccounts {
+		accounts = append(accounts, toAccount(ba))
+	}
+	rc.accounts.Set(accounts)
+	glog.V(3).Infof("Synced %d billing accounts", len(accounts))
+}
+
+// reconcile updates the status of all billing resources
+func (rc *ResourceController) reconcile() {
+	rc.syncPrices()
+	rc.syncAccounts()
+
+	now := time.Now()
+	rc.reconcilePriceLists(now)
+	rc.reconcileAccounts(now)
+}
+
+func (rc *ResourceController) reconcilePriceLists(now time.Time) {
+	priceLists, err := rc.priceLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Failed to list price lists: %v", err)
+		return
+	}
+	spend := make(map[string]billing.Money)
+	for _, record := range rc.ledger.Records() {
+		for _, wc := range record.Breakdown {
+			spend[wc.PriceVersion] += wc.Cost
+		}
+	}
+	defaultName := defaultPriceList(priceLists)
+	for _, pl := range priceLists {
+		status := billingv1.PriceListStatus{
+			Phase:            priceListPhase(pl, now),
+			LastComputedTime: &metav1.Time{Time: now},
+		}
+		version := pl.Name
+		if pl.Spec.EffectiveFrom == nil {
+			version = billing.DefaultPriceVersion
+		}
+		if _, err := toRateCardVersion(pl); err != nil {
+			status.Phase = billingv1.PriceListInvalid
+			status.Message = err.Error()
+		} else if pl.Spec.EffectiveFrom == nil && pl.Name != defaultName {
+			status.Phase = billingv1.PriceListInvalid
+			status.Message = fmt.Sprintf("%s is the default price list, only one price list may omit effectiveFrom", defaultName)
+		} else {
+			status.CurrentSpend = spend[version].String()
+		}
+
+		if !rc.needsUpdate(priceListStatusChanged(&pl.Status, &status), pl.Status.LastComputedTime, now) {
+			continue
+		}
+		updated := pl.DeepCopy()
+		updated.Status = status
+		if _, err := rc.client.BillingV1().PriceLists().UpdateStatus(updated); err != nil {
+			glog.Errorf("Failed to update status of price list %s: %v", pl.Name, err)
+		}
+	}
+}
+
+func (rc *ResourceController) reconcileAccounts(now time.Time) {
+	billingAccounts, err := rc.accountLister.List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Failed to list billing accounts: %v", err)
+		return
+	}
+	periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	spend := make(map[string]billing.Money)
+	count := make(map[string]int64)
+	for _, record := range rc.ledger.RecordsBetween(periodStart, now) {
+		if account := rc.accounts.AccountOf(record); account != nil {
+			spend[account.Name] += record.Cost
+			count[account.Name]++
+		}
+	}
+	for _, ba := range billingAccounts {
+		status := billingv1.BillingAccountStatus{
+			CurrentSpend:     spend[ba.Name].String(),
+			PeriodStart:      &metav1.Time{Time: periodStart},
+			UsageRecords:     count[ba.Name],
+			LastComputedTime: &metav1.Time{Time: now},
+		}
+		if !rc.needsUpdate(accountStatusChanged(&ba.Status, &status), ba.Status.LastComputedTime, now) {
+			continue
+		}
+		updated := ba.DeepCopy()
+		updated.Status = status
+		if _, err := rc.client.BillingV1().BillingAccounts().UpdateStatus(updated); err != nil {
+			glog.Errorf("Failed to update status of billing account %s: %v", ba.Name, err)
+		}
+	}
+}
+
+// needsUpdate returns whether a status is written, every write is an update
+// watched by all informers of the resource
+func (rc *ResourceController) needsUpdate(changed bool, lastComputed *metav1.Time, now time.Time) bool {
+	return changed || lastComputed == nil || now.Sub(lastComputed.Time) >= statusRefreshPeriods*rc.period
+}
+
+// priceListStatusChanged compares the status except the last computed time
+func priceListStatusChanged(old, new *billingv1.PriceListStatus) bool {
+	return old.Phase != new.Phase || old.Message != new.Message || old.CurrentSpend != new.CurrentSpend
+}
+
+// accountStatusChanged compares the status except the last computed time
+func accountStatusChanged(old, new *billingv1.BillingAccountStatus) bool {
+	return old.CurrentSpend != new.CurrentSpend || old.UsageRecords != new.UsageRecords ||
+		old.PeriodStart == nil || !old.PeriodStart.Time.Equal(new.PeriodStart.Time)
+}
+
+// defaultPriceList returns the name of the valid price list without
+// effectiveFrom replacing the rates of the config, the first by name if there
+// are several so the choice does not depend on the order of the lister
+func defaultPriceList(priceLists []*billingv1.PriceList) string {
+	name := ""
+	for _, pl := range priceLists {
+		if pl.Spec.EffectiveFrom != nil || (name != "" && pl.Name >= name) {
+			continue
+		}
+		if _, err := toRateCardVersion(pl); err == nil {
+			name = pl.Name
+		}
+	}
+	return name
+}
+
+func priceListPhase(pl *billingv1.PriceList, now time.Time) billingv1.PriceListPhase {
+	if pl.Spec.EffectiveFrom != nil && now.Before(pl.Spec.EffectiveFrom.Time) {
+		return billingv1.PriceListPending
+	}
+	if pl.Spec.EffectiveTo != nil && !now.Before(pl.Spec.EffectiveTo.Time) {
+		return billingv1.PriceListExpired
+	}
+	return billingv1.PriceListActive
+}
+
+// toRateCardVersion converts a PriceList resource to a rate card version named like the resource
+func toRateCardVersion(pl *billingv1.PriceList) (billing.RateCardVersion, error) {
+	version := billing.RateCardVersion{Version: pl.Name}
+	if pl.Spec.EffectiveFrom != nil {
+		version.EffectiveFrom = pl.Spec.EffectiveFrom.Time
+	}
+	if pl.Spec.EffectiveTo != nil {
+		effectiveTo := pl.Spec.EffectiveTo.Time
+		version.EffectiveTo = &effectiveTo
+	}
+
+	var err error
+	parse := func(field, value string) billing.Money {
+		if value == "" || err != nil {
+			return 0
+		}
+		m, parseErr := billing.ParseMoney(value)
+		if parseErr != nil {
+			err = fmt.Errorf("%s: %v", field, parseErr)
+		}
+		return m
+	}
+	version.CPUCoreHour = parse("cpuCoreHour", pl.Spec.CPUCoreHour)
+	version.MemoryGiBHour = parse("memoryGiBHour", pl.Spec.MemoryGiBHour)
+	version.DefaultGPUHour = parse("defaultGpuHour", pl.Spec.DefaultGPUHour)
+	if len(pl.Spec.GPUHour) > 0 {
+		version.GPUHour = make(map[string]billing.Money)
+		for gpuType, price := range pl.Spec.GPUHour {
+			version.GPUHour[gpuType] = parse("gpuHour."+gpuType, price)
+		}
+	}
+	if err != nil {
+		return version, err
+	}
+
+	if tou := pl.Spec.TimeOfUse; tou != nil {
+		version.TimeOfUse = &billing.TimeOfUse{
+			TimeZone: tou.TimeZone,
+			Holidays: tou.Holidays,
+		}
+		for _, w := range tou.Windows {
+			multiplier, err := billing.ParseFactor(w.Multiplier)
+			if err != nil {
+				return version, fmt.Errorf("window %s: %v", w.Name, err)
+			}
+			version.TimeOfUse.Windows = append(version.TimeOfUse.Windows, billing.PriceWindow{
+				Name:       w.Name,
+				Days:       w.Days,
+				StartHour:  int(w.StartHour),
+				EndHour:    int(w.EndHour),
+				Multiplier: multiplier,
+			})
+		}
+		if err := version.TimeOfUse.Load(""); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
+
+func toAccount(ba *billingv1.BillingAccount) *billing.Account {
+	account := &billing.Account{
+		Name:        ba.Name,
+		DisplayName: ba.Spec.DisplayName,
+		Users:       ba.Spec.Users,
+		Groups:      ba.Spec.Groups,
+		Namespaces:  ba.Spec.Namespaces,
+	}
+	if ba.Spec.Budget != nil {
+		b, err := toBudget(ba.Name, ba.Spec.Budget)
+		if err != nil {
+			glog.Errorf("Ignoring invalid budget of billing account %s: %v", ba.Name, err)
+		} else {
+			account.Budget = b
+		}
+	}
+	return account
+}
+
+// the budget of an account is named after the account
+func toBudget(name string, spec *billingv1.BudgetSpec) (*billing.Budget, error) {
+	amount, err := billing.ParseMoney(spec.Amount)
+	if err != nil {
+		return nil, err
+	}
+	b := &billing.Budget{
+		Name:    name,
+		Scope:   billing.ScopeAccount,
+		Subject: name,
+		Period:  billing.BudgetPeriod(spec.Period),
+		Amount:  amount,
+		Hard:    spec.Hard,
+	}
+	for _, threshold := range spec.Thresholds {
+		b.Thresholds = append(b.Thresholds, int(threshold))
+	}
+	if err := b.Validate(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	billingv1 "github.com/ruanxingbaozi/k8s-billing/pkg/apis/billing/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStatusUpdates(t *testing.T) {
+	rc := &ResourceController{period: time.Minute}
+	now := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+	recent := &metav1.Time{Time: now.Add(-5 * time.Minute)}
+	old := &metav1.Time{Time: now.Add(-statusRefreshPeriods * time.Minute)}
+
+	if rc.needsUpdate(false, recent, now) {
+		t.Error("expected an unchanged recent status to be skipped")
+	}
+	if !rc.needsUpdate(false, old, now) || !rc.needsUpdate(false, nil, now) {
+		t.Error("expected an unchanged status to be refreshed every refresh periods")
+	}
+	if !rc.needsUpdate(true, recent, now) {
+		t.Error("expected a changed status to be written")
+	}
+
+	price := billingv1.PriceListStatus{Phase: billingv1.PriceListActive, CurrentSpend: "10.00", LastComputedTime: recent}
+	if priceListStatusChanged(&price, &billingv1.PriceListStatus{Phase: billingv1.PriceListActive, CurrentSpend: "10.00",
+		LastComputedTime: &metav1.Time{Time: now}}) {
+		t.Error("expected only a new computed time not to be a change")
+	}
+	if !priceListStatusChanged(&price, &billingv1.PriceListStatus{Phase: billingv1.PriceListExpired, CurrentSpend: "10.00"}) {
+		t.Error("expected a new phase to be a change")
+	}
+
+	periodStart := &metav1.Time{Time: time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)}
+	account := billingv1.BillingAccountStatus{CurrentSpend: "5.00", PeriodStart: periodStart, UsageRecords: 2}
+	if accountStatusChanged(&account, &billingv1.BillingAccountStatus{CurrentSpend: "5.00",
+		PeriodStart: &metav1.Time{Time: periodStart.Time}, UsageRecords: 2}) {
+		t.Error("expected the same spend, period and count not to be a change")
+	}
+	if !accountStatusChanged(&account, &billingv1.BillingAccountStatus{CurrentSpend: "5.00", PeriodStart: periodStart, UsageRecords: 3}) {
+		t.Error("expected a new usage record count to be a change")
+	}
+	if !accountStatusChanged(&billingv1.BillingAccountStatus{}, &account) {
+		t.Error("expected an empty status to be a change")
+	}
+}
+
+func TestDefaultPriceList(t *testing.T) {
+	from := &metav1.Time{Time: time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)}
+	priceList := func(name, cpuCoreHour string, effectiveFrom *metav1.Time) *billingv1.PriceList {
+		return &billingv1.PriceList{ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: billingv1.PriceListSpec{CPUCoreHour: cpuCoreHour, EffectiveFrom: effectiveFrom}}
+	}
+	priceLists := []*billingv1.PriceList{
+		priceList("lab", "2", nil),
+		priceList("2019-11", "3", from),
+		priceList("broken", "x", nil),
+		priceList("default", "1", nil),
+	}
+	// the same in any order
+	for i := 0; i < len(priceLists); i++ {
+		if name := defaultPriceList(priceLists); name != "default" {
+			t.Errorf("expected the first undated price list by name, got %q", name)
+		}
+		priceLists = append(priceLists[1:], priceLists[0])
+	}
+	if name := defaultPriceList(priceLists[1:2]); name != "" {
+		t.Errorf("expected no default of dated price lists, got %q", name)
+	}
+}
@@ -0,0 +1,151 @@
+package estimate
+
+import (
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+)
+
+// TaskRoleEstimate is the cost of all tasks of a task role
+type TaskRoleEstimate struct {
+	Name       string `json:"name"`
+	TaskNumber int32  `json:"taskNumber"`
+	GpuType    string `json:"gpuType,omitempty"`
+	// requests of a single task
+	Requests   billing.Requests `json:"requests"`
+	HourlyCost billing.Money    `json:"hourlyCost"`
+
+	// cost of one attempt of every task for the runtime
+	Cost billing.Money `json:"cost"`
+	// attempts of a task if the framework and the task use all retries
+	MaxAttempts   int64         `json:"maxAttempts,omitempty"`
+	WorstCaseCost billing.Money `json:"worstCaseCost"`
+
+	maxTaskRetries int32
+}
+
+// Estimate is the expected cost of a job with the prices in effect
+type Estimate struct {
+	PriceVersion string              `json:"priceVersion"`
+	TaskRoles    []*TaskRoleEstimate `json:"taskRoles"`
+	HourlyCost   billing.Money       `json:"hourlyCost"`
+
+	Runtime time.Duration `json:"runtime,omitempty"`
+	Cost    billing.Money `json:"cost"`
+	// every attempt of every task runs for the runtime
+	WorstCaseCost billing.Money `json:"worstCaseCost"`
+	// maxRetryCount is negative, retries are not limited
+	UnboundedRetries bool `json:"unboundedRetries,omitempty"`
+
+	maxFrameworkRetries int32
+}
+
+// Framework estimates the hourly cost of a framework, taskNumber x container
+// requests of every task role priced at now.
+func Framework(fm *fcapi.Framework, prices *billing.PriceList, now time.Time) *Estimate {
+	version, rates := prices.At(now)
+	estimate := &Estimate{
+		PriceVersion:        version,
+		maxFrameworkRetries: fm.Spec.RetryPolicy.MaxRetryCount,
+	}
+	for _, tr := range fm.Spec.TaskRoles {
+		if tr == nil {
+			continue
+		}
+		_, requests, gpuType := api.PodSpecResource(defaultRequests(&tr.Task.Pod.Spec))
+		tre := &TaskRoleEstimate{
+			Name:           tr.Name,
+			TaskNumber:     tr.TaskNumber,
+			GpuType:        gpuType,
+			Requests:       requests,
+			HourlyCost:     rates.HourlyCost(requests.Multi(int64(tr.TaskNumber)), gpuType),
+			maxTaskRetries: tr.Task.RetryPolicy.MaxRetryCount,
+		}
+		estimate.TaskRoles = append(estimate.TaskRoles, tre)
+		estimate.HourlyCost += tre.HourlyCost
+	}
+	return estimate
+}
+
+// FrameworkRuntime estimates the cost of a framework started at start running
+// for runtime. Every task attempt is billed like a completed pod under the
+// policy, the worst case assumes every retry of the framework and of its
+// tasks is used and runs as long.
+func FrameworkRuntime(fm *fcapi.Framework, prices *billing.PriceList, policy billing.BillingPolicy, start time.Time, runtime time.Duration) *Estimate {
+	estimate := Framework(fm, prices, start)
+	estimate.Runtime = runtime
+	for _, tre := range estimate.TaskRoles {
+		record := &billing.UsageRecord{
+			Requests: tre.Requests,
+			GpuType:  tre.GpuType,
+			Start:    start,
+			End:      start.Add(runtime),
+		}
+		record.Finalize(policy, prices)
+		tre.Cost = record.Cost * billing.Money(tre.TaskNumber)
+
+		attempts, bounded := maxAttempts(estimate.maxFrameworkRetries, tre.maxTaskRetries)
+		if !bounded {
+			estimate.UnboundedRetries = true
+		}
+		tre.MaxAttempts = attempts
+		tre.WorstCaseCost = tre.Cost * billing.Money(attempts)
+
+		estimate.Cost += tre.Cost
+		estimate.WorstCaseCost += tre.WorstCaseCost
+	}
+	return estimate
+}
+
+// maxAttempts of a task, every framework attempt retries the task. A negative
+// maxRetryCount retries without limit, only the first attempt is counted then.
+func maxAttempts(frameworkRetries, taskRetries int32) (int64, bool) {
+	attempts, bounded := int64(1), true
+	for _, retries := range []int32{frameworkRetries, taskRetries} {
+		if retries < 0 {
+			bounded = false
+			continue
+		}
+		attempts *= int64(retries) + 1
+	}
+	return attempts, bounded
+}
+
+// Pod estimates the hourly cost of a single pod priced at now
+func Pod(pod *v1.Pod, prices *billing.PriceList, now time.Time) *Estimate {
+	version, rates := prices.At(now)
+	_, requests, gpuType := api.PodSpecResource(defaultRequests(&pod.Spec))
+	tre := &TaskRoleEstimate{
+		Name:       pod.Annotations[api.AnnotationTaskRoleKey],
+		TaskNumber: 1,
+		GpuType:    gpuType,
+		Requests:   requests,
+		HourlyCost: rates.HourlyCost(requests, gpuType),
+	}
+	return &Estimate{
+		PriceVersion: version,
+		TaskRoles:    []*TaskRoleEstimate{tre},
+		HourlyCost:   tre.HourlyCost,
+	}
+}
+
+// defaultRequests copies the spec with the limits of containers as requests
+// where requests are missing, like the apiserver defaults created pods.
+func defaultRequests(spec *v1.PodSpec) *v1.PodSpec {
+	spec = spec.DeepCopy()
+	for i := range spec.Containers {
+		resources := &spec.Containers[i].Resources
+		for name, limit := range resources.Limits {
+			if resources.Requests == nil {
+				resources.Requests = v1.ResourceList{}
+			}
+			if _, found := resources.Requests[name]; !found {
+				resources.Requests[name] = limit.DeepCopy()
+			}
+		}
+	}
+	return spec
+}
@@ -0,0 +1,89 @@
+package estimate
+
+import (
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func newFramework(taskNumber, frameworkRetries, taskRetries int32) *fcapi.Framework {
+	fm := &fcapi.Framework{}
+	fm.Spec.RetryPolicy.MaxRetryCount = frameworkRetries
+	tr := &fcapi.TaskRoleSpec{Name: "task1", TaskNumber: taskNumber}
+	tr.Task.RetryPolicy.MaxRetryCount = taskRetries
+	tr.Task.Pod.Spec = v1.PodSpec{
+		NodeSelector: map[string]string{"resourceType": "2080ti"},
+		Containers: []v1.Container{{
+			Name: "task1-container",
+			// limits only like example/fm01.yaml, the apiserver defaults the requests
+			Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+				v1.ResourceCPU:          resource.MustParse("16"),
+				v1.ResourceMemory:       resource.MustParse("128Gi"),
+				billing.GPUResourceName: resource.MustParse("8"),
+			}},
+		}},
+	}
+	fm.Spec.TaskRoles = []*fcapi.TaskRoleSpec{tr}
+	return fm
+}
+
+func newPrices() *billing.PriceList {
+	return billing.SinglePriceList(&billing.RateCard{
+		CPUCoreHour:   billing.MustParseMoney("0.05"),
+		MemoryGiBHour: billing.MustParseMoney("0.01"),
+		GPUHour:       map[string]billing.Money{"2080ti": billing.MustParseMoney("2")},
+	})
+}
+
+func TestFrameworkHourlyCost(t *testing.T) {
+	estimate := Framework(newFramework(2, 0, 0), newPrices(), time.Now())
+	// 2 x (16 x 0.05 + 128 x 0.01 + 8 x 2)
+	if expected := billing.MustParseMoney("36.16"); estimate.HourlyCost != expected {
+		t.Errorf("expected hourly cost %v, got %v", expected, estimate.HourlyCost)
+	}
+	if len(estimate.TaskRoles) != 1 || estimate.TaskRoles[0].GpuType != "2080ti" {
+		t.Errorf("expected the 2080ti task role, got %+v", estimate.TaskRoles)
+	}
+}
+
+func TestFrameworkRuntime(t *testing.T) {
+	start := time.Date(2019, 9, 20, 2, 0, 0, 0, time.UTC)
+	policy := billing.BillingPolicy{Granularity: billing.PerHour}
+	tests := []struct {
+		frameworkRetries, taskRetries int32
+		worstCase                     string
+		unbounded                     bool
+	}{
+		{0, 0, "36.16", false},
+		{1, 2, "216.96", false},
+		{-1, 2, "108.48", true},
+	}
+	for _, test := range tests {
+		// 30 minutes are billed as an hour
+		estimate := FrameworkRuntime(newFramework(2, test.frameworkRetries, test.taskRetries), newPrices(), policy, start, 30*time.Minute)
+		if expected := billing.MustParseMoney("36.16"); estimate.Cost != expected {
+			t.Errorf("expected cost %v, got %v", expected, estimate.Cost)
+		}
+		if expected := billing.MustParseMoney(test.worstCase); estimate.WorstCaseCost != expected {
+			t.Errorf("retries %d/%d: expected worst case %v, got %v", test.frameworkRetries, test.taskRetries, expected, estimate.WorstCaseCost)
+		}
+		if estimate.UnboundedRetries != test.unbounded {
+			t.Errorf("retries %d/%d: expected unbounded %v", test.frameworkRetries, test.taskRetries, test.unbounded)
+		}
+	}
+}
+
+func TestPodRequests(t *testing.T) {
+	pod := &v1.Pod{Spec: newFramework(1, 0, 0).Spec.TaskRoles[0].Task.Pod.Spec}
+	// an explicit request is kept, the other resources default to their limits
+	pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")}
+	estimate := Pod(pod, newPrices(), time.Now())
+	// 4 x 0.05 + 128 x 0.01 + 8 x 2
+	if expected := billing.MustParseMoney("17.48"); estimate.HourlyCost != expected {
+		t.Errorf("expected hourly cost %v, got %v", expected, estimate.HourlyCost)
+	}
+}
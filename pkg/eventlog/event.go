@@ -0,0 +1,97 @@
+package eventlog
+
+import (
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// operations of informer events
+const (
+	OpAdd    = "add"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// kinds of logged objects
+const (
+	KindPod       = "Pod"
+	KindFramework = "Framework"
+)
+
+// Event is an informer event as received by the cache handlers, the object
+// is trimmed to the fields billing reads.
+type Event struct {
+	// increasing over all segments of a log
+	Seq       uint64           `json:"seq"`
+	Time      time.Time        `json:"time"`
+	Op        string           `json:"op"`
+	Kind      string           `json:"kind"`
+	Pod       *v1.Pod          `json:"pod,omitempty"`
+	Framework *fcapi.Framework `json:"framework,omitempty"`
+}
+
+// Key returns namespace/name of the object
+func (e *Event) Key() string {
+	if e.Pod != nil {
+		return e.Pod.Namespace + "/" + e.Pod.Name
+	}
+	if e.Framework != nil {
+		return e.Framework.Namespace + "/" + e.Framework.Name
+	}
+	return ""
+}
+
+// trimMeta keeps identity, labels and the deletion time
+func trimMeta(meta *metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:              meta.Name,
+		Namespace:         meta.Namespace,
+		UID:               meta.UID,
+		ResourceVersion:   meta.ResourceVersion,
+		Labels:            meta.Labels,
+		DeletionTimestamp: meta.DeletionTimestamp,
+	}
+}
+
+// TrimPod keeps the fields api.NewPodInfo reads
+func TrimPod(pod *v1.Pod) *v1.Pod {
+	trimmed := &v1.Pod{
+		ObjectMeta: trimMeta(&pod.ObjectMeta),
+		Spec: v1.PodSpec{
+			NodeSelector: pod.Spec.NodeSelector,
+		},
+		Status: v1.PodStatus{
+			Phase:      pod.Status.Phase,
+			Reason:     pod.Status.Reason,
+			Conditions: pod.Status.Conditions,
+		},
+	}
+	for _, key := range []string{api.AnnotationFrameworkNameKey, api.AnnotationTaskRoleKey} {
+		if value, found := pod.Annotations[key]; found {
+			if trimmed.Annotations == nil {
+				trimmed.Annotations = make(map[string]string)
+			}
+			trimmed.Annotations[key] = value
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		trimmed.Spec.Containers = append(trimmed.Spec.Containers, v1.Container{Name: c.Name, Resources: c.Resources})
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		trimmed.Status.ContainerStatuses = append(trimmed.Status.ContainerStatuses,
+			v1.ContainerStatus{Name: cs.Name, RestartCount: cs.RestartCount})
+	}
+	return trimmed
+}
+
+// TrimFramework keeps the metadata and the status, the spec is not billed
+func TrimFramework(fm *fcapi.Framework) *fcapi.Framework {
+	return &fcapi.Framework{
+		ObjectMeta: trimMeta(&fm.ObjectMeta),
+		Status:     fm.Status,
+	}
+}
@@ -0,0 +1,241 @@
+package eventlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	segmentPrefix = "events-"
+	segmentSuffix = ".jsonl"
+)
+
+// Log appends informer events to json lines segments in a directory. A
+// segment is closed when it would grow beyond the segment size and never
+// written again. Closed segments beyond the retention are removed on
+// rotation, events of removed segments can't be replayed.
+type Log struct {
+	sync.Mutex
+
+	dir         string
+	segmentSize int64
+	// retention of closed segments, unlimited if zero
+	maxSegments int
+	maxAge      time.Duration
+
+	segment int
+	file    *os.File
+	size    int64
+	seq     uint64
+}
+
+// Open continues the log in dir after its last event
+func Open(dir string, segmentSize int64) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, segmentSize: segmentSize}
+	if len(segments) == 0 {
+		return l, l.open(1)
+	}
+	last := segments[len(segments)-1]
+	if l.seq, err = repair(last); err != nil {
+		return nil, err
+	}
+	// a rotation leaves the newest segment empty until the next event, the
+	// sequence continues after the last event of the closed segments
+	for i := len(segments) - 2; l.seq == 0 && i >= 0; i-- {
+		data, err := ioutil.ReadFile(segments[i])
+		if err != nil {
+			return nil, err
+		}
+		if l.seq, err = lastSeq(segments[i], data); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := fmt.Sscanf(filepath.Base(last), segmentPrefix+"%08d"+segmentSuffix, &l.segment); err != nil {
+		return nil, fmt.Errorf("invalid segment name %s", last)
+	}
+	return l, l.open(l.segment)
+}
+
+// Segments returns the segment files of the log in dir in order
+func Segments(dir string) ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(dir, segmentPrefix+"*"+segmentSuffix))
+	sort.Strings(segments)
+	return segments, err
+}
+
+func (l *Log) segmentPath(segment int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%s%08d%s", segmentPrefix, segment, segmentSuffix))
+}
+
+func (l *Log) open(segment int) error {
+	file, err := os.OpenFile(l.segmentPath(segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.segment, l.file, l.size = segment, file, info.Size()
+	return nil
+}
+
+// repair drops a partial last line left by a crash and returns the sequence
+// number of the last event of the segment, zero if it has none
+func repair(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end < len(data) {
+		glog.Warningf("Dropping %d bytes of a partial event at the end of %s", len(data)-end, path)
+		if err := os.Truncate(path, int64(end)); err != nil {
+			return 0, err
+		}
+	}
+	return lastSeq(path, data[:end])
+}
+
+// lastSeq returns the sequence number of the last event of the complete
+// lines in data, zero if there are none
+func lastSeq(path string, data []byte) (uint64, error) {
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	event := &Event{}
+	if len(lines[len(lines)-1]) == 0 {
+		return 0, nil
+	}
+	if err := json.Unmarshal(lines[len(lines)-1], event); err != nil {
+		return 0, fmt.Errorf("invalid last event in %s: %v", path, err)
+	}
+	return event.Seq, nil
+}
+
+// RecordPod appends a pod event
+func (l *Log) RecordPod(op string, pod *v1.Pod) {
+	l.append(&Event{Op: op, Kind: KindPod, Pod: TrimPod(pod)})
+}
+
+// RecordFramework appends a framework event
+func (l *Log) RecordFramework(op string, fm *fcapi.Framework) {
+	l.append(&Event{Op: op, Kind: KindFramework, Framework: TrimFramework(fm)})
+}
+
+func (l *Log) append(event *Event) {
+	l.Lock()
+	defer l.Unlock()
+
+	event.Seq = l.seq + 1
+	event.Time = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("Failed to encode %s event of %s: %v", event.Op, event.Key(), err)
+		return
+	}
+	data = append(data, '\n')
+	if l.size > 0 && l.size+int64(len(data)) > l.segmentSize {
+		if err := l.rotate(); err != nil {
+			glog.Errorf("Failed to rotate the event log: %v", err)
+			return
+		}
+	}
+	if _, err := l.file.Write(data); err != nil {
+		glog.Errorf("Failed to append %s event of %s: %v", event.Op, event.Key(), err)
+		return
+	}
+	l.size += int64(len(data))
+	l.seq = event.Seq
+}
+
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if err := l.open(l.segment + 1); err != nil {
+		return err
+	}
+	if err := l.prune(time.Now()); err != nil {
+		glog.Errorf("Failed to remove old segments of the event log: %v", err)
+	}
+	return nil
+}
+
+// SetRetention keeps at most maxSegments segments including the current one
+// and removes closed segments last written more than maxAge ago, a zero
+// limit is unlimited. Segments beyond the retention are removed at once,
+// except for the last closed segment while the current one is empty.
+func (l *Log) SetRetention(maxSegments int, maxAge time.Duration) error {
+	l.Lock()
+	defer l.Unlock()
+	l.maxSegments, l.maxAge = maxSegments, maxAge
+	return l.prune(time.Now())
+}
+
+// prune removes the closed segments beyond the retention, oldest first
+func (l *Log) prune(now time.Time) error {
+	if l.maxSegments <= 0 && l.maxAge <= 0 {
+		return nil
+	}
+	segments, err := Segments(l.dir)
+	if err != nil {
+		return err
+	}
+	current := l.segmentPath(l.segment)
+	removed := 0
+	for i, segment := range segments {
+		if segment == current {
+			break
+		}
+		// the last event of the segment before an empty current one is the
+		// sequence number to continue after on a restart
+		if l.size == 0 && i+1 < len(segments) && segments[i+1] == current {
+			break
+		}
+		expired := l.maxSegments > 0 && len(segments)-i > l.maxSegments
+		if !expired && l.maxAge > 0 {
+			info, err := os.Stat(segment)
+			if err != nil {
+				return err
+			}
+			expired = now.Sub(info.ModTime()) > l.maxAge
+		}
+		if !expired {
+			// later segments were written later
+			break
+		}
+		if err := os.Remove(segment); err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed > 0 {
+		glog.V(3).Infof("Removed %d old segments of the event log", removed)
+	}
+	return nil
+}
+
+// Close closes the current segment
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	return l.file.Close()
+}
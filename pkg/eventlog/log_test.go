@@ -0,0 +1,226 @@
+package eventlog
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testPod(phase v1.PodPhase) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "train-worker-0", Namespace: "default", UID: "uid-1",
+			Annotations: map[string]string{
+				api.AnnotationFrameworkNameKey: "train",
+				api.AnnotationTaskRoleKey:      "worker",
+				"unrelated":                    "dropped",
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "dropped",
+			Containers: []v1.Container{{
+				Name:  "main",
+				Image: "dropped",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU: resource.MustParse("2"),
+				}},
+			}},
+		},
+		Status: v1.PodStatus{Phase: phase},
+	}
+}
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestTrimPod(t *testing.T) {
+	trimmed := TrimPod(testPod(v1.PodRunning))
+	if len(trimmed.Annotations) != 2 || trimmed.Spec.NodeName != "" || trimmed.Spec.Containers[0].Image != "" {
+		t.Errorf("expected unbilled fields to be dropped, got %+v", trimmed)
+	}
+	pi := api.NewPodInfo(trimmed)
+	if pi.FrameworkName != "train" || pi.TaskName != "worker" || pi.Requests.MilliCPU != 2000 {
+		t.Errorf("expected the trimmed pod to keep its billing, got %+v", pi)
+	}
+}
+
+func TestRotateAndReopen(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		l.RecordPod(OpUpdate, testPod(v1.PodRunning))
+	}
+	l.Close()
+	segments, _ := Segments(dir)
+	if len(segments) < 2 {
+		t.Fatalf("expected the log to rotate, got %v", segments)
+	}
+
+	// a crash while appending leaves a partial line
+	f, _ := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"seq":11,"op":"upd`)
+	f.Close()
+
+	l, err = Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.RecordFramework(OpAdd, &fcapi.Framework{ObjectMeta: metav1.ObjectMeta{Name: "train", Namespace: "default"}})
+	l.Close()
+
+	var seqs []uint64
+	if err := Read(dir, func(event *Event) bool {
+		seqs = append(seqs, event.Seq)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 11 {
+		t.Fatalf("expected 11 events, got %v", seqs)
+	}
+	for i, seq := range seqs {
+		if seq != uint64(i+1) {
+			t.Fatalf("expected increasing sequence numbers, got %v", seqs)
+		}
+	}
+}
+
+func TestReopenEmptySegment(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		l.RecordPod(OpUpdate, testPod(v1.PodRunning))
+	}
+	next := l.segmentPath(l.segment + 1)
+	l.Close()
+
+	// a crash right after a rotation leaves a new segment without a whole event
+	if err := ioutil.WriteFile(next, []byte(`{"seq":11,"op":"upd`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	l, err = Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.segmentPath(l.segment) != next {
+		t.Fatalf("expected the log to continue in the empty segment, got segment %d", l.segment)
+	}
+	// the previous segment is kept while the current one is empty
+	if err := l.SetRetention(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	l, err = Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.RecordPod(OpUpdate, testPod(v1.PodSucceeded))
+	l.Close()
+	var last uint64
+	Read(dir, func(event *Event) bool {
+		last = event.Seq
+		return true
+	})
+	if last != 11 {
+		t.Errorf("expected the sequence to continue after the previous segment, got %d", last)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.SetRetention(3, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		l.RecordPod(OpUpdate, testPod(v1.PodRunning))
+	}
+	segments, _ := Segments(dir)
+	if len(segments) != 3 || segments[2] != l.segmentPath(l.segment) {
+		t.Fatalf("expected the last 3 segments to be kept, got %v", segments)
+	}
+	// the kept events stay readable
+	var first uint64
+	Read(dir, func(event *Event) bool {
+		first = event.Seq
+		return false
+	})
+	if first <= 1 {
+		t.Errorf("expected the first events to be removed, got %d", first)
+	}
+
+	// closed segments last written before max age
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(segments[0], old, old)
+	if err := l.SetRetention(0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if kept, _ := Segments(dir); len(kept) != 2 || kept[0] != segments[1] {
+		t.Errorf("expected the expired segment to be removed, got %v", kept)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.RecordPod(OpAdd, testPod(v1.PodPending))
+	l.RecordPod(OpUpdate, testPod(v1.PodRunning))
+	time.Sleep(10 * time.Millisecond)
+	until := time.Now()
+	l.RecordPod(OpDelete, testPod(v1.PodSucceeded))
+	l.Close()
+
+	var ops []string
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { ops = append(ops, OpAdd) },
+		UpdateFunc: func(old, obj interface{}) {
+			if old.(*v1.Pod).Status.Phase != v1.PodPending {
+				t.Errorf("expected the previous pod as old object")
+			}
+			ops = append(ops, OpUpdate)
+		},
+		DeleteFunc: func(obj interface{}) { ops = append(ops, OpDelete) },
+	}
+	replayed, err := Replay(dir, until, handler, cache.ResourceEventHandlerFuncs{})
+	if err != nil || replayed != 2 {
+		t.Fatalf("expected 2 events before until, got %d, %v", replayed, err)
+	}
+	if len(ops) != 2 || ops[0] != OpAdd || ops[1] != OpUpdate {
+		t.Errorf("expected add and update, got %v", ops)
+	}
+}
@@ -0,0 +1,106 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// maximum length of an event line
+const maxEventSize = 16 << 20
+
+// Read calls f with every event of the log in dir in order until f returns
+// false. A partial last line is skipped.
+func Read(dir string, f func(event *Event) bool) error {
+	segments, err := Segments(dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		more, err := readSegment(segment, f)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSegment(path string, f func(event *Event) bool) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	var pending error
+	for line := 1; scanner.Scan(); line++ {
+		if pending != nil {
+			// only the last line may be partial
+			return false, pending
+		}
+		event := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			pending = fmt.Errorf("invalid event at %s:%d: %v", path, line, err)
+			continue
+		}
+		if !f(event) {
+			return false, nil
+		}
+	}
+	return true, scanner.Err()
+}
+
+// Replay feeds the events received before until (all if zero) to the pod
+// and framework handlers as the informers did, so the state of the cache is
+// rebuilt. It returns the number of events replayed.
+func Replay(dir string, until time.Time, pods, frameworks cache.ResourceEventHandler) (int, error) {
+	lastPods := make(map[string]*v1.Pod)
+	lastFrameworks := make(map[string]*fcapi.Framework)
+	replayed := 0
+	err := Read(dir, func(event *Event) bool {
+		if !until.IsZero() && !event.Time.Before(until) {
+			return false
+		}
+		key := event.Key()
+		switch {
+		case event.Pod != nil:
+			old, found := lastPods[key]
+			if !found {
+				old = event.Pod
+			}
+			dispatch(pods, event.Op, old, event.Pod)
+			lastPods[key] = event.Pod
+		case event.Framework != nil:
+			old, found := lastFrameworks[key]
+			if !found {
+				old = event.Framework
+			}
+			dispatch(frameworks, event.Op, old, event.Framework)
+			lastFrameworks[key] = event.Framework
+		default:
+			return true
+		}
+		replayed++
+		return true
+	})
+	return replayed, err
+}
+
+func dispatch(handler cache.ResourceEventHandler, op string, old, obj interface{}) {
+	switch op {
+	case OpAdd:
+		handler.OnAdd(obj)
+	case OpUpdate:
+		handler.OnUpdate(old, obj)
+	case OpDelete:
+		handler.OnDelete(obj)
+	}
+}
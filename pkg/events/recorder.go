@@ -0,0 +1,106 @@
+package events
+
+import (
+	"github.com/golang/glog"
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/budget"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubeClient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// reasons of the billing events
+const (
+	ReasonBilled                 = "Billed"
+	ReasonChargeWaived           = "ChargeWaived"
+	ReasonBudgetThresholdCrossed = "BudgetThresholdCrossed"
+)
+
+// Recorder emits kubernetes events on frameworks and namespaces for billing milestones
+type Recorder struct {
+	recorder record.EventRecorder
+	accounts *billing.AccountDirectory
+}
+
+// NewRecorder creates a recorder sending events with kClient
+func NewRecorder(kClient kubeClient.Interface, accounts *billing.AccountDirectory) *Recorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kClient.CoreV1().Events("")})
+	return &Recorder{
+		recorder: broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "k8s-billing"}),
+		accounts: accounts,
+	}
+}
+
+// EventRecorder returns the underlying recorder, e.g. for budget enforcement
+func (r *Recorder) EventRecorder() record.EventRecorder {
+	return r.recorder
+}
+
+// JobBilled records the final cost of a completed framework
+func (r *Recorder) JobBilled(namespace, name string, uid types.UID, attempts int, cost billing.Money) {
+	r.recorder.Eventf(FrameworkReference(namespace, name, uid), v1.EventTypeNormal, ReasonBilled,
+		"Job completed, billed %v for %d pod attempts", cost.RoundCurrency(), attempts)
+}
+
+// ChargeWaived records a pod attempt not charged because of a platform failure
+func (r *Recorder) ChargeWaived(record *billing.UsageRecord, uid types.UID) {
+	r.recorder.Eventf(FrameworkReference(record.Namespace, record.JobName, uid), v1.EventTypeNormal, ReasonChargeWaived,
+		"Charge of pod %s waived, it failed because of the platform: %s", record.PodName, record.Reason)
+}
+
+// Notify records a crossed budget threshold on the namespaces of the budget,
+// budgets of users and groups have no namespace and are only logged.
+func (r *Recorder) Notify(alert *budget.Alert) error {
+	eventType := v1.EventTypeNormal
+	if alert.Threshold >= 100 {
+		eventType = v1.EventTypeWarning
+	}
+	for _, namespace := range r.namespacesOf(alert) {
+		r.recorder.Eventf(NamespaceReference(namespace), eventType, ReasonBudgetThresholdCrossed,
+			"Budget %s crossed %d%%: spent %v of %v", alert.Budget, alert.Threshold, alert.Spent.RoundCurrency(), alert.Amount)
+	}
+	return nil
+}
+
+func (r *Recorder) namespacesOf(alert *budget.Alert) []string {
+	switch alert.Scope {
+	case billing.ScopeNamespace:
+		return []string{alert.Subject}
+	case billing.ScopeAccount:
+		if r.accounts == nil {
+			return nil
+		}
+		if account := r.accounts.Get(alert.Subject); account != nil {
+			return account.Namespaces
+		}
+	}
+	return nil
+}
+
+// FrameworkReference refers to a framework as the object of an event
+func FrameworkReference(namespace, name string, uid types.UID) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: fcapi.SchemeGroupVersion.String(),
+		Kind:       "Framework",
+		Namespace:  namespace,
+		Name:       name,
+		UID:        uid,
+	}
+}
+
+// NamespaceReference refers to a namespace as the object of an event
+func NamespaceReference(namespace string) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       namespace,
+	}
+}
+
+var _ budget.Notifier = &Recorder{}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/budget"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fakeRecorder keeps the events with the object they were recorded on
+type fakeRecorder struct {
+	events []string
+}
+
+func (f *fakeRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	ref := object.(*v1.ObjectReference)
+	f.events = append(f.events, fmt.Sprintf("%s %s/%s/%s(%s): %s %s %s",
+		ref.APIVersion, ref.Kind, ref.Namespace, ref.Name, ref.UID, eventtype, reason, message))
+}
+
+func (f *fakeRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	f.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (f *fakeRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	f.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func (f *fakeRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	f.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func TestJobEvents(t *testing.T) {
+	fake := &fakeRecorder{}
+	r := &Recorder{recorder: fake}
+
+	r.JobBilled("default", "train", "fm-1", 3, billing.MustParseMoney("12.3456"))
+	r.ChargeWaived(&billing.UsageRecord{Namespace: "default", JobName: "train", PodName: "train-worker-0", Reason: "NodeLost"}, "fm-1")
+	expected := []string{
+		"frameworkcontroller.microsoft.com/v1 Framework/default/train(fm-1): Normal Billed Job completed, billed 12.35 for 3 pod attempts",
+		"frameworkcontroller.microsoft.com/v1 Framework/default/train(fm-1): Normal ChargeWaived Charge of pod train-worker-0 waived, it failed because of the platform: NodeLost",
+	}
+	if len(fake.events) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), fake.events)
+	}
+	for i := range expected {
+		if fake.events[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], fake.events[i])
+		}
+	}
+}
+
+func TestBudgetEvents(t *testing.T) {
+	accounts := billing.NewAccountDirectory()
+	accounts.Set([]*billing.Account{{Name: "lab", Namespaces: []string{"ns01", "ns02"}}})
+	fake := &fakeRecorder{}
+	r := &Recorder{recorder: fake, accounts: accounts}
+
+	alert := func(scope billing.BudgetScope, subject string, threshold int) *budget.Alert {
+		return &budget.Alert{Budget: subject, Scope: scope, Subject: subject, Threshold: threshold,
+			Amount: billing.MustParseMoney("100"), Spent: billing.MustParseMoney("80.004")}
+	}
+	r.Notify(alert(billing.ScopeNamespace, "ns03", 80))
+	r.Notify(alert(billing.ScopeAccount, "lab", 100))
+	// users and groups have no namespace
+	r.Notify(alert(billing.ScopeUser, "alice", 100))
+	r.Notify(alert(billing.ScopeAccount, "unknown", 100))
+
+	expected := []string{
+		"v1 Namespace//ns03(): Normal BudgetThresholdCrossed Budget ns03 crossed 80%: spent 80.00 of 100.00",
+		"v1 Namespace//ns01(): Warning BudgetThresholdCrossed Budget lab crossed 100%: spent 80.00 of 100.00",
+		"v1 Namespace//ns02(): Warning BudgetThresholdCrossed Budget lab crossed 100%: spent 80.00 of 100.00",
+	}
+	if len(fake.events) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), fake.events)
+	}
+	for i := range expected {
+		if fake.events[i] != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], fake.events[i])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package export
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// Column is a field of an exported usage record
+type Column struct {
+	Name string
+	// values are json numbers or objects, written unquoted to json lines
+	Raw   bool
+	Value func(record *billing.UsageRecord) string
+}
+
+// Columns are all columns by name
+var Columns = map[string]Column{}
+
+// DefaultColumns are exported when no columns are selected
+var DefaultColumns []string
+
+func init() {
+	for _, c := range []Column{
+		{"id", false, func(r *billing.UsageRecord) string { return r.ID }},
+		{"job", false, func(r *billing.UsageRecord) string { return r.JobName }},
+		{"task", false, func(r *billing.UsageRecord) string { return r.TaskName }},
+		{"pod", false, func(r *billing.UsageRecord) string { return r.PodName }},
+		{"retry", true, func(r *billing.UsageRecord) string { return strconv.Itoa(r.RetryCount) }},
+		{"user", false, func(r *billing.UsageRecord) string { return r.UserId }},
+		{"group", false, func(r *billing.UsageRecord) string { return r.Group }},
+		{"namespace", false, func(r *billing.UsageRecord) string { return r.Namespace }},
+		{"gpu_type", false, func(r *billing.UsageRecord) string { return r.GpuType }},
+		{"cpu", true, func(r *billing.UsageRecord) string { return formatMilli(r.Requests.MilliCPU) }},
+		{"memory_bytes", true, func(r *billing.UsageRecord) string { return strconv.FormatInt(r.Requests.Memory, 10) }},
+		{"gpu", true, func(r *billing.UsageRecord) string { return formatMilli(r.Requests.MilliGPU) }},
+		{"cpu_core_hours", true, func(r *billing.UsageRecord) string { return formatFloat(r.Usage.CPUHours()) }},
+		{"memory_gib_hours", true, func(r *billing.UsageRecord) string { return formatFloat(r.Usage.MemoryGiBHours()) }},
+		{"gpu_hours", true, func(r *billing.UsageRecord) string { return formatFloat(r.Usage.GPUHours()) }},
+		{"start", false, func(r *billing.UsageRecord) string { return formatTime(r.Start) }},
+		{"end", false, func(r *billing.UsageRecord) string { return formatTime(r.End) }},
+		{"duration_seconds", true, func(r *billing.UsageRecord) string { return formatFloat(r.Duration.Seconds()) }},
+		{"billed_seconds", true, func(r *billing.UsageRecord) string { return formatFloat(r.BilledDuration.Seconds()) }},
+		{"reason", false, func(r *billing.UsageRecord) string { return r.Reason }},
+		{"waived", true, func(r *billing.UsageRecord) string { return strconv.FormatBool(r.Waived) }},
+		// money keeps its exact decimal string like in the api
+		{"cost", false, func(r *billing.UsageRecord) string { return r.Cost.String() }},
+	} {
+		Columns[c.Name] = c
+		// reason and waived are only exported on request
+		if c.Name != "reason" && c.Name != "waived" {
+			DefaultColumns = append(DefaultColumns, c.Name)
+		}
+	}
+}
+
+// ParseColumns resolves comma separated column names in their order, empty selects the default columns
+func ParseColumns(names string) ([]Column, error) {
+	selected := DefaultColumns
+	if strings.TrimSpace(names) != "" {
+		selected = strings.Split(names, ",")
+	}
+	var columns []Column
+	for _, name := range selected {
+		name = strings.TrimSpace(name)
+		c, found := Columns[name]
+		if !found {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// ParseTime accepts RFC3339 times and dates, dates are midnight in loc
+func ParseTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339 or 2006-01-02", value)
+	}
+	return t, nil
+}
+
+// formatMilli writes milli units as exact decimal
+func formatMilli(milli int64) string {
+	s := fmt.Sprintf("%d.%03d", milli/1000, abs(milli%1000))
+	if milli < 0 && milli > -1000 {
+		s = "-" + s
+	}
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,99 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+func testRecords() []*billing.UsageRecord {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	return []*billing.UsageRecord{
+		{
+			ID: "uid-1", JobName: "train", TaskName: "worker", PodName: "train-worker-0",
+			UserId: "alice", Group: "vision", Namespace: "default", GpuType: "v100",
+			Requests: billing.Requests{MilliCPU: 1500, Memory: 1 << 30, MilliGPU: 1000},
+			Start:    start, End: start.Add(90 * time.Minute), Duration: 90 * time.Minute,
+			Cost: billing.Money(1234500),
+		},
+		{
+			ID: "uid-2", JobName: "eval, \"quoted\"", TaskName: "worker",
+			Start: start, End: start.Add(time.Minute), Duration: time.Minute,
+		},
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns("")
+	if err != nil || len(columns) != len(DefaultColumns) {
+		t.Fatalf("expected default columns, got %d, %v", len(columns), err)
+	}
+	columns, err = ParseColumns("cost, job")
+	if err != nil || len(columns) != 2 || columns[0].Name != "cost" || columns[1].Name != "job" {
+		t.Fatalf("expected cost and job in order, got %v, %v", columns, err)
+	}
+	if _, err := ParseColumns("job,price"); err == nil {
+		t.Fatalf("expected unknown column to fail")
+	}
+}
+
+func TestCSV(t *testing.T) {
+	columns, _ := ParseColumns("job,cpu,memory_bytes,gpu,start,duration_seconds,cost")
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatCSV, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteAll(w, testRecords(), 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := "job,cpu,memory_bytes,gpu,start,duration_seconds,cost\n" +
+		"train,1.5,1073741824,1,2024-03-01T08:00:00Z,5400,1.2345\n" +
+		"\"eval, \"\"quoted\"\"\",0,0,0,2024-03-01T08:00:00Z,60,0.00\n"
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	columns, _ := ParseColumns("user,gpu,cost,waived")
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatJSONL, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteAll(w, testRecords(), 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if expected := `{"user":"alice","gpu":1,"cost":"1.2345","waived":false}`; lines[0] != expected {
+		t.Errorf("expected %s, got %s", expected, lines[0])
+	}
+	for _, line := range lines {
+		var row map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Errorf("invalid json line %s: %v", line, err)
+		}
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	if _, err := NewWriter(&bytes.Buffer{}, "xml", nil); err == nil {
+		t.Errorf("expected unknown format to fail")
+	}
+}
+
+func TestFormatMilli(t *testing.T) {
+	for milli, expected := range map[int64]string{0: "0", 1000: "1", 1500: "1.5", 250: "0.25", -500: "-0.5", 1: "0.001"} {
+		if s := formatMilli(milli); s != expected {
+			t.Errorf("expected %d milli as %s, got %s", milli, expected, s)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// SchemaFocus selects the FinOps Open Cost and Usage Specification columns
+const SchemaFocus = "focus"
+
+// FOCUS values of the exported charges
+const (
+	FocusProvider        = "k8s-billing"
+	FocusServiceName     = "FrameworkController"
+	FocusResourceType    = "Pod"
+	FocusChargeCategory  = "Usage"
+	FocusChargeFrequency = "Usage-Based"
+	FocusUnit            = "Hours"
+	// sku of pods without gpus
+	FocusSkuCPU = "cpu"
+
+	defaultCurrency = "USD"
+)
+
+// FocusType is the data type of a FOCUS column
+type FocusType string
+
+const (
+	FocusString   FocusType = "String"
+	FocusDecimal  FocusType = "Decimal"
+	FocusDateTime FocusType = "DateTime"
+	FocusJSON     FocusType = "JSON"
+)
+
+// FocusColumn is a column of the FOCUS 1.0 schema
+type FocusColumn struct {
+	Name     string
+	Type     FocusType
+	Nullable bool
+}
+
+// FocusColumns are the exported FOCUS columns in output order
+var FocusColumns = []FocusColumn{
+	{"BilledCost", FocusDecimal, false},
+	{"BillingAccountId", FocusString, false},
+	{"BillingAccountName", FocusString, true},
+	{"BillingCurrency", FocusString, false},
+	{"BillingPeriodEnd", FocusDateTime, false},
+	{"BillingPeriodStart", FocusDateTime, false},
+	{"ChargeCategory", FocusString, false},
+	{"ChargeClass", FocusString, true},
+	{"ChargeDescription", FocusString, true},
+	{"ChargeFrequency", FocusString, true},
+	{"ChargePeriodEnd", FocusDateTime, false},
+	{"ChargePeriodStart", FocusDateTime, false},
+	{"ConsumedQuantity", FocusDecimal, true},
+	{"ConsumedUnit", FocusString, true},
+	{"ContractedCost", FocusDecimal, false},
+	{"EffectiveCost", FocusDecimal, false},
+	{"InvoiceIssuerName", FocusString, false},
+	{"ListCost", FocusDecimal, false},
+	{"PricingQuantity", FocusDecimal, true},
+	{"PricingUnit", FocusString, true},
+	{"ProviderName", FocusString, false},
+	{"PublisherName", FocusString, false},
+	{"ResourceId", FocusString, true},
+	{"ResourceName", FocusString, true},
+	{"ResourceType", FocusString, true},
+	{"ServiceCategory", FocusString, false},
+	{"ServiceName", FocusString, false},
+	{"SkuId", FocusString, true},
+	{"SubAccountId", FocusString, true},
+	{"SubAccountName", FocusString, true},
+	{"Tags", FocusJSON, true},
+}
+
+// Focus maps usage records to FOCUS charges, one charge per pod attempt and
+// billing period, see Charges. Discounts of the billing account are taken off
+// the billed, contracted and effective cost like the invoices take them off
+// the total, the list cost is the cost before discounts. Credits are left to
+// the invoices.
+type Focus struct {
+	Accounts  *billing.AccountDirectory
+	Discounts []billing.Discount
+	// ISO 4217 currency code, USD if empty
+	Currency string
+	// time zone of the monthly billing periods
+	Location *time.Location
+}
+
+// Columns returns the FOCUS columns in output order
+func (f *Focus) Columns() []Column {
+	values := map[string]func(r *billing.UsageRecord) string{
+		"BilledCost":         func(r *billing.UsageRecord) string { return f.effectiveCost(r).String() },
+		"BillingAccountId":   f.Accounts.BilledTo,
+		"BillingAccountName": f.accountName,
+		"BillingCurrency":    func(r *billing.UsageRecord) string { return f.currency() },
+		"BillingPeriodEnd": func(r *billing.UsageRecord) string {
+			_, end := f.billingPeriod(r)
+			return formatFocusTime(end)
+		},
+		"BillingPeriodStart": func(r *billing.UsageRecord) string {
+			start, _ := f.billingPeriod(r)
+			return formatFocusTime(start)
+		},
+		"ChargeCategory": func(r *billing.UsageRecord) string { return FocusChargeCategory },
+		"ChargeClass":    func(r *billing.UsageRecord) string { return "" },
+		"ChargeDescription": func(r *billing.UsageRecord) string {
+			description := fmt.Sprintf("%s/%s task %s attempt %d", r.Namespace, r.JobName, r.TaskName, r.RetryCount)
+			if r.Waived {
+				description += ", waived: " + r.Reason
+			}
+			return description
+		},
+		"ChargeFrequency":   func(r *billing.UsageRecord) string { return FocusChargeFrequency },
+		"ChargePeriodEnd":   func(r *billing.UsageRecord) string { return formatFocusTime(r.End) },
+		"ChargePeriodStart": func(r *billing.UsageRecord) string { return formatFocusTime(r.Start) },
+		"ConsumedQuantity":  billedHours,
+		"ConsumedUnit":      func(r *billing.UsageRecord) string { return FocusUnit },
+		"ContractedCost":    func(r *billing.UsageRecord) string { return f.effectiveCost(r).String() },
+		"EffectiveCost":     func(r *billing.UsageRecord) string { return f.effectiveCost(r).String() },
+		"InvoiceIssuerName": func(r *billing.UsageRecord) string { return FocusProvider },
+		"ListCost":          func(r *billing.UsageRecord) string { return r.Cost.String() },
+		"PricingQuantity":   billedHours,
+		"PricingUnit":       func(r *billing.UsageRecord) string { return FocusUnit },
+		"ProviderName":      func(r *billing.UsageRecord) string { return FocusProvider },
+		"PublisherName":     func(r *billing.UsageRecord) string { return FocusProvider },
+		"ResourceId":        func(r *billing.UsageRecord) string { return r.ID },
+		"ResourceName":      func(r *billing.UsageRecord) string { return r.PodName },
+		"ResourceType":      func(r *billing.UsageRecord) string { return FocusResourceType },
+		"ServiceCategory": func(r *billing.UsageRecord) string {
+			if r.Requests.MilliGPU > 0 {
+				return "AI and Machine Learning"
+			}
+			return "Compute"
+		},
+		"ServiceName": func(r *billing.UsageRecord) string { return FocusServiceName },
+		"SkuId": func(r *billing.UsageRecord) string {
+			if r.Requests.MilliGPU > 0 && r.GpuType != "" {
+				return r.GpuType
+			}
+			return FocusSkuCPU
+		},
+		"SubAccountId":   func(r *billing.UsageRecord) string { return r.Namespace },
+		"SubAccountName": func(r *billing.UsageRecord) string { return r.Namespace },
+		"Tags": func(r *billing.UsageRecord) string {
+			if len(r.Labels) == 0 {
+				return ""
+			}
+			tags, _ := json.Marshal(r.Labels)
+			return string(tags)
+		},
+	}
+	columns := make([]Column, len(FocusColumns))
+	for i, c := range FocusColumns {
+		columns[i] = Column{Name: c.Name, Raw: c.Type == FocusDecimal || c.Type == FocusJSON, Value: values[c.Name]}
+	}
+	return columns
+}
+
+func (f *Focus) currency() string {
+	if f.Currency == "" {
+		return defaultCurrency
+	}
+	return f.Currency
+}
+
+// accountName prefers the display name of the billing account
+func (f *Focus) accountName(r *billing.UsageRecord) string {
+	if f.Accounts != nil {
+		if account := f.Accounts.AccountOf(r); account != nil && account.DisplayName != "" {
+			return account.DisplayName
+		}
+	}
+	return f.Accounts.BilledTo(r)
+}
+
+// Charges splits the records at the month boundaries, so every charge period
+// lies in its billing period. The cost and usage are split by time and the
+// parts sum up to the record.
+func (f *Focus) Charges(records []*billing.UsageRecord) []*billing.UsageRecord {
+	charges := make([]*billing.UsageRecord, 0, len(records))
+	for _, r := range records {
+		charges = append(charges, f.split(r)...)
+	}
+	return charges
+}
+
+func (f *Focus) split(r *billing.UsageRecord) []*billing.UsageRecord {
+	total := r.End.Sub(r.Start)
+	_, end := billing.Monthly.Bounds(r.Start.In(f.location()))
+	if r.Start.IsZero() || total <= 0 || !end.Before(r.End) {
+		return []*billing.UsageRecord{r}
+	}
+	// until returns the part of v elapsed at t
+	until := func(v *big.Int, t time.Time) *big.Int {
+		part := new(big.Int).Mul(v, big.NewInt(int64(t.Sub(r.Start))))
+		return part.Quo(part, big.NewInt(int64(total)))
+	}
+	between := func(v *big.Int, from, to time.Time) *big.Int {
+		if v == nil {
+			return nil
+		}
+		return new(big.Int).Sub(until(v, to), until(v, from))
+	}
+	var charges []*billing.UsageRecord
+	for from := r.Start; from.Before(r.End); from, end = end, end.AddDate(0, 1, 0) {
+		to := end
+		if to.After(r.End) {
+			to = r.End
+		}
+		charge := *r
+		charge.Start, charge.End, charge.Duration = from, to, to.Sub(from)
+		charge.BilledDuration = time.Duration(between(big.NewInt(int64(r.BilledDuration)), from, to).Int64())
+		charge.Cost = billing.Money(between(big.NewInt(int64(r.Cost)), from, to).Int64())
+		charge.Usage = billing.ResourceTime{
+			MilliCPUSeconds:   between(r.Usage.MilliCPUSeconds, from, to),
+			MemoryByteSeconds: between(r.Usage.MemoryByteSeconds, from, to),
+			MilliGPUSeconds:   between(r.Usage.MilliGPUSeconds, from, to),
+		}
+		charges = append(charges, &charge)
+	}
+	return charges
+}
+
+func (f *Focus) location() *time.Location {
+	if f.Location == nil {
+		return time.UTC
+	}
+	return f.Location
+}
+
+// billingPeriod returns the month the charge starts in, split charges end in
+// it too
+func (f *Focus) billingPeriod(r *billing.UsageRecord) (time.Time, time.Time) {
+	if r.Start.IsZero() {
+		return billing.Monthly.Bounds(r.End.In(f.location()))
+	}
+	return billing.Monthly.Bounds(r.Start.In(f.location()))
+}
+
+// effectiveCost takes the discounts of the account off the cost
+// effectiveCost is the cost after the discounts of the billing account
+func (f *Focus) effectiveCost(r *billing.UsageRecord) billing.Money {
+	account := f.Accounts.BilledTo(r)
+	percent := new(big.Rat)
+	for _, d := range f.Discounts {
+		if d.Account == account {
+			percent.Add(percent, d.Percent.Rat())
+		}
+	}
+	left := new(big.Rat).Sub(big.NewRat(100, 1), percent)
+	if left.Sign() < 0 {
+		return 0
+	}
+	return r.Cost.MulRat(left.Quo(left, big.NewRat(100, 1)))
+}
+
+func billedHours(r *billing.UsageRecord) string {
+	return strconv.FormatFloat(r.BilledDuration.Hours(), 'f', -1, 64)
+}
+
+// formatFocusTime formats times as ISO 8601 in UTC
+func formatFocusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
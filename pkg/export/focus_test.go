@@ -0,0 +1,184 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+var focusDecimal = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+func focusSample() (*Focus, []*billing.UsageRecord) {
+	accounts := billing.NewAccountDirectory()
+	accounts.Set([]*billing.Account{{Name: "lab", DisplayName: "Vision Lab", Groups: []string{"vision"}}})
+	percent, _ := billing.ParseFactor("10")
+	focus := &Focus{
+		Accounts:  accounts,
+		Discounts: []billing.Discount{{Name: "edu", Account: "lab", Percent: percent}},
+		Location:  time.UTC,
+	}
+	start := time.Date(2024, 3, 31, 23, 0, 0, 0, time.UTC)
+	records := testRecords()
+	records[0].Group = "vision"
+	records[0].BilledDuration = 90 * time.Minute
+	records[0].Labels = map[string]string{"platform-user": "alice", "team": "cv"}
+	records[1].UserId = "bob"
+	records[1].Start, records[1].End = start, start.Add(2*time.Hour)
+	records[1].Waived, records[1].Reason = true, "NodeLost"
+	records[1].BilledDuration, records[1].Cost = 2*time.Hour, billing.MustParseMoney("3")
+	return focus, records
+}
+
+// TestFocusSchema validates exported sample data against the FOCUS column types
+func TestFocusSchema(t *testing.T) {
+	focus, records := focusSample()
+	// the second record crosses into april
+	records = focus.Charges(records)
+	if len(records) != 3 {
+		t.Fatalf("expected the second record to be split in 2 charges, got %d charges", len(records))
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatCSV, focus.Columns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteAll(w, records, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(records)+1 {
+		t.Fatalf("expected header and %d rows, got %d rows", len(records), len(rows))
+	}
+	for i, c := range FocusColumns {
+		if rows[0][i] != c.Name {
+			t.Fatalf("expected column %d to be %s, got %s", i, c.Name, rows[0][i])
+		}
+	}
+
+	for _, row := range rows[1:] {
+		values := make(map[string]string)
+		for i, c := range FocusColumns {
+			value := row[i]
+			values[c.Name] = value
+			if value == "" {
+				if !c.Nullable {
+					t.Errorf("%s must not be null", c.Name)
+				}
+				continue
+			}
+			switch c.Type {
+			case FocusDecimal:
+				if !focusDecimal.MatchString(value) {
+					t.Errorf("%s is not a decimal: %q", c.Name, value)
+				}
+			case FocusDateTime:
+				if ts, err := time.Parse(time.RFC3339, value); err != nil || ts.Location() != time.UTC {
+					t.Errorf("%s is not an ISO 8601 time in UTC: %q", c.Name, value)
+				}
+			case FocusJSON:
+				var tags map[string]string
+				if err := json.Unmarshal([]byte(value), &tags); err != nil {
+					t.Errorf("%s is not a json object: %q", c.Name, value)
+				}
+			}
+		}
+		if values["ChargePeriodStart"] > values["ChargePeriodEnd"] {
+			t.Errorf("charge period ends before it starts: %v", row)
+		}
+		if values["ChargePeriodStart"] < values["BillingPeriodStart"] || values["ChargePeriodStart"] >= values["BillingPeriodEnd"] ||
+			values["ChargePeriodEnd"] <= values["BillingPeriodStart"] || values["ChargePeriodEnd"] > values["BillingPeriodEnd"] {
+			t.Errorf("charge period is outside of the billing period: %v", row)
+		}
+		if values["ChargeCategory"] != FocusChargeCategory || values["BillingCurrency"] != "USD" {
+			t.Errorf("unexpected charge category or currency: %v", row)
+		}
+	}
+
+	first, march, april := rows[1], rows[2], rows[3]
+	column := func(row []string, name string) string {
+		for i, c := range FocusColumns {
+			if c.Name == name {
+				return row[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	for name, expected := range map[string]string{
+		"BillingAccountId":   "lab",
+		"BillingAccountName": "Vision Lab",
+		"BilledCost":         "1.11105",
+		"ContractedCost":     "1.11105",
+		"EffectiveCost":      "1.11105",
+		"ListCost":           "1.2345",
+		"ConsumedQuantity":   "1.5",
+		"SkuId":              "v100",
+		"ServiceCategory":    "AI and Machine Learning",
+		"Tags":               `{"platform-user":"alice","team":"cv"}`,
+	} {
+		if value := column(first, name); value != expected {
+			t.Errorf("expected %s %s, got %s", name, expected, value)
+		}
+	}
+	for name, expected := range map[string]string{
+		"BillingAccountId":   "user:bob",
+		"BillingPeriodStart": "2024-03-01T00:00:00Z",
+		"BillingPeriodEnd":   "2024-04-01T00:00:00Z",
+		"ChargePeriodStart":  "2024-03-31T23:00:00Z",
+		"ChargePeriodEnd":    "2024-04-01T00:00:00Z",
+		"BilledCost":         "1.50",
+		"EffectiveCost":      "1.50",
+		"ListCost":           "1.50",
+		"ConsumedQuantity":   "1",
+		"SkuId":              FocusSkuCPU,
+		"Tags":               "",
+	} {
+		if value := column(march, name); value != expected {
+			t.Errorf("expected %s %s in march, got %s", name, expected, value)
+		}
+	}
+	for name, expected := range map[string]string{
+		"BillingPeriodStart": "2024-04-01T00:00:00Z",
+		"BillingPeriodEnd":   "2024-05-01T00:00:00Z",
+		"ChargePeriodStart":  "2024-04-01T00:00:00Z",
+		"ChargePeriodEnd":    "2024-04-01T01:00:00Z",
+		"BilledCost":         "1.50",
+		"ResourceId":         column(march, "ResourceId"),
+	} {
+		if value := column(april, name); value != expected {
+			t.Errorf("expected %s %s in april, got %s", name, expected, value)
+		}
+	}
+}
+
+func TestFocusJSONL(t *testing.T) {
+	focus, records := focusSample()
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, FormatJSONL, focus.Columns())
+	if err := WriteAll(w, records, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var row map[string]interface{}
+		if err := decoder.Decode(&row); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := row["BilledCost"].(float64); !ok {
+			t.Errorf("expected BilledCost to be a number, got %v", row["BilledCost"])
+		}
+		if tags, found := row["Tags"]; !found {
+			t.Errorf("expected Tags in every row")
+		} else if _, ok := tags.(map[string]interface{}); !ok && tags != nil {
+			t.Errorf("expected Tags to be an object or null, got %v", tags)
+		}
+	}
+}
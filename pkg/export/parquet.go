@@ -0,0 +1,117 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// FormatParquet writes a snappy compressed parquet file, only of the FOCUS columns
+const FormatParquet = "parquet"
+
+// parquetWriter writes every flush as a row group, the footer is written on Close
+type parquetWriter struct {
+	pw      *writer.CSVWriter
+	columns []Column
+	types   []FocusColumn
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	focusColumns := make(map[string]FocusColumn)
+	for _, c := range FocusColumns {
+		focusColumns[c.Name] = c
+	}
+	types := make([]FocusColumn, len(columns))
+	metadata := make([]string, len(columns))
+	for i, c := range columns {
+		fc, found := focusColumns[c.Name]
+		if !found {
+			return nil, fmt.Errorf("parquet is only written in the FOCUS columns, not %q", c.Name)
+		}
+		types[i], metadata[i] = fc, parquetMetadata(fc)
+	}
+	pw, err := writer.NewCSVWriterFromWriter(metadata, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetWriter{pw: pw, columns: columns, types: types}, nil
+}
+
+// parquetMetadata maps a FOCUS column to a parquet column, decimals keep six
+// decimals like money and times are milliseconds since the epoch
+func parquetMetadata(c FocusColumn) string {
+	var md string
+	switch c.Type {
+	case FocusDecimal:
+		md = fmt.Sprintf("name=%s, type=INT64, convertedtype=DECIMAL, scale=%d, precision=18", c.Name, billing.MoneyDecimals)
+	case FocusDateTime:
+		md = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS", c.Name)
+	case FocusJSON:
+		md = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=JSON", c.Name)
+	default:
+		md = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8", c.Name)
+	}
+	if c.Nullable {
+		md += ", repetitiontype=OPTIONAL"
+	}
+	return md
+}
+
+func (pw *parquetWriter) Write(record *billing.UsageRecord) error {
+	row := make([]interface{}, len(pw.columns))
+	for i, c := range pw.columns {
+		value, err := parquetValue(pw.types[i], c.Value(record))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", c.Name, err)
+		}
+		row[i] = value
+	}
+	return pw.pw.Write(row)
+}
+
+// Flush writes the buffered rows as a row group
+func (pw *parquetWriter) Flush() error {
+	return pw.pw.Flush(true)
+}
+
+// Close writes the footer, the file is incomplete without it
+func (pw *parquetWriter) Close() error {
+	return pw.pw.WriteStop()
+}
+
+func parquetValue(c FocusColumn, value string) (interface{}, error) {
+	if value == "" && c.Nullable {
+		return nil, nil
+	}
+	switch c.Type {
+	case FocusDecimal:
+		return decimalMicros(value)
+	case FocusDateTime:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, err
+		}
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	return value, nil
+}
+
+// decimalMicros converts a decimal to millionths, exactly for money and
+// rounded for quantities with more decimals
+func decimalMicros(value string) (int64, error) {
+	if m, err := billing.ParseMoney(value); err == nil {
+		return int64(m), nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(f * billing.MoneyScale)), nil
+}
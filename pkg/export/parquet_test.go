@@ -0,0 +1,70 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestFocusParquet(t *testing.T) {
+	focus, records := focusSample()
+	records = focus.Charges(records)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, FormatParquet, focus.Columns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every flush writes a row group
+	if err := WriteAll(w, records, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := reader.NewParquetColumnReader(buffer.NewBufferFileFromBytes(buf.Bytes()), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if rows := pr.GetNumRows(); rows != int64(len(records)) {
+		t.Fatalf("expected %d rows, got %d", len(records), rows)
+	}
+	read := func(name string) []interface{} {
+		for i, c := range FocusColumns {
+			if c.Name == name {
+				values, _, _, err := pr.ReadColumnByIndex(int64(i), int64(len(records)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return values
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return nil
+	}
+	costs := read("ListCost")
+	for i, record := range records {
+		if costs[i] != int64(record.Cost) {
+			t.Errorf("expected cost %v of row %d, got %v", record.Cost, i, costs[i])
+		}
+	}
+	if effective := read("EffectiveCost"); effective[0] != int64(billing.MustParseMoney("1.11105")) {
+		t.Errorf("expected the exact effective cost, got %v", effective[0])
+	}
+	if billed := read("BilledCost"); billed[0] != int64(billing.MustParseMoney("1.11105")) {
+		t.Errorf("expected the discounted billed cost, got %v", billed[0])
+	}
+	april := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	if starts := read("ChargePeriodStart"); starts[2] != april.UnixNano()/int64(time.Millisecond) {
+		t.Errorf("expected the april charge to start in april, got %v", starts[2])
+	}
+	if tags := read("Tags"); tags[0] != `{"platform-user":"alice","team":"cv"}` || tags[1] != nil {
+		t.Errorf("expected tags or null, got %v", tags)
+	}
+
+	if _, err := NewWriter(&buf, FormatParquet, []Column{Columns["id"]}); err == nil {
+		t.Errorf("expected parquet of columns outside of FOCUS to fail")
+	}
+}
@@ -0,0 +1,136 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// formats
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Writer encodes usage records one by one, nothing is kept in memory
+type Writer interface {
+	Write(record *billing.UsageRecord) error
+	// Flush writes buffered rows to the underlying writer
+	Flush() error
+}
+
+// NewWriter creates a writer of the format, the csv header is written at once
+func NewWriter(w io.Writer, format string, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCSV, "":
+		return newCSVWriter(w, columns)
+	case FormatJSONL, "json":
+		return &jsonlWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown export format %q, expected csv, jsonl or parquet", format)
+}
+
+// ContentType returns the mime type of the format
+func ContentType(format string) string {
+	if format == FormatJSONL || format == "json" {
+		return "application/x-ndjson"
+	}
+	if format == FormatParquet {
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+	row     []string
+}
+
+func newCSVWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	cw := &csvWriter{w: csv.NewWriter(w), columns: columns, row: make([]string, len(columns))}
+	for i, c := range columns {
+		cw.row[i] = c.Name
+	}
+	if err := cw.w.Write(cw.row); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) Write(record *billing.UsageRecord) error {
+	for i, c := range cw.columns {
+		cw.row[i] = c.Value(record)
+	}
+	return cw.w.Write(cw.row)
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type jsonlWriter struct {
+	w       *bufio.Writer
+	columns []Column
+}
+
+// Write writes an object with the columns in their order
+func (jw *jsonlWriter) Write(record *billing.UsageRecord) error {
+	jw.w.WriteByte('{')
+	for i, c := range jw.columns {
+		if i > 0 {
+			jw.w.WriteByte(',')
+		}
+		name, _ := json.Marshal(c.Name)
+		jw.w.Write(name)
+		jw.w.WriteByte(':')
+		value := c.Value(record)
+		if c.Raw {
+			if value == "" {
+				value = "null"
+			}
+			jw.w.WriteString(value)
+			continue
+		}
+		quoted, _ := json.Marshal(value)
+		jw.w.Write(quoted)
+	}
+	_, err := jw.w.WriteString("}\n")
+	return err
+}
+
+func (jw *jsonlWriter) Flush() error {
+	return jw.w.Flush()
+}
+
+// WriteAll exports the records, flushing every flushEvery rows, and closes
+// writers that need to, e.g. parquet
+func WriteAll(w Writer, records []*billing.UsageRecord, flushEvery int, flushed func()) error {
+	for i, record := range records {
+		if err := w.Write(record); err != nil {
+			return err
+		}
+		if flushEvery > 0 && (i+1)%flushEvery == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if flushed != nil {
+				flushed()
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if closer, ok := w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
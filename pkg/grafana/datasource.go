@@ -0,0 +1,330 @@
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/rollup"
+)
+
+// metrics of the targets by name
+var metrics = map[string]func(p *rollup.Point) float64{
+	"cost":             func(p *rollup.Point) float64 { return p.Cost.Float() },
+	"cpu_hours":        func(p *rollup.Point) float64 { return p.CPUCoreHours },
+	"memory_gib_hours": func(p *rollup.Point) float64 { return p.MemoryGiBHours },
+	"gpu_hours": func(p *rollup.Point) float64 {
+		hours := 0.0
+		for _, h := range p.GPUHours {
+			hours += h
+		}
+		return hours
+	},
+}
+
+// dimensions of the targets, in the order they are searched
+var dimensions = []string{"user", "group", "namespace", "gputype"}
+
+// all names of a dimension
+const wildcard = "*"
+
+// Rollups provides the usage time series
+type Rollups interface {
+	Names(dimension string) []string
+	Series(q rollup.Query, now time.Time) ([]*rollup.Series, error)
+}
+
+// Cache provides the jobs annotated with their start and completion
+type Cache interface {
+	Snapshot() *api.ClusterInfo
+}
+
+// Datasource implements the Grafana JSON datasource protocol. Targets are
+// named <metric>.<dimension>.<name>, e.g. cost.user.alice or
+// gpu_hours.gputype.v100, and * as name selects every name.
+type Datasource struct {
+	rollups Rollups
+	cache   Cache
+}
+
+// New creates the datasource of the usage rollups and the jobs of the cache
+func New(rollups Rollups, cache Cache) *Datasource {
+	return &Datasource{rollups: rollups, cache: cache}
+}
+
+// Handler serves the datasource, Grafana is pointed at the path it is mounted on
+func (d *Datasource) Handler() http.Handler {
+	mux := http.NewServeMux()
+	// checked when the datasource is saved
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/search", d.Search)
+	mux.HandleFunc("/query", d.Query)
+	mux.HandleFunc("/annotations", d.Annotations)
+	return mux
+}
+
+// Target is a metric of a user, group, namespace or gpu type
+type Target struct {
+	Metric    string
+	Dimension string
+	Name      string
+}
+
+// ParseTarget parses <metric>.<dimension>.<name>, the name may contain dots
+func ParseTarget(s string) (Target, error) {
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) != 3 {
+		return Target{}, fmt.Errorf("invalid target %q, expected <metric>.<dimension>.<name>", s)
+	}
+	t := Target{Metric: parts[0], Dimension: parts[1], Name: parts[2]}
+	if _, found := metrics[t.Metric]; !found {
+		return Target{}, fmt.Errorf("unknown metric %q of target %q", t.Metric, s)
+	}
+	for _, dimension := range dimensions {
+		if t.Dimension == dimension {
+			return t, nil
+		}
+	}
+	return Target{}, fmt.Errorf("unknown dimension %q of target %q", t.Dimension, s)
+}
+
+func (t Target) String() string {
+	return t.Metric + "." + t.Dimension + "." + t.Name
+}
+
+type searchRequest struct {
+	Target string `json:"target"`
+}
+
+// Search lists the targets containing the searched text
+func (d *Datasource) Search(w http.ResponseWriter, r *http.Request) {
+	req := searchRequest{}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	names := make([]string, 0, len(metrics))
+	for metric := range metrics {
+		names = append(names, metric)
+	}
+	sort.Strings(names)
+
+	targets := []string{}
+	for _, dimension := range dimensions {
+		values := append([]string{wildcard}, d.rollups.Names(dimension)...)
+		for _, metric := range names {
+			for _, value := range values {
+				if value == "" {
+					continue
+				}
+				target := Target{Metric: metric, Dimension: dimension, Name: value}.String()
+				if strings.Contains(target, req.Target) {
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+	writeJSON(w, targets)
+}
+
+type timeRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type queryRequest struct {
+	Range      timeRange `json:"range"`
+	IntervalMs int64     `json:"intervalMs"`
+	Targets    []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+type timeSeries struct {
+	Target string `json:"target"`
+	// value and unix milliseconds
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// Query returns the time series of the targets in hourly buckets, or in daily
+// buckets if Grafana asks for an interval of a day or longer
+func (d *Datasource) Query(w http.ResponseWriter, r *http.Request) {
+	req := queryRequest{}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resolution := rollup.ResolutionHour
+	if time.Duration(req.IntervalMs)*time.Millisecond >= 24*time.Hour {
+		resolution = rollup.ResolutionDay
+	}
+	now := time.Now()
+	result := []*timeSeries{}
+	for _, rt := range req.Targets {
+		if rt.Target == "" {
+			continue
+		}
+		target, err := ParseTarget(rt.Target)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		q := rollup.Query{Resolution: resolution, Dimension: target.Dimension, From: req.Range.From, To: req.Range.To}
+		if target.Name != wildcard {
+			q.Name = target.Name
+		}
+		// buckets which started before the range still overlap it
+		if resolution == rollup.ResolutionDay {
+			q.From = q.From.AddDate(0, 0, -1)
+		} else {
+			q.From = q.From.Add(-time.Hour)
+		}
+		series, err := d.rollups.Series(q, now)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		value := metrics[target.Metric]
+		for _, s := range series {
+			ts := &timeSeries{
+				Target:     Target{Metric: target.Metric, Dimension: target.Dimension, Name: s.Name}.String(),
+				Datapoints: make([][2]float64, 0, len(s.Points)),
+			}
+			for _, p := range s.Points {
+				ts.Datapoints = append(ts.Datapoints, [2]float64{value(p), float64(p.Time.UnixNano() / int64(time.Millisecond))})
+			}
+			result = append(result, ts)
+		}
+	}
+	writeJSON(w, result)
+}
+
+type annotationRequest struct {
+	Range      timeRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"`
+	} `json:"annotation"`
+}
+
+type annotation struct {
+	Annotation interface{} `json:"annotation"`
+	// unix milliseconds
+	Time  int64    `json:"time"`
+	Title string   `json:"title"`
+	Text  string   `json:"text"`
+	Tags  []string `json:"tags"`
+}
+
+// Annotations returns the starts and completions of the jobs in the cache
+// within the range. The query of the annotation filters the jobs by user,
+// group and namespace, e.g. "user=alice namespace=default".
+func (d *Datasource) Annotations(w http.ResponseWriter, r *http.Request) {
+	req := annotationRequest{}
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filters, err := parseFilters(req.Annotation.Query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	inRange := func(t time.Time) bool {
+		return !t.IsZero() && !t.Before(req.Range.From) && !t.After(req.Range.To)
+	}
+
+	result := []*annotation{}
+	for _, fi := range d.cache.Snapshot().Jobs {
+		if fi.Status == nil || !filters.match(fi) {
+			continue
+		}
+		name := fi.Namespace + "/" + fi.JobName
+		tags := []string{"user:" + fi.UserId, "group:" + fi.GroupId, "namespace:" + fi.Namespace}
+		if start := fi.Status.StartTime.Time; inRange(start) {
+			result = append(result, &annotation{
+				Annotation: req.Annotation, Time: start.UnixNano() / int64(time.Millisecond),
+				Title: name + " started", Text: "user " + fi.UserId, Tags: append([]string{"start"}, tags...),
+			})
+		}
+		if completion := fi.Status.CompletionTime; completion != nil && inRange(completion.Time) {
+			result = append(result, &annotation{
+				Annotation: req.Annotation, Time: completion.UnixNano() / int64(time.Millisecond),
+				Title: name + " completed", Text: "state " + string(fi.Status.State), Tags: append([]string{"completion"}, tags...),
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Time != result[j].Time {
+			return result[i].Time < result[j].Time
+		}
+		return result[i].Title < result[j].Title
+	})
+	writeJSON(w, result)
+}
+
+// filters select jobs by user, group and namespace
+type filters map[string]string
+
+func parseFilters(query string) (filters, error) {
+	f := make(filters)
+	for _, field := range strings.FieldsFunc(query, func(r rune) bool { return r == ' ' || r == ',' }) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid filter %q, expected key=value", field)
+		}
+		switch parts[0] {
+		case "user", "group", "namespace":
+			f[parts[0]] = parts[1]
+		default:
+			return nil, fmt.Errorf("unknown filter %q, expected user, group or namespace", parts[0])
+		}
+	}
+	return f, nil
+}
+
+func (f filters) match(fi *api.JobInfo) bool {
+	for key, value := range f {
+		switch {
+		case key == "user" && fi.UserId != value,
+			key == "group" && fi.GroupId != value,
+			key == "namespace" && fi.Namespace != value:
+			return false
+		}
+	}
+	return true
+}
+
+func decode(r *http.Request, v interface{}) error {
+	if r.Method != http.MethodPost {
+		return fmt.Errorf("the request must be posted")
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	body, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
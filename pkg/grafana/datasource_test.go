@@ -0,0 +1,120 @@
+package grafana
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/rollup"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeRollups struct {
+	queries []rollup.Query
+}
+
+func (r *fakeRollups) Names(dimension string) []string {
+	if dimension == "gputype" {
+		return []string{"", "v100"}
+	}
+	return []string{"alice"}
+}
+
+func (r *fakeRollups) Series(q rollup.Query, now time.Time) ([]*rollup.Series, error) {
+	r.queries = append(r.queries, q)
+	return []*rollup.Series{{Dimension: q.Dimension, Name: "v100", Points: []*rollup.Point{{
+		Time:   time.Unix(3600, 0),
+		Values: rollup.Values{Cost: billing.MustParseMoney("1.5"), GPUHours: map[string]float64{"v100": 2}},
+	}}}}, nil
+}
+
+type fakeCache struct {
+	info *api.ClusterInfo
+}
+
+func (c fakeCache) Snapshot() *api.ClusterInfo { return c.info }
+
+func post(t *testing.T, h http.Handler, path, body string, v interface{}) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	if v != nil && w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("gpu_hours.gputype.v100.32gb")
+	if err != nil || target.Metric != "gpu_hours" || target.Dimension != "gputype" || target.Name != "v100.32gb" {
+		t.Errorf("unexpected target %+v, %v", target, err)
+	}
+	for _, s := range []string{"cost.user", "memory.user.alice", "cost.pod.alice"} {
+		if _, err := ParseTarget(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestDatasource(t *testing.T) {
+	start := time.Date(2019, 11, 4, 10, 0, 0, 0, time.UTC)
+	completion := metav1.NewTime(start.Add(time.Hour))
+	info := &api.ClusterInfo{Jobs: map[string]*api.JobInfo{
+		"default/train": {JobName: "train", Namespace: "default", UserId: "alice", GroupId: "lab",
+			Status: &fcapi.FrameworkStatus{StartTime: metav1.NewTime(start), CompletionTime: &completion, State: fcapi.FrameworkCompleted}},
+		"default/eval": {JobName: "eval", Namespace: "default", UserId: "bob", GroupId: "lab",
+			Status: &fcapi.FrameworkStatus{StartTime: metav1.NewTime(start)}},
+	}}
+	rollups := &fakeRollups{}
+	h := New(rollups, fakeCache{info}).Handler()
+
+	targets := []string{}
+	if code := post(t, h, "/search", `{"target":"gputype"}`, &targets); code != http.StatusOK {
+		t.Fatalf("search failed with %d", code)
+	}
+	if len(targets) != 8 || targets[0] != "cost.gputype.*" || targets[1] != "cost.gputype.v100" {
+		t.Errorf("unexpected targets %v", targets)
+	}
+
+	series := []*timeSeries{}
+	body := `{"range":{"from":"2019-11-04T00:00:00Z","to":"2019-11-05T00:00:00Z"},"intervalMs":60000,
+		"targets":[{"target":"gpu_hours.gputype.v100"},{"target":"cost.gputype.*"}]}`
+	if code := post(t, h, "/query", body, &series); code != http.StatusOK {
+		t.Fatalf("query failed with %d", code)
+	}
+	if len(series) != 2 || series[0].Target != "gpu_hours.gputype.v100" || series[0].Datapoints[0] != [2]float64{2, 3600000} ||
+		series[1].Datapoints[0][0] != 1.5 {
+		t.Errorf("unexpected series %+v", series)
+	}
+	if q := rollups.queries[0]; q.Resolution != rollup.ResolutionHour || q.Name != "v100" || rollups.queries[1].Name != "" {
+		t.Errorf("unexpected queries %+v", rollups.queries)
+	}
+	body = `{"range":{"from":"2019-10-01T00:00:00Z","to":"2019-11-05T00:00:00Z"},"intervalMs":86400000,"targets":[{"target":"cost.user.alice"}]}`
+	if post(t, h, "/query", body, &series); rollups.queries[2].Resolution != rollup.ResolutionDay {
+		t.Errorf("expected daily buckets for a daily interval, got %+v", rollups.queries[2])
+	}
+	if code := post(t, h, "/query", `{"targets":[{"target":"cost.pod.alice"}]}`, nil); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown dimension to be rejected, got %d", code)
+	}
+
+	annotations := []*annotation{}
+	body = `{"range":{"from":"2019-11-04T00:00:00Z","to":"2019-11-05T00:00:00Z"},"annotation":{"name":"jobs","query":"user=alice"}}`
+	if code := post(t, h, "/annotations", body, &annotations); code != http.StatusOK {
+		t.Fatalf("annotations failed with %d", code)
+	}
+	if len(annotations) != 2 || annotations[0].Title != "default/train started" || annotations[1].Title != "default/train completed" ||
+		annotations[1].Time != completion.UnixNano()/int64(time.Millisecond) {
+		t.Errorf("unexpected annotations %+v", annotations)
+	}
+	body = `{"range":{"from":"2019-11-04T00:00:00Z","to":"2019-11-05T00:00:00Z"},"annotation":{"query":"pod=train"}}`
+	if code := post(t, h, "/annotations", body, nil); code != http.StatusBadRequest {
+		t.Errorf("expected an unknown filter to be rejected, got %d", code)
+	}
+}
@@ -0,0 +1,54 @@
+package invoice
+
+import (
+	"html/template"
+	"io"
+)
+
+var htmlTemplate = template.Must(template.New("invoice").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Number}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.3em 1em; text-align: left; }
+td.amount, th.amount { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{if eq .Kind "adjustment"}}Adjustment{{else}}Invoice{{end}} {{.Number}}</h1>
+<p>
+Account: {{.Account}}{{if .DisplayName}} ({{.DisplayName}}){{end}}<br>
+Period: {{.Period}} ({{.PeriodStart.Format "2006-01-02"}} to {{.PeriodEnd.Format "2006-01-02"}})<br>
+Issued: {{.IssuedAt.Format "2006-01-02 15:04 MST"}}
+{{- if .Reason}}<br>
+Reason: {{.Reason}}{{end}}
+</p>
+{{if .Jobs}}
+<h2>Jobs</h2>
+<table>
+<tr><th>Namespace</th><th>Job</th><th class="amount">Attempts</th><th class="amount">Cost</th></tr>
+{{range .Jobs}}<tr><td>{{.Namespace}}</td><td>{{.JobName}}</td><td class="amount">{{.Attempts}}</td><td class="amount">{{.Cost.RoundCurrency}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Resources</h2>
+<table>
+<tr><th>Resource</th><th class="amount">Quantity</th><th class="amount">Cost</th></tr>
+{{range .Resources}}<tr><td>{{.Resource}}</td><td class="amount">{{if .Unit}}{{printf "%.2f" .Quantity}} {{.Unit}}{{end}}</td><td class="amount">{{.Cost.RoundCurrency}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Subtotal</th><td class="amount">{{.Subtotal.RoundCurrency}}</td></tr>
+{{range .Discounts}}<tr><th>Discount {{.Name}}{{if .Description}}: {{.Description}}{{end}}</th><td class="amount">-{{.Amount.RoundCurrency}}</td></tr>
+{{end}}{{range .Credits}}<tr><th>Credit {{.Name}}{{if .Description}}: {{.Description}}{{end}}</th><td class="amount">-{{.Amount.RoundCurrency}}</td></tr>
+{{end}}<tr><th>Total</th><td class="amount"><strong>{{.Total.RoundCurrency}}</strong></td></tr>
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML renders the invoice as printable html
+func WriteHTML(w io.Writer, inv *Invoice) error {
+	return htmlTemplate.Execute(w, inv)
+}
@@ -0,0 +1,399 @@
+package invoice
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// PeriodLayout is the layout of monthly billing period names, e.g. 2019-09
+const PeriodLayout = "2006-01"
+
+// Source provides the usage records and the billing config, e.g. the ledger
+type Source interface {
+	RecordsBetween(from, to time.Time) []*billing.UsageRecord
+	Config() *billing.Config
+}
+
+// Invoicer closes monthly billing periods and issues invoices per billing
+// account. Closed periods are never recomputed, changes to their usage are
+// only billed by explicit adjustments.
+type Invoicer struct {
+	sync.Mutex
+
+	source   Source
+	accounts *billing.AccountDirectory
+	store    Store
+	location *time.Location
+}
+
+// NewInvoicer creates an invoicer, periods start at midnight in location
+func NewInvoicer(source Source, accounts *billing.AccountDirectory, store Store, location *time.Location) *Invoicer {
+	if location == nil {
+		location = time.Local
+	}
+	return &Invoicer{
+		source:   source,
+		accounts: accounts,
+		store:    store,
+		location: location,
+	}
+}
+
+// Bounds returns the start and exclusive end of the period
+func (iv *Invoicer) Bounds(period string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation(PeriodLayout, period, iv.location)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q, expected e.g. 2019-09", period)
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// Close freezes the usage records which ended in the period and issues an
+// invoice for every billing account with usage. A period can be closed once
+// it ended and only once.
+func (iv *Invoicer) Close(period string, now time.Time) ([]*Invoice, error) {
+	iv.Lock()
+	defer iv.Unlock()
+
+	start, end, err := iv.Bounds(period)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(end) {
+		return nil, fmt.Errorf("period %s has not ended yet", period)
+	}
+	if _, err := iv.store.GetPeriod(period); err == nil {
+		return nil, fmt.Errorf("period %s is closed already, issue an adjustment instead", period)
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+	previous, err := iv.store.ListInvoices()
+	if err != nil {
+		return nil, err
+	}
+
+	records := iv.source.RecordsBetween(start, end)
+	byAccount, names := iv.groupByAccount(records)
+	p := &Period{Name: period, Start: start, End: end, ClosedAt: now, Records: records}
+	var invoices []*Invoice
+	for _, account := range names {
+		// numbered by the store when the period is closed
+		inv := iv.newInvoice(KindInvoice, period, start, end, account, now)
+		inv.Jobs = jobLines(byAccount[account])
+		inv.Resources = resourceLines(byAccount[account])
+		inv.Subtotal = billing.TotalCost(byAccount[account])
+		inv.Discounts = iv.discounts(account, inv.Subtotal)
+		inv.Total = inv.Subtotal - sumLines(inv.Discounts)
+		inv.Credits = iv.credits(account, period, inv.Total, previous)
+		inv.Total -= sumLines(inv.Credits)
+
+		invoices = append(invoices, inv)
+	}
+	// a failed close saves no invoice and uses no number, it can be retried
+	if err := iv.store.ClosePeriod(p, invoices); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// Adjust issues an adjustment of the invoices of an account in a closed
+// period. Without an amount the usage of the period is priced again from the
+// current records and the difference to everything billed so far is issued.
+func (iv *Invoicer) Adjust(period, account, reason string, amount *billing.Money, now time.Time) (*Invoice, error) {
+	iv.Lock()
+	defer iv.Unlock()
+
+	if account == "" || reason == "" {
+		return nil, fmt.Errorf("an adjustment needs an account and a reason")
+	}
+	p, err := iv.store.GetPeriod(period)
+	if err == ErrNotFound {
+		return nil, fmt.Errorf("period %s is not closed, there is nothing to adjust", period)
+	} else if err != nil {
+		return nil, err
+	}
+	inv := iv.newInvoice(KindAdjustment, period, p.Start, p.End, account, now)
+	inv.Reason = reason
+
+	if amount != nil {
+		inv.Resources = []ResourceLine{{Resource: ResourceManual, Cost: *amount}}
+		inv.Subtotal = *amount
+		inv.Total = *amount
+	} else {
+		billed, err := iv.Invoices(period, account)
+		if err != nil {
+			return nil, err
+		}
+		byAccount, _ := iv.groupByAccount(iv.source.RecordsBetween(p.Start, p.End))
+		records := byAccount[account]
+		inv.Jobs = subtractJobs(jobLines(records), billed)
+		inv.Resources = subtractResources(resourceLines(records), billed)
+		for _, line := range inv.Jobs {
+			inv.Subtotal += line.Cost
+		}
+		if inv.Subtotal == 0 && len(inv.Jobs) == 0 {
+			return nil, fmt.Errorf("usage of %s in period %s is unchanged, there is nothing to adjust", account, period)
+		}
+		inv.Discounts = iv.discounts(account, inv.Subtotal)
+		inv.Total = inv.Subtotal - sumLines(inv.Discounts)
+	}
+	if inv.Number, err = iv.store.NextNumber(); err != nil {
+		return nil, err
+	}
+	if err := iv.store.CreateInvoice(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Invoice returns the invoice with the number
+func (iv *Invoicer) Invoice(number string) (*Invoice, error) {
+	return iv.store.GetInvoice(number)
+}
+
+// Invoices returns the invoices of a period and account, empty filters match all
+func (iv *Invoicer) Invoices(period, account string) ([]*Invoice, error) {
+	all, err := iv.store.ListInvoices()
+	if err != nil {
+		return nil, err
+	}
+	var invoices []*Invoice
+	for _, inv := range all {
+		if (period == "" || inv.Period == period) && (account == "" || inv.Account == account) {
+			invoices = append(invoices, inv)
+		}
+	}
+	return invoices, nil
+}
+
+// Closed returns a closed period overlapping [from, to), if any
+func (iv *Invoicer) Closed(from, to time.Time) (string, bool) {
+	periods, err := iv.store.ListPeriods()
+	if err != nil {
+		// assume closed, records must not change unnoticed
+		return "unknown", true
+	}
+	for _, p := range periods {
+		if p.Start.Before(to) && from.Before(p.End) {
+			return p.Name, true
+		}
+	}
+	return "", false
+}
+
+// newInvoice creates an invoice without a number
+func (iv *Invoicer) newInvoice(kind Kind, period string, start, end time.Time, account string, now time.Time) *Invoice {
+	inv := &Invoice{
+		Kind:        kind,
+		Period:      period,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Account:     account,
+		IssuedAt:    now,
+	}
+	if iv.accounts != nil {
+		if a := iv.accounts.Get(account); a != nil {
+			inv.DisplayName = a.DisplayName
+		}
+	}
+	return inv
+}
+
+// groupByAccount groups the records by billing account. Records of no
+// account are billed to their user, group or namespace.
+func (iv *Invoicer) groupByAccount(records []*billing.UsageRecord) (map[string][]*billing.UsageRecord, []string) {
+	byAccount := make(map[string][]*billing.UsageRecord)
+	var names []string
+	for _, record := range records {
+		account := iv.accounts.BilledTo(record)
+		if _, found := byAccount[account]; !found {
+			names = append(names, account)
+		}
+		byAccount[account] = append(byAccount[account], record)
+	}
+	sort.Strings(names)
+	return byAccount, names
+}
+
+func (iv *Invoicer) discounts(account string, subtotal billing.Money) []AmountLine {
+	var lines []AmountLine
+	for _, d := range iv.source.Config().Discounts {
+		if d.Account != account {
+			continue
+		}
+		if amount := d.Of(subtotal); amount != 0 {
+			lines = append(lines, AmountLine{Name: d.Name, Description: d.Description, Amount: amount})
+		}
+	}
+	return lines
+}
+
+// credits uses what is left of the credits of the account, at most total
+func (iv *Invoicer) credits(account, period string, total billing.Money, previous []*Invoice) []AmountLine {
+	var lines []AmountLine
+	for _, c := range iv.source.Config().Credits {
+		if c.Account != account || !c.AppliesTo(period) || total <= 0 {
+			continue
+		}
+		left := c.Amount
+		for _, inv := range previous {
+			if inv.Account != account {
+				continue
+			}
+			for _, line := range inv.Credits {
+				if line.Name == c.Name {
+					left -= line.Amount
+				}
+			}
+		}
+		if left <= 0 {
+			continue
+		}
+		if left > total {
+			left = total
+		}
+		lines = append(lines, AmountLine{Name: c.Name, Description: c.Description, Amount: left})
+		total -= left
+	}
+	return lines
+}
+
+func sumLines(lines []AmountLine) billing.Money {
+	var sum billing.Money
+	for _, line := range lines {
+		sum += line.Amount
+	}
+	return sum
+}
+
+func jobLines(records []*billing.UsageRecord) []JobLine {
+	index := make(map[string]int)
+	var lines []JobLine
+	for _, record := range records {
+		key := record.Namespace + "/" + record.JobName
+		i, found := index[key]
+		if !found {
+			index[key] = len(lines)
+			lines = append(lines, JobLine{JobName: record.JobName, Namespace: record.Namespace})
+			i = len(lines) - 1
+		}
+		lines[i].Attempts++
+		lines[i].Cost += record.Cost
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Namespace != lines[j].Namespace {
+			return lines[i].Namespace < lines[j].Namespace
+		}
+		return lines[i].JobName < lines[j].JobName
+	})
+	return lines
+}
+
+// resourceLines splits the cost by resource type, minimum charges and
+// rounding of the attempts close the gap to the billed cost.
+func resourceLines(records []*billing.UsageRecord) []ResourceLine {
+	var cost billing.ResourceCost
+	for _, record := range records {
+		cost.Add(record.ResourceCost())
+	}
+	usage := billing.TotalUsage(records)
+	lines := []ResourceLine{
+		{Resource: ResourceCPU, Quantity: usage.CPUHours(), Unit: "core hours", Cost: cost.CPU.RoundCurrency()},
+		{Resource: ResourceMemory, Quantity: usage.MemoryGiBHours(), Unit: "GiB hours", Cost: cost.Memory.RoundCurrency()},
+		{Resource: ResourceGPU, Quantity: usage.GPUHours(), Unit: "gpu hours", Cost: cost.GPU.RoundCurrency()},
+	}
+	rounding := billing.TotalCost(records)
+	for _, line := range lines {
+		rounding -= line.Cost
+	}
+	if rounding != 0 {
+		lines = append(lines, ResourceLine{Resource: ResourceRounding, Cost: rounding})
+	}
+	return lines
+}
+
+// subtractJobs returns the changes of the job lines to what was billed
+func subtractJobs(current []JobLine, billed []*Invoice) []JobLine {
+	type total struct {
+		attempts int
+		cost     billing.Money
+	}
+	totals := make(map[string]*total)
+	for _, inv := range billed {
+		for _, line := range inv.Jobs {
+			key := line.Namespace + "/" + line.JobName
+			if totals[key] == nil {
+				totals[key] = &total{}
+			}
+			totals[key].attempts += line.Attempts
+			totals[key].cost += line.Cost
+		}
+	}
+	var lines []JobLine
+	for _, line := range current {
+		key := line.Namespace + "/" + line.JobName
+		if t := totals[key]; t != nil {
+			line.Attempts -= t.attempts
+			line.Cost -= t.cost
+			delete(totals, key)
+		}
+		if line.Attempts != 0 || line.Cost != 0 {
+			lines = append(lines, line)
+		}
+	}
+	// jobs billed before which have no usage any more
+	var gone []string
+	for key := range totals {
+		gone = append(gone, key)
+	}
+	sort.Strings(gone)
+	for _, key := range gone {
+		if t := totals[key]; t.attempts != 0 || t.cost != 0 {
+			namespace, name := splitKey(key)
+			lines = append(lines, JobLine{JobName: name, Namespace: namespace, Attempts: -t.attempts, Cost: -t.cost})
+		}
+	}
+	return lines
+}
+
+// subtractResources returns the changes of the resource lines to what was
+// billed, manual adjustments are kept as they are.
+func subtractResources(current []ResourceLine, billed []*Invoice) []ResourceLine {
+	index := make(map[string]int)
+	lines := append([]ResourceLine{}, current...)
+	for i, line := range lines {
+		index[line.Resource] = i
+	}
+	for _, inv := range billed {
+		for _, line := range inv.Resources {
+			if line.Resource == ResourceManual {
+				continue
+			}
+			i, found := index[line.Resource]
+			if !found {
+				index[line.Resource] = len(lines)
+				lines = append(lines, ResourceLine{Resource: line.Resource, Unit: line.Unit})
+				i = len(lines) - 1
+			}
+			lines[i].Quantity -= line.Quantity
+			lines[i].Cost -= line.Cost
+		}
+	}
+	var changed []ResourceLine
+	for _, line := range lines {
+		if line.Cost != 0 {
+			changed = append(changed, line)
+		}
+	}
+	return changed
+}
+
+func splitKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	return parts[0], parts[1]
+}
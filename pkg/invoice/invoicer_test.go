@@ -0,0 +1,298 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+type testSource struct {
+	config  *billing.Config
+	records []*billing.UsageRecord
+}
+
+func (ts *testSource) RecordsBetween(from, to time.Time) []*billing.UsageRecord {
+	var records []*billing.UsageRecord
+	for _, record := range ts.records {
+		if !record.End.Before(from) && record.End.Before(to) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func (ts *testSource) Config() *billing.Config {
+	return ts.config
+}
+
+func (ts *testSource) add(id, namespace, job, user string, start time.Time, d time.Duration, milliCPU int64) *billing.UsageRecord {
+	record := &billing.UsageRecord{
+		ID:        id,
+		JobName:   job,
+		Namespace: namespace,
+		UserId:    user,
+		Requests:  billing.Requests{MilliCPU: milliCPU, MilliGPU: 1000},
+		Start:     start,
+		End:       start.Add(d),
+	}
+	record.Finalize(ts.config.Policies.Default, ts.config.Prices())
+	ts.records = append(ts.records, record)
+	return record
+}
+
+func newTestInvoicer(store Store) (*Invoicer, *testSource) {
+	config := billing.DefaultConfig()
+	config.Rates.CPUCoreHour = billing.MustParseMoney("0.1")
+	config.Rates.DefaultGPUHour = billing.MustParseMoney("2")
+	config.Discounts = []billing.Discount{{Name: "edu", Account: "lab", Percent: mustFactor("10")}}
+	config.Credits = []billing.Credit{{Name: "welcome", Account: "lab", Amount: billing.MustParseMoney("5")}}
+	source := &testSource{config: config}
+
+	accounts := billing.NewAccountDirectory()
+	accounts.Set([]*billing.Account{{Name: "lab", DisplayName: "Imaging lab", Namespaces: []string{"ns01"}}})
+	return NewInvoicer(source, accounts, store, time.UTC), source
+}
+
+func mustFactor(s string) billing.Factor {
+	f, err := billing.ParseFactor(s)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func TestCloseAndAdjust(t *testing.T) {
+	iv, source := newTestInvoicer(NewMemoryStore())
+	sep := time.Date(2019, 9, 10, 8, 0, 0, 0, time.UTC)
+	// 2 hours of 1 gpu and 1 core: 2 x 2.1
+	source.add("a", "ns01", "fm01", "u1", sep, 2*time.Hour, 1000)
+	source.add("b", "ns01", "fm01", "u1", sep.Add(3*time.Hour), time.Hour, 1000)
+	source.add("c", "ns02", "fm02", "u2", sep, time.Hour, 0)
+	// ended in october
+	source.add("d", "ns01", "fm03", "u1", time.Date(2019, 9, 30, 23, 0, 0, 0, time.UTC), 2*time.Hour, 0)
+
+	if _, err := iv.Close("2019-09", time.Date(2019, 9, 30, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("expected a running period not to close")
+	}
+	invoices, err := iv.Close("2019-09", time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 2 || invoices[0].Account != "lab" || invoices[1].Account != "user:u2" {
+		t.Fatalf("expected invoices of lab and user:u2, got %+v", invoices)
+	}
+	lab := invoices[0]
+	// 6.30 - 10% - 5 credit
+	if lab.Subtotal != billing.MustParseMoney("6.3") || lab.Total != billing.MustParseMoney("0.67") {
+		t.Errorf("expected subtotal 6.30 and total 0.67, got %v and %v", lab.Subtotal, lab.Total)
+	}
+	if len(lab.Jobs) != 1 || lab.Jobs[0].Attempts != 2 {
+		t.Errorf("expected one job line with 2 attempts, got %+v", lab.Jobs)
+	}
+	var resources billing.Money
+	for _, line := range lab.Resources {
+		resources += line.Cost
+	}
+	if resources != lab.Subtotal {
+		t.Errorf("expected resource lines to add up to %v, got %v", lab.Subtotal, resources)
+	}
+	if lab.Number == invoices[1].Number {
+		t.Errorf("expected unique invoice numbers")
+	}
+
+	if _, err := iv.Close("2019-09", time.Date(2019, 10, 2, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("expected a closed period not to close again")
+	}
+	if _, found := iv.Closed(sep, sep.Add(time.Hour)); !found {
+		t.Errorf("expected september to be closed")
+	}
+
+	// a late record of september is only billed by an adjustment
+	source.add("e", "ns01", "fm01", "u1", sep.Add(5*time.Hour), time.Hour, 1000)
+	if _, err := iv.Adjust("2019-09", "lab", "", nil, time.Now()); err == nil {
+		t.Errorf("expected an adjustment without reason to fail")
+	}
+	adjustment, err := iv.Adjust("2019-09", "lab", "late pod", nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adjustment.Kind != KindAdjustment || adjustment.Subtotal != billing.MustParseMoney("2.1") || len(adjustment.Jobs) != 1 {
+		t.Errorf("expected an adjustment of 2.10 for fm01, got %+v", adjustment)
+	}
+	if _, err := iv.Adjust("2019-09", "lab", "again", nil, time.Now()); err == nil {
+		t.Errorf("expected nothing left to adjust")
+	}
+	if original, _ := iv.Invoice(lab.Number); original.Total != lab.Total {
+		t.Errorf("expected the original invoice unchanged")
+	}
+}
+
+func TestCreditsCarryOver(t *testing.T) {
+	iv, source := newTestInvoicer(NewMemoryStore())
+	source.config.Discounts = nil
+	source.add("a", "ns01", "fm01", "u1", time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Hour, 1000)
+	source.add("b", "ns01", "fm01", "u1", time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC), 2*time.Hour, 1000)
+
+	sep, err := iv.Close("2019-09", time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oct, err := iv.Close("2019-10", time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 5 credit: 2.10 used in september, 2.90 of 4.20 in october
+	if sep[0].Total != 0 || oct[0].Total != billing.MustParseMoney("1.3") {
+		t.Errorf("expected totals 0 and 1.30, got %v and %v", sep[0].Total, oct[0].Total)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, source := newTestInvoicer(store)
+	source.add("a", "ns01", "fm01", "u1", time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Hour, 1000)
+	invoices, err := iv.Close("2019-09", time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store on the same directory continues the sequence
+	reopened, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv, err := reopened.GetInvoice(invoices[0].Number)
+	if err != nil || inv.Total != invoices[0].Total {
+		t.Fatalf("expected invoice %s to be read back, got %+v: %v", invoices[0].Number, inv, err)
+	}
+	if err := reopened.CreateInvoice(inv); err != ErrExists {
+		t.Errorf("expected invoices to be immutable, got %v", err)
+	}
+	if next, _ := reopened.NextNumber(); next != "INV-000002" {
+		t.Errorf("expected INV-000002, got %s", next)
+	}
+	p, err := reopened.GetPeriod("2019-09")
+	if err != nil || len(p.Records) != 1 {
+		t.Errorf("expected the frozen record, got %+v: %v", p, err)
+	}
+
+	var html bytes.Buffer
+	if err := WriteHTML(&html, inv); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), inv.Number) || !strings.Contains(html.String(), "Imaging lab") {
+		t.Errorf("expected number and account in html")
+	}
+}
+
+// failingStore fails to close periods while failures are left
+type failingStore struct {
+	*MemoryStore
+	failures int
+}
+
+func (fs *failingStore) ClosePeriod(p *Period, invoices []*Invoice) error {
+	if fs.failures > 0 {
+		fs.failures--
+		return fmt.Errorf("connection reset")
+	}
+	return fs.MemoryStore.ClosePeriod(p, invoices)
+}
+
+func TestCloseRetry(t *testing.T) {
+	store := &failingStore{MemoryStore: NewMemoryStore(), failures: 1}
+	iv, source := newTestInvoicer(store)
+	source.add("a", "ns01", "fm01", "u1", time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Hour, 1000)
+	source.add("b", "ns02", "fm02", "u2", time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Hour, 1000)
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := iv.Close("2019-09", now); err == nil {
+		t.Fatal("expected the close to fail")
+	}
+	if invoices, _ := store.ListInvoices(); len(invoices) != 0 {
+		t.Fatalf("expected no invoice of a failed close, got %d", len(invoices))
+	}
+	invoices, err := iv.Close("2019-09", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := store.ListInvoices()
+	if len(invoices) != 2 || len(stored) != 2 {
+		t.Errorf("expected every account to be invoiced once, got %d invoices and %d stored", len(invoices), len(stored))
+	}
+	if invoices[0].Number != "INV-000001" || invoices[1].Number != "INV-000002" {
+		t.Errorf("expected the failed close not to use numbers, got %s and %s", invoices[0].Number, invoices[1].Number)
+	}
+	// the welcome credit is not used up by the failed close
+	if len(invoices[0].Credits) != 1 || invoices[0].Credits[0].Amount != billing.MustParseMoney("1.89") {
+		t.Errorf("expected the whole total to be credited, got %+v", invoices[0].Credits)
+	}
+}
+
+func TestFileStoreClosePeriod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, source := newTestInvoicer(store)
+	source.add("a", "ns01", "fm01", "u1", time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Hour, 1000)
+	source.add("b", "ns02", "fm02", "u2", time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC), time.Hour, 1000)
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	// the period cannot be written after its invoices
+	blocked := store.periodPath("2019-09") + ".tmp"
+	if err := os.Mkdir(blocked, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iv.Close("2019-09", now); err == nil {
+		t.Fatal("expected the close to fail")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "invoices", "*.json")); len(files) != 0 {
+		t.Errorf("expected the invoices of the failed close to be removed, got %v", files)
+	}
+	os.Remove(blocked)
+
+	// left behind by a crash while closing
+	orphan := &Invoice{Number: "INV-000099", Kind: KindInvoice, Period: "2019-09", Account: "lab"}
+	if err := store.CreateInvoice(orphan); err != nil {
+		t.Fatal(err)
+	}
+	if invoices, _ := store.ListInvoices(); len(invoices) != 0 {
+		t.Errorf("expected invoices of periods not closed to be ignored, got %d", len(invoices))
+	}
+	invoices, err := iv.Close("2019-09", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.ListInvoices(); len(invoices) != 2 || len(stored) != 2 {
+		t.Errorf("expected 2 invoices, got %d and %d stored", len(invoices), len(stored))
+	}
+	if invoices[0].Number != "INV-000001" || invoices[1].Number != "INV-000002" {
+		t.Errorf("expected the failed close not to use numbers, got %s and %s", invoices[0].Number, invoices[1].Number)
+	}
+	if _, err := store.GetInvoice(orphan.Number); err != ErrNotFound {
+		t.Errorf("expected the invoice missing from the period not to be found, got %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package invoice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotFound is returned for unknown invoices and periods
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when an invoice or period would be overwritten
+	ErrExists = errors.New("already exists")
+)
+
+// Store keeps invoices and closed periods. Invoices and periods are never
+// changed once created.
+type Store interface {
+	// NextNumber returns the next unused invoice number
+	NextNumber() (string, error)
+	CreateInvoice(inv *Invoice) error
+	GetInvoice(number string) (*Invoice, error)
+	ListInvoices() ([]*Invoice, error)
+	// ClosePeriod numbers the invoices, adds their numbers to the period and
+	// saves the closed period together with its invoices. Nothing is saved
+	// and no number is used if it fails.
+	ClosePeriod(p *Period, invoices []*Invoice) error
+	GetPeriod(name string) (*Period, error)
+	ListPeriods() ([]*Period, error)
+}
+
+// FormatNumber formats the n-th invoice number
+func FormatNumber(n int64) string {
+	return fmt.Sprintf("INV-%06d", n)
+}
+
+// MemoryStore keeps invoices in memory, they are lost on restart
+type MemoryStore struct {
+	sync.Mutex
+
+	sequence int64
+	invoices map[string]*Invoice
+	periods  map[string]*Period
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		invoices: make(map[string]*Invoice),
+		periods:  make(map[string]*Period),
+	}
+}
+
+// NextNumber returns the next unused invoice number
+func (ms *MemoryStore) NextNumber() (string, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.sequence++
+	return FormatNumber(ms.sequence), nil
+}
+
+// CreateInvoice keeps the invoice
+func (ms *MemoryStore) CreateInvoice(inv *Invoice) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, found := ms.invoices[inv.Number]; found {
+		return ErrExists
+	}
+	ms.invoices[inv.Number] = inv
+	return nil
+}
+
+// GetInvoice returns the invoice with the number
+func (ms *MemoryStore) GetInvoice(number string) (*Invoice, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	if inv, found := ms.invoices[number]; found {
+		return inv, nil
+	}
+	return nil, ErrNotFound
+}
+
+// ListInvoices returns all invoices ordered by number
+func (ms *MemoryStore) ListInvoices() ([]*Invoice, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	invoices := make([]*Invoice, 0, len(ms.invoices))
+	for _, inv := range ms.invoices {
+		invoices = append(invoices, inv)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Number < invoices[j].Number })
+	return invoices, nil
+}
+
+// ClosePeriod numbers and keeps the invoices and the closed period
+func (ms *MemoryStore) ClosePeriod(p *Period, invoices []*Invoice) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, found := ms.periods[p.Name]; found {
+		return ErrExists
+	}
+	for _, inv := range invoices {
+		ms.sequence++
+		inv.Number = FormatNumber(ms.sequence)
+		ms.invoices[inv.Number] = inv
+		p.Invoices = append(p.Invoices, inv.Number)
+	}
+	ms.periods[p.Name] = p
+	return nil
+}
+
+// GetPeriod returns the closed period
+func (ms *MemoryStore) GetPeriod(name string) (*Period, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	if p, found := ms.periods[name]; found {
+		return p, nil
+	}
+	return nil, ErrNotFound
+}
+
+// ListPeriods returns the closed periods ordered by name
+func (ms *MemoryStore) ListPeriods() ([]*Period, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	periods := make([]*Period, 0, len(ms.periods))
+	for _, p := range ms.periods {
+		periods = append(periods, p)
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Name < periods[j].Name })
+	return periods, nil
+}
+
+// FileStore keeps invoices and periods as json files in a directory. The
+// file of a closed period is written after its invoices, invoices missing
+// from their closed period, e.g. after a crash while closing, are ignored.
+type FileStore struct {
+	sync.Mutex
+
+	dir string
+}
+
+// NewFileStore creates the directories of the store in dir
+func NewFileStore(dir string) (*FileStore, error) {
+	for _, sub := range []string{"invoices", "periods"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0750); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// NextNumber returns the next unused invoice number, the sequence survives restarts
+func (fs *FileStore) NextNumber() (string, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	sequence, err := fs.readSequence()
+	if err != nil {
+		return "", err
+	}
+	sequence++
+	if err := fs.writeSequence(sequence); err != nil {
+		return "", err
+	}
+	return FormatNumber(sequence), nil
+}
+
+// readSequence returns the last invoice number used
+func (fs *FileStore) readSequence() (int64, error) {
+	path := filepath.Join(fs.dir, "sequence")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	sequence, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid invoice sequence %s: %v", path, err)
+	}
+	return sequence, nil
+}
+
+func (fs *FileStore) writeSequence(sequence int64) error {
+	return writeFile(filepath.Join(fs.dir, "sequence"), []byte(strconv.FormatInt(sequence, 10)))
+}
+
+// CreateInvoice writes the invoice
+func (fs *FileStore) CreateInvoice(inv *Invoice) error {
+	return fs.create(fs.invoicePath(inv.Number), inv)
+}
+
+// GetInvoice reads the invoice with the number
+func (fs *FileStore) GetInvoice(number string) (*Invoice, error) {
+	inv := &Invoice{}
+	if err := fs.read(fs.invoicePath(number), inv); err != nil {
+		return nil, err
+	}
+	if !fs.issued(inv, make(map[string]*Period)) {
+		return nil, ErrNotFound
+	}
+	return inv, nil
+}
+
+// ListInvoices reads all invoices ordered by number
+func (fs *FileStore) ListInvoices() ([]*Invoice, error) {
+	var invoices []*Invoice
+	periods := make(map[string]*Period)
+	err := fs.list("invoices", func(path string) error {
+		inv := &Invoice{}
+		if err := fs.read(path, inv); err != nil {
+			return err
+		}
+		if fs.issued(inv, periods) {
+			invoices = append(invoices, inv)
+		}
+		return nil
+	})
+	return invoices, err
+}
+
+// issued checks the period of the invoice is closed and lists the invoice,
+// adjustments are only created for closed periods. Periods read are kept in
+// periods, a period not closed is kept as nil.
+func (fs *FileStore) issued(inv *Invoice, periods map[string]*Period) bool {
+	p, found := periods[inv.Period]
+	if !found {
+		p = &Period{}
+		if err := fs.read(fs.periodPath(inv.Period), p); err != nil {
+			p = nil
+		}
+		periods[inv.Period] = p
+	}
+	if p == nil {
+		return false
+	}
+	if inv.Kind != KindInvoice {
+		return true
+	}
+	for _, number := range p.Invoices {
+		if number == inv.Number {
+			return true
+		}
+	}
+	return false
+}
+
+// ClosePeriod takes the numbers of the invoices from the sequence, writes
+// the invoices and then the closed period. The invoices written are removed
+// and the sequence is reset again if a write fails.
+func (fs *FileStore) ClosePeriod(p *Period, invoices []*Invoice) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fs.closed(p.Name) {
+		return ErrExists
+	}
+	sequence, err := fs.readSequence()
+	if err != nil {
+		return err
+	}
+	if err := fs.writeSequence(sequence + int64(len(invoices))); err != nil {
+		return err
+	}
+	var written []string
+	rollback := func(err error) error {
+		for _, path := range written {
+			os.Remove(path)
+		}
+		if resetErr := fs.writeSequence(sequence); resetErr != nil {
+			return fmt.Errorf("%v, and failed to reset the invoice sequence: %v", err, resetErr)
+		}
+		return err
+	}
+	for i, inv := range invoices {
+		inv.Number = FormatNumber(sequence + int64(i) + 1)
+		p.Invoices = append(p.Invoices, inv.Number)
+	}
+	for _, inv := range invoices {
+		path := fs.invoicePath(inv.Number)
+		if err := fs.createLocked(path, inv); err != nil {
+			return rollback(err)
+		}
+		written = append(written, path)
+	}
+	if err := fs.createLocked(fs.periodPath(p.Name), p); err != nil {
+		return rollback(err)
+	}
+	return nil
+}
+
+func (fs *FileStore) closed(period string) bool {
+	_, err := os.Stat(fs.periodPath(period))
+	return err == nil
+}
+
+// GetPeriod reads the closed period
+func (fs *FileStore) GetPeriod(name string) (*Period, error) {
+	p := &Period{}
+	if err := fs.read(fs.periodPath(name), p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListPeriods reads the closed periods ordered by name
+func (fs *FileStore) ListPeriods() ([]*Period, error) {
+	var periods []*Period
+	err := fs.list("periods", func(path string) error {
+		p := &Period{}
+		if err := fs.read(path, p); err != nil {
+			return err
+		}
+		periods = append(periods, p)
+		return nil
+	})
+	return periods, err
+}
+
+func (fs *FileStore) invoicePath(number string) string {
+	return filepath.Join(fs.dir, "invoices", filepath.Base(number)+".json")
+}
+
+func (fs *FileStore) periodPath(name string) string {
+	return filepath.Join(fs.dir, "periods", filepath.Base(name)+".json")
+}
+
+func (fs *FileStore) create(path string, v interface{}) error {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.createLocked(path, v)
+}
+
+func (fs *FileStore) createLocked(path string, v interface{}) error {
+	if _, err := os.Stat(path); err == nil {
+		return ErrExists
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+func (fs *FileStore) read(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// list calls read for the json files of the sub directory in name order
+func (fs *FileStore) list(sub string, read func(path string) error) error {
+	paths, err := filepath.Glob(filepath.Join(fs.dir, sub, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := read(path); err != nil {
+			return fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// writeFile replaces the file atomically
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
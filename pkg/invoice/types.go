@@ -0,0 +1,80 @@
+package invoice
+
+import (
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// Kind of an invoice document
+type Kind string
+
+const (
+	// KindInvoice bills the usage of a closed period
+	KindInvoice Kind = "invoice"
+	// KindAdjustment corrects the invoices of a closed period
+	KindAdjustment Kind = "adjustment"
+)
+
+// Invoice is an immutable, numbered bill of an account for a period
+type Invoice struct {
+	Number      string    `json:"number"`
+	Kind        Kind      `json:"kind"`
+	Period      string    `json:"period"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	Account     string    `json:"account"`
+	DisplayName string    `json:"displayName,omitempty"`
+	IssuedAt    time.Time `json:"issuedAt"`
+	// why an adjustment was issued
+	Reason string `json:"reason,omitempty"`
+
+	Jobs      []JobLine      `json:"jobs"`
+	Resources []ResourceLine `json:"resources"`
+	Subtotal  billing.Money  `json:"subtotal"`
+	Discounts []AmountLine   `json:"discounts,omitempty"`
+	Credits   []AmountLine   `json:"credits,omitempty"`
+	Total     billing.Money  `json:"total"`
+}
+
+// JobLine is the cost of all attempts of a job in the period
+type JobLine struct {
+	JobName   string        `json:"jobName"`
+	Namespace string        `json:"namespace"`
+	Attempts  int           `json:"attempts"`
+	Cost      billing.Money `json:"cost"`
+}
+
+// ResourceLine is the cost of a resource type in the period
+type ResourceLine struct {
+	Resource string        `json:"resource"`
+	Quantity float64       `json:"quantity,omitempty"`
+	Unit     string        `json:"unit,omitempty"`
+	Cost     billing.Money `json:"cost"`
+}
+
+// AmountLine is a discount or credit deducted from the subtotal
+type AmountLine struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Amount      billing.Money `json:"amount"`
+}
+
+// Period is a closed billing period with the usage records frozen when it was closed
+type Period struct {
+	Name     string                 `json:"name"`
+	Start    time.Time              `json:"start"`
+	End      time.Time              `json:"end"`
+	ClosedAt time.Time              `json:"closedAt"`
+	Records  []*billing.UsageRecord `json:"records"`
+	Invoices []string               `json:"invoices"`
+}
+
+// resource lines
+const (
+	ResourceCPU      = "cpu"
+	ResourceMemory   = "memory"
+	ResourceGPU      = "gpu"
+	ResourceRounding = "minimum charges and rounding"
+	ResourceManual   = "manual adjustment"
+)
@@ -0,0 +1,232 @@
+package lake
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// CheckpointName is the file keeping the end of the last exported interval
+const CheckpointName = "_checkpoint.json"
+
+// Source provides the finalized usage records, e.g. the ledger
+type Source interface {
+	// Records returns all records ordered by end time
+	Records() []*billing.UsageRecord
+	// RecordsBetween returns the records that ended in [from, to)
+	RecordsBetween(from, to time.Time) []*billing.UsageRecord
+}
+
+// Checkpoint is the progress of the export
+type Checkpoint struct {
+	// records that ended before are exported
+	Exported time.Time `json:"exported"`
+	// of those, records finalized before are exported, zero for checkpoints
+	// of old versions
+	Finalized time.Time `json:"finalized,omitempty"`
+}
+
+// Exporter writes the records of every closed hour or day once, as parquet
+// files partitioned by date and namespace:
+//
+//	date=2024-03-01/namespace=default/usage-20240301T08.parquet
+//
+// Files of exported intervals are never rewritten. An interval is closed
+// delay after its end, records of closed intervals finalized later are
+// written to late files of their interval by the next export:
+//
+//	date=2024-03-01/namespace=default/usage-20240301T08-late-20240301T093000.parquet
+type Exporter struct {
+	source   Source
+	sink     Sink
+	interval time.Duration
+	delay    time.Duration
+
+	checkpoint *Checkpoint
+}
+
+// NewExporter creates an exporter of hourly or daily files
+func NewExporter(source Source, sink Sink, interval, delay time.Duration) (*Exporter, error) {
+	if interval != time.Hour && interval != 24*time.Hour {
+		return nil, fmt.Errorf("parquet files are written per hour or per day, not per %v", interval)
+	}
+	return &Exporter{source: source, sink: sink, interval: interval, delay: delay}, nil
+}
+
+// Run exports the closed intervals every period until stopCh is closed
+func (e *Exporter) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		files, err := e.Export(time.Now())
+		if err != nil {
+			glog.Errorf("Failed to export usage to parquet: %v", err)
+		}
+		if files > 0 {
+			glog.V(3).Infof("Exported %d parquet files", files)
+		}
+	}, period, stopCh)
+}
+
+// Export writes the records finalized late since the last export and the
+// intervals closed at now that are not exported yet, and returns the number
+// of files written. Only records finalized before now are written.
+func (e *Exporter) Export(now time.Time) (int, error) {
+	from, err := e.exported()
+	if err != nil {
+		return 0, err
+	}
+	files := 0
+	if since := e.checkpoint.Finalized; !since.IsZero() {
+		n, err := e.exportLate(from, since, now)
+		files += n
+		if err != nil {
+			return files, err
+		}
+	}
+	if from.IsZero() {
+		records := e.source.Records()
+		if len(records) == 0 {
+			return 0, nil
+		}
+		from = records[0].End.UTC().Truncate(e.interval)
+	}
+	closed := now.Add(-e.delay).UTC().Truncate(e.interval)
+	for start := from; start.Before(closed); start = start.Add(e.interval) {
+		n, err := e.exportInterval(start, start.Add(e.interval), now)
+		files += n
+		if err != nil {
+			return files, err
+		}
+	}
+	return files, e.setExported(e.checkpoint.Exported, now)
+}
+
+func (e *Exporter) exportInterval(start, end, now time.Time) (int, error) {
+	var records []*billing.UsageRecord
+	for _, record := range e.source.RecordsBetween(start, end) {
+		if record.FinalizedAt.Before(now) {
+			records = append(records, record)
+		}
+	}
+	n, err := e.write(start, records, func(namespace string) string { return e.FileName(start, namespace) })
+	if err != nil {
+		return n, err
+	}
+	// records of earlier intervals finalized before now are exported too
+	return n, e.setExported(end, now)
+}
+
+// exportLate writes the records of the intervals exported before exported
+// which were finalized in [since, now). The files are named by since, so an
+// export failing midway writes them again.
+func (e *Exporter) exportLate(exported, since, now time.Time) (int, error) {
+	var starts []time.Time
+	byInterval := make(map[time.Time][]*billing.UsageRecord)
+	late := 0
+	for _, record := range e.source.RecordsBetween(time.Time{}, exported) {
+		if record.FinalizedAt.Before(since) || !record.FinalizedAt.Before(now) {
+			continue
+		}
+		start := record.End.UTC().Truncate(e.interval)
+		if _, found := byInterval[start]; !found {
+			starts = append(starts, start)
+		}
+		byInterval[start] = append(byInterval[start], record)
+		late++
+	}
+	if late == 0 {
+		return 0, nil
+	}
+	glog.Infof("Exporting %d usage records finalized after their interval was closed", late)
+	files := 0
+	for _, start := range starts {
+		n, err := e.write(start, byInterval[start], func(namespace string) string { return e.LateFileName(start, namespace, since) })
+		files += n
+		if err != nil {
+			return files, err
+		}
+	}
+	return files, nil
+}
+
+// write encodes the records of every namespace to the file named by name
+func (e *Exporter) write(start time.Time, records []*billing.UsageRecord, name func(namespace string) string) (int, error) {
+	byNamespace := make(map[string][]*billing.UsageRecord)
+	for _, record := range records {
+		byNamespace[record.Namespace] = append(byNamespace[record.Namespace], record)
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for namespace := range byNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for i, namespace := range namespaces {
+		data, err := Encode(byNamespace[namespace])
+		if err != nil {
+			return i, fmt.Errorf("failed to encode usage of %s from %v: %v", namespace, start, err)
+		}
+		if err := e.sink.Put(name(namespace), data); err != nil {
+			return i, fmt.Errorf("failed to write usage of %s from %v: %v", namespace, start, err)
+		}
+	}
+	return len(namespaces), nil
+}
+
+// FileName returns the partitioned name of the file of a namespace in the
+// interval starting at start
+func (e *Exporter) FileName(start time.Time, namespace string) string {
+	if namespace == "" {
+		// hive partitions need a value
+		namespace = "__none__"
+	}
+	layout := "20060102T15"
+	if e.interval == 24*time.Hour {
+		layout = "20060102"
+	}
+	start = start.UTC()
+	return fmt.Sprintf("date=%s/namespace=%s/usage-%s.parquet", start.Format("2006-01-02"), namespace, start.Format(layout))
+}
+
+// LateFileName returns the name of the file of a namespace with the records
+// of the interval starting at start which were finalized late since since
+func (e *Exporter) LateFileName(start time.Time, namespace string, since time.Time) string {
+	name := e.FileName(start, namespace)
+	return fmt.Sprintf("%s-late-%s.parquet", strings.TrimSuffix(name, ".parquet"), since.UTC().Format("20060102T150405"))
+}
+
+func (e *Exporter) exported() (time.Time, error) {
+	if e.checkpoint != nil {
+		return e.checkpoint.Exported, nil
+	}
+	data, err := e.sink.Get(CheckpointName)
+	if err == ErrNotFound {
+		e.checkpoint = &Checkpoint{}
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read checkpoint: %v", err)
+	}
+	checkpoint := &Checkpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return time.Time{}, fmt.Errorf("invalid checkpoint: %v", err)
+	}
+	e.checkpoint = checkpoint
+	return checkpoint.Exported, nil
+}
+
+func (e *Exporter) setExported(end, finalized time.Time) error {
+	data, err := json.Marshal(&Checkpoint{Exported: end, Finalized: finalized})
+	if err != nil {
+		return err
+	}
+	if err := e.sink.Put(CheckpointName, data); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	e.checkpoint.Exported, e.checkpoint.Finalized = end, finalized
+	return nil
+}
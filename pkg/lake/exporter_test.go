@@ -0,0 +1,174 @@
+package lake
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+type memorySink map[string][]byte
+
+func (s memorySink) Put(name string, data []byte) error {
+	s[name] = data
+	return nil
+}
+
+func (s memorySink) Get(name string) ([]byte, error) {
+	data, found := s[name]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s memorySink) names() []string {
+	var names []string
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type testSource []*billing.UsageRecord
+
+func (s testSource) Records() []*billing.UsageRecord {
+	return s
+}
+
+func (s testSource) RecordsBetween(from, to time.Time) []*billing.UsageRecord {
+	var records []*billing.UsageRecord
+	for _, record := range s {
+		if !record.End.Before(from) && record.End.Before(to) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func record(id, namespace string, end time.Time) *billing.UsageRecord {
+	return &billing.UsageRecord{
+		ID: id, JobName: "job", Namespace: namespace,
+		Start: end.Add(-time.Hour), End: end, Duration: time.Hour,
+		Cost: billing.MustParseMoney("1.5"),
+	}
+}
+
+func TestExportIncremental(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	source := testSource{
+		record("a", "default", day.Add(8*time.Hour+10*time.Minute)),
+		record("b", "default", day.Add(8*time.Hour+20*time.Minute)),
+		record("c", "vision", day.Add(8*time.Hour+30*time.Minute)),
+		record("d", "default", day.Add(10*time.Hour+5*time.Minute)),
+	}
+	sink := memorySink{}
+	e, err := NewExporter(source, sink, time.Hour, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 9:00 is not closed before 9:05
+	if files, err := e.Export(day.Add(9*time.Hour + time.Minute)); err != nil || files != 0 {
+		t.Fatalf("expected no files, got %d, %v", files, err)
+	}
+	files, err := e.Export(day.Add(10*time.Hour + 30*time.Minute))
+	if err != nil || files != 2 {
+		t.Fatalf("expected 2 files, got %d, %v", files, err)
+	}
+	expected := []string{
+		CheckpointName,
+		"date=2024-03-01/namespace=default/usage-20240301T08.parquet",
+		"date=2024-03-01/namespace=vision/usage-20240301T08.parquet",
+	}
+	if names := sink.names(); len(names) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	if rows := countRows(t, sink[expected[1]]); rows != 2 {
+		t.Errorf("expected 2 rows of default, got %d", rows)
+	}
+
+	// files of exported hours are never written again, a new exporter resumes
+	// from the checkpoint
+	delete(sink, expected[1])
+	e, _ = NewExporter(source, sink, time.Hour, 5*time.Minute)
+	if files, err := e.Export(day.Add(11*time.Hour + 10*time.Minute)); err != nil || files != 1 {
+		t.Fatalf("expected 1 file, got %d, %v", files, err)
+	}
+	if _, found := sink[expected[1]]; found {
+		t.Errorf("expected exported hour not to be rewritten")
+	}
+	if _, found := sink["date=2024-03-01/namespace=default/usage-20240301T10.parquet"]; !found {
+		t.Errorf("expected the file of 10:00, got %v", sink.names())
+	}
+}
+
+func TestExportLate(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	finalized := func(r *billing.UsageRecord, at time.Duration) *billing.UsageRecord {
+		r.FinalizedAt = day.Add(at)
+		return r
+	}
+	source := testSource{finalized(record("a", "default", day.Add(8*time.Hour+10*time.Minute)), 8*time.Hour+15*time.Minute)}
+	sink := memorySink{}
+	e, _ := NewExporter(source, sink, time.Hour, 5*time.Minute)
+	if files, err := e.Export(day.Add(9*time.Hour + 30*time.Minute)); err != nil || files != 1 {
+		t.Fatalf("expected 1 file, got %d, %v", files, err)
+	}
+
+	// b is finalized after 8:00 was exported, c after the next export and d
+	// ends in an interval not closed yet
+	source = append(source,
+		finalized(record("b", "default", day.Add(8*time.Hour+40*time.Minute)), 9*time.Hour+40*time.Minute),
+		finalized(record("c", "vision", day.Add(8*time.Hour+50*time.Minute)), 10*time.Hour+20*time.Minute),
+		finalized(record("d", "default", day.Add(9*time.Hour+50*time.Minute)), 9*time.Hour+55*time.Minute))
+	e, _ = NewExporter(source, sink, time.Hour, 5*time.Minute)
+	if files, err := e.Export(day.Add(10 * time.Hour)); err != nil || files != 1 {
+		t.Fatalf("expected 1 late file, got %d, %v", files, err)
+	}
+	late := "date=2024-03-01/namespace=default/usage-20240301T08-late-20240301T093000.parquet"
+	if data, found := sink[late]; !found || countRows(t, data) != 1 {
+		t.Fatalf("expected b in %s, got %v", late, sink.names())
+	}
+
+	// a new exporter resumes from the checkpoint
+	e, _ = NewExporter(source, sink, time.Hour, 5*time.Minute)
+	if files, err := e.Export(day.Add(10*time.Hour + 30*time.Minute)); err != nil || files != 2 {
+		t.Fatalf("expected a late file of c and the file of 9:00, got %d, %v", files, err)
+	}
+	for _, name := range []string{
+		"date=2024-03-01/namespace=vision/usage-20240301T08-late-20240301T100000.parquet",
+		"date=2024-03-01/namespace=default/usage-20240301T09.parquet",
+	} {
+		if _, found := sink[name]; !found {
+			t.Errorf("expected %s, got %v", name, sink.names())
+		}
+	}
+	if files, err := e.Export(day.Add(10*time.Hour + 40*time.Minute)); err != nil || files != 0 {
+		t.Errorf("expected every record to be exported once, got %d files, %v", files, err)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	e, _ := NewExporter(testSource{}, memorySink{}, 24*time.Hour, 0)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if name := e.FileName(start, ""); name != "date=2024-03-01/namespace=__none__/usage-20240301.parquet" {
+		t.Errorf("unexpected file name %s", name)
+	}
+	if _, err := NewExporter(testSource{}, memorySink{}, time.Minute, 0); err == nil {
+		t.Errorf("expected files per minute to fail")
+	}
+}
+
+func countRows(t *testing.T, data []byte) int64 {
+	pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(data), new(Row), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	return pr.GetNumRows()
+}
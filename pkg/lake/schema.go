@@ -0,0 +1,95 @@
+package lake
+
+import (
+	"bytes"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Row is the parquet schema of a usage record, one row per pod attempt.
+// Columns are only ever added, so queries over old files keep working.
+type Row struct {
+	ID         string `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	JobName    string `parquet:"name=job, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	TaskName   string `parquet:"name=task, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PodName    string `parquet:"name=pod, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RetryCount int32  `parquet:"name=retry, type=INT32"`
+	UserId     string `parquet:"name=user, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Group      string `parquet:"name=group, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Namespace  string `parquet:"name=namespace, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	GpuType    string `parquet:"name=gpu_type, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Reason     string `parquet:"name=reason, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Waived     bool   `parquet:"name=waived, type=BOOLEAN"`
+
+	MilliCPU    int64 `parquet:"name=milli_cpu, type=INT64"`
+	MemoryBytes int64 `parquet:"name=memory_bytes, type=INT64"`
+	MilliGPU    int64 `parquet:"name=milli_gpu, type=INT64"`
+
+	// times in milliseconds since the epoch
+	Start          int64 `parquet:"name=start, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	End            int64 `parquet:"name=end, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	DurationMillis int64 `parquet:"name=duration_ms, type=INT64"`
+	BilledMillis   int64 `parquet:"name=billed_ms, type=INT64"`
+
+	CPUCoreHours   float64 `parquet:"name=cpu_core_hours, type=DOUBLE"`
+	MemoryGiBHours float64 `parquet:"name=memory_gib_hours, type=DOUBLE"`
+	GPUHours       float64 `parquet:"name=gpu_hours, type=DOUBLE"`
+	// cost in micro units, exactly as billed
+	Cost int64 `parquet:"name=cost, type=INT64, convertedtype=DECIMAL, scale=6, precision=18"`
+
+	Labels map[string]string `parquet:"name=labels, type=MAP, convertedtype=MAP, keytype=BYTE_ARRAY, keyconvertedtype=UTF8, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+// NewRow converts a finalized usage record
+func NewRow(record *billing.UsageRecord) *Row {
+	return &Row{
+		ID:             record.ID,
+		JobName:        record.JobName,
+		TaskName:       record.TaskName,
+		PodName:        record.PodName,
+		RetryCount:     int32(record.RetryCount),
+		UserId:         record.UserId,
+		Group:          record.Group,
+		Namespace:      record.Namespace,
+		GpuType:        record.GpuType,
+		Reason:         record.Reason,
+		Waived:         record.Waived,
+		MilliCPU:       record.Requests.MilliCPU,
+		MemoryBytes:    record.Requests.Memory,
+		MilliGPU:       record.Requests.MilliGPU,
+		Start:          millis(record.Start.UnixNano()),
+		End:            millis(record.End.UnixNano()),
+		DurationMillis: millis(int64(record.Duration)),
+		BilledMillis:   millis(int64(record.BilledDuration)),
+		CPUCoreHours:   record.Usage.CPUHours(),
+		MemoryGiBHours: record.Usage.MemoryGiBHours(),
+		GPUHours:       record.Usage.GPUHours(),
+		Cost:           int64(record.Cost),
+		Labels:         record.Labels,
+	}
+}
+
+func millis(nanos int64) int64 {
+	return nanos / 1e6
+}
+
+// Encode writes the records as a snappy compressed parquet file
+func Encode(records []*billing.UsageRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	pw, err := writer.NewParquetWriterFromWriter(&buf, new(Row), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	for _, record := range records {
+		if err := pw.Write(NewRow(record)); err != nil {
+			return nil, err
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
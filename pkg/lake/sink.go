@@ -0,0 +1,101 @@
+package lake
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	minio "github.com/minio/minio-go/v6"
+)
+
+// ErrNotFound is returned for missing objects
+var ErrNotFound = errors.New("object not found")
+
+// Sink stores the exported files by slash separated name
+type Sink interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+}
+
+// DirSink writes files below a local directory
+type DirSink struct {
+	Dir string
+}
+
+// Put writes the file atomically, readers never see a partial file
+func (s *DirSink) Put(name string, data []byte) error {
+	file := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// hidden so query engines skip it while it is written
+	tmp := filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Get reads a file
+func (s *DirSink) Get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// S3Sink writes objects to a bucket of an S3 compatible endpoint, e.g. MinIO
+type S3Sink struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Sink connects to the endpoint, the bucket must exist
+func NewS3Sink(endpoint, accessKey, secretKey, bucket, prefix string, secure bool) (*S3Sink, error) {
+	client, err := minio.New(endpoint, accessKey, secretKey, secure)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Sink{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+func (s *S3Sink) object(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return path.Join(s.prefix, name)
+}
+
+// Put uploads the object, uploads are atomic in S3
+func (s *S3Sink) Put(name string, data []byte) error {
+	_, err := s.client.PutObject(s.bucket, s.object(name), bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType(name)})
+	return err
+}
+
+// Get downloads the object
+func (s *S3Sink) Get(name string) ([]byte, error) {
+	object, err := s.client.GetObject(s.bucket, s.object(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	data, err := ioutil.ReadAll(object)
+	if err != nil && minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func contentType(name string) string {
+	if strings.HasSuffix(name, ".parquet") {
+		return "application/vnd.apache.parquet"
+	}
+	return "application/json"
+}
@@ -0,0 +1,155 @@
+package lake
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testSink(t *testing.T, sink Sink) {
+	if _, err := sink.Get("missing.json"); err != ErrNotFound {
+		t.Errorf("expected missing object not to be found, got %v", err)
+	}
+	if err := sink.Put("date=2024-03-01/namespace=default/usage-20240301.parquet", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := sink.Get("date=2024-03-01/namespace=default/usage-20240301.parquet")
+	if err != nil || string(data) != "data" {
+		t.Errorf("expected data, got %q, %v", data, err)
+	}
+}
+
+func TestDirSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testSink(t, &DirSink{Dir: dir})
+}
+
+// fakeS3 is an in-process stand-in of an S3 endpoint like MinIO, it keeps the
+// objects of one bucket in memory and does not verify signatures
+type fakeS3 struct {
+	sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	// path style requests, /bucket/key
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != s.bucket {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if _, found := r.URL.Query()["location"]; found {
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`)
+		return
+	}
+	if len(parts) < 2 || parts[1] == "" {
+		s3Error(w, http.StatusBadRequest, "InvalidRequest")
+		return
+	}
+	key := parts[1]
+	s.Lock()
+	defer s.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err == nil && r.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+			data, err = decodeChunks(data)
+		}
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.objects[key] = data
+		w.Header().Set("ETag", etag(data))
+	case http.MethodGet, http.MethodHead:
+		data, found := s.objects[key]
+		if !found {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", etag(data))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>%s</Code><Message>%s</Message></Error>`, code, code)
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(data))
+}
+
+// decodeChunks decodes the aws-chunked body of uploads with a streaming
+// signature, which the client sends over plain http
+func decodeChunks(data []byte) ([]byte, error) {
+	var decoded []byte
+	for {
+		i := bytes.Index(data, []byte("\r\n"))
+		if i < 0 {
+			return nil, fmt.Errorf("missing chunk header")
+		}
+		size, err := strconv.ParseInt(strings.SplitN(string(data[:i]), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		data = data[i+2:]
+		if size == 0 {
+			return decoded, nil
+		}
+		if int64(len(data)) < size+2 {
+			return nil, fmt.Errorf("short chunk")
+		}
+		decoded = append(decoded, data[:size]...)
+		data = data[size+2:]
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	s3 := &fakeS3{bucket: "usage", objects: make(map[string][]byte)}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+
+	sink, err := NewS3Sink(strings.TrimPrefix(server.URL, "http://"), "access", "secret", "usage", "/test/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSink(t, sink)
+	if _, found := s3.objects["test/date=2024-03-01/namespace=default/usage-20240301.parquet"]; !found {
+		t.Errorf("expected the object below the prefix, got %d objects", len(s3.objects))
+	}
+
+	sink, _ = NewS3Sink(strings.TrimPrefix(server.URL, "http://"), "access", "secret", "missing", "", false)
+	if err := sink.Put("usage.parquet", []byte("data")); err == nil {
+		t.Errorf("expected a missing bucket to fail")
+	}
+}
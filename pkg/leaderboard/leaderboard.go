@@ -0,0 +1,124 @@
+package leaderboard
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// Metrics usage is ranked by
+const (
+	MetricGPUHours = "gpu"
+	MetricCPUHours = "cpu"
+	MetricCost     = "cost"
+	// failed attempts, retried or not
+	MetricFailures = "failures"
+)
+
+// keys usage is summed by
+var keys = map[string]func(record *billing.UsageRecord) string{
+	"user":      func(record *billing.UsageRecord) string { return record.UserId },
+	"group":     func(record *billing.UsageRecord) string { return record.Group },
+	"namespace": func(record *billing.UsageRecord) string { return record.Namespace },
+	"job":       func(record *billing.UsageRecord) string { return record.Namespace + "/" + record.JobName },
+}
+
+// metrics compare two entries, true if the first ranks higher
+var metrics = map[string]func(a, b *Entry) bool{
+	MetricGPUHours: func(a, b *Entry) bool { return a.GPUHours > b.GPUHours },
+	MetricCPUHours: func(a, b *Entry) bool { return a.CPUHours > b.CPUHours },
+	MetricCost:     func(a, b *Entry) bool { return a.Cost > b.Cost },
+	MetricFailures: func(a, b *Entry) bool { return a.Failures > b.Failures },
+}
+
+// Query selects the usage to rank and how
+type Query struct {
+	// user, group, namespace or job
+	By string
+	// metric the entries are ordered by
+	Sort string
+	// only attempts which ended in [From, To) are counted, zero is unbounded
+	From time.Time
+	To   time.Time
+	// only attempts of this gpu type are counted if not empty
+	GpuType string
+	// maximum number of entries, all if zero
+	Limit int
+}
+
+// Validate checks the grouping and the metric of the query
+func (q *Query) Validate() error {
+	if _, found := keys[q.By]; !found {
+		return fmt.Errorf("unknown by %q, expected user, group, namespace or job", q.By)
+	}
+	if _, found := metrics[q.Sort]; !found {
+		return fmt.Errorf("unknown sort %q, expected gpu, cpu, cost or failures", q.Sort)
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("invalid limit %d", q.Limit)
+	}
+	return nil
+}
+
+// Entry is the usage of a user, group, namespace or job
+type Entry struct {
+	Rank     int           `json:"rank"`
+	Name     string        `json:"name"`
+	Attempts int           `json:"attempts"`
+	Failures int           `json:"failures"`
+	CPUHours float64       `json:"cpuCoreHours"`
+	GPUHours float64       `json:"gpuHours"`
+	Cost     billing.Money `json:"cost"`
+}
+
+// Rank sums the records selected by the query and orders the sums by its
+// metric, ties are ordered by name
+func Rank(records []*billing.UsageRecord, q Query) ([]*Entry, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	key, before := keys[q.By], metrics[q.Sort]
+
+	sums := make(map[string]*Entry)
+	for _, record := range records {
+		if (!q.From.IsZero() && record.End.Before(q.From)) || (!q.To.IsZero() && !record.End.Before(q.To)) {
+			continue
+		}
+		if q.GpuType != "" && record.GpuType != q.GpuType {
+			continue
+		}
+		name := key(record)
+		entry, found := sums[name]
+		if !found {
+			entry = &Entry{Name: name}
+			sums[name] = entry
+		}
+		entry.Attempts++
+		if record.Failed {
+			entry.Failures++
+		}
+		entry.CPUHours += record.Usage.CPUHours()
+		entry.GPUHours += record.Usage.GPUHours()
+		entry.Cost += record.Cost
+	}
+
+	entries := make([]*Entry, 0, len(sums))
+	for _, entry := range sums {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if before(entries[i], entries[j]) != before(entries[j], entries[i]) {
+			return before(entries[i], entries[j])
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+	}
+	for i, entry := range entries {
+		entry.Rank = i + 1
+	}
+	return entries, nil
+}
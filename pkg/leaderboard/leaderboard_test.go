@@ -0,0 +1,77 @@
+package leaderboard
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+func testRecord(user, job, gpuType string, gpuHours int64, cost string, failed bool, end time.Time) *billing.UsageRecord {
+	usage := billing.NewResourceTime()
+	usage.MilliGPUSeconds = big.NewInt(gpuHours * 3600 * 1000)
+	return &billing.UsageRecord{
+		UserId:    user,
+		Group:     "lab",
+		Namespace: "default",
+		JobName:   job,
+		GpuType:   gpuType,
+		Usage:     usage,
+		Cost:      billing.MustParseMoney(cost),
+		Failed:    failed,
+		End:       end,
+	}
+}
+
+func TestRank(t *testing.T) {
+	now := time.Date(2019, 11, 5, 0, 0, 0, 0, time.UTC)
+	records := []*billing.UsageRecord{
+		testRecord("alice", "train", "2080ti", 1, "10", true, now),
+		testRecord("alice", "train", "2080ti", 1, "10", true, now),
+		testRecord("bob", "eval", "v100", 4, "8", false, now),
+		testRecord("carol", "old", "v100", 10, "50", true, now.AddDate(0, -1, 0)),
+	}
+
+	entries, err := Rank(records, Query{By: "user", Sort: MetricGPUHours, From: now.AddDate(0, 0, -7)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "bob" || entries[0].GPUHours != 4 || entries[0].Rank != 1 {
+		t.Fatalf("unexpected gpu ranking %+v", entries)
+	}
+	if alice := entries[1]; alice.Name != "alice" || alice.Attempts != 2 || alice.Failures != 2 || alice.Rank != 2 {
+		t.Errorf("unexpected entry of alice %+v", alice)
+	}
+
+	entries, err = Rank(records, Query{By: "job", Sort: MetricCost, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "default/old" || entries[1].Name != "default/train" || entries[1].Cost != billing.MustParseMoney("20") {
+		t.Errorf("unexpected cost ranking %+v", entries)
+	}
+
+	entries, err = Rank(records, Query{By: "user", Sort: MetricFailures, GpuType: "v100", To: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "carol" || entries[0].Failures != 1 {
+		t.Errorf("expected only the v100 usage before now, got %+v", entries)
+	}
+
+	entries, err = Rank(records, Query{By: "group", Sort: MetricCPUHours})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Attempts != 4 {
+		t.Errorf("expected one group with all attempts, got %+v", entries)
+	}
+
+	if _, err := Rank(records, Query{By: "user", Sort: "memory"}); err == nil {
+		t.Error("expected an error ranking by an unknown metric")
+	}
+	if _, err := Rank(records, Query{By: "pod", Sort: MetricCost}); err == nil {
+		t.Error("expected an error grouping by an unknown key")
+	}
+}
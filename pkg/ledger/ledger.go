@@ -0,0 +1,461 @@
+package ledger
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Ledger keeps the finalized usage records of completed pod attempts.
+type Ledger struct {
+	sync.Mutex
+
+	config *billing.Config
+	// prices replacing the ones of the config, e.g. from PriceList resources
+	prices  *billing.PriceList
+	records map[string]*billing.UsageRecord
+	// ids of the records by namespace and framework uid
+	jobs map[string][]string
+	// keeps the records across restarts and replicas, optional
+	store Store
+	// finalized and recomputed records by id not saved to the store yet,
+	// saved by Flush outside of the lock
+	unsaved map[string]*billing.UsageRecord
+	updated map[string]*billing.UsageRecord
+	// one flush at a time
+	flushing sync.Mutex
+	// one save of the prices at a time, and the versions saved last
+	savingPrices sync.Mutex
+	savedPrices  []billing.RateCardVersion
+	// records finalized or recomputed since Snapshot, changes[0] has the
+	// sequence number first
+	tracking bool
+	changes  []Change
+	first    uint64
+}
+
+// Change is a finalized record, or a recomputed record replacing Old
+type Change struct {
+	Old *billing.UsageRecord
+	New *billing.UsageRecord
+}
+
+// Store persists finalized usage records, the ledger keeps them in memory too.
+type Store interface {
+	// Finalize saves a new record together with its job and task in one
+	// transaction. It returns false if the attempt was saved before, e.g. by
+	// another replica.
+	Finalize(record *billing.UsageRecord) (bool, error)
+	// Update replaces recomputed records
+	Update(records []*billing.UsageRecord) error
+	// Records loads all records
+	Records() ([]*billing.UsageRecord, error)
+	// SavePrices keeps the price versions for audits
+	SavePrices(versions []billing.RateCardVersion) error
+}
+
+// New creates a ledger billing with the given config
+func New(config *billing.Config) *Ledger {
+	if config == nil {
+		config = billing.DefaultConfig()
+	}
+	return &Ledger{
+		config:  config,
+		records: make(map[string]*billing.UsageRecord),
+		jobs:    make(map[string][]string),
+		unsaved: make(map[string]*billing.UsageRecord),
+		updated: make(map[string]*billing.UsageRecord),
+	}
+}
+
+// Finalize records the usage of a completed pod attempt. It returns nil if the
+// pod is not completed yet or its attempt has already been recorded. The
+// record is saved to the store by the next Flush.
+func (l *Ledger) Finalize(fi *api.JobInfo, pi *api.PodInfo) *billing.UsageRecord {
+	if !IsCompleted(pi) {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	if _, found := l.records[string(pi.UID)]; found {
+		return nil
+	}
+	record := NewUsageRecord(fi, pi)
+	l.finalize(record)
+	record.FinalizedAt = time.Now()
+	l.add(record)
+	if l.store != nil {
+		l.unsaved[record.ID] = record
+	}
+	l.changed(nil, record)
+	return record
+}
+
+// SetStore loads the records of the store and saves all records finalized
+// from then on, see Run
+func (l *Ledger) SetStore(store Store) error {
+	records, err := store.Records()
+	if err != nil {
+		return err
+	}
+	l.Lock()
+	for _, record := range records {
+		if _, found := l.records[record.ID]; !found {
+			l.add(record)
+		}
+	}
+	l.store = store
+	l.Unlock()
+	return l.savePrices()
+}
+
+// Run saves the finalized and recomputed records every period until stopCh
+// is closed, records failing to save are retried
+func (l *Ledger) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := l.Flush(); err != nil {
+			glog.Errorf("Failed to save usage records, retrying in %v: %v", period, err)
+		}
+	}, period, stopCh)
+	if err := l.Flush(); err != nil {
+		glog.Errorf("Failed to save usage records on stop: %v", err)
+	}
+}
+
+// Flush saves the records finalized or recomputed since the last flush.
+// The store is called without holding the lock, so a slow or unavailable
+// store never blocks finalizing. Records failing to save stay pending.
+func (l *Ledger) Flush() error {
+	l.flushing.Lock()
+	defer l.flushing.Unlock()
+
+	l.Lock()
+	store := l.store
+	unsaved := sortedRecords(l.unsaved)
+	updated := sortedRecords(l.updated)
+	l.Unlock()
+	if store == nil {
+		return nil
+	}
+
+	failed := 0
+	var lastErr error
+	for _, record := range unsaved {
+		saved, err := store.Finalize(record)
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		if !saved {
+			glog.V(3).Infof("Usage record %s of %s/%s was saved by another replica", record.ID, record.Namespace, record.PodName)
+		}
+		l.Lock()
+		if current := l.unsaved[record.ID]; current != record {
+			// recomputed while saving, the saved record is updated
+			l.updated[record.ID] = current
+		}
+		delete(l.unsaved, record.ID)
+		l.Unlock()
+	}
+	if len(updated) > 0 {
+		if err := store.Update(updated); err != nil {
+			failed += len(updated)
+			lastErr = err
+		} else {
+			l.Lock()
+			for _, record := range updated {
+				// unless recomputed again while saving
+				if l.updated[record.ID] == record {
+					delete(l.updated, record.ID)
+				}
+			}
+			l.Unlock()
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d usage records not saved: %v", failed, len(unsaved)+len(updated), lastErr)
+	}
+	return nil
+}
+
+// Pending returns the number of records not saved to the store yet
+func (l *Ledger) Pending() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.unsaved) + len(l.updated)
+}
+
+// sortedRecords orders the records by end time
+func sortedRecords(byID map[string]*billing.UsageRecord) []*billing.UsageRecord {
+	records := make([]*billing.UsageRecord, 0, len(byID))
+	for _, record := range byID {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].End.Equal(records[j].End) {
+			return records[i].End.Before(records[j].End)
+		}
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+func (l *Ledger) add(record *billing.UsageRecord) {
+	l.records[record.ID] = record
+	if record.JobUID != "" {
+		key := record.Namespace + "/" + record.JobUID
+		l.jobs[key] = append(l.jobs[key], record.ID)
+	}
+}
+
+// Provisional prices a running pod attempt as if it completed at now, the
+// record is not kept. It returns nil if the pod is not running.
+func (l *Ledger) Provisional(fi *api.JobInfo, pi *api.PodInfo, now time.Time) *billing.UsageRecord {
+	if pi == nil || pi.Status.Phase != v1.PodRunning || pi.RunningTime.IsZero() {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	record := NewUsageRecord(fi, pi)
+	record.End = now
+	l.finalize(record)
+	return record
+}
+
+// Recompute prices the records that ended in [from, to) again with the
+// current config and returns how many records changed their cost. Finalized
+// records are never repriced unless asked for explicitly.
+func (l *Ledger) Recompute(from, to time.Time) int {
+	l.Lock()
+	defer l.Unlock()
+
+	changed := 0
+	for id, record := range l.records {
+		if record.End.Before(from) || !record.End.Before(to) {
+			continue
+		}
+		// records handed out before stay untouched
+		r := *record
+		l.finalize(&r)
+		if r.Cost != record.Cost {
+			changed++
+		}
+		if r.Cost != record.Cost || r.BilledDuration != record.BilledDuration {
+			l.changed(record, &r)
+		}
+		l.records[id] = &r
+		if l.store == nil {
+			continue
+		}
+		// records not saved yet are saved as recomputed
+		if _, found := l.unsaved[id]; found {
+			l.unsaved[id] = &r
+		} else {
+			l.updated[id] = &r
+		}
+	}
+	return changed
+}
+
+// Snapshot returns all records ordered by end time and the cursor of their
+// changes, the changes are kept from then on until they were read by Changes
+func (l *Ledger) Snapshot() ([]*billing.UsageRecord, uint64) {
+	l.Lock()
+	defer l.Unlock()
+
+	if !l.tracking {
+		l.tracking = true
+		l.first = 1
+	}
+	return sortedRecords(l.records), l.first + uint64(len(l.changes))
+}
+
+// Changes returns the changes since the cursor in order and the cursor of
+// the next changes. The changes before the cursor are dropped, so there is
+// only one reader of the changes.
+func (l *Ledger) Changes(cursor uint64) ([]Change, uint64) {
+	l.Lock()
+	defer l.Unlock()
+
+	if cursor > l.first {
+		dropped := cursor - l.first
+		if dropped > uint64(len(l.changes)) {
+			dropped = uint64(len(l.changes))
+		}
+		l.changes = append([]Change(nil), l.changes[dropped:]...)
+		l.first += dropped
+	}
+	return append([]Change(nil), l.changes...), l.first + uint64(len(l.changes))
+}
+
+func (l *Ledger) changed(old, record *billing.UsageRecord) {
+	if l.tracking {
+		l.changes = append(l.changes, Change{Old: old, New: record})
+	}
+}
+
+// Prices returns the price list usage is billed with
+func (l *Ledger) Prices() *billing.PriceList {
+	l.Lock()
+	defer l.Unlock()
+	return l.pricesLocked()
+}
+
+// SetPrices replaces the price list of the config, records finalized before
+// keep their cost until they are recomputed. The price versions are saved to
+// the store when they changed.
+func (l *Ledger) SetPrices(prices *billing.PriceList) {
+	l.Lock()
+	l.prices = prices
+	l.Unlock()
+	if err := l.savePrices(); err != nil {
+		glog.Errorf("Failed to save price versions, retrying with the next prices: %v", err)
+	}
+}
+
+// savePrices saves the price versions if they changed since they were saved
+// last. Like Flush the store is called without holding the lock.
+func (l *Ledger) savePrices() error {
+	l.savingPrices.Lock()
+	defer l.savingPrices.Unlock()
+
+	l.Lock()
+	store, prices := l.store, l.pricesLocked()
+	l.Unlock()
+	if store == nil {
+		return nil
+	}
+	versions := prices.Versions()
+	if l.savedPrices != nil && reflect.DeepEqual(versions, l.savedPrices) {
+		return nil
+	}
+	if err := store.SavePrices(versions); err != nil {
+		return err
+	}
+	l.savedPrices = versions
+	return nil
+}
+
+// Config returns the billing config the ledger was created with
+func (l *Ledger) Config() *billing.Config {
+	return l.config
+}
+
+func (l *Ledger) pricesLocked() *billing.PriceList {
+	if l.prices != nil {
+		return l.prices
+	}
+	return l.config.Prices()
+}
+
+func (l *Ledger) finalize(record *billing.UsageRecord) {
+	policy := l.config.Policies.Select(record.Namespace, record.Group)
+	record.Finalize(policy, l.pricesLocked())
+}
+
+// Records returns all finalized records ordered by end time
+func (l *Ledger) Records() []*billing.UsageRecord {
+	l.Lock()
+	defer l.Unlock()
+
+	return sortedRecords(l.records)
+}
+
+// JobRecords returns the records of the framework with the uid in namespace
+func (l *Ledger) JobRecords(namespace string, uid types.UID) []*billing.UsageRecord {
+	l.Lock()
+	defer l.Unlock()
+
+	ids := l.jobs[namespace+"/"+string(uid)]
+	records := make([]*billing.UsageRecord, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, l.records[id])
+	}
+	return records
+}
+
+// RecordsBetween returns the records that ended in [from, to) ordered by end time
+func (l *Ledger) RecordsBetween(from, to time.Time) []*billing.UsageRecord {
+	var records []*billing.UsageRecord
+	for _, record := range l.Records() {
+		if !record.End.Before(from) && record.End.Before(to) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// IsCompleted checks whether the pod attempt reached a final phase with a known completion time
+func IsCompleted(pi *api.PodInfo) bool {
+	if pi == nil || pi.CompateTime.IsZero() {
+		return false
+	}
+	return pi.Status.Phase == v1.PodSucceeded || pi.Status.Phase == v1.PodFailed
+}
+
+// NewUsageRecord builds an unfinalized usage record of a pod attempt
+func NewUsageRecord(fi *api.JobInfo, pi *api.PodInfo) *billing.UsageRecord {
+	record := &billing.UsageRecord{
+		ID:         string(pi.UID),
+		JobName:    pi.FrameworkName,
+		TaskName:   pi.TaskName,
+		PodName:    pi.Name,
+		RetryCount: pi.RetryCount,
+		Namespace:  pi.Namespace,
+		GpuType:    pi.GpuType,
+		Reason:     pi.Status.Reason,
+		Failed:     pi.Status.Phase == v1.PodFailed,
+		Requests:   pi.Requests,
+		Start:      pi.RunningTime.Time,
+		End:        pi.CompateTime.Time,
+	}
+	// a pod that never became ready did not run
+	if record.Start.IsZero() {
+		record.Start = record.End
+	}
+	if fi != nil {
+		record.JobUID = string(fi.UID)
+		record.UserId = fi.UserId
+		record.Group = fi.GroupId
+		record.Labels = fi.Labels
+	}
+	return record
+}
+
+// JobCost is the billed cost of a job and its breakdown by price window. The
+// breakdown is taken before minimum charges and rounding, so it may differ
+// slightly from the cost.
+type JobCost struct {
+	JobName   string               `json:"jobName"`
+	Attempts  int                  `json:"attempts"`
+	Cost      billing.Money        `json:"cost"`
+	Breakdown []billing.WindowCost `json:"breakdown"`
+}
+
+// JobCost sums the finalized records of a job
+func (l *Ledger) JobCost(jobName string) *JobCost {
+	jc := &JobCost{JobName: jobName}
+	var breakdowns [][]billing.WindowCost
+	for _, record := range l.Records() {
+		if record.JobName != jobName {
+			continue
+		}
+		jc.Attempts++
+		jc.Cost += record.Cost
+		breakdowns = append(breakdowns, record.Breakdown)
+	}
+	jc.Breakdown = billing.SumBreakdown(breakdowns...)
+	return jc
+}
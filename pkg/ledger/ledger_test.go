@@ -0,0 +1,234 @@
+package ledger
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeStore keeps saved records by id and counts the saved prices, saves
+// fail while err is set and block while block is not nil
+type fakeStore struct {
+	sync.Mutex
+	saved  map[string]*billing.UsageRecord
+	prices int
+	err    error
+	block  chan struct{}
+}
+
+func (s *fakeStore) Finalize(record *billing.UsageRecord) (bool, error) {
+	s.Lock()
+	block := s.block
+	s.Unlock()
+	if block != nil {
+		<-block
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if _, found := s.saved[record.ID]; found {
+		return false, nil
+	}
+	s.saved[record.ID] = record
+	return true, nil
+}
+
+func (s *fakeStore) Update(records []*billing.UsageRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	for _, record := range records {
+		s.saved[record.ID] = record
+	}
+	return nil
+}
+
+func (s *fakeStore) Records() ([]*billing.UsageRecord, error) { return nil, nil }
+
+func (s *fakeStore) SavePrices(versions []billing.RateCardVersion) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.prices++
+	return nil
+}
+
+func (s *fakeStore) cost(id string) billing.Money {
+	s.Lock()
+	defer s.Unlock()
+	if record, found := s.saved[id]; found {
+		return record.Cost
+	}
+	return -1
+}
+
+var start = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+func completedPod(name string) *api.PodInfo {
+	return &api.PodInfo{
+		UID: types.UID("uid-" + name), Name: name, FrameworkName: "train", Namespace: "default",
+		RunningTime: metav1.NewTime(start), CompateTime: metav1.NewTime(start.Add(time.Hour)),
+		Status:   api.PodStatus{Phase: v1.PodSucceeded},
+		Requests: billing.Requests{MilliCPU: 1000},
+	}
+}
+
+func testLedger(t *testing.T, cpuHour string) (*Ledger, *fakeStore) {
+	config := billing.DefaultConfig()
+	config.Rates.CPUCoreHour = billing.MustParseMoney(cpuHour)
+	l := New(config)
+	store := &fakeStore{saved: make(map[string]*billing.UsageRecord)}
+	if err := l.SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+	return l, store
+}
+
+func TestFlushRetry(t *testing.T) {
+	l, store := testLedger(t, "1")
+	store.err = fmt.Errorf("connection refused")
+	if record := l.Finalize(nil, completedPod("a")); record == nil || record.Cost != billing.MustParseMoney("1") {
+		t.Fatalf("expected the record to be finalized without the store, got %+v", record)
+	}
+	if err := l.Flush(); err == nil {
+		t.Fatalf("expected the flush to fail")
+	}
+	if l.Pending() != 1 || store.cost("uid-a") != -1 {
+		t.Fatalf("expected the record to stay pending, %d pending", l.Pending())
+	}
+
+	store.err = nil
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Pending() != 0 || store.cost("uid-a") != billing.MustParseMoney("1") {
+		t.Errorf("expected the record to be saved by the retry, %d pending", l.Pending())
+	}
+
+	// recomputed records are updated
+	l.config.Rates.CPUCoreHour = billing.MustParseMoney("2")
+	if changed := l.Recompute(start, start.Add(2*time.Hour)); changed != 1 {
+		t.Fatalf("expected 1 changed record, got %d", changed)
+	}
+	store.err = fmt.Errorf("connection refused")
+	l.Flush()
+	store.err = nil
+	if err := l.Flush(); err != nil || store.cost("uid-a") != billing.MustParseMoney("2") {
+		t.Errorf("expected the recomputed record to be saved, got %v, %v", store.cost("uid-a"), err)
+	}
+}
+
+func TestFlushOutsideOfLock(t *testing.T) {
+	l, store := testLedger(t, "1")
+	l.Finalize(nil, completedPod("a"))
+	store.block = make(chan struct{})
+	flushed := make(chan error)
+	go func() {
+		flushed <- l.Flush()
+	}()
+
+	// finalizing and recomputing go on while the store hangs
+	finalized := make(chan *billing.UsageRecord)
+	go func() {
+		l.config.Rates.CPUCoreHour = billing.MustParseMoney("3")
+		l.Recompute(start, start.Add(2*time.Hour))
+		finalized <- l.Finalize(nil, completedPod("b"))
+	}()
+	select {
+	case record := <-finalized:
+		if record == nil {
+			t.Fatalf("expected b to be finalized")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected finalizing not to wait for the store")
+	}
+
+	store.Lock()
+	close(store.block)
+	store.block = nil
+	store.Unlock()
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	// a was recomputed while it was saved
+	if err := l.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Pending() != 0 || store.cost("uid-a") != billing.MustParseMoney("3") || store.cost("uid-b") != billing.MustParseMoney("3") {
+		t.Errorf("expected both records saved at the recomputed cost, got %v and %v", store.cost("uid-a"), store.cost("uid-b"))
+	}
+}
+
+func TestSavePrices(t *testing.T) {
+	l, store := testLedger(t, "1")
+	if store.prices != 1 {
+		t.Fatalf("expected the prices to be saved with the store, got %d saves", store.prices)
+	}
+	march := billing.RateCardVersion{Version: "2024-03", EffectiveFrom: start, RateCard: billing.DefaultConfig().Rates}
+	prices := func() *billing.PriceList {
+		pl, err := billing.NewPriceList(&billing.RateCard{}, []billing.RateCardVersion{march})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pl
+	}
+
+	// unchanged prices of every sync are not saved again
+	l.SetPrices(prices())
+	l.SetPrices(prices())
+	if store.prices != 2 {
+		t.Errorf("expected only the new versions to be saved, got %d saves", store.prices)
+	}
+
+	// a failed save is retried with the next prices
+	march.Version = "2024-03-b"
+	store.err = fmt.Errorf("connection refused")
+	l.SetPrices(prices())
+	store.err = nil
+	l.SetPrices(prices())
+	if store.prices != 3 {
+		t.Errorf("expected the failed save to be retried, got %d saves", store.prices)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	l, _ := testLedger(t, "1")
+	l.Finalize(nil, completedPod("a"))
+	records, cursor := l.Snapshot()
+	if len(records) != 1 {
+		t.Fatalf("expected the finalized record, got %d", len(records))
+	}
+	if changes, next := l.Changes(cursor); len(changes) != 0 || next != cursor {
+		t.Fatalf("expected no changes since the snapshot, got %d", len(changes))
+	}
+
+	b := l.Finalize(nil, completedPod("b"))
+	l.config.Rates.CPUCoreHour = billing.MustParseMoney("2")
+	l.Recompute(start, start.Add(2*time.Hour))
+	changes, next := l.Changes(cursor)
+	if len(changes) != 3 || changes[0].Old != nil || changes[0].New != b {
+		t.Fatalf("expected b to be finalized first, got %+v", changes)
+	}
+	for _, change := range changes[1:] {
+		if change.Old == nil || change.New.Cost != 2*change.Old.Cost {
+			t.Errorf("expected a recomputed record, got %+v", change)
+		}
+	}
+	// reading the next changes drops the ones read
+	if changes, _ := l.Changes(next); len(changes) != 0 || len(l.changes) != 0 {
+		t.Errorf("expected the read changes to be dropped, got %d, %d kept", len(changes), len(l.changes))
+	}
+}
@@ -6,15 +6,20 @@ import (
 )
 
 const (
-	LabelPlatformUserKey = "platform-user"
+	LabelPlatformUserKey  = "platform-user"
+	LabelPlatformGroupKey = "platform-group"
 )
 
 type PodName string
 
 type JobInfo struct {
-	UID      types.UID
-	JobName  string
-	UserId   string
+	UID       types.UID
+	JobName   string
+	Namespace string
+	UserId    string
+	GroupId   string
+	// framework labels, exported as cost tags
+	Labels   map[string]string
 	Tasks    map[string]*TaskInfo
 	Resource *Resource
 	// 冗余framework 申请的资源resource
@@ -25,16 +30,26 @@ type JobInfo struct {
 // create frameworkinfo by framework
 func NewFrameworkInfoByFramework(fm *fcapi.Framework) *JobInfo {
 	fi := &JobInfo{
-		UID:      fm.UID,
-		JobName:  fm.Name,
 		Tasks:    make(map[string]*TaskInfo),
 		Resource: EmptyResource(),
 	}
+	fi.SetFramework(fm)
+	return fi
+}
+
+// set framework meta and status
+func (fi *JobInfo) SetFramework(fm *fcapi.Framework) {
+	fi.UID = fm.UID
+	fi.JobName = fm.Name
+	fi.Namespace = fm.Namespace
+	fi.Labels = fm.Labels
 	if userId, found := fm.Labels[LabelPlatformUserKey]; found {
 		fi.UserId = userId
 	}
+	if groupId, found := fm.Labels[LabelPlatformGroupKey]; found {
+		fi.GroupId = groupId
+	}
 	fi.Status = fm.Status
-	return fi
 }
 
 // use task info create framework info
@@ -55,15 +70,17 @@ func (fi *JobInfo) AddTask(ti *TaskInfo) {
 	fi.Resource.Add(ti.Resource)
 }
 
-// update task
-func (fi *JobInfo) UpdateTask(ti *TaskInfo) {
+// update task, an inconsistent resource is clamped and reported by the returned error
+func (fi *JobInfo) UpdateTask(ti *TaskInfo) error {
+	var err error
 	if taskInfo, found := fi.Tasks[ti.Name]; found {
 		if !taskInfo.Resource.Equals(ti.Resource) {
-			fi.Resource.Sub(taskInfo.Resource)
+			err = fi.Resource.SubClamp(taskInfo.Resource)
 			fi.Resource.Add(ti.Resource)
 		}
 	}
 	fi.Tasks[ti.Name] = ti
+	return err
 }
 
 // update frameworkinfo by framework
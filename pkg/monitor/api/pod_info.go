@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -37,6 +38,8 @@ type PodInfo struct {
 
 	// resource
 	Resource *Resource
+	// exact requests used for billing
+	Requests billing.Requests
 }
 
 //type PodPhase string
@@ -48,8 +51,9 @@ type PodStatus struct {
 // new pod info
 func NewPodInfo(pod *v1.Pod) *PodInfo {
 	podInfo := &PodInfo{
-		UID:  pod.UID,
-		Name: pod.Name,
+		UID:       pod.UID,
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
 	}
 	// set time
 	podInfo.setPodInfoTime(pod)
@@ -80,16 +84,26 @@ func (podInfo *PodInfo) UpdatePodInfo(pod *v1.Pod) {
 
 // set resource
 func (pi *PodInfo) setPodInfoResource(pod *v1.Pod) {
-	resource := EmptyResource()
-	gpuType, found := pod.Spec.NodeSelector[SelectorNvidiaGPUTypeKey]
-	if found {
+	resource, requests, gpuType := PodSpecResource(&pod.Spec)
+	if gpuType != "" {
 		pi.GpuType = gpuType
 	}
-	for _, c := range pod.Spec.Containers {
+	pi.Resource = resource
+	pi.Requests = requests
+}
+
+// PodSpecResource returns the requests of the containers and the gpu type
+// of a pod spec, it is shared by running pods and specs not yet submitted.
+func PodSpecResource(spec *v1.PodSpec) (*Resource, billing.Requests, string) {
+	resource := EmptyResource()
+	requests := billing.Requests{}
+	gpuType := spec.NodeSelector[SelectorNvidiaGPUTypeKey]
+	for _, c := range spec.Containers {
 		r := NewResource(c.Resources.Requests)
 		resource.Add(r)
+		requests.Add(billing.NewRequests(c.Resources.Requests))
 	}
-	pi.Resource = resource
+	return resource, requests, gpuType
 }
 
 // set task name
@@ -19,6 +19,7 @@ package api
 import (
 	"fmt"
 	"math"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 	v1helper "k8s.io/kubernetes/pkg/apis/core/v1/helper"
@@ -117,6 +118,23 @@ func (r *Resource) IsZero(rn v1.ResourceName) bool {
 	}
 }
 
+// TryIsZero is like IsZero but returns an error instead of panicking on an unknown scalar resource
+func (r *Resource) TryIsZero(rn v1.ResourceName) (bool, error) {
+	switch rn {
+	case v1.ResourceCPU, v1.ResourceMemory:
+		return r.IsZero(rn), nil
+	default:
+		if r.ScalarResources == nil {
+			return true, nil
+		}
+		rQuant, ok := r.ScalarResources[rn]
+		if !ok {
+			return true, fmt.Errorf("unknown resource %s", rn)
+		}
+		return rQuant < minMilliScalarResources, nil
+	}
+}
+
 // Add is used to add the two resources
 func (r *Resource) Add(rr *Resource) *Resource {
 	r.MilliCPU += rr.MilliCPU
@@ -152,6 +170,58 @@ func (r *Resource) Sub(rr *Resource) *Resource {
 		r, rr))
 }
 
+// TrySub is like Sub but returns an error and leaves r unchanged instead of
+// panicking when rr is not less equal r.
+func (r *Resource) TrySub(rr *Resource) error {
+	if !rr.LessEqual(r) {
+		return fmt.Errorf("resource is not sufficient to do operation: <%v> sub <%v>", r, rr)
+	}
+	r.Sub(rr)
+	return nil
+}
+
+// SubClamp subtracts rr from r, every resource that would become negative is
+// clamped to zero. The returned error reports the clamped resources.
+func (r *Resource) SubClamp(rr *Resource) error {
+	var clamped []string
+	sub := func(name v1.ResourceName, v, sv float64) float64 {
+		if sv > v && sv-v >= minDeltaOf(name) {
+			clamped = append(clamped, fmt.Sprintf("%s %0.2f < %0.2f", name, v, sv))
+			return 0
+		}
+		if v -= sv; v < 0 {
+			v = 0
+		}
+		return v
+	}
+	r.MilliCPU = sub(v1.ResourceCPU, r.MilliCPU, rr.MilliCPU)
+	r.Memory = sub(v1.ResourceMemory, r.Memory, rr.Memory)
+	for rrName, rrQuant := range rr.ScalarResources {
+		if r.ScalarResources == nil {
+			r.ScalarResources = map[v1.ResourceName]float64{}
+		}
+		r.ScalarResources[rrName] = sub(rrName, r.ScalarResources[rrName], rrQuant)
+	}
+
+	if len(clamped) > 0 {
+		return fmt.Errorf("resource is not sufficient to do operation, clamped to zero: %s",
+			strings.Join(clamped, ", "))
+	}
+	return nil
+}
+
+// minDeltaOf returns the tolerance LessEqual uses for the resource
+func minDeltaOf(rn v1.ResourceName) float64 {
+	switch rn {
+	case v1.ResourceCPU:
+		return minMilliCPU
+	case v1.ResourceMemory:
+		return minMemory
+	default:
+		return minMilliScalarResources
+	}
+}
+
 // SetMaxResource compares with ResourceList and takes max value for each Resource.
 func (r *Resource) SetMaxResource(rr *Resource) {
 	if r == nil || rr == nil {
@@ -300,6 +370,16 @@ func (r *Resource) Diff(rr *Resource) (*Resource, *Resource) {
 			decreasedVal.ScalarResources[rName] += rrQuant - rQuant
 		}
 	}
+	// scalar resources only present in rr
+	for rrName, rrQuant := range rr.ScalarResources {
+		if _, found := r.ScalarResources[rrName]; found {
+			continue
+		}
+		if decreasedVal.ScalarResources == nil {
+			decreasedVal.ScalarResources = map[v1.ResourceName]float64{}
+		}
+		decreasedVal.ScalarResources[rrName] += rrQuant
+	}
 
 	return increasedVal, decreasedVal
 }
@@ -353,15 +433,18 @@ func (r *Resource) SetScalar(name v1.ResourceName, quantity float64) {
 	r.ScalarResources[name] = quantity
 }
 
-// calculate resource is equals
-func (r *Resource) Equals(rr *Resource) (bool) {
+// calculate resource is equals, scalar resources missing on one side count as zero
+func (r *Resource) Equals(rr *Resource) bool {
 	if r.MilliCPU != rr.MilliCPU || r.Memory != rr.Memory {
 		return false
 	}
 	for rName, rQuant := range r.ScalarResources {
-		rrQuant := rr.ScalarResources[rName]
-
-		if rQuant != rrQuant {
+		if rQuant != rr.ScalarResources[rName] {
+			return false
+		}
+	}
+	for rrName, rrQuant := range rr.ScalarResources {
+		if rrQuant != r.ScalarResources[rrName] {
 			return false
 		}
 	}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// testResource generates non negative resources with integral values, so
+// float arithmetic on them is exact.
+type testResource struct {
+	*Resource
+}
+
+func (testResource) Generate(rand *rand.Rand, size int) reflect.Value {
+	r := EmptyResource()
+	r.MilliCPU = float64(rand.Intn(64000))
+	r.Memory = float64(rand.Int63n(256 << 30))
+	for _, name := range []v1.ResourceName{GPUResourceName, "example.com/fpga"} {
+		if rand.Intn(2) == 0 {
+			r.SetScalar(name, float64(rand.Intn(8)*1000))
+		}
+	}
+	return reflect.ValueOf(testResource{r})
+}
+
+func (tr testResource) String() string {
+	return tr.Resource.String()
+}
+
+func checkProperty(t *testing.T, name string, f interface{}) {
+	if err := quick.Check(f, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Errorf("%s: %v", name, err)
+	}
+}
+
+func TestAddSubProperties(t *testing.T) {
+	checkProperty(t, "a+b-b == a", func(a, b testResource) bool {
+		sum := a.Clone().Add(b.Resource)
+		if err := sum.TrySub(b.Resource); err != nil {
+			return false
+		}
+		return sum.Equals(a.Resource)
+	})
+	checkProperty(t, "a+b == b+a", func(a, b testResource) bool {
+		return a.Clone().Add(b.Resource).Equals(b.Clone().Add(a.Resource))
+	})
+	checkProperty(t, "TrySub fails iff b is not less equal a and leaves a unchanged", func(a, b testResource) bool {
+		r := a.Clone()
+		err := r.TrySub(b.Resource)
+		if b.LessEqual(a.Resource) {
+			return err == nil
+		}
+		return err != nil && r.Equals(a.Resource)
+	})
+	checkProperty(t, "SubClamp never goes negative", func(a, b testResource) bool {
+		r := a.Clone()
+		err := r.SubClamp(b.Resource)
+		if b.LessEqual(a.Resource) && err != nil {
+			return false
+		}
+		if r.MilliCPU < 0 || r.Memory < 0 {
+			return false
+		}
+		for _, v := range r.ScalarResources {
+			if v < 0 {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestSubDoesNotPanicInCallers(t *testing.T) {
+	small := NewResource(nil)
+	big := &Resource{MilliCPU: 4000, Memory: 1 << 30, ScalarResources: map[v1.ResourceName]float64{GPUResourceName: 1000}}
+	if err := small.SubClamp(big); err == nil {
+		t.Errorf("expected clamping to be reported")
+	}
+	if !small.Equals(EmptyResource()) {
+		t.Errorf("expected clamped resource to be empty, got %v", small)
+	}
+	if _, err := big.TryIsZero("example.com/unknown"); err == nil {
+		t.Errorf("expected unknown resource to be reported")
+	}
+}
+
+func TestDiffProperties(t *testing.T) {
+	checkProperty(t, "a+decreased == b+increased", func(a, b testResource) bool {
+		increased, decreased := a.Diff(b.Resource)
+		return a.Clone().Add(decreased).Equals(b.Clone().Add(increased))
+	})
+	checkProperty(t, "Diff of equal resources is empty", func(a testResource) bool {
+		increased, decreased := a.Diff(a.Clone())
+		return increased.Equals(EmptyResource()) && decreased.Equals(EmptyResource())
+	})
+}
+
+func TestLessEqualProperties(t *testing.T) {
+	checkProperty(t, "a <= a", func(a testResource) bool {
+		return a.LessEqual(a.Clone())
+	})
+	checkProperty(t, "a <= a+b", func(a, b testResource) bool {
+		return a.LessEqual(a.Clone().Add(b.Resource))
+	})
+}
+
+func TestEqualsProperties(t *testing.T) {
+	checkProperty(t, "Equals is symmetric", func(a, b testResource) bool {
+		return a.Equals(b.Resource) == b.Equals(a.Resource)
+	})
+	checkProperty(t, "a equals its clone", func(a testResource) bool {
+		return a.Equals(a.Clone())
+	})
+
+	onlyInRR := &Resource{ScalarResources: map[v1.ResourceName]float64{GPUResourceName: 1000}}
+	if EmptyResource().Equals(onlyInRR) {
+		t.Errorf("expected a scalar only present in rr to be compared")
+	}
+}
@@ -41,17 +41,19 @@ func (ti *TaskInfo) AddPod(pi *PodInfo) {
 	ti.Resource.Add(pi.Resource)
 }
 
-// update pod
-func (ti *TaskInfo) UpdatePod(pi *PodInfo) {
+// update pod, an inconsistent resource is clamped and reported by the returned error
+func (ti *TaskInfo) UpdatePod(pi *PodInfo) error {
+	var err error
 	if podinfo, found := ti.Pods[pi.Name]; found {
 		if !podinfo.Resource.Equals(pi.Resource) {
-			ti.Resource.Sub(podinfo.Resource)
-			ti.Resource.Add(ti.Resource)
+			err = ti.Resource.SubClamp(podinfo.Resource)
+			ti.Resource.Add(pi.Resource)
 		}
-		ti.Pods[ti.Name] = pi
+		ti.Pods[pi.Name] = pi
 
 		// recode all pods
 		key := fmt.Sprintf("%v-%v", pi.Name, pi.RetryCount)
 		ti.AllPods[key] = pi
 	}
+	return err
 }
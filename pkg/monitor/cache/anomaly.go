@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/metrics"
+)
+
+// maxAnomalies is the number of recent anomalies kept in memory
+const maxAnomalies = 1000
+
+// Anomaly is an inconsistency the cache found and recovered from while handling an informer event.
+type Anomaly struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Object    string    `json:"object"`
+	Message   string    `json:"message"`
+}
+
+// reportAnomaly logs, counts and keeps an anomaly, the cache lock must be held.
+func (cc *BillingCache) reportAnomaly(operation, object string, err error) {
+	glog.Warningf("Billing anomaly in %s of <%s>: %v", operation, object, err)
+	metrics.RegisterResourceAnomaly(operation)
+
+	cc.anomalies = append(cc.anomalies, Anomaly{
+		Time:      time.Now(),
+		Operation: operation,
+		Object:    object,
+		Message:   err.Error(),
+	})
+	if len(cc.anomalies) > maxAnomalies {
+		cc.anomalies = cc.anomalies[len(cc.anomalies)-maxAnomalies:]
+	}
+}
+
+// Anomalies returns the recent anomalies, oldest first
+func (cc *BillingCache) Anomalies() []Anomaly {
+	cc.Mutex.Lock()
+	defer cc.Mutex.Unlock()
+
+	anomalies := make([]Anomaly, len(cc.anomalies))
+	copy(anomalies, cc.anomalies)
+	return anomalies
+}
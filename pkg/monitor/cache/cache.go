@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"encoding/json"
 	"fmt"
 	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
 	frameworkClient "github.com/microsoft/frameworkcontroller/pkg/client/clientset/versioned"
@@ -12,8 +13,11 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
 	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
 	"sync"
+	"time"
 )
 
 type PodID types.UID
@@ -30,6 +34,19 @@ type BillingCache struct {
 	podInformer cache.SharedIndexInformer
 	fmInformer  cache.SharedIndexInformer
 
+	// finalized usage of completed pods
+	ledger *ledger.Ledger
+	// recent inconsistencies recovered from
+	anomalies []Anomaly
+	// events of billing milestones, optional
+	recorder Recorder
+	// raw informer events, optional
+	eventLog EventLog
+	// changes of jobs, tasks, pods and usage, optional
+	watcher Watcher
+	// completed frameworks by uid whose final cost is not emitted yet
+	unbilled map[types.UID]bool
+
 	// data
 	Pods  map[string]*api.PodInfo
 	Tasks map[string]*api.TaskInfo
@@ -37,50 +54,77 @@ type BillingCache struct {
 }
 
 // New returns a Cache implementation.
-func New(config *rest.Config) *BillingCache {
-	return NewChargingCache(config)
+func New(config *rest.Config, l *ledger.Ledger) *BillingCache {
+	return NewChargingCache(config, l)
 }
 
 // charging
-func NewChargingCache(config *rest.Config) *BillingCache {
+func NewChargingCache(config *rest.Config, l *ledger.Ledger) *BillingCache {
 	kClient, fClient := CreateClients(config)
 
 	cc := &BillingCache{
 		Pods:       make(map[string]*api.PodInfo),
 		Tasks:      make(map[string]*api.TaskInfo),
 		Jobs:       make(map[string]*api.JobInfo),
+		unbilled:   make(map[types.UID]bool),
 		kubeClient: kClient,
 		fmClient:   fClient,
+		ledger:     l,
 	}
 	// pod informer
 	informerFactory := kubeInformer.NewSharedInformerFactory(cc.kubeClient, 0)
 	cc.podInformer = informerFactory.Core().V1().Pods().Informer()
-	cc.podInformer.AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
-		AddFunc:    cc.AddPod,
-		UpdateFunc: cc.UpdatePod,
-		DeleteFunc: cc.DeletePod,
-	}, 0)
+	cc.podInformer.AddEventHandlerWithResyncPeriod(cc.PodHandler(), 0)
 
 	// framework informer
 	frameworkInformerFactory := frameworkInformer.NewSharedInformerFactory(cc.fmClient, 0)
 	cc.fmInformer = frameworkInformerFactory.Frameworkcontroller().V1().Frameworks().Informer()
-	cc.fmInformer.AddEventHandlerWithResyncPeriod(cache.ResourceEventHandlerFuncs{
-		AddFunc:    cc.AddFramework,
-		UpdateFunc: cc.UpdateFramework,
-		DeleteFunc: cc.DeleteFramework,
-	}, 0)
+	cc.fmInformer.AddEventHandlerWithResyncPeriod(cc.FrameworkHandler(), 0)
 	return cc
 }
 
+// NewReplayCache returns a cache without clients and informers, its state is
+// built by feeding it logged events
+func NewReplayCache(l *ledger.Ledger) *BillingCache {
+	return &BillingCache{
+		Pods:     make(map[string]*api.PodInfo),
+		Tasks:    make(map[string]*api.TaskInfo),
+		Jobs:     make(map[string]*api.JobInfo),
+		unbilled: make(map[types.UID]bool),
+		ledger:   l,
+	}
+}
+
 // Run  starts the schedulerCache
 func (cc *BillingCache) Run(stopCh <-chan struct{}) {
 	go cc.podInformer.Run(stopCh)
 	go cc.fmInformer.Run(stopCh)
 }
 
+// PodHandler returns the handlers of pod events
+func (cc *BillingCache) PodHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    cc.AddPod,
+		UpdateFunc: cc.UpdatePod,
+		DeleteFunc: cc.DeletePod,
+	}
+}
+
+// FrameworkHandler returns the handlers of framework events
+func (cc *BillingCache) FrameworkHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    cc.AddFramework,
+		UpdateFunc: cc.UpdateFramework,
+		DeleteFunc: cc.DeleteFramework,
+	}
+}
+
 // clean fm
 func (cc *BillingCache) Clean(fmname string) {
-	fi := cc.Jobs[fmname]
+	fi, found := cc.Jobs[fmname]
+	if !found {
+		return
+	}
 	for _, ti := range fi.Tasks {
 		for _, pi := range ti.Pods {
 			delete(cc.Pods, pi.Name)
@@ -126,6 +170,79 @@ func CreateClients(kConfig *rest.Config) (kubeClient.Interface, frameworkClient.
 	return kClient, fClient
 }
 
+// Capture returns a deep copy of the cache which stays valid while the cache changes
+func (bc *BillingCache) Capture() (*api.ClusterInfo, error) {
+	bc.Mutex.Lock()
+	data, err := json.Marshal(&api.ClusterInfo{
+		RunningJobs: len(bc.Jobs),
+		Jobs:        bc.Jobs,
+		Tasks:       bc.Tasks,
+		Pods:        bc.Pods,
+	})
+	bc.Mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &api.ClusterInfo{}
+	return snapshot, json.Unmarshal(data, snapshot)
+}
+
+// RunningUsage returns provisional usage records of the running pods as if they completed at now
+func (bc *BillingCache) RunningUsage(now time.Time) []*billing.UsageRecord {
+	bc.Mutex.Lock()
+	defer bc.Mutex.Unlock()
+
+	var records []*billing.UsageRecord
+	if bc.ledger == nil {
+		return records
+	}
+	for _, pi := range bc.Pods {
+		if record := bc.ledger.Provisional(bc.Jobs[pi.FrameworkName], pi, now); record != nil {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// SetRecorder sets the recorder of billing milestones
+func (bc *BillingCache) SetRecorder(recorder Recorder) {
+	bc.Mutex.Lock()
+	defer bc.Mutex.Unlock()
+	bc.recorder = recorder
+}
+
+// SetEventLog records the received informer events, it must be set before Run
+func (bc *BillingCache) SetEventLog(eventLog EventLog) {
+	bc.eventLog = eventLog
+}
+
+// SetWatcher sets the watcher notified of the changes of the cache
+func (bc *BillingCache) SetWatcher(watcher Watcher) {
+	bc.Mutex.Lock()
+	defer bc.Mutex.Unlock()
+	bc.watcher = watcher
+}
+
+// KubeClient returns the kubernetes client of the cache
+func (bc *BillingCache) KubeClient() kubeClient.Interface {
+	return bc.kubeClient
+}
+
+// FrameworkClient returns the frameworkcontroller client of the cache
+func (bc *BillingCache) FrameworkClient() frameworkClient.Interface {
+	return bc.fmClient
+}
+
+// Framework returns the framework of the informer
+func (bc *BillingCache) Framework(namespace, name string) (*fcapi.Framework, bool) {
+	obj, found, err := bc.fmInformer.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil || !found {
+		return nil, false
+	}
+	fm, ok := obj.(*fcapi.Framework)
+	return fm, ok
+}
+
 // Snapshot returns the complete snapshot of the cluster from cache
 func (bc *BillingCache) Snapshot() *api.ClusterInfo {
 	bc.Mutex.Lock()
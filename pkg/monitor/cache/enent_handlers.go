@@ -1,10 +1,15 @@
 package cache
 
 import (
+	"fmt"
+
 	"github.com/golang/glog"
 	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/cache"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/eventlog"
 	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
 )
 
@@ -15,6 +20,9 @@ func (cc *BillingCache) AddPod(obj interface{}) {
 		glog.Errorf("Cannot convert to *v1.Pod: %v", obj)
 		return
 	}
+	if cc.eventLog != nil {
+		cc.eventLog.RecordPod(eventlog.OpAdd, pod)
+	}
 	cc.Mutex.Lock()
 	defer cc.Mutex.Unlock()
 
@@ -38,6 +46,9 @@ func (cc *BillingCache) UpdatePod(oldObj, newObj interface{}) {
 		glog.Errorf("Cannot convert newObj to *v1.Pod: %v", newObj)
 		return
 	}
+	if cc.eventLog != nil {
+		cc.eventLog.RecordPod(eventlog.OpUpdate, newPod)
+	}
 	cc.Mutex.Lock()
 	defer cc.Mutex.Unlock()
 
@@ -67,6 +78,9 @@ func (cc *BillingCache) DeletePod(obj interface{}) {
 		glog.Errorf("Cannot convert to *v1.Pod: %v", t)
 		return
 	}
+	if cc.eventLog != nil {
+		cc.eventLog.RecordPod(eventlog.OpDelete, pod)
+	}
 	cc.Mutex.Lock()
 	defer cc.Mutex.Unlock()
 
@@ -87,6 +101,9 @@ func (cc *BillingCache) AddFramework(obj interface{}) {
 		glog.Errorf("Cannot convert to *v1.Pod: %v", obj)
 		return
 	}
+	if cc.eventLog != nil {
+		cc.eventLog.RecordFramework(eventlog.OpAdd, fm)
+	}
 	cc.Mutex.Lock()
 	defer cc.Mutex.Unlock()
 	err := cc.addFramework(fm)
@@ -109,6 +126,9 @@ func (cc *BillingCache) UpdateFramework(oldObj, newObj interface{}) {
 		glog.Errorf("Cannot convert newObj to *fcapi.Framework: %v", newObj)
 		return
 	}
+	if cc.eventLog != nil {
+		cc.eventLog.RecordFramework(eventlog.OpUpdate, newFc)
+	}
 	cc.Mutex.Lock()
 	defer cc.Mutex.Unlock()
 	err := cc.updateFramework(newFc)
@@ -116,6 +136,7 @@ func (cc *BillingCache) UpdateFramework(oldObj, newObj interface{}) {
 		glog.Errorf("Failed to update framework %v in cache: %v", oldFc.Name, err)
 		return
 	}
+	cc.markCompleted(oldFc, newFc)
 
 	glog.V(3).Infof("Updated framework <%s/%v> in cache.", oldFc.Namespace, oldFc.Name)
 
@@ -128,6 +149,9 @@ func (cc *BillingCache) DeleteFramework(obj interface{}) {
 		glog.Errorf("Cannot convert to *v1.Pod: %v", obj)
 		return
 	}
+	if cc.eventLog != nil {
+		cc.eventLog.RecordFramework(eventlog.OpDelete, fm)
+	}
 	cc.Mutex.Lock()
 	defer cc.Mutex.Unlock()
 	err := cc.deleteFramework(fm)
@@ -153,6 +177,11 @@ func (cc *BillingCache) addPod(pod *v1.Pod) error {
 		cc.Pods[pi.Name] = pi
 		cc.Tasks[pi.TaskName] = ti
 		cc.Jobs[pi.FrameworkName] = fi
+		if cc.watcher != nil {
+			cc.watcher.PodChanged(fi, ti, pi)
+		}
+
+		cc.finalizeUsage(fi, pi)
 	}
 	return nil
 }
@@ -163,22 +192,105 @@ func (cc *BillingCache) updatePod(newPod *v1.Pod) error {
 	newpi := api.NewPodInfo(newPod)
 
 	if len(newpi.FrameworkName) > 0 {
-		pi := cc.Pods[newpi.Name]
+		pi, found := cc.Pods[newpi.Name]
+		if !found {
+			cc.reportAnomaly("UpdatePod", podKey(newPod), fmt.Errorf("pod is not in cache, adding it"))
+			return cc.addPod(newPod)
+		}
 		pi.UpdatePodInfo(newPod)
 
-		ti := cc.Tasks[pi.TaskName]
-		ti.UpdatePod(pi)
+		ti, found := cc.Tasks[pi.TaskName]
+		if !found {
+			cc.reportAnomaly("UpdatePod", podKey(newPod), fmt.Errorf("task %s is not in cache", pi.TaskName))
+			ti = api.NewTaskInfo(pi)
+		} else if err := ti.UpdatePod(pi); err != nil {
+			cc.reportAnomaly("UpdatePod", podKey(newPod), err)
+		}
 
-		fi := cc.Jobs[pi.FrameworkName]
-		fi.UpdateTask(ti)
+		fi, found := cc.Jobs[pi.FrameworkName]
+		if !found {
+			cc.reportAnomaly("UpdateTask", podKey(newPod), fmt.Errorf("job %s is not in cache", pi.FrameworkName))
+			fi = api.NewFrameworkInfo(ti)
+		} else if err := fi.UpdateTask(ti); err != nil {
+			cc.reportAnomaly("UpdateTask", podKey(newPod), err)
+		}
 
 		cc.Pods[pi.Name] = pi
 		cc.Tasks[pi.TaskName] = ti
 		cc.Jobs[pi.FrameworkName] = fi
+		if cc.watcher != nil {
+			cc.watcher.PodChanged(fi, ti, pi)
+		}
+
+		cc.finalizeUsage(fi, pi)
 	}
 	return nil
 }
 
+// finalize the usage record of a completed pod attempt
+func (cc *BillingCache) finalizeUsage(fi *api.JobInfo, pi *api.PodInfo) {
+	if cc.ledger == nil {
+		return
+	}
+	if record := cc.ledger.Finalize(fi, pi); record != nil {
+		glog.V(3).Infof("Finalized usage of pod <%s/%s>: billed %v, cost %v",
+			record.Namespace, record.PodName, record.BilledDuration, record.Cost)
+		if record.Waived && cc.recorder != nil {
+			var uid types.UID
+			if fi != nil {
+				uid = fi.UID
+			}
+			cc.recorder.ChargeWaived(record, uid)
+		}
+		if cc.watcher != nil {
+			cc.watcher.UsageFinalized(record)
+		}
+	}
+	if fi != nil && cc.unbilled[fi.UID] {
+		cc.recordBilled(fi, false)
+	}
+}
+
+// markCompleted remembers a framework which just completed, its final cost
+// is emitted once the usage of all its pods is finalized
+func (cc *BillingCache) markCompleted(oldFm, newFm *fcapi.Framework) {
+	if cc.recorder == nil || cc.ledger == nil || isCompleted(oldFm) || !isCompleted(newFm) {
+		return
+	}
+	fi, found := cc.Jobs[newFm.Name]
+	if !found || fi.UID != newFm.UID {
+		return
+	}
+	cc.unbilled[fi.UID] = true
+	cc.recordBilled(fi, false)
+}
+
+// recordBilled emits the final cost of a completed framework unless some of
+// its pods still run in the cache, e.g. their completion was not received
+// yet. A deleted framework is billed with the usage finalized so far.
+func (cc *BillingCache) recordBilled(fi *api.JobInfo, deleted bool) {
+	if !deleted {
+		for _, ti := range fi.Tasks {
+			for _, pi := range ti.Pods {
+				if pi.Namespace == fi.Namespace && pi.Status.Phase == v1.PodRunning {
+					return
+				}
+			}
+		}
+	}
+	delete(cc.unbilled, fi.UID)
+	var cost billing.Money
+	records := cc.ledger.JobRecords(fi.Namespace, fi.UID)
+	for _, record := range records {
+		cost += record.Cost
+	}
+	cc.recorder.JobBilled(fi.Namespace, fi.JobName, fi.UID, len(records), cost)
+}
+
+func isCompleted(fm *fcapi.Framework) bool {
+	return fm.Status != nil && fm.Status.State == fcapi.FrameworkCompleted
+}
+
 // add framework
 func (cc *BillingCache) addFramework(fm *fcapi.Framework) error {
 	newfi := api.NewFrameworkInfoByFramework(fm)
@@ -186,22 +298,45 @@ func (cc *BillingCache) addFramework(fm *fcapi.Framework) error {
 		newfi.UpdateFramework(fi)
 	}
 	cc.Jobs[newfi.JobName] = newfi
+	if cc.watcher != nil {
+		cc.watcher.JobChanged(newfi)
+	}
 	return nil
 }
 
 // update framework
 func (cc *BillingCache) updateFramework(fm *fcapi.Framework) error {
-	return cc.updateFramework(fm)
+	if fi, found := cc.Jobs[fm.Name]; found {
+		fi.SetFramework(fm)
+		if cc.watcher != nil {
+			cc.watcher.JobChanged(fi)
+		}
+		return nil
+	}
+	return cc.addFramework(fm)
 }
 
 // delete framework
 func (cc *BillingCache) deleteFramework(fm *fcapi.Framework) error {
-	return cc.updateFramework(fm)
+	err := cc.updateFramework(fm)
+	if fi, found := cc.Jobs[fm.Name]; found {
+		if cc.unbilled[fi.UID] {
+			cc.recordBilled(fi, true)
+		}
+		if cc.watcher != nil {
+			cc.watcher.JobDeleted(fi)
+		}
+	}
+	return err
 }
 
 // delete pod 不在这里进行删除，只进行更新，定期清理cache
 func (cc *BillingCache) deletePod(pod *v1.Pod) interface{} {
-	return cc.updatePod(pod)
+	err := cc.updatePod(pod)
+	if pi, found := cc.Pods[pod.Name]; found && cc.watcher != nil {
+		cc.watcher.PodDeleted(cc.Jobs[pi.FrameworkName], pi)
+	}
+	return err
 }
 
 // get or create task info
@@ -221,3 +356,8 @@ func (cc *BillingCache) getOrCreateFramework(ti *api.TaskInfo) *api.JobInfo {
 	}
 	return api.NewFrameworkInfo(ti)
 }
+
+// namespace/name of a pod
+func podKey(pod *v1.Pod) string {
+	return fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+}
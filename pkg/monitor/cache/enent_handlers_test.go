@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeRecorder struct {
+	billed []string
+}
+
+func (r *fakeRecorder) JobBilled(namespace, name string, uid types.UID, attempts int, cost billing.Money) {
+	r.billed = append(r.billed, fmt.Sprintf("%s/%s/%s %d %v", namespace, name, uid, attempts, cost))
+}
+
+func (r *fakeRecorder) ChargeWaived(record *billing.UsageRecord, uid types.UID) {}
+
+// fakeWatcher keeps the deletions it is notified of
+type fakeWatcher struct {
+	deleted []string
+}
+
+func (w *fakeWatcher) PodChanged(fi *api.JobInfo, ti *api.TaskInfo, pi *api.PodInfo) {}
+func (w *fakeWatcher) JobChanged(fi *api.JobInfo)                                    {}
+func (w *fakeWatcher) UsageFinalized(record *billing.UsageRecord)                    {}
+
+func (w *fakeWatcher) PodDeleted(fi *api.JobInfo, pi *api.PodInfo) {
+	w.deleted = append(w.deleted, fmt.Sprintf("pod %s of %s", pi.Name, fi.JobName))
+}
+
+func (w *fakeWatcher) JobDeleted(fi *api.JobInfo) {
+	w.deleted = append(w.deleted, "job "+fi.JobName)
+}
+
+func testFramework(uid types.UID, state fcapi.FrameworkState) *fcapi.Framework {
+	return &fcapi.Framework{
+		ObjectMeta: metav1.ObjectMeta{Name: "train", Namespace: "default", UID: uid},
+		Status:     &fcapi.FrameworkStatus{State: state},
+	}
+}
+
+// testPod ran for an hour from start, it is completed if deleted
+func testPod(uid types.UID, start time.Time, deleted bool) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "train-worker-0", Namespace: "default", UID: uid,
+			Annotations: map[string]string{
+				api.AnnotationFrameworkNameKey: "train",
+				api.AnnotationTaskRoleKey:      "worker",
+			},
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "main",
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceCPU: resource.MustParse("2"),
+			}},
+		}}},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, LastTransitionTime: metav1.NewTime(start)}},
+		},
+	}
+	if deleted {
+		end := metav1.NewTime(start.Add(time.Hour))
+		pod.DeletionTimestamp = &end
+		pod.Status.Phase = v1.PodSucceeded
+	}
+	return pod
+}
+
+func TestJobBilled(t *testing.T) {
+	config := billing.DefaultConfig()
+	config.Rates.CPUCoreHour = billing.MustParseMoney("1")
+	cc := NewReplayCache(ledger.New(config))
+	recorder := &fakeRecorder{}
+	cc.SetRecorder(recorder)
+	start := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	cc.AddFramework(testFramework("fm-1", fcapi.FrameworkAttemptRunning))
+	cc.AddPod(testPod("pod-1", start, false))
+	// the framework completes before the completion of its pod is received
+	cc.UpdateFramework(testFramework("fm-1", fcapi.FrameworkAttemptRunning), testFramework("fm-1", fcapi.FrameworkCompleted))
+	if len(recorder.billed) != 0 {
+		t.Fatalf("expected no final cost while a pod still runs, got %v", recorder.billed)
+	}
+	cc.DeletePod(testPod("pod-1", start, true))
+	cc.UpdateFramework(testFramework("fm-1", fcapi.FrameworkCompleted), testFramework("fm-1", fcapi.FrameworkCompleted))
+	if len(recorder.billed) != 1 || recorder.billed[0] != "default/train/fm-1 1 2.00" {
+		t.Fatalf("expected the final cost once the pod is finalized, got %v", recorder.billed)
+	}
+
+	// a re-created framework of the same name is billed for its own pods only
+	start = start.Add(2 * time.Hour)
+	cc.AddFramework(testFramework("fm-2", fcapi.FrameworkAttemptRunning))
+	cc.AddPod(testPod("pod-2", start, false))
+	cc.DeletePod(testPod("pod-2", start, true))
+	cc.UpdateFramework(testFramework("fm-2", fcapi.FrameworkAttemptRunning), testFramework("fm-2", fcapi.FrameworkCompleted))
+	if len(recorder.billed) != 2 || recorder.billed[1] != "default/train/fm-2 1 2.00" {
+		t.Errorf("expected the cost of the re-created framework, got %v", recorder.billed)
+	}
+}
+
+func TestWatcherDeleted(t *testing.T) {
+	cc := NewReplayCache(ledger.New(nil))
+	watcher := &fakeWatcher{}
+	cc.SetWatcher(watcher)
+	start := time.Date(2019, 11, 5, 12, 0, 0, 0, time.UTC)
+
+	cc.AddFramework(testFramework("fm-1", fcapi.FrameworkAttemptRunning))
+	cc.AddPod(testPod("pod-1", start, false))
+	cc.DeletePod(testPod("pod-1", start, true))
+	cc.DeleteFramework(testFramework("fm-1", fcapi.FrameworkCompleted))
+	if len(watcher.deleted) != 2 || watcher.deleted[0] != "pod train-worker-0 of train" || watcher.deleted[1] != "job train" {
+		t.Errorf("expected the pod and the job to be deleted, got %v", watcher.deleted)
+	}
+}
@@ -1,7 +1,11 @@
 package cache
 
 import (
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
 	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // Cache collects pods/nodes/queues information
@@ -13,3 +17,33 @@ type Cache interface {
 	// Snapshot deep copy overall cache information into snapshot
 	Snapshot() *api.ClusterInfo
 }
+
+// Recorder emits events of billing milestones
+type Recorder interface {
+	// JobBilled is called when a framework completed
+	JobBilled(namespace, name string, uid types.UID, attempts int, cost billing.Money)
+	// ChargeWaived is called when a pod attempt is not charged
+	ChargeWaived(record *billing.UsageRecord, uid types.UID)
+}
+
+// EventLog records the informer events the cache receives
+type EventLog interface {
+	RecordPod(op string, pod *v1.Pod)
+	RecordFramework(op string, fm *fcapi.Framework)
+}
+
+// Watcher is notified of the changes of the cache, it is called with the cache
+// locked and must not block
+type Watcher interface {
+	// PodChanged is called after a pod event updated the pod, its task and job
+	PodChanged(fi *api.JobInfo, ti *api.TaskInfo, pi *api.PodInfo)
+	// JobChanged is called after a framework event updated the job
+	JobChanged(fi *api.JobInfo)
+	// PodDeleted is called after the pod was deleted, the job is nil if it is
+	// not in the cache
+	PodDeleted(fi *api.JobInfo, pi *api.PodInfo)
+	// JobDeleted is called after the framework of the job was deleted
+	JobDeleted(fi *api.JobInfo)
+	// UsageFinalized is called when the usage of a pod attempt was finalized
+	UsageFinalized(record *billing.UsageRecord)
+}
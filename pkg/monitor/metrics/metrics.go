@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// namespace of all billing metrics
+	namespace = "k8s_billing"
+)
+
+var (
+	resourceAnomalies = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "resource_anomalies_total",
+			Help:      "Number of inconsistent informer events the cache recovered from, by operation",
+		}, []string{"operation"},
+	)
+
+	budgetAlerts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "budget_alerts_total",
+			Help:      "Number of budget threshold alerts delivered, by budget scope and threshold",
+		}, []string{"scope", "threshold"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(resourceAnomalies)
+	prometheus.MustRegister(budgetAlerts)
+}
+
+// RegisterResourceAnomaly counts an anomaly of the given cache operation
+func RegisterResourceAnomaly(operation string) {
+	resourceAnomalies.WithLabelValues(operation).Inc()
+}
+
+// RegisterBudgetAlert counts a delivered budget alert
+func RegisterBudgetAlert(scope string, threshold int) {
+	budgetAlerts.WithLabelValues(scope, strconv.Itoa(threshold)).Inc()
+}
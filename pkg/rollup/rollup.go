@@ -0,0 +1,440 @@
+package rollup
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Resolutions of the buckets
+const (
+	ResolutionHour = "hour"
+	ResolutionDay  = "day"
+)
+
+var resolutions = []string{ResolutionHour, ResolutionDay}
+
+// dimensions usage is rolled up by
+var dimensions = map[string]func(record *billing.UsageRecord) string{
+	"user":      func(record *billing.UsageRecord) string { return record.UserId },
+	"group":     func(record *billing.UsageRecord) string { return record.Group },
+	"namespace": func(record *billing.UsageRecord) string { return record.Namespace },
+	// empty for pods without gpus
+	"gputype": func(record *billing.UsageRecord) string { return record.GpuType },
+}
+
+// Values are the usage and cost of a bucket
+type Values struct {
+	CPUCoreHours   float64 `json:"cpuCoreHours"`
+	MemoryGiBHours float64 `json:"memoryGiBHours"`
+	// gpu hours by gpu type
+	GPUHours map[string]float64 `json:"gpuHours"`
+	Cost     billing.Money      `json:"cost"`
+}
+
+// Bucket is the usage of a user, group, namespace or gpu type in an hour or a day
+type Bucket struct {
+	Resolution string    `json:"resolution"`
+	Dimension  string    `json:"dimension"`
+	Name       string    `json:"name"`
+	Start      time.Time `json:"start"`
+	Values
+}
+
+type key struct {
+	resolution string
+	dimension  string
+	name       string
+	start      int64
+}
+
+// Store persists the buckets
+type Store interface {
+	// SaveBuckets inserts new buckets and replaces changed ones, the buckets
+	// include the records finalized until through
+	SaveBuckets(buckets []*Bucket, through time.Time) error
+	// LoadBuckets returns the daily buckets, the hourly buckets starting at
+	// or after hourlyFrom and the time they include the records finalized
+	// until, zero if they were never saved
+	LoadBuckets(hourlyFrom time.Time) ([]*Bucket, time.Time, error)
+	// DeleteBuckets deletes the buckets of the resolution starting before
+	DeleteBuckets(resolution string, before time.Time) error
+}
+
+// Ledger provides the finalized usage records and their changes
+type Ledger interface {
+	Snapshot() ([]*billing.UsageRecord, uint64)
+	Changes(cursor uint64) ([]ledger.Change, uint64)
+}
+
+// Cache provides the usage of the running pods
+type Cache interface {
+	RunningUsage(now time.Time) []*billing.UsageRecord
+}
+
+// Aggregator bins the billed duration of finalized usage records into hourly
+// and daily buckets. The saved buckets are loaded once, then only the records
+// finalized or recomputed since are added, so the buckets are never rebuilt
+// from all records. Hourly buckets older than the hourly retention are
+// dropped, daily buckets are kept.
+type Aggregator struct {
+	sync.Mutex
+
+	ledger Ledger
+	cache  Cache
+	store  Store
+	// days start at midnight in this location
+	loc *time.Location
+	// hourly buckets are kept this long, all are kept if zero
+	hourlyRetention time.Duration
+
+	// whether the saved buckets were loaded
+	loaded bool
+	// cursor of the changes of the ledger not in the buckets yet
+	cursor uint64
+	// the buckets include the records finalized until through
+	through time.Time
+	buckets map[key]*Bucket
+	// buckets changed since they were saved
+	dirty map[key]bool
+	// hourly buckets starting before are dropped, and were deleted from the
+	// store before pruned
+	hourlyFrom time.Time
+	pruned     time.Time
+}
+
+// NewAggregator creates an aggregator of the records of the ledger, the
+// store is optional
+func NewAggregator(ledger Ledger, cache Cache, store Store, loc *time.Location) *Aggregator {
+	return &Aggregator{
+		ledger:  ledger,
+		cache:   cache,
+		store:   store,
+		loc:     loc,
+		buckets: make(map[key]*Bucket),
+		dirty:   make(map[key]bool),
+	}
+}
+
+// SetHourlyRetention keeps the hourly buckets of the last retention only,
+// all hourly buckets are kept if zero
+func (a *Aggregator) SetHourlyRetention(retention time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	a.hourlyRetention = retention
+}
+
+// Run adds the new records every period until stopCh is closed
+func (a *Aggregator) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if err := a.Sync(); err != nil {
+			glog.Errorf("Failed to save usage rollups: %v", err)
+		}
+	}, period, stopCh)
+}
+
+// Sync adds the records finalized or recomputed since the last sync and
+// saves the changed buckets. The first sync loads the saved buckets and adds
+// the records finalized after they were saved.
+func (a *Aggregator) Sync() error {
+	return a.sync(time.Now())
+}
+
+func (a *Aggregator) sync(now time.Time) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.hourlyRetention > 0 {
+		a.hourlyFrom = a.bucketStart(now.Add(-a.hourlyRetention), ResolutionHour)
+	}
+	if !a.loaded {
+		if err := a.load(now); err != nil {
+			return fmt.Errorf("failed to load usage rollups: %v", err)
+		}
+	}
+	changes, cursor := a.ledger.Changes(a.cursor)
+	for _, change := range changes {
+		// a recomputed record replaces the old one
+		if change.Old != nil {
+			a.apply(a.buckets, change.Old, -1, a.dirty)
+		}
+		a.apply(a.buckets, change.New, 1, a.dirty)
+		if change.New.FinalizedAt.After(a.through) {
+			a.through = change.New.FinalizedAt
+		}
+	}
+	a.cursor = cursor
+	if a.store == nil || len(a.dirty) == 0 {
+		a.dirty = make(map[key]bool)
+		return a.prune()
+	}
+	changed := make([]*Bucket, 0, len(a.dirty))
+	for k := range a.dirty {
+		changed = append(changed, a.buckets[k])
+	}
+	if err := a.store.SaveBuckets(changed, a.through); err != nil {
+		// saved again with the next sync
+		return err
+	}
+	a.dirty = make(map[key]bool)
+	return a.prune()
+}
+
+// prune drops the hourly buckets starting before hourlyFrom, once per hour
+func (a *Aggregator) prune() error {
+	if !a.pruned.Before(a.hourlyFrom) {
+		return nil
+	}
+	for k := range a.buckets {
+		if k.resolution == ResolutionHour && k.start < a.hourlyFrom.Unix() {
+			delete(a.buckets, k)
+			delete(a.dirty, k)
+		}
+	}
+	if a.store != nil {
+		if err := a.store.DeleteBuckets(ResolutionHour, a.hourlyFrom); err != nil {
+			// deleted again with the next sync
+			return err
+		}
+	}
+	a.pruned = a.hourlyFrom
+	return nil
+}
+
+// load loads the saved buckets and adds the records of the ledger finalized
+// after they were saved, or all records if there are no saved buckets
+func (a *Aggregator) load(now time.Time) error {
+	var through time.Time
+	if a.store != nil {
+		buckets, saved, err := a.store.LoadBuckets(a.hourlyFrom)
+		if err != nil {
+			return err
+		}
+		for _, b := range buckets {
+			b.Start = b.Start.In(a.loc)
+			if b.GPUHours == nil {
+				b.GPUHours = make(map[string]float64)
+			}
+			a.buckets[key{resolution: b.Resolution, dimension: b.Dimension, name: b.Name, start: b.Start.Unix()}] = b
+		}
+		through = saved
+	}
+	records, cursor := a.ledger.Snapshot()
+	// the records finalized from now on are changes
+	a.through = now
+	if through.After(a.through) {
+		a.through = through
+	}
+	for _, record := range records {
+		if through.IsZero() || record.FinalizedAt.After(through) {
+			a.apply(a.buckets, record, 1, a.dirty)
+		}
+		if record.FinalizedAt.After(a.through) {
+			a.through = record.FinalizedAt
+		}
+	}
+	a.cursor, a.loaded = cursor, true
+	return nil
+}
+
+// apply adds the usage of the record to all its buckets, or subtracts it
+// with a negative sign. Hourly buckets before the retention are left out.
+func (a *Aggregator) apply(buckets map[key]*Bucket, record *billing.UsageRecord, sign int64, dirty map[key]bool) {
+	for _, resolution := range resolutions {
+		for dimension, name := range dimensions {
+			a.split(record, resolution, func(start time.Time, values Values) {
+				if resolution == ResolutionHour && start.Before(a.hourlyFrom) {
+					return
+				}
+				k := key{resolution: resolution, dimension: dimension, name: name(record), start: start.Unix()}
+				b, found := buckets[k]
+				if !found {
+					b = &Bucket{Resolution: resolution, Dimension: dimension, Name: k.name, Start: start,
+						Values: Values{GPUHours: make(map[string]float64)}}
+					buckets[k] = b
+				}
+				b.add(values, sign)
+				if dirty != nil {
+					dirty[k] = true
+				}
+			})
+		}
+	}
+}
+
+// split calls f with the usage and cost of the record in every bucket of the
+// resolution its billed duration overlaps. The cost is split by time and the
+// parts always sum up to the cost.
+func (a *Aggregator) split(record *billing.UsageRecord, resolution string, f func(start time.Time, values Values)) {
+	billed := record.BilledDuration
+	if billed <= 0 {
+		return
+	}
+	costUntil := func(elapsed time.Duration) billing.Money {
+		part := new(big.Int).Mul(big.NewInt(int64(record.Cost)), big.NewInt(int64(elapsed)))
+		return billing.Money(part.Quo(part, big.NewInt(int64(billed))).Int64())
+	}
+	end := record.Start.Add(billed)
+	for start := a.bucketStart(record.Start, resolution); start.Before(end); start = a.next(start, resolution) {
+		from, to := start, a.next(start, resolution)
+		if from.Before(record.Start) {
+			from = record.Start
+		}
+		if to.After(end) {
+			to = end
+		}
+		hours := to.Sub(from).Hours()
+		values := Values{
+			CPUCoreHours:   float64(record.Requests.MilliCPU) / 1000 * hours,
+			MemoryGiBHours: float64(record.Requests.Memory) / (1 << 30) * hours,
+			Cost:           costUntil(to.Sub(record.Start)) - costUntil(from.Sub(record.Start)),
+		}
+		if record.Requests.MilliGPU > 0 {
+			values.GPUHours = map[string]float64{record.GpuType: float64(record.Requests.MilliGPU) / 1000 * hours}
+		}
+		f(start, values)
+	}
+}
+
+func (a *Aggregator) bucketStart(t time.Time, resolution string) time.Time {
+	t = t.In(a.loc)
+	if resolution == ResolutionDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, a.loc)
+	}
+	return t.Truncate(time.Hour)
+}
+
+func (a *Aggregator) next(start time.Time, resolution string) time.Time {
+	if resolution == ResolutionDay {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(time.Hour)
+}
+
+func (b *Bucket) add(values Values, sign int64) {
+	b.CPUCoreHours += float64(sign) * values.CPUCoreHours
+	b.MemoryGiBHours += float64(sign) * values.MemoryGiBHours
+	for gpuType, hours := range values.GPUHours {
+		b.GPUHours[gpuType] += float64(sign) * hours
+	}
+	b.Cost += billing.Money(sign) * values.Cost
+}
+
+// Names returns the names with usage in the dimension in order
+func (a *Aggregator) Names(dimension string) []string {
+	a.Lock()
+	defer a.Unlock()
+	seen := make(map[string]bool)
+	names := []string{}
+	for k := range a.buckets {
+		if k.dimension == dimension && !seen[k.name] {
+			seen[k.name] = true
+			names = append(names, k.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Query selects a time series
+type Query struct {
+	// hour or day
+	Resolution string
+	// user, group, namespace or gputype
+	Dimension string
+	// only the series of this name if not empty
+	Name string
+	// buckets starting in [From, To)
+	From time.Time
+	To   time.Time
+}
+
+// Validate checks the resolution, dimension and range of the query
+func (q *Query) Validate() error {
+	if q.Resolution != ResolutionHour && q.Resolution != ResolutionDay {
+		return fmt.Errorf("unknown resolution %q, expected hour or day", q.Resolution)
+	}
+	if _, found := dimensions[q.Dimension]; !found {
+		return fmt.Errorf("unknown dimension %q, expected user, group, namespace or gputype", q.Dimension)
+	}
+	if !q.From.Before(q.To) {
+		return fmt.Errorf("from %v is not before to %v", q.From, q.To)
+	}
+	return nil
+}
+
+// Point is the value of a series in the bucket starting at time
+type Point struct {
+	Time time.Time `json:"time"`
+	Values
+}
+
+// Series is the usage of a user, group, namespace or gpu type over time
+type Series struct {
+	Dimension string   `json:"dimension"`
+	Name      string   `json:"name"`
+	Points    []*Point `json:"points"`
+}
+
+// Series returns the time series of the query ordered by name, with the
+// usage of the running pods as if they completed at now. Buckets without
+// usage are left out.
+func (a *Aggregator) Series(q Query, now time.Time) ([]*Series, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	records := a.cache.RunningUsage(now)
+
+	a.Lock()
+	defer a.Unlock()
+	running := make(map[key]*Bucket)
+	for _, record := range records {
+		a.apply(running, record, 1, nil)
+	}
+	byName := make(map[string]*Series)
+	points := make(map[key]*Point)
+	add := func(k key, b *Bucket) {
+		if k.resolution != q.Resolution || k.dimension != q.Dimension || (q.Name != "" && k.name != q.Name) ||
+			b.Start.Before(q.From) || !b.Start.Before(q.To) {
+			return
+		}
+		p, found := points[k]
+		if !found {
+			p = &Point{Time: b.Start, Values: Values{GPUHours: make(map[string]float64)}}
+			points[k] = p
+			s, found := byName[k.name]
+			if !found {
+				s = &Series{Dimension: q.Dimension, Name: k.name}
+				byName[k.name] = s
+			}
+			s.Points = append(s.Points, p)
+		}
+		p.CPUCoreHours += b.CPUCoreHours
+		p.MemoryGiBHours += b.MemoryGiBHours
+		for gpuType, hours := range b.GPUHours {
+			p.GPUHours[gpuType] += hours
+		}
+		p.Cost += b.Cost
+	}
+	for k, b := range a.buckets {
+		add(k, b)
+	}
+	for k, b := range running {
+		add(k, b)
+	}
+
+	series := make([]*Series, 0, len(byName))
+	for _, s := range byName {
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Name < series[j].Name })
+	return series, nil
+}
@@ -0,0 +1,243 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+)
+
+// fakeLedger hands out the records, the cursor is the index of the changes
+type fakeLedger struct {
+	records []*billing.UsageRecord
+	changes []ledger.Change
+}
+
+func (l *fakeLedger) Snapshot() ([]*billing.UsageRecord, uint64) {
+	return l.records, uint64(len(l.changes))
+}
+
+func (l *fakeLedger) Changes(cursor uint64) ([]ledger.Change, uint64) {
+	return l.changes[cursor:], uint64(len(l.changes))
+}
+
+type fakeCache []*billing.UsageRecord
+
+func (c fakeCache) RunningUsage(now time.Time) []*billing.UsageRecord { return c }
+
+type fakeStore struct {
+	saved   map[string]*Bucket
+	through time.Time
+	saves   int
+}
+
+func (s *fakeStore) SaveBuckets(buckets []*Bucket, through time.Time) error {
+	s.saves++
+	for _, b := range buckets {
+		saved := *b
+		saved.GPUHours = make(map[string]float64)
+		for gpuType, hours := range b.GPUHours {
+			saved.GPUHours[gpuType] = hours
+		}
+		s.saved[b.Resolution+"/"+b.Dimension+"/"+b.Name+"/"+b.Start.String()] = &saved
+	}
+	s.through = through
+	return nil
+}
+
+func (s *fakeStore) LoadBuckets(hourlyFrom time.Time) ([]*Bucket, time.Time, error) {
+	var buckets []*Bucket
+	for _, b := range s.saved {
+		if b.Resolution == ResolutionHour && b.Start.Before(hourlyFrom) {
+			continue
+		}
+		loaded := *b
+		buckets = append(buckets, &loaded)
+	}
+	return buckets, s.through, nil
+}
+
+func (s *fakeStore) DeleteBuckets(resolution string, before time.Time) error {
+	for k, b := range s.saved {
+		if b.Resolution == resolution && b.Start.Before(before) {
+			delete(s.saved, k)
+		}
+	}
+	return nil
+}
+
+func testRecord(id, user string, start time.Time, billed time.Duration, cost string) *billing.UsageRecord {
+	return &billing.UsageRecord{
+		ID: id, UserId: user, Group: "lab", Namespace: "default", GpuType: "v100",
+		Requests:       billing.Requests{MilliCPU: 2000, Memory: 4 << 30, MilliGPU: 1000},
+		Start:          start,
+		End:            start.Add(billed),
+		BilledDuration: billed,
+		Cost:           billing.MustParseMoney(cost),
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	start := time.Date(2019, 11, 4, 22, 30, 0, 0, time.UTC)
+	// 90 minutes on the 4th and 30 minutes on the 5th
+	source := &fakeLedger{records: []*billing.UsageRecord{testRecord("uid-1", "alice", start, 2*time.Hour, "1")}}
+	store := &fakeStore{saved: make(map[string]*Bucket)}
+	a := NewAggregator(source, fakeCache{testRecord("uid-2", "bob", start.Add(time.Hour), time.Hour, "2")}, store, time.UTC)
+	if err := a.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// 3 hours and 2 days in 4 dimensions
+	if len(store.saved) != 20 {
+		t.Fatalf("expected 20 buckets to be saved, got %d", len(store.saved))
+	}
+	if names := a.Names("gputype"); len(names) != 1 || names[0] != "v100" {
+		t.Errorf("expected the gpu type with usage, got %v", names)
+	}
+
+	q := Query{Resolution: ResolutionHour, Dimension: "user", Name: "alice", From: start.Add(-time.Hour), To: start.Add(24 * time.Hour)}
+	series, err := a.Series(q, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || len(series[0].Points) != 3 {
+		t.Fatalf("expected one series of 3 hours, got %+v", series)
+	}
+	var cost billing.Money
+	for _, p := range series[0].Points {
+		cost += p.Cost
+	}
+	first := series[0].Points[0]
+	if cost != billing.MustParseMoney("1") || first.Cost != billing.MustParseMoney("0.25") ||
+		first.CPUCoreHours != 1 || first.MemoryGiBHours != 2 || first.GPUHours["v100"] != 0.5 {
+		t.Errorf("unexpected split of the cost %v, first hour %+v", cost, first)
+	}
+
+	q = Query{Resolution: ResolutionDay, Dimension: "user", From: start.AddDate(0, 0, -1), To: start.AddDate(0, 0, 2)}
+	series, err = a.Series(q, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 2 || series[0].Name != "alice" || series[1].Name != "bob" {
+		t.Fatalf("expected the finalized usage of alice and the running usage of bob, got %+v", series)
+	}
+	if days := series[0].Points; len(days) != 2 || days[0].GPUHours["v100"] != 1.5 || days[1].GPUHours["v100"] != 0.5 {
+		t.Errorf("unexpected days of alice %+v", days)
+	}
+	if bob := series[1].Points; len(bob) != 2 || bob[0].Cost+bob[1].Cost != billing.MustParseMoney("2") {
+		t.Errorf("unexpected running usage of bob %+v", bob)
+	}
+
+	// nothing changed
+	if err := a.Sync(); err != nil || store.saves != 1 {
+		t.Fatalf("expected no save without changes, got %d saves, %v", store.saves, err)
+	}
+
+	// a recomputed record replaces the old one
+	recomputed := *source.records[0]
+	recomputed.Cost = billing.MustParseMoney("3")
+	source.changes = append(source.changes, ledger.Change{Old: source.records[0], New: &recomputed})
+	if err := a.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	q = Query{Resolution: ResolutionDay, Dimension: "group", From: start.AddDate(0, 0, -1), To: start.AddDate(0, 0, 2)}
+	a.cache = fakeCache{}
+	series, err = a.Series(q, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if days := series[0].Points; days[0].Cost != billing.MustParseMoney("2.25") || days[1].Cost != billing.MustParseMoney("0.75") ||
+		days[0].GPUHours["v100"] != 1.5 {
+		t.Errorf("expected the recomputed cost, got %+v %+v", days[0], days[1])
+	}
+
+	if _, err := a.Series(Query{Resolution: "week", Dimension: "user", From: start, To: start.Add(time.Hour)}, start); err == nil {
+		t.Error("expected an error for an unknown resolution")
+	}
+}
+
+func TestAggregatorRestart(t *testing.T) {
+	start := time.Date(2019, 11, 4, 8, 0, 0, 0, time.UTC)
+	first := testRecord("uid-1", "alice", start, time.Hour, "1")
+	store := &fakeStore{saved: make(map[string]*Bucket)}
+	if err := NewAggregator(&fakeLedger{records: []*billing.UsageRecord{first}}, fakeCache{}, store, time.UTC).Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the saved buckets are loaded, only the record finalized since is added
+	second := testRecord("uid-2", "alice", start, time.Hour, "2")
+	second.FinalizedAt = store.through.Add(time.Minute)
+	source := &fakeLedger{records: []*billing.UsageRecord{first, second}}
+	a := NewAggregator(source, fakeCache{}, store, time.UTC)
+	if err := a.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	q := Query{Resolution: ResolutionHour, Dimension: "user", From: start, To: start.Add(time.Hour)}
+	series, err := a.Series(q, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0].Points[0].Cost != billing.MustParseMoney("3") || series[0].Points[0].CPUCoreHours != 4 {
+		t.Fatalf("expected the loaded and the new record, got %+v", series[0].Points[0])
+	}
+	if !store.through.Equal(second.FinalizedAt) {
+		t.Errorf("expected the buckets to be saved through %v, got %v", second.FinalizedAt, store.through)
+	}
+	if b := store.saved["hour/user/alice/"+start.String()]; b == nil || b.Cost != billing.MustParseMoney("3") {
+		t.Errorf("expected the saved bucket to include both records, got %+v", b)
+	}
+}
+
+func TestHourlyRetention(t *testing.T) {
+	start := time.Date(2019, 11, 4, 8, 0, 0, 0, time.UTC)
+	source := &fakeLedger{records: []*billing.UsageRecord{testRecord("uid-1", "alice", start, 2*time.Hour, "2")}}
+	store := &fakeStore{saved: make(map[string]*Bucket)}
+	a := NewAggregator(source, fakeCache{}, store, time.UTC)
+	a.SetHourlyRetention(24 * time.Hour)
+	if err := a.sync(start.Add(3 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	hours := Query{Resolution: ResolutionHour, Dimension: "user", From: start, To: start.Add(2 * time.Hour)}
+	if series, _ := a.Series(hours, start); len(series) != 1 || len(series[0].Points) != 2 {
+		t.Fatalf("expected the hours within the retention, got %+v", series)
+	}
+
+	// a day later the first hour is dropped, the day is kept
+	if err := a.sync(start.Add(25 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if series, _ := a.Series(hours, start); len(series) != 1 || len(series[0].Points) != 1 || !series[0].Points[0].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected only the second hour, got %+v", series)
+	}
+	if b := store.saved["hour/user/alice/"+start.String()]; b != nil {
+		t.Errorf("expected the first hour to be deleted from the store, got %+v", b)
+	}
+	days := Query{Resolution: ResolutionDay, Dimension: "user", From: start.AddDate(0, 0, -1), To: start.AddDate(0, 0, 1)}
+	if series, _ := a.Series(days, start); len(series) != 1 || series[0].Points[0].Cost != billing.MustParseMoney("2") {
+		t.Errorf("expected the whole day, got %+v", series)
+	}
+
+	// a record recomputed after its hours were dropped only changes the day
+	recomputed := *source.records[0]
+	recomputed.Cost = billing.MustParseMoney("4")
+	source.changes = append(source.changes, ledger.Change{Old: source.records[0], New: &recomputed})
+	if err := a.sync(start.Add(25 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if series, _ := a.Series(hours, start); len(series[0].Points) != 1 || series[0].Points[0].Cost != billing.MustParseMoney("2") {
+		t.Errorf("expected the second hour to be recomputed only, got %+v", series[0].Points)
+	}
+	if series, _ := a.Series(days, start); series[0].Points[0].Cost != billing.MustParseMoney("4") {
+		t.Errorf("expected the recomputed day, got %+v", series[0].Points)
+	}
+
+	// a restart does not load the dropped hours
+	restarted := NewAggregator(source, fakeCache{}, store, time.UTC)
+	restarted.SetHourlyRetention(24 * time.Hour)
+	if err := restarted.sync(start.Add(25 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if series, _ := restarted.Series(hours, start); len(series[0].Points) != 1 {
+		t.Errorf("expected only the second hour after a restart, got %+v", series[0].Points)
+	}
+}
@@ -0,0 +1,78 @@
+package simulate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// separates the documents of a yaml file
+var documentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// Manifests are the pods and frameworks read from manifest files
+type Manifests struct {
+	Pods       []*v1.Pod
+	Frameworks []*fcapi.Framework
+}
+
+// LoadManifests reads the Pod and Framework manifests of the yaml and json
+// files in dir, documents of other kinds and lists are skipped.
+func LoadManifests(dir string) (*Manifests, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml", "*.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	m := &Manifests{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for i, document := range documentSeparator.Split(string(data), -1) {
+			if err := m.add([]byte(document)); err != nil {
+				return nil, fmt.Errorf("invalid manifest %d of %s: %v", i+1, file, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m *Manifests) add(document []byte) error {
+	if len(bytes.TrimSpace(document)) == 0 {
+		return nil
+	}
+	var meta metav1.TypeMeta
+	if err := yaml.Unmarshal(document, &meta); err != nil {
+		return err
+	}
+	switch {
+	case meta.Kind == "Pod" && meta.APIVersion == "v1":
+		pod := &v1.Pod{}
+		if err := yaml.Unmarshal(document, pod); err != nil {
+			return err
+		}
+		m.Pods = append(m.Pods, pod)
+	case meta.Kind == "Framework" && strings.HasPrefix(meta.APIVersion, "frameworkcontroller.microsoft.com/"):
+		fm := &fcapi.Framework{}
+		if err := yaml.Unmarshal(document, fm); err != nil {
+			return err
+		}
+		m.Frameworks = append(m.Frameworks, fm)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package simulate
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/eventlog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/ledger"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/cache"
+	kubecache "k8s.io/client-go/tools/cache"
+)
+
+// Source feeds recorded pods and frameworks to the handlers of a cache
+type Source func(pods, frameworks kubecache.ResourceEventHandler) error
+
+// ManifestSource adds the frameworks and then the pods of the manifests, so
+// pods find their framework in the cache
+func ManifestSource(m *Manifests) Source {
+	return func(pods, frameworks kubecache.ResourceEventHandler) error {
+		for _, fm := range m.Frameworks {
+			frameworks.OnAdd(fm)
+		}
+		for _, pod := range m.Pods {
+			pods.OnAdd(pod)
+		}
+		return nil
+	}
+}
+
+// EventLogSource replays the events of the log in dir received before until
+func EventLogSource(dir string, until time.Time) Source {
+	return func(pods, frameworks kubecache.ResourceEventHandler) error {
+		_, err := eventlog.Replay(dir, until, pods, frameworks)
+		return err
+	}
+}
+
+// Options limit the usage a simulation bills
+type Options struct {
+	// only attempts which ended in [From, To) are billed, zero is unbounded
+	From time.Time
+	To   time.Time
+	// running attempts are billed as if they completed at Now
+	Now time.Time
+}
+
+// Cost is the simulated cost of a job or user
+type Cost struct {
+	Name      string  `json:"name"`
+	Namespace string  `json:"namespace,omitempty"`
+	UserId    string  `json:"userId,omitempty"`
+	Attempts  int     `json:"attempts"`
+	GPUHours  float64 `json:"gpuHours"`
+	// cost under the simulated config
+	Cost billing.Money `json:"cost"`
+	// cost under the baseline config and the change from it, if compared
+	Baseline *billing.Money `json:"baseline,omitempty"`
+	Delta    *billing.Money `json:"delta,omitempty"`
+}
+
+// Result is the cost of a workload by job and by user
+type Result struct {
+	Jobs  []*Cost       `json:"jobs"`
+	Users []*Cost       `json:"users"`
+	Total billing.Money `json:"total"`
+	// total under the baseline config and the change from it, if compared
+	Baseline *billing.Money `json:"baseline,omitempty"`
+	Delta    *billing.Money `json:"delta,omitempty"`
+}
+
+// Run feeds the source to a cache without a cluster and bills its usage
+// with the config
+func Run(config *billing.Config, source Source, opts Options) (*Result, error) {
+	l := ledger.New(config)
+	bc := cache.NewReplayCache(l)
+	if err := source(bc.PodHandler(), bc.FrameworkHandler()); err != nil {
+		return nil, err
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if !opts.To.IsZero() && opts.To.Before(now) {
+		now = opts.To
+	}
+	records := append(l.Records(), bc.RunningUsage(now)...)
+	return Summarize(records, opts.From, opts.To), nil
+}
+
+// Summarize sums the records which ended in [from, to) by job and user
+func Summarize(records []*billing.UsageRecord, from, to time.Time) *Result {
+	jobs := make(map[string]*Cost)
+	users := make(map[string]*Cost)
+	add := func(costs map[string]*Cost, key string, c *Cost, record *billing.UsageRecord) {
+		sum, found := costs[key]
+		if !found {
+			sum = c
+			costs[key] = sum
+		}
+		sum.Attempts++
+		sum.GPUHours += record.Usage.GPUHours()
+		sum.Cost += record.Cost
+	}
+	result := &Result{Jobs: []*Cost{}, Users: []*Cost{}}
+	for _, record := range records {
+		if (!from.IsZero() && record.End.Before(from)) || (!to.IsZero() && !record.End.Before(to)) {
+			continue
+		}
+		add(jobs, record.Namespace+"/"+record.JobName, &Cost{Name: record.JobName, Namespace: record.Namespace, UserId: record.UserId}, record)
+		add(users, record.UserId, &Cost{Name: record.UserId}, record)
+		result.Total += record.Cost
+	}
+	result.Jobs = sorted(jobs)
+	result.Users = sorted(users)
+	return result
+}
+
+// Compare sets the baseline costs and the changes from them, jobs and users
+// only billed by the baseline are added with a zero cost
+func (r *Result) Compare(baseline *Result) {
+	r.Jobs = compare(r.Jobs, baseline.Jobs, func(c *Cost) string { return c.Namespace + "/" + c.Name })
+	r.Users = compare(r.Users, baseline.Users, func(c *Cost) string { return c.Name })
+	r.Baseline, r.Delta = money(baseline.Total), money(r.Total-baseline.Total)
+}
+
+func compare(costs, baseline []*Cost, key func(*Cost) string) []*Cost {
+	byKey := make(map[string]*Cost)
+	for _, c := range costs {
+		c.Baseline, c.Delta = money(0), money(c.Cost)
+		byKey[key(c)] = c
+	}
+	for _, b := range baseline {
+		c, found := byKey[key(b)]
+		if !found {
+			c = &Cost{Name: b.Name, Namespace: b.Namespace, UserId: b.UserId, Attempts: b.Attempts, GPUHours: b.GPUHours}
+			byKey[key(b)] = c
+		}
+		c.Baseline, c.Delta = money(b.Cost), money(c.Cost-b.Cost)
+	}
+	return sorted(byKey)
+}
+
+func money(m billing.Money) *billing.Money {
+	return &m
+}
+
+// sorted orders the costs by cost, the most expensive first
+func sorted(costs map[string]*Cost) []*Cost {
+	list := make([]*Cost, 0, len(costs))
+	for _, c := range costs {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Cost != list[j].Cost {
+			return list[i].Cost > list[j].Cost
+		}
+		if list[i].Namespace != list[j].Namespace {
+			return list[i].Namespace < list[j].Namespace
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
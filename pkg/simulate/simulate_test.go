@@ -0,0 +1,152 @@
+package simulate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+var base = time.Date(2019, 9, 2, 10, 0, 0, 0, time.UTC)
+
+func testFramework(name, user string) *fcapi.Framework {
+	return &fcapi.Framework{
+		TypeMeta: metav1.TypeMeta{APIVersion: "frameworkcontroller.microsoft.com/v1", Kind: "Framework"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "default", UID: types.UID("uid-" + name),
+			Labels: map[string]string{api.LabelPlatformUserKey: user},
+		},
+	}
+}
+
+func testPod(job, gpuType string, phase v1.PodPhase, end time.Time) *v1.Pod {
+	pod := &v1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: job + "-worker-0", Namespace: "default", UID: types.UID("uid-" + job + "-worker-0"),
+			Annotations: map[string]string{
+				api.AnnotationFrameworkNameKey: job,
+				// the cache keys tasks by their name only
+				api.AnnotationTaskRoleKey: job + "-worker",
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeSelector: map[string]string{api.SelectorNvidiaGPUTypeKey: gpuType},
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					api.GPUResourceName: resource.MustParse("1"),
+				}},
+			}},
+		},
+		Status: v1.PodStatus{
+			Phase: phase,
+			Conditions: []v1.PodCondition{{
+				Type: v1.PodReady, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(base),
+			}},
+		},
+	}
+	if !end.IsZero() {
+		deleted := metav1.NewTime(end)
+		pod.DeletionTimestamp = &deleted
+	}
+	return pod
+}
+
+func writeManifests(t *testing.T, dir, name string, objects ...interface{}) {
+	var data []byte
+	for _, obj := range objects {
+		document, err := yaml.Marshal(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, "---\n"...)
+		data = append(data, document...)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testConfig(gpuHour string) *billing.Config {
+	config := billing.DefaultConfig()
+	config.Rates.GPUHour = map[string]billing.Money{
+		"2080ti": billing.MustParseMoney(gpuHour),
+		"v100":   billing.MustParseMoney("4"),
+	}
+	return config
+}
+
+func TestSimulateManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simulate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeManifests(t, dir, "frameworks.yaml", testFramework("train", "alice"), testFramework("eval", "bob"),
+		&v1.ConfigMap{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}})
+	writeManifests(t, dir, "pods.yaml",
+		testPod("train", "2080ti", v1.PodSucceeded, base.Add(2*time.Hour)),
+		testPod("eval", "v100", v1.PodRunning, time.Time{}))
+
+	m, err := LoadManifests(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Frameworks) != 2 || len(m.Pods) != 2 {
+		t.Fatalf("expected 2 frameworks and 2 pods, got %d and %d", len(m.Frameworks), len(m.Pods))
+	}
+
+	// the running pod is billed until now
+	opts := Options{Now: base.Add(time.Hour)}
+	result, err := Run(testConfig("2.4"), ManifestSource(m), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := Run(testConfig("2"), ManifestSource(m), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseline.Total != billing.MustParseMoney("8") {
+		t.Fatalf("expected a baseline total of 8, got %v", baseline.Total)
+	}
+	result.Compare(baseline)
+
+	if result.Total != billing.MustParseMoney("8.8") || *result.Delta != billing.MustParseMoney("0.8") {
+		t.Fatalf("expected a total of 8.8 and a delta of 0.8, got %v and %v", result.Total, *result.Delta)
+	}
+	if len(result.Jobs) != 2 {
+		t.Fatalf("expected 2 jobs, got %+v", result.Jobs)
+	}
+	train := result.Jobs[0]
+	if train.Name != "train" || train.UserId != "alice" || train.GPUHours != 2 || *train.Delta != billing.MustParseMoney("0.8") {
+		t.Errorf("unexpected cost of train %+v", train)
+	}
+	eval := result.Jobs[1]
+	if eval.Name != "eval" || eval.Cost != billing.MustParseMoney("4") || *eval.Delta != 0 {
+		t.Errorf("unexpected cost of eval %+v", eval)
+	}
+	if len(result.Users) != 2 || result.Users[0].Name != "alice" || result.Users[1].Name != "bob" {
+		t.Errorf("unexpected users %+v", result.Users)
+	}
+
+	// only the completed attempt ended in the range
+	result, err = Run(testConfig("2"), ManifestSource(m), Options{From: base, To: base.Add(3 * time.Hour), Now: base.Add(4 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Jobs) != 1 || result.Jobs[0].Name != "train" {
+		t.Errorf("expected only train in range, got %+v", result.Jobs)
+	}
+}
@@ -0,0 +1,140 @@
+package snapshot
+
+import (
+	"sort"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Diff is what changed between two snapshots
+type Diff struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// jobs running at to which did not run at from
+	JobsStarted []JobChange `json:"jobsStarted"`
+	// jobs completed at to, or running at from and gone at to
+	JobsFinished []JobChange `json:"jobsFinished"`
+	// requests of the running pods and cost by user
+	Users     []UserDelta   `json:"users"`
+	CostDelta billing.Money `json:"costDelta"`
+}
+
+// JobChange is a job which started or finished
+type JobChange struct {
+	JobName   string `json:"jobName"`
+	Namespace string `json:"namespace"`
+	UserId    string `json:"userId"`
+	GroupId   string `json:"groupId"`
+	// accumulated cost at to, or at from for jobs gone at to
+	Cost billing.Money `json:"cost"`
+}
+
+// UserDelta is the change of the running requests and the cost of a user
+type UserDelta struct {
+	UserId    string           `json:"userId"`
+	From      billing.Requests `json:"from"`
+	To        billing.Requests `json:"to"`
+	Delta     billing.Requests `json:"delta"`
+	CostDelta billing.Money    `json:"costDelta"`
+}
+
+// Compare returns what changed from one snapshot to another
+func Compare(from, to *Snapshot) *Diff {
+	diff := &Diff{From: from.Time, To: to.Time, JobsStarted: []JobChange{}, JobsFinished: []JobChange{}, Users: []UserDelta{}}
+
+	fromRunning, toRunning := runningJobs(from.Cluster), runningJobs(to.Cluster)
+	for name, fi := range to.Cluster.Jobs {
+		before, existed := from.Cluster.Jobs[name]
+		if toRunning[name] && !fromRunning[name] && (!existed || !completed(before)) {
+			diff.JobsStarted = append(diff.JobsStarted, jobChange(fi, to.JobCosts[name]))
+		}
+		if completed(fi) && (!existed || !completed(before)) {
+			diff.JobsFinished = append(diff.JobsFinished, jobChange(fi, to.JobCosts[name]))
+		}
+	}
+	for name, fi := range from.Cluster.Jobs {
+		if _, found := to.Cluster.Jobs[name]; !found && fromRunning[name] {
+			diff.JobsFinished = append(diff.JobsFinished, jobChange(fi, from.JobCosts[name]))
+		}
+	}
+	sortJobs(diff.JobsStarted)
+	sortJobs(diff.JobsFinished)
+
+	fromRequests, toRequests := userRequests(from.Cluster), userRequests(to.Cluster)
+	users := make(map[string]bool)
+	for _, m := range []map[string]billing.Requests{fromRequests, toRequests} {
+		for user := range m {
+			users[user] = true
+		}
+	}
+	for _, m := range []map[string]billing.Money{from.UserCosts, to.UserCosts} {
+		for user := range m {
+			users[user] = true
+		}
+	}
+	for user := range users {
+		delta := UserDelta{
+			UserId:    user,
+			From:      fromRequests[user],
+			To:        toRequests[user],
+			CostDelta: to.UserCosts[user] - from.UserCosts[user],
+		}
+		delta.Delta = billing.Requests{
+			MilliCPU: delta.To.MilliCPU - delta.From.MilliCPU,
+			Memory:   delta.To.Memory - delta.From.Memory,
+			MilliGPU: delta.To.MilliGPU - delta.From.MilliGPU,
+		}
+		if delta.Delta == (billing.Requests{}) && delta.From == (billing.Requests{}) && delta.CostDelta == 0 {
+			continue
+		}
+		diff.Users = append(diff.Users, delta)
+		diff.CostDelta += delta.CostDelta
+	}
+	sort.Slice(diff.Users, func(i, j int) bool { return diff.Users[i].UserId < diff.Users[j].UserId })
+	return diff
+}
+
+func completed(fi *api.JobInfo) bool {
+	return fi.Status != nil && fi.Status.State == fcapi.FrameworkCompleted
+}
+
+// runningJobs returns the jobs with a running pod
+func runningJobs(cluster *api.ClusterInfo) map[string]bool {
+	running := make(map[string]bool)
+	for _, pi := range cluster.Pods {
+		if pi.Status.Phase == v1.PodRunning {
+			running[pi.FrameworkName] = true
+		}
+	}
+	return running
+}
+
+// userRequests sums the requests of the running pods by user
+func userRequests(cluster *api.ClusterInfo) map[string]billing.Requests {
+	requests := make(map[string]billing.Requests)
+	for _, pi := range cluster.Pods {
+		if pi.Status.Phase != v1.PodRunning {
+			continue
+		}
+		var user string
+		if fi, found := cluster.Jobs[pi.FrameworkName]; found {
+			user = fi.UserId
+		}
+		r := requests[user]
+		r.Add(pi.Requests)
+		requests[user] = r
+	}
+	return requests
+}
+
+func jobChange(fi *api.JobInfo, cost billing.Money) JobChange {
+	return JobChange{JobName: fi.JobName, Namespace: fi.Namespace, UserId: fi.UserId, GroupId: fi.GroupId, Cost: cost}
+}
+
+func sortJobs(jobs []JobChange) {
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].JobName < jobs[j].JobName })
+}
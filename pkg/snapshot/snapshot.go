@@ -0,0 +1,119 @@
+package snapshot
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Snapshot is the state of the cache at a point in time with the cost
+// accumulated until then
+type Snapshot struct {
+	Time    time.Time        `json:"time"`
+	Cluster *api.ClusterInfo `json:"cluster"`
+	// finalized and running cost of every job by name
+	JobCosts map[string]billing.Money `json:"jobCosts"`
+	// finalized and running cost of every user since billing started
+	UserCosts map[string]billing.Money `json:"userCosts"`
+}
+
+// Cache is the state snapshots are taken of
+type Cache interface {
+	// Capture returns a deep copy of the cache
+	Capture() (*api.ClusterInfo, error)
+	// RunningUsage prices the running pods as if they completed at now
+	RunningUsage(now time.Time) []*billing.UsageRecord
+}
+
+// Ledger provides the finalized usage records
+type Ledger interface {
+	Records() []*billing.UsageRecord
+}
+
+// Snapshotter saves snapshots periodically and removes the expired ones
+type Snapshotter struct {
+	cache     Cache
+	ledger    Ledger
+	store     *Store
+	retention time.Duration
+}
+
+// NewSnapshotter creates a snapshotter keeping snapshots for retention, forever if zero
+func NewSnapshotter(cache Cache, ledger Ledger, store *Store, retention time.Duration) *Snapshotter {
+	return &Snapshotter{cache: cache, ledger: ledger, store: store, retention: retention}
+}
+
+// Run saves a snapshot every period until stopCh is closed
+func (s *Snapshotter) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		now := time.Now()
+		snapshot, err := s.Take(now)
+		if err == nil {
+			err = s.store.Save(snapshot)
+		}
+		if err != nil {
+			glog.Errorf("Failed to save snapshot: %v", err)
+			return
+		}
+		if s.retention > 0 {
+			removed, err := s.store.Expire(now.Add(-s.retention))
+			if err != nil {
+				glog.Errorf("Failed to remove expired snapshots: %v", err)
+			} else if removed > 0 {
+				glog.V(3).Infof("Removed %d expired snapshots", removed)
+			}
+		}
+	}, period, stopCh)
+}
+
+// Take captures the cache and the cost accumulated at now
+func (s *Snapshotter) Take(now time.Time) (*Snapshot, error) {
+	cluster, err := s.cache.Capture()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{
+		Time:      now.UTC(),
+		Cluster:   cluster,
+		JobCosts:  make(map[string]billing.Money),
+		UserCosts: make(map[string]billing.Money),
+	}
+	add := func(record *billing.UsageRecord) {
+		snapshot.JobCosts[record.JobName] += record.Cost
+		snapshot.UserCosts[record.UserId] += record.Cost
+	}
+	for _, record := range s.ledger.Records() {
+		add(record)
+	}
+	for _, record := range s.cache.RunningUsage(now) {
+		add(record)
+	}
+	return snapshot, nil
+}
+
+// Filter keeps the pods of the gpu type with their tasks and jobs
+func (s *Snapshot) Filter(gpuType string) *Snapshot {
+	cluster := &api.ClusterInfo{
+		Jobs:  make(map[string]*api.JobInfo),
+		Tasks: make(map[string]*api.TaskInfo),
+		Pods:  make(map[string]*api.PodInfo),
+	}
+	filtered := &Snapshot{Time: s.Time, Cluster: cluster, JobCosts: make(map[string]billing.Money), UserCosts: s.UserCosts}
+	for name, pi := range s.Cluster.Pods {
+		if pi.GpuType != gpuType {
+			continue
+		}
+		cluster.Pods[name] = pi
+		if ti, found := s.Cluster.Tasks[pi.TaskName]; found {
+			cluster.Tasks[pi.TaskName] = ti
+		}
+		if fi, found := s.Cluster.Jobs[pi.FrameworkName]; found {
+			cluster.Jobs[pi.FrameworkName] = fi
+			filtered.JobCosts[pi.FrameworkName] = s.JobCosts[pi.FrameworkName]
+		}
+	}
+	return filtered
+}
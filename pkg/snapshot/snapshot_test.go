@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+)
+
+func testCluster(pods ...*api.PodInfo) *api.ClusterInfo {
+	cluster := &api.ClusterInfo{
+		Jobs:  make(map[string]*api.JobInfo),
+		Tasks: make(map[string]*api.TaskInfo),
+		Pods:  make(map[string]*api.PodInfo),
+	}
+	for _, pi := range pods {
+		cluster.Pods[pi.Name] = pi
+		if _, found := cluster.Jobs[pi.FrameworkName]; !found {
+			cluster.Jobs[pi.FrameworkName] = &api.JobInfo{JobName: pi.FrameworkName, Namespace: "default", UserId: "alice"}
+		}
+	}
+	return cluster
+}
+
+func testPod(job string, phase v1.PodPhase, gpuType string, milliGPU int64) *api.PodInfo {
+	return &api.PodInfo{
+		Name:          job + "-worker-0",
+		TaskName:      "worker",
+		FrameworkName: job,
+		Namespace:     "default",
+		Status:        api.PodStatus{Phase: phase},
+		GpuType:       gpuType,
+		Requests:      billing.Requests{MilliCPU: 1000, MilliGPU: milliGPU},
+	}
+}
+
+func TestStoreClosestAndExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Closest(time.Now()); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound from an empty store, got %v", err)
+	}
+	base := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		s := &Snapshot{
+			Time:     base.Add(time.Duration(i) * time.Hour),
+			Cluster:  testCluster(testPod("train", v1.PodRunning, "2080ti", 1000)),
+			JobCosts: map[string]billing.Money{"train": billing.Money(i)},
+		}
+		if err := store.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a reopened store finds the saved snapshots
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if times := store.Times(); len(times) != 3 {
+		t.Fatalf("expected 3 snapshots, got %v", times)
+	}
+	cases := []struct {
+		at   time.Time
+		want time.Time
+	}{
+		{base.Add(-time.Hour), base},
+		{base.Add(20 * time.Minute), base},
+		{base.Add(40 * time.Minute), base.Add(time.Hour)},
+		{base.Add(10 * time.Hour), base.Add(2 * time.Hour)},
+	}
+	for _, c := range cases {
+		s, err := store.Closest(c.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !s.Time.Equal(c.want) {
+			t.Errorf("closest to %v: expected %v, got %v", c.at, c.want, s.Time)
+		}
+		if s.Cluster.Pods["train-worker-0"] == nil {
+			t.Errorf("snapshot at %v lost its pods", s.Time)
+		}
+	}
+
+	removed, err := store.Expire(base.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 || len(store.Times()) != 1 {
+		t.Fatalf("expected 2 snapshots removed and 1 kept, got %d and %v", removed, store.Times())
+	}
+	if s, err := store.Closest(base); err != nil || !s.Time.Equal(base.Add(2*time.Hour)) {
+		t.Fatalf("expected the last snapshot after expiry, got %v, %v", s, err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	from := &Snapshot{
+		Time: base,
+		Cluster: testCluster(
+			testPod("done", v1.PodRunning, "2080ti", 1000),
+			testPod("gone", v1.PodRunning, "v100", 2000),
+		),
+		JobCosts:  map[string]billing.Money{"done": 10, "gone": 20},
+		UserCosts: map[string]billing.Money{"alice": 30},
+	}
+	to := &Snapshot{
+		Time: base.Add(time.Hour),
+		Cluster: testCluster(
+			testPod("done", v1.PodSucceeded, "2080ti", 1000),
+			testPod("new", v1.PodRunning, "2080ti", 4000),
+		),
+		JobCosts:  map[string]billing.Money{"done": 15, "new": 5},
+		UserCosts: map[string]billing.Money{"alice": 50},
+	}
+	to.Cluster.Jobs["done"].Status = &fcapi.FrameworkStatus{State: fcapi.FrameworkCompleted}
+
+	diff := Compare(from, to)
+	if len(diff.JobsStarted) != 1 || diff.JobsStarted[0].JobName != "new" || diff.JobsStarted[0].Cost != 5 {
+		t.Errorf("expected new to have started, got %+v", diff.JobsStarted)
+	}
+	if len(diff.JobsFinished) != 2 || diff.JobsFinished[0].JobName != "done" || diff.JobsFinished[1].JobName != "gone" {
+		t.Errorf("expected done and gone to have finished, got %+v", diff.JobsFinished)
+	}
+	if diff.JobsFinished[1].Cost != 20 {
+		t.Errorf("expected the cost of a job gone at to from the earlier snapshot, got %v", diff.JobsFinished[1].Cost)
+	}
+	if len(diff.Users) != 1 {
+		t.Fatalf("expected one user, got %+v", diff.Users)
+	}
+	user := diff.Users[0]
+	if user.From.MilliGPU != 3000 || user.To.MilliGPU != 4000 || user.Delta.MilliGPU != 1000 || user.Delta.MilliCPU != -1000 {
+		t.Errorf("unexpected requests delta %+v", user)
+	}
+	if user.CostDelta != 20 || diff.CostDelta != 20 {
+		t.Errorf("expected a cost delta of 20, got %v and %v", user.CostDelta, diff.CostDelta)
+	}
+
+	filtered := to.Filter("2080ti")
+	if len(filtered.Cluster.Pods) != 2 || filtered.JobCosts["new"] != 5 {
+		t.Errorf("expected both 2080ti pods, got %+v", filtered.Cluster.Pods)
+	}
+	if filtered = to.Filter("v100"); len(filtered.Cluster.Pods) != 0 || len(filtered.Cluster.Jobs) != 0 {
+		t.Errorf("expected no v100 pods, got %+v", filtered.Cluster.Pods)
+	}
+}
@@ -0,0 +1,149 @@
+package snapshot
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileLayout = "20060102T150405Z"
+	fileSuffix = ".json.gz"
+)
+
+// ErrNotFound is returned when there are no snapshots
+var ErrNotFound = errors.New("no snapshot found")
+
+// Store keeps gzip compressed snapshots as files named by their time
+type Store struct {
+	sync.Mutex
+
+	dir string
+	// times of the snapshots in order
+	times []time.Time
+}
+
+// NewStore indexes the snapshots in dir
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileSuffix))
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir}
+	for _, file := range files {
+		t, err := time.Parse(fileLayout, strings.TrimSuffix(filepath.Base(file), fileSuffix))
+		if err != nil {
+			continue
+		}
+		s.times = append(s.times, t)
+	}
+	sort.Slice(s.times, func(i, j int) bool { return s.times[i].Before(s.times[j]) })
+	return s, nil
+}
+
+func (s *Store) path(t time.Time) string {
+	return filepath.Join(s.dir, t.UTC().Format(fileLayout)+fileSuffix)
+}
+
+// Save writes the snapshot, snapshots are kept at second precision
+func (s *Store) Save(snapshot *Snapshot) error {
+	s.Lock()
+	defer s.Unlock()
+
+	snapshot.Time = snapshot.Time.UTC().Truncate(time.Second)
+	path := s.path(snapshot.Time)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(file)
+	err = json.NewEncoder(zw).Encode(snapshot)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	i := sort.Search(len(s.times), func(i int) bool { return !s.times[i].Before(snapshot.Time) })
+	if i < len(s.times) && s.times[i].Equal(snapshot.Time) {
+		return nil
+	}
+	s.times = append(s.times, time.Time{})
+	copy(s.times[i+1:], s.times[i:])
+	s.times[i] = snapshot.Time
+	return nil
+}
+
+// Times returns the times of all snapshots in order
+func (s *Store) Times() []time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return append([]time.Time{}, s.times...)
+}
+
+// Closest loads the snapshot taken closest to t
+func (s *Store) Closest(t time.Time) (*Snapshot, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.times) == 0 {
+		return nil, ErrNotFound
+	}
+	i := sort.Search(len(s.times), func(i int) bool { return !s.times[i].Before(t) })
+	switch {
+	case i == len(s.times):
+		i--
+	case i > 0 && t.Sub(s.times[i-1]) <= s.times[i].Sub(t):
+		i--
+	}
+	return s.load(s.times[i])
+}
+
+func (s *Store) load(t time.Time) (*Snapshot, error) {
+	file, err := os.Open(s.path(t))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	snapshot := &Snapshot{}
+	return snapshot, json.NewDecoder(zr).Decode(snapshot)
+}
+
+// Expire removes the snapshots taken before t and returns how many
+func (s *Store) Expire(t time.Time) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	removed := 0
+	for removed < len(s.times) && s.times[removed].Before(t) {
+		if err := os.Remove(s.path(s.times[removed])); err != nil && !os.IsNotExist(err) {
+			s.times = s.times[removed:]
+			return removed, err
+		}
+		removed++
+	}
+	s.times = s.times[removed:]
+	return removed, nil
+}
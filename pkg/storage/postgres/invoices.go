@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/invoice"
+)
+
+// NextNumber returns the next unused invoice number
+func (d *DB) NextNumber() (string, error) {
+	last, err := takeNumbers(d.db, 1)
+	if err != nil {
+		return "", err
+	}
+	return invoice.FormatNumber(last), nil
+}
+
+// querier is a database or a transaction
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// takeNumbers uses n invoice numbers and returns the last of them, the row
+// stays locked until the transaction ends
+func takeNumbers(db querier, n int) (int64, error) {
+	var last int64
+	err := db.QueryRow(`UPDATE invoice_sequence SET last = last + $1 RETURNING last`, n).Scan(&last)
+	return last, err
+}
+
+// CreateInvoice saves a new invoice, invoices are never changed
+func (d *DB) CreateInvoice(inv *invoice.Invoice) error {
+	err := createInvoice(d.db, inv)
+	if isUniqueViolation(err) {
+		return invoice.ErrExists
+	}
+	return err
+}
+
+// execer is a database or a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func createInvoice(db execer, inv *invoice.Invoice) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO invoices (number, kind, period, account, issued_at, total, data) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		inv.Number, string(inv.Kind), inv.Period, inv.Account, inv.IssuedAt, int64(inv.Total), data)
+	return err
+}
+
+// GetInvoice returns the invoice with the number
+func (d *DB) GetInvoice(number string) (*invoice.Invoice, error) {
+	inv := &invoice.Invoice{}
+	if err := d.get(`SELECT data FROM invoices WHERE number = $1`, number, inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// ListInvoices returns all invoices ordered by number
+func (d *DB) ListInvoices() ([]*invoice.Invoice, error) {
+	invoices := []*invoice.Invoice{}
+	err := d.list(`SELECT data FROM invoices ORDER BY number`, func() interface{} {
+		inv := &invoice.Invoice{}
+		invoices = append(invoices, inv)
+		return inv
+	})
+	return invoices, err
+}
+
+// ClosePeriod numbers the invoices and saves them with the closed period in
+// one transaction, periods are never changed
+func (d *DB) ClosePeriod(p *invoice.Period, invoices []*invoice.Invoice) error {
+	err := d.inTx(func(tx *sql.Tx) error {
+		last, err := takeNumbers(tx, len(invoices))
+		if err != nil {
+			return err
+		}
+		for i, inv := range invoices {
+			inv.Number = invoice.FormatNumber(last - int64(len(invoices)-1-i))
+			p.Invoices = append(p.Invoices, inv.Number)
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO invoice_periods (name, start_time, end_time, closed_at, data) VALUES ($1, $2, $3, $4, $5)`,
+			p.Name, p.Start, p.End, p.ClosedAt, data); err != nil {
+			return err
+		}
+		for _, inv := range invoices {
+			if err := createInvoice(tx, inv); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if isUniqueViolation(err) {
+		return invoice.ErrExists
+	}
+	return err
+}
+
+// GetPeriod returns the closed period
+func (d *DB) GetPeriod(name string) (*invoice.Period, error) {
+	p := &invoice.Period{}
+	if err := d.get(`SELECT data FROM invoice_periods WHERE name = $1`, name, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListPeriods returns the closed periods ordered by name
+func (d *DB) ListPeriods() ([]*invoice.Period, error) {
+	periods := []*invoice.Period{}
+	err := d.list(`SELECT data FROM invoice_periods ORDER BY name`, func() interface{} {
+		p := &invoice.Period{}
+		periods = append(periods, p)
+		return p
+	})
+	return periods, err
+}
+
+// get decodes the json of a single row into v
+func (d *DB) get(query, key string, v interface{}) error {
+	var data []byte
+	err := d.db.QueryRow(query, key).Scan(&data)
+	if err == sql.ErrNoRows {
+		return invoice.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// list decodes the json of every row into a new value
+func (d *DB) list(query string, next func() interface{}) error {
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, next()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
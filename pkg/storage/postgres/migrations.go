@@ -0,0 +1,122 @@
+package postgres
+
+// migration is a versioned schema change, applied once in its own transaction
+type migration struct {
+	version     int
+	description string
+	sql         string
+}
+
+// migrations are applied in order, released migrations are never changed
+var migrations = []migration{
+	{1, "usage and invoices", `
+CREATE TABLE jobs (
+	id         BIGSERIAL PRIMARY KEY,
+	namespace  TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	user_id    TEXT NOT NULL DEFAULT '',
+	group_name TEXT NOT NULL DEFAULT '',
+	labels     JSONB,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (namespace, name)
+);
+CREATE INDEX jobs_user_id ON jobs (user_id);
+CREATE INDEX jobs_group_name ON jobs (group_name);
+
+CREATE TABLE tasks (
+	id     BIGSERIAL PRIMARY KEY,
+	job_id BIGINT NOT NULL REFERENCES jobs (id),
+	name   TEXT NOT NULL,
+	UNIQUE (job_id, name)
+);
+
+CREATE TABLE pod_attempts (
+	id           TEXT PRIMARY KEY,
+	task_id      BIGINT NOT NULL REFERENCES tasks (id),
+	pod_name     TEXT NOT NULL,
+	retry_count  INTEGER NOT NULL,
+	gpu_type     TEXT NOT NULL DEFAULT '',
+	reason       TEXT NOT NULL DEFAULT '',
+	waived       BOOLEAN NOT NULL DEFAULT false,
+	milli_cpu    BIGINT NOT NULL,
+	memory_bytes BIGINT NOT NULL,
+	milli_gpu    BIGINT NOT NULL,
+	start_time   TIMESTAMPTZ NOT NULL,
+	end_time     TIMESTAMPTZ NOT NULL,
+	billed_ns    BIGINT NOT NULL,
+	-- micro units of the currency
+	cost         BIGINT NOT NULL,
+	-- the whole usage record including its breakdown
+	record       JSONB NOT NULL,
+	finalized_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX pod_attempts_task_id ON pod_attempts (task_id);
+CREATE INDEX pod_attempts_end_time ON pod_attempts (end_time, id);
+
+CREATE TABLE prices (
+	version        TEXT PRIMARY KEY,
+	effective_from TIMESTAMPTZ NOT NULL,
+	effective_to   TIMESTAMPTZ,
+	rates          JSONB NOT NULL,
+	updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX prices_effective_from ON prices (effective_from);
+
+CREATE SEQUENCE invoice_numbers;
+
+CREATE TABLE invoices (
+	number    TEXT PRIMARY KEY,
+	kind      TEXT NOT NULL,
+	period    TEXT NOT NULL,
+	account   TEXT NOT NULL,
+	issued_at TIMESTAMPTZ NOT NULL,
+	total     BIGINT NOT NULL,
+	data      JSONB NOT NULL
+);
+CREATE INDEX invoices_period_account ON invoices (period, account);
+CREATE INDEX invoices_account ON invoices (account);
+
+CREATE TABLE invoice_periods (
+	name       TEXT PRIMARY KEY,
+	start_time TIMESTAMPTZ NOT NULL,
+	end_time   TIMESTAMPTZ NOT NULL,
+	closed_at  TIMESTAMPTZ NOT NULL,
+	data       JSONB NOT NULL
+);
+`},
+	{2, "usage rollups", `
+CREATE TABLE usage_rollups (
+	resolution       TEXT NOT NULL,
+	dimension        TEXT NOT NULL,
+	name             TEXT NOT NULL,
+	bucket_start     TIMESTAMPTZ NOT NULL,
+	cpu_core_hours   DOUBLE PRECISION NOT NULL,
+	memory_gib_hours DOUBLE PRECISION NOT NULL,
+	-- gpu hours by gpu type
+	gpu_hours        JSONB NOT NULL,
+	-- micro units of the currency
+	cost             BIGINT NOT NULL,
+	updated_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (resolution, dimension, name, bucket_start)
+);
+CREATE INDEX usage_rollups_bucket_start ON usage_rollups (resolution, dimension, bucket_start);
+`},
+	{3, "usage rollups cursor", `
+-- the usage rollups include the records finalized until through, one row
+CREATE TABLE usage_rollups_cursor (
+	id      BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+	through TIMESTAMPTZ NOT NULL
+);
+`},
+	{4, "invoice numbers in closing transactions", `
+-- the last invoice number used, one row. Unlike a sequence it is rolled back
+-- with a failed close, so no number is skipped.
+CREATE TABLE invoice_sequence (
+	id   BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+	last BIGINT NOT NULL
+);
+INSERT INTO invoice_sequence (last) SELECT CASE WHEN is_called THEN last_value ELSE 0 END FROM invoice_numbers;
+DROP SEQUENCE invoice_numbers;
+`},
+}
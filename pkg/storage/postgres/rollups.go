@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/rollup"
+)
+
+// SaveBuckets inserts new usage rollups and replaces changed ones together
+// with the time they include the records finalized until in one transaction
+func (d *DB) SaveBuckets(buckets []*rollup.Bucket, through time.Time) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`INSERT INTO usage_rollups (resolution, dimension, name, bucket_start,
+	cpu_core_hours, memory_gib_hours, gpu_hours, cost)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (resolution, dimension, name, bucket_start) DO UPDATE SET cpu_core_hours = EXCLUDED.cpu_core_hours,
+	memory_gib_hours = EXCLUDED.memory_gib_hours, gpu_hours = EXCLUDED.gpu_hours, cost = EXCLUDED.cost, updated_at = now()`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, b := range buckets {
+			gpuHours, err := json.Marshal(b.GPUHours)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(b.Resolution, b.Dimension, b.Name, b.Start,
+				b.CPUCoreHours, b.MemoryGiBHours, gpuHours, int64(b.Cost)); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`INSERT INTO usage_rollups_cursor (through) VALUES ($1)
+ON CONFLICT (id) DO UPDATE SET through = EXCLUDED.through`, through)
+		return err
+	})
+}
+
+// LoadBuckets loads the daily usage rollups, the hourly ones starting at or
+// after hourlyFrom and the time they include the records finalized until,
+// zero if they were never saved
+func (d *DB) LoadBuckets(hourlyFrom time.Time) ([]*rollup.Bucket, time.Time, error) {
+	var through time.Time
+	err := d.db.QueryRow(`SELECT through FROM usage_rollups_cursor`).Scan(&through)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, through, err
+	}
+	rows, err := d.db.Query(`SELECT resolution, dimension, name, bucket_start, cpu_core_hours, memory_gib_hours,
+	gpu_hours, cost FROM usage_rollups WHERE resolution <> $1 OR bucket_start >= $2`, rollup.ResolutionHour, hourlyFrom)
+	if err != nil {
+		return nil, through, err
+	}
+	defer rows.Close()
+	var buckets []*rollup.Bucket
+	for rows.Next() {
+		b := &rollup.Bucket{}
+		var gpuHours []byte
+		var cost int64
+		if err := rows.Scan(&b.Resolution, &b.Dimension, &b.Name, &b.Start, &b.CPUCoreHours, &b.MemoryGiBHours,
+			&gpuHours, &cost); err != nil {
+			return nil, through, err
+		}
+		if err := json.Unmarshal(gpuHours, &b.GPUHours); err != nil {
+			return nil, through, err
+		}
+		b.Cost = billing.Money(cost)
+		buckets = append(buckets, b)
+	}
+	return buckets, through, rows.Err()
+}
+
+// DeleteBuckets deletes the usage rollups of the resolution starting before
+func (d *DB) DeleteBuckets(resolution string, before time.Time) error {
+	_, err := d.db.Exec(`DELETE FROM usage_rollups WHERE resolution = $1 AND bucket_start < $2`, resolution, before)
+	return err
+}
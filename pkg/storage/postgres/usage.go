@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+)
+
+// Finalize saves the record with its job and task in one transaction, it
+// returns false if the attempt was saved before
+func (d *DB) Finalize(record *billing.UsageRecord) (bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	saved := false
+	err = d.inTx(func(tx *sql.Tx) error {
+		labels, err := json.Marshal(record.Labels)
+		if err != nil {
+			return err
+		}
+		var jobID, taskID int64
+		if err := tx.QueryRow(`INSERT INTO jobs (namespace, name, user_id, group_name, labels) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (namespace, name) DO UPDATE SET user_id = EXCLUDED.user_id, group_name = EXCLUDED.group_name, labels = EXCLUDED.labels
+RETURNING id`, record.Namespace, record.JobName, record.UserId, record.Group, labels).Scan(&jobID); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(`INSERT INTO tasks (job_id, name) VALUES ($1, $2)
+ON CONFLICT (job_id, name) DO UPDATE SET name = EXCLUDED.name
+RETURNING id`, jobID, record.TaskName).Scan(&taskID); err != nil {
+			return err
+		}
+		result, err := tx.Exec(`INSERT INTO pod_attempts (id, task_id, pod_name, retry_count, gpu_type, reason, waived,
+	milli_cpu, memory_bytes, milli_gpu, start_time, end_time, billed_ns, cost, record)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+ON CONFLICT (id) DO NOTHING`,
+			record.ID, taskID, record.PodName, record.RetryCount, record.GpuType, record.Reason, record.Waived,
+			record.Requests.MilliCPU, record.Requests.Memory, record.Requests.MilliGPU,
+			record.Start, record.End, int64(record.BilledDuration), int64(record.Cost), data)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		saved = rows > 0
+		return err
+	})
+	return saved, err
+}
+
+// Update replaces recomputed records in one transaction
+func (d *DB) Update(records []*billing.UsageRecord) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`UPDATE pod_attempts SET billed_ns = $2, cost = $3, waived = $4, record = $5, updated_at = now() WHERE id = $1`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(record.ID, int64(record.BilledDuration), int64(record.Cost), record.Waived, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Records loads all records ordered by end time
+func (d *DB) Records() ([]*billing.UsageRecord, error) {
+	rows, err := d.db.Query(`SELECT record FROM pod_attempts ORDER BY end_time, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []*billing.UsageRecord
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		record := &billing.UsageRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// SavePrices inserts new price versions and updates changed ones
+func (d *DB) SavePrices(versions []billing.RateCardVersion) error {
+	return d.inTx(func(tx *sql.Tx) error {
+		for _, version := range versions {
+			rates, err := json.Marshal(version.RateCard)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO prices (version, effective_from, effective_to, rates) VALUES ($1, $2, $3, $4)
+ON CONFLICT (version) DO UPDATE SET effective_from = EXCLUDED.effective_from, effective_to = EXCLUDED.effective_to,
+	rates = EXCLUDED.rates, updated_at = now()
+WHERE (prices.effective_from, prices.effective_to, prices.rates) IS DISTINCT FROM (EXCLUDED.effective_from, EXCLUDED.effective_to, EXCLUDED.rates)`,
+				version.Version, version.EffectiveFrom, version.EffectiveTo, rates); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,101 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// comments sent to keep idle connections open through proxies
+const keepAlivePeriod = 30 * time.Second
+
+// Serve streams the events as server-sent events filtered by the user,
+// namespace, job and types query parameters. Clients resume after the event
+// id in the Last-Event-ID header or the since query parameter.
+func (b *Broker) Serve(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	filter := Filter{
+		User:      query.Get("user"),
+		Namespace: query.Get("namespace"),
+		Job:       query.Get("job"),
+		Types:     ParseTypes(query.Get("types")),
+	}
+	if err := filter.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = query.Get("since")
+	}
+	var epoch int64
+	var since uint64
+	if cursor != "" {
+		var err error
+		if epoch, since, err = ParseID(cursor); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, replay, unsubscribe := b.Subscribe(filter, epoch, since, cursor != "")
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, e := range replay {
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAlivePeriod)
+	defer keepAlive.Stop()
+	done := r.Context().Done()
+	for {
+		select {
+		case <-done:
+			return
+		case e, ok := <-events:
+			if !ok {
+				// dropped for falling behind, the client reconnects and resumes
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes an event, events kept for resuming clients carry their epoch and sequence as id
+func writeEvent(w http.ResponseWriter, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		glog.Errorf("Failed to marshal %s event %d: %v", e.Type, e.Seq, err)
+		return nil
+	}
+	if e.Type != TypeCost {
+		if _, err := fmt.Fprintf(w, "id: %s\n", e.ID()); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
@@ -0,0 +1,429 @@
+package stream
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Types of the events
+const (
+	TypeJob   = "job"
+	TypeTask  = "task"
+	TypePod   = "pod"
+	TypeUsage = "usage"
+	// the live cost of a running job, it is not kept for resuming clients
+	TypeCost = "cost"
+	// the resumed sequence is no longer kept, the client has to fetch the jobs again
+	TypeReset = "reset"
+)
+
+// events buffered per subscriber, a subscriber falling further behind is dropped
+const subscriberBuffer = 256
+
+// Event is a change of a job, task, pod or usage record
+type Event struct {
+	// the broker started at epoch, sequences restart with every epoch
+	Epoch int64 `json:"epoch,omitempty"`
+	// sequence of the event, zero for cost events, the current sequence for reset events
+	Seq       uint64      `json:"seq,omitempty"`
+	Type      string      `json:"type"`
+	Time      time.Time   `json:"time"`
+	Job       string      `json:"job"`
+	Namespace string      `json:"namespace"`
+	User      string      `json:"user"`
+	Group     string      `json:"group"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// ID returns the id of the event, <epoch>-<seq>
+func (e *Event) ID() string {
+	return fmt.Sprintf("%d-%d", e.Epoch, e.Seq)
+}
+
+// ParseID parses the epoch and sequence of an event id
+func ParseID(id string) (int64, uint64, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid event id %q, expected <epoch>-<seq>", id)
+	}
+	epoch, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid epoch of event id %q: %v", id, err)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid sequence of event id %q: %v", id, err)
+	}
+	return epoch, seq, nil
+}
+
+// JobData is the state of a job
+type JobData struct {
+	State          string     `json:"state"`
+	StartTime      *time.Time `json:"startTime,omitempty"`
+	CompletionTime *time.Time `json:"completionTime,omitempty"`
+	// the job was deleted, no events of it follow
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// TaskData is the number of pod attempts of a task
+type TaskData struct {
+	Task     string `json:"task"`
+	Attempts int    `json:"attempts"`
+}
+
+// PodData is the phase of a pod attempt
+type PodData struct {
+	Pod        string `json:"pod"`
+	Task       string `json:"task"`
+	Phase      string `json:"phase"`
+	Reason     string `json:"reason,omitempty"`
+	RetryCount int    `json:"retryCount"`
+	GpuType    string `json:"gpuType,omitempty"`
+	// the pod was deleted, no events of it follow
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// CostData is the cost of a running job so far
+type CostData struct {
+	// cost of the completed pod attempts
+	Finalized billing.Money `json:"finalized"`
+	// cost of the running pods as if they completed now
+	Running     billing.Money `json:"running"`
+	Total       billing.Money `json:"total"`
+	RunningPods int           `json:"runningPods"`
+}
+
+// Filter selects the events of a user, namespace or job, empty fields match everything
+type Filter struct {
+	User      string
+	Namespace string
+	Job       string
+	// event types, all if empty
+	Types []string
+}
+
+// Match returns whether the event is selected by the filter
+func (f *Filter) Match(e *Event) bool {
+	if (f.User != "" && e.User != f.User) || (f.Namespace != "" && e.Namespace != f.Namespace) ||
+		(f.Job != "" && e.Job != f.Job) {
+		return false
+	}
+	if len(f.Types) == 0 || e.Type == TypeReset {
+		return true
+	}
+	for _, t := range f.Types {
+		if e.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the event types of the filter
+func (f *Filter) Validate() error {
+	for _, t := range f.Types {
+		switch t {
+		case TypeJob, TypeTask, TypePod, TypeUsage, TypeCost:
+		default:
+			return fmt.Errorf("unknown event type %q, expected job, task, pod, usage or cost", t)
+		}
+	}
+	return nil
+}
+
+type subscriber struct {
+	filter Filter
+	events chan *Event
+}
+
+// Ledger provides the cost of the completed pod attempts
+type Ledger interface {
+	JobRecords(namespace string, uid types.UID) []*billing.UsageRecord
+}
+
+// Cache provides the usage of the running pods
+type Cache interface {
+	RunningUsage(now time.Time) []*billing.UsageRecord
+}
+
+// Broker turns the changes of the cache into events with increasing sequence
+// numbers. Only state changes are published, the last events are kept so
+// clients can resume the stream after reconnecting.
+type Broker struct {
+	sync.Mutex
+
+	ledger Ledger
+	cache  Cache
+
+	// milliseconds since the unix epoch the broker was created at, tells the
+	// sequences of a restarted broker apart
+	epoch int64
+	seq   uint64
+	// ring of the last events
+	history []*Event
+	next    int
+	// last published states of the jobs, tasks and pods in the cache by key,
+	// removed when they are deleted
+	states      map[string]string
+	subscribers map[*subscriber]bool
+}
+
+// NewBroker creates a broker keeping the last history events, the live cost
+// of the running jobs is computed from the ledger and the cache
+func NewBroker(history int, ledger Ledger, cache Cache) *Broker {
+	if history < 1 {
+		history = 1
+	}
+	return &Broker{
+		ledger:      ledger,
+		cache:       cache,
+		epoch:       time.Now().UnixNano() / int64(time.Millisecond),
+		history:     make([]*Event, history),
+		states:      make(map[string]string),
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+// JobChanged publishes the state of the job if it changed
+func (b *Broker) JobChanged(fi *api.JobInfo) {
+	data := &JobData{}
+	if fi.Status != nil {
+		data.State = string(fi.Status.State)
+		if !fi.Status.StartTime.IsZero() {
+			start := fi.Status.StartTime.Time
+			data.StartTime = &start
+		}
+		if fi.Status.CompletionTime != nil {
+			completion := fi.Status.CompletionTime.Time
+			data.CompletionTime = &completion
+		}
+	}
+	b.publishChange(jobKey(fi.Namespace, fi.JobName), data.State, jobEvent(TypeJob, fi, data))
+}
+
+// JobDeleted publishes the removal of the job and forgets the states of the
+// job and its tasks
+func (b *Broker) JobDeleted(fi *api.JobInfo) {
+	data := &JobData{Deleted: true}
+	if fi.Status != nil {
+		data.State = string(fi.Status.State)
+	}
+	b.Lock()
+	defer b.Unlock()
+	delete(b.states, jobKey(fi.Namespace, fi.JobName))
+	tasks := taskKey(fi.Namespace, fi.JobName, "")
+	for key := range b.states {
+		if strings.HasPrefix(key, tasks) {
+			delete(b.states, key)
+		}
+	}
+	b.publish(jobEvent(TypeJob, fi, data))
+}
+
+// PodChanged publishes the phase of the pod and the attempts of its task if they changed
+func (b *Broker) PodChanged(fi *api.JobInfo, ti *api.TaskInfo, pi *api.PodInfo) {
+	task := &TaskData{Task: ti.Name, Attempts: len(ti.AllPods)}
+	b.publishChange(taskKey(pi.Namespace, ti.FrameworkName, ti.Name), fmt.Sprint(task.Attempts), jobEvent(TypeTask, fi, task))
+
+	pod := podData(pi)
+	b.publishChange(podKey(pi.Namespace, pi.Name), fmt.Sprintf("%s/%s/%d", pod.Phase, pod.Reason, pod.RetryCount),
+		jobEvent(TypePod, fi, pod))
+}
+
+// PodDeleted publishes the removal of the pod and forgets its state, the job
+// is nil if it is not in the cache
+func (b *Broker) PodDeleted(fi *api.JobInfo, pi *api.PodInfo) {
+	if fi == nil {
+		fi = &api.JobInfo{JobName: pi.FrameworkName, Namespace: pi.Namespace}
+	}
+	pod := podData(pi)
+	pod.Deleted = true
+	b.Lock()
+	defer b.Unlock()
+	delete(b.states, podKey(pi.Namespace, pi.Name))
+	b.publish(jobEvent(TypePod, fi, pod))
+}
+
+func podData(pi *api.PodInfo) *PodData {
+	return &PodData{Pod: pi.Name, Task: pi.TaskName, Phase: string(pi.Status.Phase), Reason: pi.Status.Reason,
+		RetryCount: pi.RetryCount, GpuType: pi.GpuType}
+}
+
+func jobKey(namespace, job string) string {
+	return "job/" + namespace + "/" + job
+}
+
+func taskKey(namespace, job, task string) string {
+	return "task/" + namespace + "/" + job + "/" + task
+}
+
+func podKey(namespace, pod string) string {
+	return "pod/" + namespace + "/" + pod
+}
+
+// UsageFinalized publishes the finalized usage record
+func (b *Broker) UsageFinalized(record *billing.UsageRecord) {
+	b.Lock()
+	defer b.Unlock()
+	b.publish(&Event{Type: TypeUsage, Time: time.Now(), Job: record.JobName, Namespace: record.Namespace,
+		User: record.UserId, Group: record.Group, Data: record})
+}
+
+func jobEvent(eventType string, fi *api.JobInfo, data interface{}) *Event {
+	return &Event{Type: eventType, Time: time.Now(), Job: fi.JobName, Namespace: fi.Namespace,
+		User: fi.UserId, Group: fi.GroupId, Data: data}
+}
+
+func (b *Broker) publishChange(key, state string, e *Event) {
+	b.Lock()
+	defer b.Unlock()
+	if last, found := b.states[key]; found && last == state {
+		return
+	}
+	b.states[key] = state
+	b.publish(e)
+}
+
+// publish numbers the event, keeps it and sends it to the subscribers, the
+// broker must be locked
+func (b *Broker) publish(e *Event) {
+	e.Epoch = b.epoch
+	if e.Type != TypeCost {
+		b.seq++
+		e.Seq = b.seq
+		b.history[b.next] = e
+		b.next = (b.next + 1) % len(b.history)
+	}
+	for s := range b.subscribers {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			// resumes from its last event after reconnecting
+			glog.Warningf("Dropped a stream subscriber falling behind at event %d", e.Seq)
+			b.drop(s)
+		}
+	}
+}
+
+// Subscribe subscribes to the events matching the filter. Resuming clients
+// also get the kept events after since, preceded by a reset event if some of
+// them are no longer kept. Clients resuming from another epoch only get the
+// reset event.
+func (b *Broker) Subscribe(filter Filter, epoch int64, since uint64, resume bool) (<-chan *Event, []*Event, func()) {
+	b.Lock()
+	defer b.Unlock()
+
+	var replay []*Event
+	if resume && epoch != b.epoch {
+		replay = []*Event{{Epoch: b.epoch, Seq: b.seq, Type: TypeReset, Time: time.Now()}}
+	} else if resume {
+		var oldest uint64
+		for i := range b.history {
+			e := b.history[(b.next+i)%len(b.history)]
+			if e == nil {
+				continue
+			}
+			if oldest == 0 {
+				oldest = e.Seq
+			}
+			if e.Seq > since && filter.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+		if since > b.seq || (oldest > since+1) {
+			reset := &Event{Epoch: b.epoch, Seq: b.seq, Type: TypeReset, Time: time.Now()}
+			replay = append([]*Event{reset}, replay...)
+		}
+	}
+
+	s := &subscriber{filter: filter, events: make(chan *Event, subscriberBuffer)}
+	b.subscribers[s] = true
+	return s.events, replay, func() {
+		b.Lock()
+		defer b.Unlock()
+		b.drop(s)
+	}
+}
+
+func (b *Broker) drop(s *subscriber) {
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Run publishes the cost of the running jobs every period while there are subscribers
+func (b *Broker) Run(period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		b.Lock()
+		subscribers := len(b.subscribers)
+		b.Unlock()
+		if subscribers == 0 {
+			return
+		}
+		events := b.costs(time.Now())
+		b.Lock()
+		defer b.Unlock()
+		for _, e := range events {
+			b.publish(e)
+		}
+	}, period, stopCh)
+}
+
+// costs returns the cost events of the jobs with running pods in order. Jobs
+// are told apart by namespace and uid, a recreated job starts from zero.
+func (b *Broker) costs(now time.Time) []*Event {
+	byJob := make(map[string]*Event)
+	var jobs []string
+	for _, record := range b.cache.RunningUsage(now) {
+		key := record.Namespace + "/" + record.JobName + "/" + record.JobUID
+		e, found := byJob[key]
+		if !found {
+			e = &Event{Type: TypeCost, Time: now, Job: record.JobName, Namespace: record.Namespace,
+				User: record.UserId, Group: record.Group, Data: &CostData{}}
+			byJob[key] = e
+			jobs = append(jobs, key)
+			if record.JobUID != "" {
+				for _, finalized := range b.ledger.JobRecords(record.Namespace, types.UID(record.JobUID)) {
+					e.Data.(*CostData).Finalized += finalized.Cost
+				}
+			}
+		}
+		data := e.Data.(*CostData)
+		data.Running += record.Cost
+		data.RunningPods++
+	}
+	sort.Strings(jobs)
+	events := make([]*Event, 0, len(jobs))
+	for _, job := range jobs {
+		data := byJob[job].Data.(*CostData)
+		data.Total = data.Finalized + data.Running
+		events = append(events, byJob[job])
+	}
+	return events
+}
+
+// ParseTypes splits a comma separated list of event types
+func ParseTypes(s string) []string {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
@@ -0,0 +1,216 @@
+package stream
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	fcapi "github.com/microsoft/frameworkcontroller/pkg/apis/frameworkcontroller/v1"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/billing"
+	"github.com/ruanxingbaozi/k8s-billing/pkg/monitor/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeLedger []*billing.UsageRecord
+
+func (l fakeLedger) JobRecords(namespace string, uid types.UID) []*billing.UsageRecord {
+	var records []*billing.UsageRecord
+	for _, record := range l {
+		if record.Namespace == namespace && record.JobUID == string(uid) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+type fakeCache []*billing.UsageRecord
+
+func (c fakeCache) RunningUsage(now time.Time) []*billing.UsageRecord { return c }
+
+func testJob(user string) *api.JobInfo {
+	return &api.JobInfo{JobName: "train", Namespace: "default", UserId: user, GroupId: "lab",
+		Status: &fcapi.FrameworkStatus{State: fcapi.FrameworkAttemptRunning}}
+}
+
+func testPod(phase v1.PodPhase, retry int) (*api.TaskInfo, *api.PodInfo) {
+	pi := &api.PodInfo{Name: "train-worker-0", TaskName: "worker", FrameworkName: "train", Namespace: "default",
+		Status: api.PodStatus{Phase: phase}, RetryCount: retry}
+	ti := &api.TaskInfo{Name: "worker", FrameworkName: "train", AllPods: map[string]*api.PodInfo{}}
+	for i := 0; i <= retry; i++ {
+		ti.AllPods[fmt.Sprint(i)] = pi
+	}
+	return ti, pi
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(4, fakeLedger{}, fakeCache{})
+	events, replay, unsubscribe := b.Subscribe(Filter{User: "alice", Types: []string{TypeJob, TypePod}}, b.epoch, 0, false)
+	defer unsubscribe()
+	if len(replay) != 0 {
+		t.Fatalf("expected no replay without resuming, got %+v", replay)
+	}
+
+	fi := testJob("alice")
+	b.JobChanged(fi)
+	// unchanged
+	b.JobChanged(fi)
+	ti, pi := testPod(v1.PodRunning, 0)
+	b.PodChanged(fi, ti, pi)
+	b.PodChanged(fi, ti, pi)
+	ti, pi = testPod(v1.PodPending, 1)
+	b.PodChanged(fi, ti, pi)
+	b.UsageFinalized(&billing.UsageRecord{JobName: "train", Namespace: "default", UserId: "alice"})
+	other := testJob("bob")
+	other.JobName = "eval"
+	b.JobChanged(other)
+
+	var got []string
+	for len(events) > 0 {
+		e := <-events
+		got = append(got, e.Type)
+	}
+	if strings.Join(got, ",") != "job,pod,pod" {
+		t.Errorf("expected the state changes of jobs and pods of alice, got %v", got)
+	}
+	// job, task, pod, task, pod, usage and the job of bob
+	if b.seq != 7 {
+		t.Errorf("expected 7 events, got %d", b.seq)
+	}
+
+	_, replay, unsubscribe2 := b.Subscribe(Filter{}, b.epoch, 5, true)
+	unsubscribe2()
+	if len(replay) != 2 || replay[0].Type != TypeUsage || replay[1].Seq != 7 {
+		t.Errorf("expected the events after 5, got %+v", replay)
+	}
+	_, replay, unsubscribe2 = b.Subscribe(Filter{}, b.epoch, 1, true)
+	unsubscribe2()
+	if len(replay) != 5 || replay[0].Type != TypeReset || replay[0].Seq != 7 || replay[1].Seq != 4 {
+		t.Errorf("expected a reset before the kept events, got %+v", replay)
+	}
+	_, replay, unsubscribe2 = b.Subscribe(Filter{}, b.epoch, 100, true)
+	unsubscribe2()
+	if len(replay) != 1 || replay[0].Type != TypeReset {
+		t.Errorf("expected a reset for a sequence ahead of the broker, got %+v", replay)
+	}
+	// the sequences of a restarted broker start again
+	_, replay, unsubscribe2 = b.Subscribe(Filter{}, b.epoch-1, 5, true)
+	unsubscribe2()
+	if len(replay) != 1 || replay[0].Type != TypeReset || replay[0].ID() != fmt.Sprintf("%d-7", b.epoch) {
+		t.Errorf("expected a reset for a sequence of another epoch, got %+v", replay)
+	}
+
+	if err := (&Filter{Types: []string{"node"}}).Validate(); err == nil {
+		t.Error("expected an error for an unknown event type")
+	}
+}
+
+func TestDeleted(t *testing.T) {
+	b := NewBroker(10, fakeLedger{}, fakeCache{})
+	fi := testJob("alice")
+	ti, pi := testPod(v1.PodRunning, 0)
+	b.JobChanged(fi)
+	b.PodChanged(fi, ti, pi)
+	// the same names in another namespace
+	other := testJob("bob")
+	other.Namespace = "test"
+	b.JobChanged(other)
+	if b.seq != 4 || len(b.states) != 4 {
+		t.Fatalf("expected the job of each namespace, got %d events and states %v", b.seq, b.states)
+	}
+
+	events, _, unsubscribe := b.Subscribe(Filter{}, b.epoch, 0, false)
+	defer unsubscribe()
+	b.PodDeleted(nil, pi)
+	b.JobDeleted(fi)
+	if pod := (<-events).Data.(*PodData); !pod.Deleted || pod.Pod != pi.Name {
+		t.Errorf("expected the pod to be deleted, got %+v", pod)
+	}
+	if job := <-events; job.Type != TypeJob || !job.Data.(*JobData).Deleted || job.Namespace != "default" {
+		t.Errorf("expected the job to be deleted, got %+v", job)
+	}
+	if len(b.states) != 1 || b.states[jobKey("test", "train")] == "" {
+		t.Errorf("expected only the state of the other job to be kept, got %v", b.states)
+	}
+}
+
+func TestCosts(t *testing.T) {
+	running := fakeCache{
+		{JobName: "train", JobUID: "uid-2", Namespace: "default", UserId: "alice", Cost: billing.MustParseMoney("1")},
+		{JobName: "train", JobUID: "uid-2", Namespace: "default", UserId: "alice", Cost: billing.MustParseMoney("2")},
+		{JobName: "train", JobUID: "uid-3", Namespace: "test", UserId: "bob", Cost: billing.MustParseMoney("16")},
+	}
+	ledger := fakeLedger{
+		{JobName: "train", JobUID: "uid-2", Namespace: "default", Cost: billing.MustParseMoney("4")},
+		{JobName: "eval", JobUID: "uid-4", Namespace: "default", Cost: billing.MustParseMoney("8")},
+		// a deleted job of the same name
+		{JobName: "train", JobUID: "uid-1", Namespace: "default", Cost: billing.MustParseMoney("32")},
+	}
+	b := NewBroker(10, ledger, running)
+	events := b.costs(time.Now())
+	if len(events) != 2 || events[0].Namespace != "default" || events[1].Namespace != "test" {
+		t.Fatalf("expected the costs of the running jobs by namespace, got %+v", events)
+	}
+	data := events[0].Data.(*CostData)
+	if data.Running != billing.MustParseMoney("3") || data.Finalized != billing.MustParseMoney("4") ||
+		data.Total != billing.MustParseMoney("7") || data.RunningPods != 2 {
+		t.Errorf("unexpected cost %+v", data)
+	}
+	if data := events[1].Data.(*CostData); events[1].User != "bob" || data.Finalized != 0 || data.Total != billing.MustParseMoney("16") {
+		t.Errorf("expected the job of the other namespace to be apart, got %+v %+v", events[1], data)
+	}
+}
+
+func TestServe(t *testing.T) {
+	b := NewBroker(10, fakeLedger{}, fakeCache{})
+	b.JobChanged(testJob("alice"))
+	server := httptest.NewServer(http.HandlerFunc(b.Serve))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"?user=alice", nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprintf("%d-0", b.epoch))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() []string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return lines
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+	}
+	if e := readEvent(); len(e) != 3 || e[0] != fmt.Sprintf("id: %d-1", b.epoch) || e[1] != "event: job" || !strings.Contains(e[2], `"state":"`+string(fcapi.FrameworkAttemptRunning)+`"`) {
+		t.Errorf("expected the resumed job event, got %v", e)
+	}
+	b.UsageFinalized(&billing.UsageRecord{JobName: "train", Namespace: "default", UserId: "alice"})
+	if e := readEvent(); len(e) != 3 || e[0] != fmt.Sprintf("id: %d-2", b.epoch) || e[1] != "event: usage" {
+		t.Errorf("expected the live usage event, got %v", e)
+	}
+
+	for _, since := range []string{"abc", "7", "1-abc"} {
+		resp, err = http.Get(server.URL + "?since=" + since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected the invalid event id %s to be rejected, got %d", since, resp.StatusCode)
+		}
+	}
+}